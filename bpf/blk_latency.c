@@ -0,0 +1,245 @@
+#include "vmlinux.h"
+
+#include <bpf/bpf_core_read.h>
+#include <bpf/bpf_helpers.h>
+#include <bpf/bpf_tracing.h>
+
+#include "bpf_blkio.h"
+#include "bpf_common.h"
+#include "bpf_event_pipe.h"
+#include "bpf_ratelimit.h"
+#include "bpf_stack.h"
+
+char __license[] SEC("license") = "Dual MIT/GPL";
+
+// the queue and device time of the outliers, in nanoseconds.
+volatile const u64 latency_thresh = 500 * NSEC_PER_MSEC;
+// the kernel stacks of the issuing tasks, only of the tasks and the devices
+// with the outliers in the outlier_window.
+volatile const bool stacks_enabled = true;
+volatile const u64 outlier_window  = 60ULL * 1000 * NSEC_PER_MSEC;
+
+struct blk_latency_event_t {
+	u64 queue;  // from the issue to the dispatch
+	u64 device; // from the dispatch to the completion
+	u64 sector;
+	u64 css; // the blkio cgroup
+	u32 len;
+	u32 cmd_flags;
+	u32 major;
+	u32 minor;
+	u32 pid;
+	u32 tgid;
+	s32 stack_id;
+	s32 error;
+	char comm[COMPAT_TASK_COMM_LEN];
+};
+
+// the issue of a bio by the task.
+struct issue_t {
+	u64 ts;
+	u32 pid;
+	u32 tgid;
+	s32 stack_id;
+	u32 pad0;
+	char comm[COMPAT_TASK_COMM_LEN];
+};
+
+// the dispatch of a request to the driver.
+struct dispatch_t {
+	u64 ts;
+	u64 sector;
+	u32 len;
+	u32 cmd_flags;
+};
+
+// the bios merged into the others, or of the bio based devices, are never
+// completed as the first bio of a request, the lru evicts them.
+struct {
+	__uint(type, BPF_MAP_TYPE_LRU_HASH);
+	__type(key, u64);
+	__type(value, struct issue_t);
+	__uint(max_entries, 10240);
+} bio_issues SEC(".maps");
+
+struct {
+	__uint(type, BPF_MAP_TYPE_LRU_HASH);
+	__type(key, u64);
+	__type(value, struct dispatch_t);
+	__uint(max_entries, 10240);
+} rq_dispatches SEC(".maps");
+
+// the last outliers of the tasks by the tgid, and of the devices by the
+// major and minor numbers, the stacks are captured at the issues of them.
+struct {
+	__uint(type, BPF_MAP_TYPE_LRU_HASH);
+	__type(key, u32);
+	__type(value, u64);
+	__uint(max_entries, 1024);
+} outlier_tasks SEC(".maps");
+
+struct {
+	__uint(type, BPF_MAP_TYPE_LRU_HASH);
+	__type(key, u64);
+	__type(value, u64);
+	__uint(max_entries, 256);
+} outlier_devices SEC(".maps");
+
+BPF_EVENT_PIPE(blk_latency_events);
+BPF_STACK_TRACE(stacks, STACK_TRACE_EVENT_ENTRIES);
+
+// the ratelimit protects the event pipe from the slow disks.
+BPF_RATELIMIT(rate, 1, 20);
+
+static __always_inline u64 device_key(struct bio *bio)
+{
+	u32 disk_dev[2];
+
+	bio_major_minor_numbers(bio, disk_dev);
+	return (u64)disk_dev[0] << 32 | disk_dev[1];
+}
+
+static __always_inline bool recent_outlier(void *map, void *key, u64 now)
+{
+	u64 *last = bpf_map_lookup_elem(map, key);
+
+	return last && now - *last < outlier_window;
+}
+
+// stack_wanted reports whether the task or the device has the outliers in the
+// window, the stacks of the others aren't worth the unwinding.
+static __always_inline bool stack_wanted(struct bio *bio, u32 tgid, u64 now)
+{
+	u64 dev;
+
+	if (!stacks_enabled)
+		return false;
+
+	if (recent_outlier(&outlier_tasks, &tgid, now))
+		return true;
+
+	dev = device_key(bio);
+	return recent_outlier(&outlier_devices, &dev, now);
+}
+
+SEC("kprobe/submit_bio")
+int submit_bio_entry(struct pt_regs *ctx)
+{
+	u64 bio		     = (u64)PT_REGS_PARM1(ctx);
+	u64 pid_tgid	     = bpf_get_current_pid_tgid();
+	struct issue_t issue = {
+		.ts	  = bpf_ktime_get_ns(),
+		.pid	  = (u32)pid_tgid,
+		.tgid	  = pid_tgid >> 32,
+		.stack_id = -1,
+	};
+
+	bpf_get_current_comm(&issue.comm, sizeof(issue.comm));
+	// -1 is no stack captured, the others negative are the failures.
+	if (stack_wanted((struct bio *)bio, issue.tgid, issue.ts))
+		issue.stack_id = bpf_get_kstackid(ctx, &stacks);
+
+	bpf_map_update_elem(&bio_issues, &bio, &issue, COMPAT_BPF_ANY);
+	return 0;
+}
+
+SEC("kprobe/blk_mq_start_request")
+int blk_mq_start_request_entry(struct pt_regs *ctx)
+{
+	struct request *req	   = (struct request *)PT_REGS_PARM1(ctx);
+	u64 key			   = (u64)req;
+	struct dispatch_t dispatch = {
+		.ts	   = bpf_ktime_get_ns(),
+		.sector	   = BPF_CORE_READ(req, __sector),
+		.len	   = BPF_CORE_READ(req, __data_len),
+		.cmd_flags = BPF_CORE_READ(req, cmd_flags),
+	};
+
+	bpf_map_update_elem(&rq_dispatches, &key, &dispatch, COMPAT_BPF_ANY);
+	return 0;
+}
+
+// request_start_time returns the allocation time of the request, it is 0 if
+// the block layer doesn't account it.
+static __always_inline u64 request_start_time(struct request *req)
+{
+	if (bpf_core_field_exists(req->start_time_ns))
+		return BPF_CORE_READ(req, start_time_ns);
+	return 0;
+}
+
+SEC("raw_tracepoint/block_rq_complete")
+int block_rq_complete_prog(struct bpf_raw_tracepoint_args *ctx)
+{
+	// TP_PROTO(struct request *rq, blk_status_t error, unsigned int
+	// nr_bytes)
+	struct request *req		= (struct request *)ctx->args[0];
+	struct blk_latency_event_t data = {};
+	struct dispatch_t *dispatch;
+	struct issue_t *issue;
+	u64 key = (u64)req, bio, start, dispatch_ts, now, dev;
+	u32 tgid;
+
+	dispatch = bpf_map_lookup_elem(&rq_dispatches, &key);
+	if (!dispatch)
+		return 0;
+
+	now	       = bpf_ktime_get_ns();
+	dispatch_ts    = dispatch->ts;
+	data.device    = now - dispatch_ts;
+	data.sector    = dispatch->sector;
+	data.len       = dispatch->len;
+	data.cmd_flags = dispatch->cmd_flags;
+	bpf_map_delete_elem(&rq_dispatches, &key);
+
+	// the requests without the data, e.g. the flushes, are ignored.
+	bio = (u64)BPF_CORE_READ(req, bio);
+	if (!bio)
+		return 0;
+
+	issue = bpf_map_lookup_elem(&bio_issues, &bio);
+	start = issue ? issue->ts : request_start_time(req);
+	if (start && start < dispatch_ts)
+		data.queue = dispatch_ts - start;
+
+	if (data.queue + data.device < latency_thresh) {
+		if (issue)
+			bpf_map_delete_elem(&bio_issues, &bio);
+		return 0;
+	}
+
+	// the next issues of the task and the device are with the stacks.
+	dev = device_key((struct bio *)bio);
+	if (stacks_enabled) {
+		bpf_map_update_elem(&outlier_devices, &dev, &now,
+				    COMPAT_BPF_ANY);
+		if (issue) {
+			tgid = issue->tgid;
+			bpf_map_update_elem(&outlier_tasks, &tgid, &now,
+					    COMPAT_BPF_ANY);
+		}
+	}
+
+	if (bpf_ratelimited(&rate)) {
+		if (issue)
+			bpf_map_delete_elem(&bio_issues, &bio);
+		return 0;
+	}
+
+	data.stack_id = -1;
+	if (issue) {
+		data.pid      = issue->pid;
+		data.tgid     = issue->tgid;
+		data.stack_id = issue->stack_id;
+		__builtin_memcpy(data.comm, issue->comm, sizeof(data.comm));
+		bpf_map_delete_elem(&bio_issues, &bio);
+	}
+
+	data.major = dev >> 32;
+	data.minor = (u32)dev;
+	data.css   = (u64)BPF_CORE_READ((struct bio *)bio, bi_blkg, blkcg);
+	data.error = (s32)ctx->args[1];
+
+	bpf_event_pipe_output(ctx, blk_latency_events, &data, sizeof(data));
+	return 0;
+}
@@ -0,0 +1,279 @@
+#include "vmlinux.h"
+
+#include <bpf/bpf_core_read.h>
+#include <bpf/bpf_helpers.h>
+#include <bpf/bpf_tracing.h>
+
+#include "bpf_common.h"
+#include "bpf_event_pipe.h"
+#include "bpf_ratelimit.h"
+
+#define RUNTIME_INF ((u64)~0ULL)
+#define CFS_THROTTLE_ZONES 4
+// the ancestors of a task group walked for the quota, e.g. the container and
+// the pod.
+#define CFS_THROTTLE_MAX_DEPTH 8
+
+char __license[] SEC("license") = "Dual MIT/GPL";
+
+// the throttled time of the outliers, in nanoseconds.
+volatile const u64 duration_thresh = 50 * NSEC_PER_MSEC;
+// at most one event of a container, the cpu cgroup, in the interval.
+volatile const u64 container_interval = 10ULL * 1000 * NSEC_PER_MSEC;
+
+struct cfs_throttle_event_t {
+	u64 css;
+	u64 duration;
+	u64 quota;
+	u64 period;
+	u64 episodes;
+	u32 cpu;
+	u32 pad0;
+};
+
+// the throttle episodes of a task group, an episode is the throttled time of
+// a cfs_rq, the cfs_rq of a cpu.
+struct cfs_throttle_stat_t {
+	u64 episodes;
+	u64 throttled_ns;
+	// the episodes in [0, 10)ms, [10, 50)ms, [50, 100)ms and [100, inf)ms.
+	u64 zones[CFS_THROTTLE_ZONES];
+};
+
+struct thread_key_t {
+	u64 css;
+	u32 pid;
+	u32 pad0;
+};
+
+// the on-cpu time of a thread in a task group with the cpu quota, the thread
+// is in the task group or its descendants. The runtime is counted in the
+// window from start, container_interval long.
+struct thread_t {
+	u64 runtime;
+	u64 start;
+	// the task group of the thread itself.
+	u64 leaf;
+	u32 tgid;
+	u32 pad0;
+	char comm[COMPAT_TASK_COMM_LEN];
+};
+
+// the throttled time of the cfs_rq.
+struct {
+	__uint(type, BPF_MAP_TYPE_LRU_HASH);
+	__type(key, u64);
+	__type(value, u64);
+	__uint(max_entries, 10240);
+} throttle_start SEC(".maps");
+
+struct {
+	__uint(type, BPF_MAP_TYPE_HASH);
+	__type(key, u64);
+	__type(value, struct cfs_throttle_stat_t);
+	__uint(max_entries, 10000);
+} cfs_throttle_stats SEC(".maps");
+
+struct {
+	__uint(type, BPF_MAP_TYPE_LRU_HASH);
+	__type(key, struct thread_key_t);
+	__type(value, struct thread_t);
+	__uint(max_entries, 10240);
+} cfs_throttle_threads SEC(".maps");
+
+// the last switch of the cpu.
+struct {
+	__uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+	__type(key, u32);
+	__type(value, u64);
+	__uint(max_entries, 1);
+} switch_ts SEC(".maps");
+
+// the last event of the containers by the css.
+struct {
+	__uint(type, BPF_MAP_TYPE_LRU_HASH);
+	__type(key, u64);
+	__type(value, u64);
+	__uint(max_entries, 4096);
+} container_last SEC(".maps");
+
+BPF_EVENT_PIPE(cfs_throttle_events);
+
+// the ratelimit protects the event pipe from the storms of the containers.
+BPF_RATELIMIT(rate, 1, 100);
+
+SEC("kprobe/throttle_cfs_rq")
+int throttle_cfs_rq_entry(struct pt_regs *ctx)
+{
+	u64 cfs_rq = (u64)PT_REGS_PARM1(ctx);
+	u64 now	   = bpf_ktime_get_ns();
+
+	// the cfs_rq may get the runtime and not be throttled since 5.10, the
+	// start is overwritten by the next throttle then.
+	bpf_map_update_elem(&throttle_start, &cfs_rq, &now, COMPAT_BPF_ANY);
+	return 0;
+}
+
+// container_ratelimited reports whether the container has an event in the
+// interval, or updates the last event.
+static __always_inline bool container_ratelimited(u64 css, u64 now)
+{
+	u64 *last = bpf_map_lookup_elem(&container_last, &css);
+
+	if (last && now - *last < container_interval)
+		return true;
+
+	bpf_map_update_elem(&container_last, &css, &now, COMPAT_BPF_ANY);
+	return false;
+}
+
+static __always_inline struct cfs_throttle_stat_t *lookup_stat(u64 css)
+{
+	struct cfs_throttle_stat_t *stat, zero = {};
+
+	stat = bpf_map_lookup_elem(&cfs_throttle_stats, &css);
+	if (stat)
+		return stat;
+
+	bpf_map_update_elem(&cfs_throttle_stats, &css, &zero,
+			    COMPAT_BPF_NOEXIST);
+	return bpf_map_lookup_elem(&cfs_throttle_stats, &css);
+}
+
+SEC("kprobe/unthrottle_cfs_rq")
+int unthrottle_cfs_rq_entry(struct pt_regs *ctx)
+{
+	struct cfs_rq *cfs_rq = (void *)PT_REGS_PARM1(ctx);
+	struct cfs_throttle_event_t data = {};
+	struct cfs_throttle_stat_t *stat;
+	struct task_group *tg;
+	u64 key = (u64)cfs_rq;
+	u64 now, *start;
+	u32 zone;
+
+	start = bpf_map_lookup_elem(&throttle_start, &key);
+	if (!start)
+		return 0;
+
+	now	      = bpf_ktime_get_ns();
+	data.duration = now - *start;
+	bpf_map_delete_elem(&throttle_start, &key);
+
+	// the css is the first member of the task_group.
+	tg	 = BPF_CORE_READ(cfs_rq, tg);
+	data.css = (u64)tg;
+
+	stat = lookup_stat(data.css);
+	if (!stat)
+		return 0;
+
+	if (data.duration < 10 * NSEC_PER_MSEC)
+		zone = 0;
+	else if (data.duration < 50 * NSEC_PER_MSEC)
+		zone = 1;
+	else if (data.duration < 100 * NSEC_PER_MSEC)
+		zone = 2;
+	else
+		zone = 3;
+
+	__sync_fetch_and_add(&stat->episodes, 1);
+	__sync_fetch_and_add(&stat->throttled_ns, data.duration);
+	__sync_fetch_and_add(&stat->zones[zone], 1);
+
+	if (data.duration < duration_thresh)
+		return 0;
+
+	if (container_ratelimited(data.css, now) || bpf_ratelimited(&rate))
+		return 0;
+
+	data.quota    = BPF_CORE_READ(tg, cfs_bandwidth.quota);
+	data.period   = BPF_CORE_READ(tg, cfs_bandwidth.period);
+	data.episodes = stat->episodes;
+	data.cpu      = bpf_get_smp_processor_id();
+
+	bpf_event_pipe_output(ctx, cfs_throttle_events, &data, sizeof(data));
+	return 0;
+}
+
+// account_thread adds the runtime of the thread to the task group.
+static __always_inline void account_thread(struct task_struct *prev,
+					   struct thread_key_t *key, u64 leaf,
+					   u64 now, u64 delta)
+{
+	struct thread_t *thread;
+
+	thread = bpf_map_lookup_elem(&cfs_throttle_threads, key);
+	if (thread) {
+		// start the next window.
+		if (now - thread->start >= container_interval) {
+			thread->start	= now;
+			thread->runtime = delta;
+			return;
+		}
+		__sync_fetch_and_add(&thread->runtime, delta);
+		return;
+	}
+
+	struct thread_t new_thread = {
+		.runtime = delta,
+		.start	 = now,
+		.leaf	 = leaf,
+		.tgid	 = BPF_CORE_READ(prev, tgid),
+	};
+	BPF_CORE_READ_STR_INTO(&new_thread.comm, prev, comm);
+	bpf_map_update_elem(&cfs_throttle_threads, key, &new_thread,
+			    COMPAT_BPF_NOEXIST);
+}
+
+SEC("raw_tracepoint/sched_switch")
+int sched_switch_prog(struct bpf_raw_tracepoint_args *ctx)
+{
+	// TP_PROTO(bool preempt, struct task_struct *prev, struct task_struct
+	// *next)
+	struct task_struct *prev = (struct task_struct *)ctx->args[1];
+	struct thread_key_t key	 = {};
+	struct task_group *tg;
+	u64 now, *last, delta, leaf;
+	u32 idx = 0;
+
+	last = bpf_map_lookup_elem(&switch_ts, &idx);
+	if (!last)
+		return 0;
+
+	now   = bpf_ktime_get_ns();
+	delta = *last ? now - *last : 0;
+	*last = now;
+
+	key.pid = BPF_CORE_READ(prev, pid);
+	if (key.pid == 0 || delta == 0)
+		return 0;
+
+	tg   = BPF_CORE_READ(prev, sched_task_group);
+	leaf = (u64)tg;
+
+	// only the task groups with the cpu quota are throttled, the quota may
+	// be on the ancestors, e.g. the pod.
+#pragma unroll
+	for (int i = 0; i < CFS_THROTTLE_MAX_DEPTH; i++) {
+		if (!tg)
+			break;
+
+		if (BPF_CORE_READ(tg, cfs_bandwidth.quota) != RUNTIME_INF) {
+			key.css = (u64)tg;
+			account_thread(prev, &key, leaf, now, delta);
+		}
+		tg = BPF_CORE_READ(tg, parent);
+	}
+	return 0;
+}
+
+// When cgroup is removed, the record should be deleted.
+SEC("kprobe/free_fair_sched_group")
+int free_fair_sched_group_entry(struct pt_regs *ctx)
+{
+	u64 css = (u64)PT_REGS_PARM1(ctx);
+
+	bpf_map_delete_elem(&cfs_throttle_stats, &css);
+	bpf_map_delete_elem(&container_last, &css);
+	return 0;
+}
@@ -1,9 +1,11 @@
 #include "vmlinux.h"
 
 #include "bpf_common.h"
+#include "bpf_event_pipe.h"
 #include "bpf_net_namespace.h"
 #include "bpf_netdevice.h"
 #include "bpf_ratelimit.h"
+#include "bpf_stack.h"
 #include "vmlinux_net.h"
 
 #define TYPE_TCP_COMMON_DROP 1
@@ -16,17 +18,17 @@
 #define SK_FL_TYPE_SHIFT 16
 #define SK_FL_TYPE_MASK 0xffff0000
 
+// the IPv4 addresses are in the first 4 bytes of the saddr and the daddr.
 struct perf_event_t {
 	u64 tgid_pid;
-	u32 saddr;
-	u32 daddr;
+	u8 saddr[16];
+	u8 daddr[16];
 	u16 sport;
 	u16 dport;
 	u32 seq;
 	u32 ack_seq;
 	u32 pkt_len;
-	s64 stack_size;
-	u64 stack[PERF_MAX_STACK_DEPTH];
+	s32 stack_id;
 	u32 queue_mapping;
 	u32 dev_flags;
 	u8 dev_name[IFNAMSIZ];
@@ -34,17 +36,15 @@ struct perf_event_t {
 	u32 sk_max_ack_backlog;
 	u8 sk_state;
 	u8 type;
-	u16 pad0;
-	u32 pad1;
+	u16 family;
+	u32 reason;
+	u32 pad2;
 	u64 net_cookie;
+	u64 location; // the caller of kfree_skb, even if the stack is lost.
 	char comm[COMPAT_TASK_COMM_LEN];
 };
 
-struct {
-	__uint(type, BPF_MAP_TYPE_PERF_EVENT_ARRAY);
-	__uint(key_size, sizeof(int));
-	__uint(value_size, sizeof(u32));
-} perf_events SEC(".maps");
+BPF_EVENT_PIPE(perf_events);
 
 struct {
 	__uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
@@ -53,6 +53,8 @@ struct {
 	__uint(value_size, sizeof(struct perf_event_t));
 } dropwatch_stackmap SEC(".maps");
 
+BPF_STACK_TRACE(stacks, STACK_TRACE_EVENT_ENTRIES);
+
 char __license[] SEC("license") = "Dual MIT/GPL";
 
 static const struct perf_event_t zero_data = {};
@@ -65,6 +67,16 @@ struct sock___5_10 {
 	u16 sk_protocol;
 } __attribute__((preserve_access_index));
 
+// kernel version >= 5.17
+//
+// struct trace_event_raw_kfree_skb {
+//	...
+//	enum skb_drop_reason reason;
+// }
+struct trace_event_raw_kfree_skb___reason {
+	u32 reason;
+} __attribute__((preserve_access_index));
+
 static void sk_get_type_and_protocol(struct sock *sk, u16 *protocol, u16 *type)
 {
 	// kernel version <= 4.18
@@ -122,18 +134,18 @@ int bpf_kfree_skb_prog(struct trace_event_raw_kfree_skb *ctx)
 	struct sock_common *sk_common;
 	struct net_device *dev;
 	struct tcphdr tcphdr;
-	struct iphdr iphdr;
 	struct sock *sk;
-	u16 protocol = 0;
-	u16 type     = 0;
-	u8 sk_state  = 0;
-
-	/* only for IP && TCP */
-	if (ctx->protocol != ETH_P_IP)
-		return 0;
-
-	bpf_probe_read(&iphdr, sizeof(iphdr), skb_network_header(skb));
-	if (iphdr.protocol != IPPROTO_TCP)
+	u8 saddr[16]   = {};
+	u8 daddr[16]   = {};
+	u8 l4_protocol = 0;
+	u16 protocol   = 0;
+	u16 type       = 0;
+	u16 family;
+	u8 sk_state = 0;
+
+	/* only for IPv4/IPv6 && TCP */
+	family = skb_ip_addrs(skb, ctx->protocol, saddr, daddr, &l4_protocol);
+	if (!family || l4_protocol != IPPROTO_TCP)
 		return 0;
 
 	sk = BPF_CORE_READ(skb, sk);
@@ -142,8 +154,10 @@ int bpf_kfree_skb_prog(struct trace_event_raw_kfree_skb *ctx)
 
 	sk_common = (struct sock_common *)sk;
 
-	// filter the sock by AF_INET, SOCK_STREAM, IPPROTO_TCP
-	if (BPF_CORE_READ(sk_common, skc_family) != AF_INET)
+	// filter the sock by AF_INET/AF_INET6, SOCK_STREAM, IPPROTO_TCP, the
+	// IPv4 packets of the AF_INET6 socks are the v4-mapped addresses.
+	if (BPF_CORE_READ(sk_common, skc_family) != AF_INET &&
+	    BPF_CORE_READ(sk_common, skc_family) != AF_INET6)
 		return 0;
 
 	sk_get_type_and_protocol(sk, &protocol, &type);
@@ -169,8 +183,9 @@ int bpf_kfree_skb_prog(struct trace_event_raw_kfree_skb *ctx)
 	bpf_get_current_comm(&data->comm, sizeof(data->comm));
 	data->type		 = TYPE_TCP_COMMON_DROP;
 	data->sk_state		 = sk_state;
-	data->saddr		 = iphdr.saddr;
-	data->daddr		 = iphdr.daddr;
+	data->family		 = family;
+	__builtin_memcpy(data->saddr, saddr, sizeof(saddr));
+	__builtin_memcpy(data->daddr, daddr, sizeof(daddr));
 	data->sport		 = tcphdr.source;
 	data->dport		 = tcphdr.dest;
 	data->seq		 = tcphdr.seq;
@@ -182,8 +197,13 @@ int bpf_kfree_skb_prog(struct trace_event_raw_kfree_skb *ctx)
 	data->dev_flags		 = 0;
 	data->ifindex		 = 0;
 	data->net_cookie	 = net_get_netns_cookie(skb);
-	data->stack_size =
-		bpf_get_stack(ctx, data->stack, sizeof(data->stack), 0);
+	data->location		 = (u64)ctx->location;
+	data->reason		 = 0;
+	if (bpf_core_field_exists(
+		((struct trace_event_raw_kfree_skb___reason *)ctx)->reason))
+		data->reason = BPF_CORE_READ(
+		    (struct trace_event_raw_kfree_skb___reason *)ctx, reason);
+	data->stack_id		 = bpf_get_kstackid(ctx, &stacks);
 
 	dev = BPF_CORE_READ(skb, dev);
 	if (dev) {
@@ -193,8 +213,7 @@ int bpf_kfree_skb_prog(struct trace_event_raw_kfree_skb *ctx)
 					  sizeof(data->dev_name), dev->name);
 	}
 
-	bpf_perf_event_output(ctx, &perf_events, COMPAT_BPF_F_CURRENT_CPU, data,
-			      sizeof(*data));
+	bpf_event_pipe_output(ctx, perf_events, data, sizeof(*data));
 
 	bpf_map_update_elem(&dropwatch_stackmap, &stackmap_key, &zero_data,
 			    COMPAT_BPF_EXIST);
@@ -5,15 +5,12 @@
 #include <bpf/bpf_tracing.h>
 
 #include "bpf_common.h"
+#include "bpf_event_pipe.h"
 #include "bpf_ratelimit.h"
 
 char __license[] SEC("license") = "Dual MIT/GPL";
 
-struct {
-	__uint(type, BPF_MAP_TYPE_PERF_EVENT_ARRAY);
-	__uint(key_size, sizeof(int));
-	__uint(value_size, sizeof(u32));
-} hungtask_perf_events SEC(".maps");
+BPF_EVENT_PIPE(hungtask_perf_events);
 
 struct hungtask_info {
 	int32_t pid;
@@ -27,7 +24,6 @@ int tracepoint_sched_process_hang(struct trace_event_raw_sched_process_hang *ctx
 
 	info.pid = ctx->pid;
 	BPF_CORE_READ_STR_INTO(&info.comm, ctx, comm);
-	bpf_perf_event_output(ctx, &hungtask_perf_events,
-			      COMPAT_BPF_F_CURRENT_CPU, &info, sizeof(info));
+	bpf_event_pipe_output(ctx, hungtask_perf_events, &info, sizeof(info));
 	return 0;
 }
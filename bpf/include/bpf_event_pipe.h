@@ -0,0 +1,69 @@
+#ifndef __BPF_EVENT_PIPE_H__
+#define __BPF_EVENT_PIPE_H__
+
+#include <bpf/bpf_helpers.h>
+
+#include "bpf_common.h"
+
+/* 256KB shared by all cpus, a power of 2 multiple of the page size. */
+#define EVENT_PIPE_RINGBUF_SIZE (256 * 1024)
+
+// event_pipe_ringbuf is set by the loader on the kernels supporting
+// BPF_MAP_TYPE_RINGBUF (5.8+). The verifier eliminates the branch not
+// taken, so the ringbuf helpers are never seen by the older kernels.
+volatile const bool event_pipe_ringbuf = false;
+
+// BPF_EVENT_PIPE declares an event pipe, a perf event array and a ring
+// buffer named name##_ringbuf. The userspace reads it by the name of the
+// perf event array, e.g. AttachAndEventPipe(ctx, "name", ...), from the
+// ring buffer if the kernel supports it, or the perf event array.
+//
+// The events dropped by the full ring buffer are counted per cpu in
+// name##_lost, the perf buffers report the lost samples by themselves.
+#define BPF_EVENT_PIPE(name)                                                   \
+	struct {                                                               \
+		__uint(type, BPF_MAP_TYPE_PERF_EVENT_ARRAY);                   \
+		__uint(key_size, sizeof(int));                                 \
+		__uint(value_size, sizeof(u32));                               \
+	} name SEC(".maps");                                                   \
+	struct {                                                               \
+		__uint(type, BPF_MAP_TYPE_RINGBUF);                            \
+		__uint(max_entries, EVENT_PIPE_RINGBUF_SIZE);                  \
+	} name##_ringbuf SEC(".maps");                                         \
+	struct {                                                               \
+		__uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);                       \
+		__uint(key_size, sizeof(u32));                                 \
+		__uint(value_size, sizeof(u64));                               \
+		__uint(max_entries, 1);                                        \
+	} name##_lost SEC(".maps")
+
+static __always_inline long
+event_pipe_ringbuf_output(void *ring, void *lost, void *data, u64 size)
+{
+	u32 key = 0;
+	u64 *count;
+	long ret;
+
+	ret = bpf_ringbuf_output(ring, data, size, 0);
+	if (ret < 0) {
+		count = bpf_map_lookup_elem(lost, &key);
+		if (count)
+			*count += 1;
+	}
+
+	return ret;
+}
+
+// bpf_event_pipe_output: outputs the event to the event pipe
+//
+// @ctx: the context of the program, for the perf event array
+// @name: the event pipe declared by BPF_EVENT_PIPE
+// @return: 0 on success, negative on errors, e.g. the buffer is full.
+#define bpf_event_pipe_output(ctx, name, data, size)                           \
+	(event_pipe_ringbuf                                                    \
+	     ? event_pipe_ringbuf_output(&name##_ringbuf, &name##_lost, data,  \
+					 size)                                 \
+	     : bpf_perf_event_output(ctx, &name, COMPAT_BPF_F_CURRENT_CPU,     \
+				     data, size))
+
+#endif /* __BPF_EVENT_PIPE_H__ */
@@ -3,6 +3,8 @@
 
 #include <bpf/bpf_helpers.h>
 
+// the agent may set the interval and the burst in place, through the mmapped
+// maps holding the ratelimits, see internal/bpf/ratelimit_default.go.
 struct bpf_ratelimit {
 	uint64_t interval; // unit: second
 	uint64_t begin;
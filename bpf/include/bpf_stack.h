@@ -0,0 +1,42 @@
+#ifndef __BPF_STACK_H__
+#define __BPF_STACK_H__
+
+#include <bpf/bpf_helpers.h>
+
+#include "bpf_common.h"
+
+// the stacks of the profilers, which aggregate the samples by the stack ids.
+#define STACK_TRACE_MAX_ENTRIES 0x4000
+// the stacks of the event tracers, the events are ratelimited and the stack
+// ids are resolved right after the events are read. the stacks unused for a
+// minute are deleted by the userspace, the buckets are sized for the unique
+// stacks of the minute, and the stacks lost on the collisions are counted.
+#define STACK_TRACE_EVENT_ENTRIES 4096
+
+// BPF_STACK_TRACE declares a BPF_MAP_TYPE_STACK_TRACE map of the stack ids.
+//
+// The value size is of the max stack depth, the loader shrinks it to the
+// configured depth, and the map to a single bucket if stacks_enabled is
+// false. The stack ids are not reused on the hash collisions, so that the
+// id of an event always refers to the same stack, and the userspace
+// resolves every id only once. The buckets are never freed by the bpf, the
+// userspace resets the map periodically, or deletes the stale stacks.
+#define BPF_STACK_TRACE(name, entries)                                         \
+	struct {                                                               \
+		__uint(type, BPF_MAP_TYPE_STACK_TRACE);                        \
+		__uint(key_size, sizeof(u32));                                 \
+		__uint(value_size, PERF_MAX_STACK_DEPTH * sizeof(u64));        \
+		__uint(max_entries, entries);                                  \
+	} name SEC(".maps")
+
+// bpf_get_kstackid: the kernel stack id of the current context
+//
+// @return: the stack id, negative on errors, e.g. -EEXIST if the bucket is
+// taken by another stack.
+#define bpf_get_kstackid(ctx, map) bpf_get_stackid(ctx, map, 0)
+
+// bpf_get_ustackid: the user stack id of the current context
+#define bpf_get_ustackid(ctx, map)                                             \
+	bpf_get_stackid(ctx, map, COMPAT_BPF_F_USER_STACK)
+
+#endif /* __BPF_STACK_H__ */
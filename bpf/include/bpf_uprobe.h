@@ -0,0 +1,38 @@
+#ifndef __BPF_UPROBE_H__
+#define __BPF_UPROBE_H__
+
+#include <bpf/bpf_helpers.h>
+
+#include "bpf_common.h"
+
+struct uprobe_filter {
+	u64 cgroup_id; /* the cgroup v2 id, 0 for all */
+};
+
+// uprobe_filter is set by the loader with the cgroup of the attach options,
+// it is shared by all uprobes/uretprobes/usdt of the bpf object.
+struct {
+	__uint(type, BPF_MAP_TYPE_ARRAY);
+	__uint(key_size, sizeof(u32));
+	__uint(value_size, sizeof(struct uprobe_filter));
+	__uint(max_entries, 1);
+} uprobe_filter SEC(".maps");
+
+// bpf_uprobe_filtered: whether the current task is filtered out
+//
+// @return:
+//   true: the task is not in the cgroup of the filter
+//   false: the task is traced
+static __always_inline bool bpf_uprobe_filtered(void)
+{
+	u32 key = 0;
+	struct uprobe_filter *filter;
+
+	filter = bpf_map_lookup_elem(&uprobe_filter, &key);
+	if (!filter || !filter->cgroup_id)
+		return false;
+
+	return filter->cgroup_id != bpf_get_current_cgroup_id();
+}
+
+#endif /* __BPF_UPROBE_H__ */
@@ -7,7 +7,9 @@
 #define IFNAMSIZ        16
 
 #define ETH_P_IP        0x0800          /* Internet Protocol packet     */
+#define ETH_P_IPV6      0x86DD          /* IPv6 over bluebook           */
 #define AF_INET         2       /* Internet IP Protocol         */
+#define AF_INET6        10      /* IP version 6                 */
 
 #define IP_MF           0x2000          /* Flag: "More Fragments"       */
 #define IP_OFFSET       0x1FFF          /* "Fragment Offset" part       */
@@ -23,4 +25,33 @@ static inline unsigned char *skb_transport_header(struct sk_buff *skb)
 {
     return BPF_CORE_READ(skb, head) + BPF_CORE_READ(skb, transport_header);
 }
+
+// skb_ip_addrs - copy the IPv4 or IPv6 addresses of the skb by the ethernet
+// protocol in the host order, the IPv4 address is in the first 4 bytes.
+// returns the address family and the l4 protocol, 0 for the others.
+static inline u16 skb_ip_addrs(struct sk_buff *skb, u16 protocol, u8 *saddr,
+                               u8 *daddr, u8 *l4_protocol)
+{
+    if (protocol == ETH_P_IP) {
+        struct iphdr iphdr;
+
+        bpf_probe_read(&iphdr, sizeof(iphdr), skb_network_header(skb));
+        __builtin_memcpy(saddr, &iphdr.saddr, 4);
+        __builtin_memcpy(daddr, &iphdr.daddr, 4);
+        *l4_protocol = iphdr.protocol;
+        return AF_INET;
+    }
+
+    if (protocol == ETH_P_IPV6) {
+        struct ipv6hdr ip6hdr;
+
+        bpf_probe_read(&ip6hdr, sizeof(ip6hdr), skb_network_header(skb));
+        __builtin_memcpy(saddr, &ip6hdr.saddr, 16);
+        __builtin_memcpy(daddr, &ip6hdr.daddr, 16);
+        *l4_protocol = ip6hdr.nexthdr;
+        return AF_INET6;
+    }
+
+    return 0;
+}
 #endif
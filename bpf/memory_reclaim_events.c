@@ -5,6 +5,7 @@
 #include <bpf/bpf_tracing.h>
 
 #include "bpf_common.h"
+#include "bpf_event_pipe.h"
 #include "bpf_func_trace.h"
 #include "bpf_ratelimit.h"
 
@@ -12,11 +13,7 @@ char __license[] SEC("license") = "Dual MIT/GPL";
 
 volatile const unsigned long deltath = 0;
 
-struct {
-	__uint(type, BPF_MAP_TYPE_PERF_EVENT_ARRAY);
-	__uint(key_size, sizeof(int));
-	__uint(value_size, sizeof(u32));
-} reclaim_perf_events SEC(".maps");
+BPF_EVENT_PIPE(reclaim_perf_events);
 
 struct reclaim_entry {
 	char comm[COMPAT_TASK_COMM_LEN];
@@ -54,8 +51,7 @@ int kretprobe_try_to_free_pages(struct pt_regs *ctx)
 
 		bpf_get_current_comm(data.comm, sizeof(data.comm));
 
-		bpf_perf_event_output(ctx, &reclaim_perf_events,
-				      COMPAT_BPF_F_CURRENT_CPU, &data,
+		bpf_event_pipe_output(ctx, reclaim_perf_events, &data,
 				      sizeof(struct reclaim_entry));
 	}
 
@@ -0,0 +1,107 @@
+#include "vmlinux.h"
+
+#include <bpf/bpf_core_read.h>
+#include <bpf/bpf_helpers.h>
+
+#include "bpf_common.h"
+#include "vmlinux_net.h"
+
+char __license[] SEC("license") = "Dual MIT/GPL";
+
+// the drops are counted by the reason, the protocols, the netdev and the net
+// namespace. the entries of the dead containers are deleted from the user
+// space, and the lru evicts the others, e.g. the net namespaces of no
+// containers.
+struct drop_key_t {
+	u32 reason;
+	u32 netns_inode;
+	u16 protocol; // the ethernet protocol, in the host order.
+	u8 l4_protocol;
+	u8 pad0;
+	char dev_name[IFNAMSIZ];
+};
+
+struct {
+	__uint(type, BPF_MAP_TYPE_LRU_HASH);
+	__type(key, struct drop_key_t);
+	__type(value, u64);
+	__uint(max_entries, 10240);
+} drop_reasons SEC(".maps");
+
+// kernel version >= 5.17
+//
+// struct trace_event_raw_kfree_skb {
+//	struct trace_entry ent;
+//	void *skbaddr;
+//	void *location;
+//	short unsigned int protocol;
+//	enum skb_drop_reason reason;
+// }
+struct trace_event_raw_kfree_skb___reason {
+	u32 reason;
+} __attribute__((preserve_access_index));
+
+static __always_inline u8 skb_l4_protocol(struct sk_buff *skb, u16 protocol)
+{
+	if (protocol == ETH_P_IP) {
+		struct iphdr iphdr;
+
+		bpf_probe_read(&iphdr, sizeof(iphdr), skb_network_header(skb));
+		return iphdr.protocol;
+	}
+
+	if (protocol == ETH_P_IPV6) {
+		struct ipv6hdr ip6hdr;
+
+		bpf_probe_read(&ip6hdr, sizeof(ip6hdr), skb_network_header(skb));
+		return ip6hdr.nexthdr;
+	}
+
+	return 0;
+}
+
+SEC("tracepoint/skb/kfree_skb")
+int bpf_kfree_skb_reason_prog(struct trace_event_raw_kfree_skb *ctx)
+{
+	struct trace_event_raw_kfree_skb___reason *ctx_reason = (void *)ctx;
+	struct sk_buff *skb = ctx->skbaddr;
+	struct drop_key_t key = {};
+	struct net_device *dev;
+	struct sock *sk;
+	u64 *count, one = 1;
+
+	// the older kernels have no reasons, the drops are counted as the
+	// reason 0, SKB_DROP_REASON_NOT_SPECIFIED.
+	if (bpf_core_field_exists(ctx_reason->reason))
+		key.reason = BPF_CORE_READ(ctx_reason, reason);
+
+	key.protocol	= ctx->protocol;
+	key.l4_protocol = skb_l4_protocol(skb, key.protocol);
+
+	dev = BPF_CORE_READ(skb, dev);
+	if (dev) {
+		bpf_probe_read_kernel_str(&key.dev_name, sizeof(key.dev_name),
+					  dev->name);
+		key.netns_inode = BPF_CORE_READ(dev, nd_net.net, ns.inum);
+	} else {
+		key.dev_name[0] = '-';
+		sk		= BPF_CORE_READ(skb, sk);
+		if (sk)
+			key.netns_inode =
+			    BPF_CORE_READ(sk, __sk_common.skc_net.net, ns.inum);
+	}
+
+	count = bpf_map_lookup_elem(&drop_reasons, &key);
+	if (count) {
+		__sync_fetch_and_add(count, 1);
+		return 0;
+	}
+
+	// the key may be created by the others at the same time.
+	if (bpf_map_update_elem(&drop_reasons, &key, &one, COMPAT_BPF_NOEXIST)) {
+		count = bpf_map_lookup_elem(&drop_reasons, &key);
+		if (count)
+			__sync_fetch_and_add(count, 1);
+	}
+	return 0;
+}
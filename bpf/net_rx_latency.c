@@ -21,6 +21,7 @@ volatile const long long to_user_copy	  = 115 * 1000 * 1000; // 115ms
 
 BPF_RATELIMIT(rate, 1, 100);
 
+// the IPv4 addresses are in the first 4 bytes of the saddr and the daddr.
 struct perf_event_t {
 	char comm[COMPAT_TASK_COMM_LEN];
 	u64 latency;
@@ -28,18 +29,20 @@ struct perf_event_t {
 	u64 pkt_len;
 	u16 sport;
 	u16 dport;
-	u32 saddr;
-	u32 daddr;
+	u8 saddr[16];
+	u8 daddr[16];
 	u32 seq;
 	u32 ack_seq;
 	u8 state;
 	u8 where;
+	u16 family;
 };
 
 enum skb_rcv_where {
 	TO_NETIF_RCV,
 	TO_TCPV4_RCV,
 	TO_USER_COPY,
+	TO_TCPV6_RCV,
 };
 
 struct {
@@ -48,13 +51,6 @@ struct {
 	__uint(value_size, sizeof(u32));
 } net_recv_lat_event_map SEC(".maps");
 
-struct mix {
-	struct iphdr *ip_hdr;
-	u64 lat;
-	u8 state;
-	u8 where;
-};
-
 static inline u64 delta_now_skb_tstamp(struct sk_buff *skb)
 {
 	u64 tstamp = BPF_CORE_READ(skb, tstamp);
@@ -72,105 +68,114 @@ static inline u8 get_state(struct sk_buff *skb)
 	return BPF_CORE_READ(skb, sk, __sk_common.skc_state);
 }
 
-static inline void
-fill_and_output_event(void *ctx, struct sk_buff *skb, struct mix *_mix)
+// fill_addrs fills the IPv4 or IPv6 addresses, only for TCP.
+static inline int fill_addrs(struct perf_event_t *event, struct sk_buff *skb)
+{
+	u8 l4_protocol = 0;
+
+	event->family = skb_ip_addrs(skb, bpf_ntohs(BPF_CORE_READ(skb, protocol)),
+				     event->saddr, event->daddr, &l4_protocol);
+	if (!event->family || l4_protocol != IPPROTO_TCP)
+		return -1;
+	return 0;
+}
+
+static inline void fill_and_output_event(void *ctx, struct sk_buff *skb,
+					 struct perf_event_t *event, u64 lat,
+					 u8 state, u8 where)
 {
-	struct perf_event_t event = {};
 	struct tcphdr tcp_hdr;
 
 	// ratelimit
 	if (bpf_ratelimited(&rate))
 		return;
 
-	if (likely(_mix->where == TO_USER_COPY)) {
-		event.tgid_pid = bpf_get_current_pid_tgid();
-		bpf_get_current_comm(&event.comm, sizeof(event.comm));
+	if (likely(where == TO_USER_COPY)) {
+		event->tgid_pid = bpf_get_current_pid_tgid();
+		bpf_get_current_comm(&event->comm, sizeof(event->comm));
 	}
 
 	bpf_probe_read(&tcp_hdr, sizeof(tcp_hdr), skb_transport_header(skb));
-	event.latency = _mix->lat;
-	event.saddr   = _mix->ip_hdr->saddr;
-	event.daddr   = _mix->ip_hdr->daddr;
-	event.sport   = tcp_hdr.source;
-	event.dport   = tcp_hdr.dest;
-	event.seq     = tcp_hdr.seq;
-	event.ack_seq = tcp_hdr.ack_seq;
-	event.pkt_len = BPF_CORE_READ(skb, len);
-	event.state   = _mix->state;
-	event.where   = _mix->where;
+	event->latency = lat;
+	event->sport   = tcp_hdr.source;
+	event->dport   = tcp_hdr.dest;
+	event->seq     = tcp_hdr.seq;
+	event->ack_seq = tcp_hdr.ack_seq;
+	event->pkt_len = BPF_CORE_READ(skb, len);
+	event->state   = state;
+	event->where   = where;
 
 	bpf_perf_event_output(ctx, &net_recv_lat_event_map,
-			      COMPAT_BPF_F_CURRENT_CPU, &event,
+			      COMPAT_BPF_F_CURRENT_CPU, event,
 			      sizeof(struct perf_event_t));
 }
 
 SEC("tracepoint/net/netif_receive_skb")
 int netif_receive_skb_prog(struct trace_event_raw_net_dev_template *args)
 {
-	struct sk_buff *skb = (struct sk_buff *)args->skbaddr;
-	struct iphdr ip_hdr;
+	struct sk_buff *skb	   = (struct sk_buff *)args->skbaddr;
+	struct perf_event_t event = {};
 	u64 delta;
 
-	if (unlikely(BPF_CORE_READ(skb, protocol) !=
-		     bpf_ntohs(ETH_P_IP))) // IPv4
-		return 0;
-
-	bpf_probe_read(&ip_hdr, sizeof(ip_hdr), skb_network_header(skb));
-	if (ip_hdr.protocol != IPPROTO_TCP)
+	// IPv4 or IPv6
+	if (fill_addrs(&event, skb) < 0)
 		return 0;
 
 	delta = delta_now_skb_tstamp(skb);
 	if (delta < to_netif)
 		return 0;
 
-	fill_and_output_event(args, skb,
-			      &(struct mix){&ip_hdr, delta, 0, TO_NETIF_RCV});
-
+	fill_and_output_event(args, skb, &event, delta, 0, TO_NETIF_RCV);
 	return 0;
 }
 
-SEC("kprobe/tcp_v4_rcv")
-int tcp_v4_rcv_prog(struct pt_regs *ctx)
+static inline int tcp_rcv(struct pt_regs *ctx, u8 where)
 {
-	struct sk_buff *skb = (struct sk_buff *)PT_REGS_PARM1_CORE(ctx);
-	struct iphdr ip_hdr;
+	struct sk_buff *skb	   = (struct sk_buff *)PT_REGS_PARM1_CORE(ctx);
+	struct perf_event_t event = {};
 	u64 delta;
 
 	delta = delta_now_skb_tstamp(skb);
 	if (delta < to_tcpv4)
 		return 0;
 
-	bpf_probe_read(&ip_hdr, sizeof(ip_hdr), skb_network_header(skb));
-	fill_and_output_event(
-	    ctx, skb,
-	    &(struct mix){&ip_hdr, delta, get_state(skb), TO_TCPV4_RCV});
+	if (fill_addrs(&event, skb) < 0)
+		return 0;
 
+	fill_and_output_event(ctx, skb, &event, delta, get_state(skb), where);
 	return 0;
 }
 
+SEC("kprobe/tcp_v4_rcv")
+int tcp_v4_rcv_prog(struct pt_regs *ctx)
+{
+	return tcp_rcv(ctx, TO_TCPV4_RCV);
+}
+
+// the threshold of tcp_v6_rcv is the same as tcp_v4_rcv.
+SEC("kprobe/tcp_v6_rcv")
+int tcp_v6_rcv_prog(struct pt_regs *ctx)
+{
+	return tcp_rcv(ctx, TO_TCPV6_RCV);
+}
+
 SEC("tracepoint/skb/skb_copy_datagram_iovec")
 int skb_copy_datagram_iovec_prog(
     struct trace_event_raw_skb_copy_datagram_iovec *args)
 {
-	struct sk_buff *skb = (struct sk_buff *)args->skbaddr;
-	struct iphdr ip_hdr;
+	struct sk_buff *skb	   = (struct sk_buff *)args->skbaddr;
+	struct perf_event_t event = {};
 	u64 delta;
 
-	if (unlikely(BPF_CORE_READ(skb, protocol) != bpf_ntohs(ETH_P_IP)))
-		return 0;
-
-	bpf_probe_read(&ip_hdr, sizeof(ip_hdr), skb_network_header(skb));
-	if (ip_hdr.protocol != IPPROTO_TCP)
+	if (fill_addrs(&event, skb) < 0)
 		return 0;
 
 	delta = delta_now_skb_tstamp(skb);
 	if (delta < to_user_copy)
 		return 0;
 
-	fill_and_output_event(
-	    args, skb,
-	    &(struct mix){&ip_hdr, delta, get_state(skb), TO_USER_COPY});
-
+	fill_and_output_event(args, skb, &event, delta, get_state(skb),
+			      TO_USER_COPY);
 	return 0;
 }
 
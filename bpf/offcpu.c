@@ -0,0 +1,126 @@
+#include "vmlinux.h"
+
+#include <bpf/bpf_core_read.h>
+#include <bpf/bpf_helpers.h>
+#include <bpf/bpf_tracing.h>
+
+#include "bpf_common.h"
+#include "bpf_stack.h"
+
+char __license[] SEC("license") = "Dual MIT/GPL";
+
+#define TASK_RUNNING 0
+
+volatile const u64 css = 0;
+volatile const u64 pid = 0;
+/* ignore the blocked time shorter than this. */
+volatile const u64 min_block_ns = 1000;
+
+struct key_t {
+	u64 css;
+	s32 ustack_id;
+	s32 kstack_id;
+	u32 pid;
+	char name[COMPAT_TASK_COMM_LEN];
+};
+
+BPF_STACK_TRACE(stacks, STACK_TRACE_MAX_ENTRIES);
+
+struct start_t {
+	u64 ts;
+	struct key_t key;
+};
+
+/* tid -> the switch-out time and stacks of a blocked task */
+struct {
+	__uint(type, BPF_MAP_TYPE_HASH);
+	__type(key, u32);
+	__type(value, struct start_t);
+	__uint(max_entries, 10240);
+} start SEC(".maps");
+
+/* stacks -> the blocked time in ns */
+struct {
+	__uint(type, BPF_MAP_TYPE_HASH);
+	__uint(key_size, sizeof(struct key_t));
+	__uint(value_size, sizeof(u64));
+	__uint(max_entries, 10240);
+} counts SEC(".maps");
+
+struct task_struct___5_14 {
+	unsigned int __state;
+} __attribute__((preserve_access_index));
+
+static __always_inline long get_task_state(struct task_struct *task)
+{
+	if (bpf_core_field_exists(task->state))
+		return BPF_CORE_READ(task, state);
+
+	struct task_struct___5_14 *task_new = (struct task_struct___5_14 *)task;
+	return (long)BPF_CORE_READ(task_new, __state);
+}
+
+static __always_inline void record_switch_out(struct bpf_raw_tracepoint_args *ctx,
+					      struct task_struct *prev)
+{
+	u32 tid = BPF_CORE_READ(prev, pid);
+	u32 tgid = BPF_CORE_READ(prev, tgid);
+	u64 prev_css = (u64)BPF_CORE_READ(prev, cgroups, subsys[cpu_cgrp_id]);
+	struct start_t s = {};
+
+	/* idle task, or a preempted task which is still runnable. */
+	if (tid == 0 || get_task_state(prev) == TASK_RUNNING)
+		return;
+
+	if (css != 0 && css != prev_css)
+		return;
+
+	if (pid != 0 && pid != tgid)
+		return;
+
+	s.ts = bpf_ktime_get_ns();
+	s.key.css = prev_css;
+	s.key.pid = tgid;
+	BPF_CORE_READ_STR_INTO(&s.key.name, prev, comm);
+
+	/* prev is still the current task in sched_switch. */
+	s.key.ustack_id = bpf_get_ustackid(ctx, &stacks);
+	s.key.kstack_id = bpf_get_kstackid(ctx, &stacks);
+
+	bpf_map_update_elem(&start, &tid, &s, COMPAT_BPF_ANY);
+}
+
+static __always_inline void record_switch_in(struct task_struct *next)
+{
+	u32 tid = BPF_CORE_READ(next, pid);
+	struct start_t *s;
+	u64 delta, *valp;
+
+	s = bpf_map_lookup_elem(&start, &tid);
+	if (!s)
+		return;
+
+	delta = bpf_ktime_get_ns() - s->ts;
+	if (delta >= min_block_ns) {
+		valp = bpf_map_lookup_elem(&counts, &s->key);
+		if (valp)
+			__sync_fetch_and_add(valp, delta);
+		else
+			bpf_map_update_elem(&counts, &s->key, &delta, COMPAT_BPF_ANY);
+	}
+
+	bpf_map_delete_elem(&start, &tid);
+}
+
+SEC("raw_tracepoint/sched_switch")
+int sched_switch_entry(struct bpf_raw_tracepoint_args *ctx)
+{
+	// TP_PROTO(bool preempt, struct task_struct *prev, struct task_struct
+	// *next)
+	struct task_struct *prev = (struct task_struct *)ctx->args[1];
+	struct task_struct *next = (struct task_struct *)ctx->args[2];
+
+	record_switch_out(ctx, prev);
+	record_switch_in(next);
+	return 0;
+}
@@ -5,17 +5,14 @@
 #include <bpf/bpf_tracing.h>
 
 #include "bpf_common.h"
+#include "bpf_event_pipe.h"
 #include "bpf_ratelimit.h"
 
 char __license[] SEC("license") = "Dual MIT/GPL";
 
 BPF_RATELIMIT_IN_MAP(rate, 1, COMPAT_CPU_NUM * 10000, 0);
 
-struct {
-	__uint(type, BPF_MAP_TYPE_PERF_EVENT_ARRAY);
-	__uint(key_size, sizeof(int));
-	__uint(value_size, sizeof(u32));
-} oom_perf_events SEC(".maps");
+BPF_EVENT_PIPE(oom_perf_events);
 
 struct oom_info {
 	char trigger_comm[COMPAT_TASK_COMM_LEN];
@@ -50,7 +47,6 @@ int BPF_KPROBE(oom_kill_process, struct oom_control *oc, const char *message)
 	info.trigger_memcg_css =
 	    (u64)BPF_CORE_READ(trigger_task, cgroups, subsys[memory_cgrp_id]);
 
-	bpf_perf_event_output(ctx, &oom_perf_events, COMPAT_BPF_F_CURRENT_CPU,
-			      &info, sizeof(info));
+	bpf_event_pipe_output(ctx, oom_perf_events, &info, sizeof(info));
 	return 0;
 }
@@ -6,23 +6,22 @@
 
 #include "bpf_common.h"
 #include "bpf_ratelimit.h"
+#include "bpf_stack.h"
 
 char __license[] SEC("license") = "Dual MIT/GPL";
 
 volatile const u64 css = 0;
 volatile const u64 pid = 0;
 
-#define PERF_STACK_DEPTH 20
-
 struct key_t {
-	u64 ustack[PERF_STACK_DEPTH];
-	u64 kstack[PERF_STACK_DEPTH];
-	s64 ustack_size;
-	s64 kstack_size;
+	s32 ustack_id;
+	s32 kstack_id;
 	u32 pid;
 	char name[COMPAT_TASK_COMM_LEN];
 };
 
+BPF_STACK_TRACE(stacks, STACK_TRACE_MAX_ENTRIES);
+
 struct {
 	__uint(type, BPF_MAP_TYPE_HASH);
 	__uint(key_size, sizeof(struct key_t));
@@ -45,9 +44,8 @@ int perf_event_sw_cpu_clock(struct pt_regs *ctx)
 	struct key_t key = {.pid = bpf_get_current_pid_tgid() >> 32};
 	bpf_get_current_comm(&key.name, sizeof(key.name));
 
-	key.ustack_size = bpf_get_stack(ctx, key.ustack, sizeof(key.ustack),
-					COMPAT_BPF_F_USER_STACK);
-	key.kstack_size = bpf_get_stack(ctx, key.kstack, sizeof(key.kstack), 0);
+	key.ustack_id = bpf_get_ustackid(ctx, &stacks);
+	key.kstack_id = bpf_get_kstackid(ctx, &stacks);
 
 	u64 *valp = bpf_map_lookup_elem(&counts, &key);
 	if (!valp) {
@@ -0,0 +1,142 @@
+#include "vmlinux.h"
+
+#include <bpf/bpf_core_read.h>
+#include <bpf/bpf_helpers.h>
+#include <bpf/bpf_tracing.h>
+
+#include "bpf_common.h"
+#include "bpf_stack.h"
+
+char __license[] SEC("license") = "Dual MIT/GPL";
+
+/* the same stack layout as perf.c, keyed by the cpu cgroup css in addition. */
+struct key_t {
+	u64 css;
+	s32 ustack_id;
+	s32 kstack_id;
+	u32 pid;
+	char name[COMPAT_TASK_COMM_LEN];
+};
+
+/*
+ * the counts and the stacks are double buffered, the agent switches the
+ * active ones and drains the others, so no samples are lost between the dump
+ * and the delete, and the stacks of the active counts are never cleared.
+ */
+BPF_STACK_TRACE(stacks_0, STACK_TRACE_MAX_ENTRIES);
+BPF_STACK_TRACE(stacks_1, STACK_TRACE_MAX_ENTRIES);
+
+struct {
+	__uint(type, BPF_MAP_TYPE_HASH);
+	__uint(key_size, sizeof(struct key_t));
+	__uint(value_size, sizeof(u64));
+	__uint(max_entries, 10240);
+} counts_0 SEC(".maps");
+
+struct {
+	__uint(type, BPF_MAP_TYPE_HASH);
+	__uint(key_size, sizeof(struct key_t));
+	__uint(value_size, sizeof(u64));
+	__uint(max_entries, 10240);
+} counts_1 SEC(".maps");
+
+/*
+ * ctl is written by the agent: one of every stride samples is recorded, zero
+ * pauses the profiler. It is how the agent caps the overhead without
+ * re-attaching the perf events. The samples are skipped once the measured
+ * cost reaches max_used_ns, the hard cap of the window.
+ */
+struct ctl_t {
+	u32 stride;
+	u32 active;
+	u64 max_used_ns;
+};
+
+struct {
+	__uint(type, BPF_MAP_TYPE_ARRAY);
+	__type(key, u32);
+	__type(value, struct ctl_t);
+	__uint(max_entries, 1);
+} profiling_ctl SEC(".maps");
+
+/* the total cost of the samples, in nanoseconds. */
+struct {
+	__uint(type, BPF_MAP_TYPE_ARRAY);
+	__type(key, u32);
+	__type(value, u64);
+	__uint(max_entries, 1);
+} profiling_used SEC(".maps");
+
+struct stats_t {
+	u64 ticks;
+	u64 samples;
+	u64 dropped;
+	u64 throttled;
+};
+
+struct {
+	__uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+	__type(key, u32);
+	__type(value, struct stats_t);
+	__uint(max_entries, 1);
+} profiling_stats SEC(".maps");
+
+static __always_inline void count(void *counts, struct key_t *key,
+				  struct stats_t *stats)
+{
+	u64 *valp = bpf_map_lookup_elem(counts, key);
+
+	if (!valp) {
+		u64 cnt = 1;
+		if (bpf_map_update_elem(counts, key, &cnt, COMPAT_BPF_ANY))
+			stats->dropped++;
+		return;
+	}
+
+	__sync_fetch_and_add(valp, 1);
+}
+
+SEC("perf_event/software/cpu_clock")
+int perf_event_sw_cpu_clock(struct pt_regs *ctx)
+{
+	u64 begin = bpf_ktime_get_ns();
+	u32 zero = 0;
+	struct ctl_t *ctl = bpf_map_lookup_elem(&profiling_ctl, &zero);
+	struct stats_t *stats = bpf_map_lookup_elem(&profiling_stats, &zero);
+	u64 *used = bpf_map_lookup_elem(&profiling_used, &zero);
+
+	if (!ctl || !stats || !used || ctl->stride == 0)
+		return 0;
+
+	/* percpu value, no atomic operation needed. */
+	if (stats->ticks++ % ctl->stride != 0)
+		return 0;
+
+	if (*used >= ctl->max_used_ns) {
+		stats->throttled++;
+		return 0;
+	}
+
+	struct task_struct *curr = (struct task_struct *)bpf_get_current_task();
+	struct key_t key = {
+		.css = (u64)BPF_CORE_READ(curr, cgroups, subsys[cpu_cgrp_id]),
+		.pid = bpf_get_current_pid_tgid() >> 32,
+	};
+
+	bpf_get_current_comm(&key.name, sizeof(key.name));
+	stats->samples++;
+
+	if (ctl->active) {
+		key.ustack_id = bpf_get_ustackid(ctx, &stacks_1);
+		key.kstack_id = bpf_get_kstackid(ctx, &stacks_1);
+		count(&counts_1, &key, stats);
+	} else {
+		key.ustack_id = bpf_get_ustackid(ctx, &stacks_0);
+		key.kstack_id = bpf_get_kstackid(ctx, &stacks_0);
+		count(&counts_0, &key, stats);
+	}
+
+	/* the skipped ticks above are cheap, only the samples are measured. */
+	__sync_fetch_and_add(used, bpf_ktime_get_ns() - begin);
+	return 0;
+}
@@ -0,0 +1,222 @@
+#include "vmlinux.h"
+
+#include <bpf/bpf_core_read.h>
+#include <bpf/bpf_helpers.h>
+
+#include "bpf_common.h"
+#include "bpf_event_pipe.h"
+#include "bpf_ratelimit.h"
+#include "bpf_stack.h"
+
+#define TASK_RUNNING 0
+
+char __license[] SEC("license") = "Dual MIT/GPL";
+
+// the wakeup-to-run latency of the outliers, in nanoseconds.
+volatile const u64 latency_thresh = 100 * NSEC_PER_MSEC;
+// at most one event of a container, the cpu cgroup, in the interval.
+volatile const u64 container_interval = 10ULL * 1000 * NSEC_PER_MSEC;
+// the kernel stacks of the waker and the delayed task.
+volatile const bool stacks_enabled = false;
+
+struct sched_latency_event_t {
+	u64 latency;
+	u64 css;	 // the delayed task
+	u64 running_css; // the task that was running
+	u32 pid;
+	u32 tgid;
+	u32 running_pid;
+	u32 running_tgid;
+	u32 cpu;
+	s32 waker_stack_id;
+	s32 stack_size; // in bytes, negative on errors.
+	u32 waker_pid;
+	char comm[COMPAT_TASK_COMM_LEN];
+	char running_comm[COMPAT_TASK_COMM_LEN];
+	char waker_comm[COMPAT_TASK_COMM_LEN];
+	// the kernel stack of the delayed task, where it blocked or was
+	// preempted, copied at the switch-in of the outliers only.
+	u64 stack[PERF_MIN_STACK_DEPTH];
+};
+
+// the wakeup of a task, the waker is 0 for the preempted tasks.
+struct enqueue_t {
+	u64 ts;
+	s32 waker_stack_id;
+	u32 waker_pid;
+	char waker_comm[COMPAT_TASK_COMM_LEN];
+};
+
+struct {
+	__uint(type, BPF_MAP_TYPE_HASH);
+	__type(key, u32);
+	__type(value, struct enqueue_t);
+	__uint(max_entries, 10240);
+} enqueues SEC(".maps");
+
+// the last event of the containers by the css.
+struct {
+	__uint(type, BPF_MAP_TYPE_LRU_HASH);
+	__type(key, u64);
+	__type(value, u64);
+	__uint(max_entries, 4096);
+} container_last SEC(".maps");
+
+BPF_EVENT_PIPE(sched_latency_events);
+BPF_STACK_TRACE(stacks, STACK_TRACE_EVENT_ENTRIES);
+
+// the ratelimit protects the event pipe from the storms of the containers.
+BPF_RATELIMIT(rate, 1, 100);
+
+struct task_struct___5_14 {
+	unsigned int __state;
+} __attribute__((preserve_access_index));
+
+static __always_inline long get_task_state(struct task_struct *task)
+{
+	if (bpf_core_field_exists(task->state))
+		return BPF_CORE_READ(task, state);
+
+	struct task_struct___5_14 *task_new = (struct task_struct___5_14 *)task;
+	return (long)BPF_CORE_READ(task_new, __state);
+}
+
+static __always_inline u64 task_cpu_css(struct task_struct *task)
+{
+	return (u64)BPF_CORE_READ(task, cgroups, subsys[cpu_cgrp_id]);
+}
+
+static __always_inline void trace_enqueue(void *ctx, u32 pid, bool wakeup)
+{
+	struct enqueue_t entry = {
+		.ts		= bpf_ktime_get_ns(),
+		.waker_stack_id = -1,
+	};
+
+	if (pid == 0)
+		return;
+
+	// the current task is the waker.
+	if (wakeup) {
+		entry.waker_pid = bpf_get_current_pid_tgid();
+		bpf_get_current_comm(&entry.waker_comm,
+				     sizeof(entry.waker_comm));
+		if (stacks_enabled)
+			entry.waker_stack_id = bpf_get_kstackid(ctx, &stacks);
+	}
+
+	bpf_map_update_elem(&enqueues, &pid, &entry, COMPAT_BPF_ANY);
+}
+
+SEC("raw_tracepoint/sched_wakeup")
+int sched_wakeup_prog(struct bpf_raw_tracepoint_args *ctx)
+{
+	// TP_PROTO(struct task_struct *p)
+	struct task_struct *p = (struct task_struct *)ctx->args[0];
+
+	trace_enqueue(ctx, BPF_CORE_READ(p, pid), true);
+	return 0;
+}
+
+SEC("raw_tracepoint/sched_wakeup_new")
+int sched_wakeup_new_prog(struct bpf_raw_tracepoint_args *ctx)
+{
+	// TP_PROTO(struct task_struct *p)
+	struct task_struct *p = (struct task_struct *)ctx->args[0];
+
+	trace_enqueue(ctx, BPF_CORE_READ(p, pid), true);
+	return 0;
+}
+
+// container_ratelimited reports whether the container has an event in the
+// interval, or updates the last event.
+static __always_inline bool container_ratelimited(u64 css, u64 now)
+{
+	u64 *last = bpf_map_lookup_elem(&container_last, &css);
+
+	if (last && now - *last < container_interval)
+		return true;
+
+	bpf_map_update_elem(&container_last, &css, &now, COMPAT_BPF_ANY);
+	return false;
+}
+
+SEC("raw_tracepoint/sched_switch")
+int sched_switch_prog(struct bpf_raw_tracepoint_args *ctx)
+{
+	// TP_PROTO(bool preempt, struct task_struct *prev, struct task_struct
+	// *next)
+	struct task_struct *prev = (struct task_struct *)ctx->args[1];
+	struct task_struct *next = (struct task_struct *)ctx->args[2];
+	struct sched_latency_event_t data = {};
+	struct enqueue_t *entry;
+	u32 prev_pid, next_pid;
+	u64 now, css;
+
+	// the preempted task is still runnable, it waits for the cpu again.
+	prev_pid = BPF_CORE_READ(prev, pid);
+	if (get_task_state(prev) == TASK_RUNNING)
+		trace_enqueue(ctx, prev_pid, false);
+
+	next_pid = BPF_CORE_READ(next, pid);
+	if (next_pid == 0)
+		return 0;
+
+	entry = bpf_map_lookup_elem(&enqueues, &next_pid);
+	if (!entry)
+		return 0;
+
+	now	     = bpf_ktime_get_ns();
+	data.latency = now - entry->ts;
+	if (data.latency < latency_thresh) {
+		bpf_map_delete_elem(&enqueues, &next_pid);
+		return 0;
+	}
+
+	css = task_cpu_css(next);
+	if (container_ratelimited(css, now) || bpf_ratelimited(&rate)) {
+		bpf_map_delete_elem(&enqueues, &next_pid);
+		return 0;
+	}
+
+	data.css	    = css;
+	data.pid	    = next_pid;
+	data.tgid	    = BPF_CORE_READ(next, tgid);
+	data.cpu	    = bpf_get_smp_processor_id();
+	data.waker_pid	    = entry->waker_pid;
+	data.waker_stack_id = entry->waker_stack_id;
+	__builtin_memcpy(data.waker_comm, entry->waker_comm,
+			 sizeof(data.waker_comm));
+	BPF_CORE_READ_STR_INTO(&data.comm, next, comm);
+	bpf_map_delete_elem(&enqueues, &next_pid);
+
+	// the current task is the one that was running.
+	data.running_css  = task_cpu_css(prev);
+	data.running_pid  = prev_pid;
+	data.running_tgid = BPF_CORE_READ(prev, tgid);
+	BPF_CORE_READ_STR_INTO(&data.running_comm, prev, comm);
+
+	// the next isn't running yet, its saved stack is where it blocked or
+	// was preempted before being delayed.
+	data.stack_size = -1;
+	if (stacks_enabled &&
+	    bpf_core_enum_value_exists(enum bpf_func_id,
+				       BPF_FUNC_get_task_stack))
+		data.stack_size =
+		    bpf_get_task_stack(next, data.stack, sizeof(data.stack), 0);
+
+	bpf_event_pipe_output(ctx, sched_latency_events, &data, sizeof(data));
+	return 0;
+}
+
+SEC("raw_tracepoint/sched_process_exit")
+int sched_process_exit_prog(struct bpf_raw_tracepoint_args *ctx)
+{
+	// TP_PROTO(struct task_struct *p)
+	struct task_struct *p = (struct task_struct *)ctx->args[0];
+	u32 pid		      = BPF_CORE_READ(p, pid);
+
+	// the woken tasks exit before running on the other cpus.
+	bpf_map_delete_elem(&enqueues, &pid);
+	return 0;
+}
@@ -5,10 +5,10 @@
 
 #include "bpf_common.h"
 #include "bpf_ratelimit.h"
+#include "bpf_stack.h"
 
 char __license[] SEC("license") = "Dual MIT/GPL";
 
-#define NR_STACK_TRACE_MAX 0x4000
 #define MSEC_PER_NSEC 1000000UL
 #define TICK_DEP_MASK_NONE 0
 #define SOFTIRQ_THRESH 5000000UL
@@ -26,8 +26,7 @@ struct timer_softirq_run_ts {
 };
 
 struct report_event {
-	u64 stack[PERF_MAX_STACK_DEPTH];
-	s64 stack_size;
+	s64 stack_id;
 	u64 now;
 	u64 stall_time;
 	char comm[COMPAT_TASK_COMM_LEN];
@@ -51,6 +50,8 @@ struct {
 	__uint(max_entries, 1);
 } report_map SEC(".maps");
 
+BPF_STACK_TRACE(stacks, STACK_TRACE_EVENT_ENTRIES);
+
 // the event map use for report userspace
 struct {
 	__uint(type, BPF_MAP_TYPE_PERF_EVENT_ARRAY);
@@ -108,8 +109,7 @@ void probe_account_process_tick(struct pt_regs *ctx)
 		bpf_get_current_comm(&event->comm, sizeof(event->comm));
 		event->pid = (u32)bpf_get_current_pid_tgid();
 		event->cpu = bpf_get_smp_processor_id();
-		event->stack_size =
-		    bpf_get_stack(ctx, event->stack, sizeof(event->stack), 0);
+		event->stack_id = bpf_get_kstackid(ctx, &stacks);
 
 		bpf_perf_event_output(ctx, &irqoff_event_map,
 				      COMPAT_BPF_F_CURRENT_CPU, event,
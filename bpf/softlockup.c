@@ -5,6 +5,7 @@
 #include <bpf/bpf_tracing.h>
 
 #include "bpf_common.h"
+#include "bpf_event_pipe.h"
 #include "bpf_ratelimit.h"
 #include "linux_kernel.h"
 
@@ -12,11 +13,7 @@ char __license[] SEC("license") = "Dual MIT/GPL";
 
 BPF_RATELIMIT_IN_MAP(rate, 1, COMPAT_CPU_NUM * 10000, 0);
 
-struct {
-	__uint(type, BPF_MAP_TYPE_PERF_EVENT_ARRAY);
-	__uint(key_size, sizeof(int));
-	__uint(value_size, sizeof(u32));
-} softlockup_perf_events SEC(".maps");
+BPF_EVENT_PIPE(softlockup_perf_events);
 
 struct softlockup_info {
 	u32 cpu;
@@ -41,7 +38,6 @@ int kprobe_softlockup(struct pt_regs *ctx)
 
 	struct task_struct *task = (struct task_struct *)bpf_get_current_task();
 	BPF_CORE_READ_STR_INTO(&info.comm, task, comm);
-	bpf_perf_event_output(ctx, &softlockup_perf_events,
-			      COMPAT_BPF_F_CURRENT_CPU, &info, sizeof(info));
+	bpf_event_pipe_output(ctx, softlockup_perf_events, &info, sizeof(info));
 	return 0;
 }
@@ -0,0 +1,142 @@
+#include "vmlinux.h"
+
+#include <bpf/bpf_core_read.h>
+#include <bpf/bpf_endian.h>
+#include <bpf/bpf_helpers.h>
+
+#include "bpf_common.h"
+#include "bpf_event_pipe.h"
+#include "bpf_ratelimit.h"
+#include "vmlinux_net.h"
+
+#define TYPE_TCP_RETRANSMIT 1
+#define TYPE_TCP_SEND_RESET 2
+#define TYPE_TCP_RECEIVE_RESET 3
+
+// the local side is the source, the addresses and the ports are in the
+// network order, the IPv4 addresses are in the first 4 bytes.
+struct tcp_event_t {
+	u64 tgid_pid;
+	u32 netns_inode;
+	u16 family;
+	u16 pad0;
+	u8 saddr[16];
+	u8 daddr[16];
+	u16 sport;
+	u16 dport;
+	u32 srtt_us;
+	u32 total_retrans;
+	u8 retransmits;
+	u8 state;
+	u8 type;
+	u8 pad1;
+	char comm[COMPAT_TASK_COMM_LEN];
+};
+
+BPF_EVENT_PIPE(tcp_events);
+
+char __license[] SEC("license") = "Dual MIT/GPL";
+
+// the events are counted in the userspace, the ratelimit only protects the
+// event pipe from the storms.
+BPF_RATELIMIT(rate, 1, 10000);
+
+static __always_inline void tcp_event_fill_sock(struct tcp_event_t *data,
+						struct sock *sk)
+{
+	struct sock_common *skc = &sk->__sk_common;
+	struct tcp_sock *tp	= (struct tcp_sock *)sk;
+
+	data->family = BPF_CORE_READ(skc, skc_family);
+	if (data->family == AF_INET6) {
+		BPF_CORE_READ_INTO(&data->saddr, skc, skc_v6_rcv_saddr);
+		BPF_CORE_READ_INTO(&data->daddr, skc, skc_v6_daddr);
+	} else {
+		BPF_CORE_READ_INTO(&data->saddr, skc, skc_rcv_saddr);
+		BPF_CORE_READ_INTO(&data->daddr, skc, skc_daddr);
+	}
+
+	data->sport	  = bpf_htons(BPF_CORE_READ(skc, skc_num));
+	data->dport	  = BPF_CORE_READ(skc, skc_dport);
+	data->state	  = BPF_CORE_READ(skc, skc_state);
+	data->netns_inode = BPF_CORE_READ(skc, skc_net.net, ns.inum);
+
+	// the time wait and the request socks are not the full tcp socks.
+	if (data->state == TCP_TIME_WAIT || data->state == TCP_NEW_SYN_RECV)
+		return;
+
+	data->srtt_us	    = BPF_CORE_READ(tp, srtt_us) >> 3;
+	data->total_retrans = BPF_CORE_READ(tp, total_retrans);
+	data->retransmits   = BPF_CORE_READ(tp, inet_conn.icsk_retransmits);
+}
+
+// tcp_event_fill_skb fills the event by the received skb without the sock,
+// e.g. the reset of a closed port, the local side is the destination.
+static __always_inline int tcp_event_fill_skb(struct tcp_event_t *data,
+					      struct sk_buff *skb)
+{
+	u16 protocol = bpf_ntohs(BPF_CORE_READ(skb, protocol));
+	struct tcphdr tcphdr;
+	u8 l4_protocol = 0;
+
+	data->family = skb_ip_addrs(skb, protocol, data->daddr, data->saddr,
+				    &l4_protocol);
+	if (!data->family || l4_protocol != IPPROTO_TCP)
+		return -1;
+
+	bpf_probe_read(&tcphdr, sizeof(tcphdr), skb_transport_header(skb));
+
+	data->sport	  = tcphdr.dest;
+	data->dport	  = tcphdr.source;
+	data->netns_inode = BPF_CORE_READ(skb, dev, nd_net.net, ns.inum);
+	return 0;
+}
+
+static __always_inline void tcp_event_output(void *ctx, struct sock *sk,
+					     struct sk_buff *skb, u8 type)
+{
+	struct tcp_event_t data = {};
+
+	if (sk) {
+		u16 family = BPF_CORE_READ(sk, __sk_common.skc_family);
+
+		if (family != AF_INET && family != AF_INET6)
+			return;
+		tcp_event_fill_sock(&data, sk);
+	} else if (!skb || tcp_event_fill_skb(&data, skb) < 0) {
+		return;
+	}
+
+	if (bpf_ratelimited(&rate))
+		return;
+
+	data.type     = type;
+	data.tgid_pid = bpf_get_current_pid_tgid();
+	bpf_get_current_comm(&data.comm, sizeof(data.comm));
+
+	bpf_event_pipe_output(ctx, tcp_events, &data, sizeof(data));
+}
+
+SEC("tracepoint/tcp/tcp_retransmit_skb")
+int bpf_tcp_retransmit_skb_prog(struct trace_event_raw_tcp_event_sk_skb *ctx)
+{
+	tcp_event_output(ctx, (struct sock *)ctx->skaddr, NULL,
+			 TYPE_TCP_RETRANSMIT);
+	return 0;
+}
+
+SEC("tracepoint/tcp/tcp_send_reset")
+int bpf_tcp_send_reset_prog(struct trace_event_raw_tcp_event_sk_skb *ctx)
+{
+	tcp_event_output(ctx, (struct sock *)ctx->skaddr,
+			 (struct sk_buff *)ctx->skbaddr, TYPE_TCP_SEND_RESET);
+	return 0;
+}
+
+SEC("tracepoint/tcp/tcp_receive_reset")
+int bpf_tcp_receive_reset_prog(struct trace_event_raw_tcp_event_sk *ctx)
+{
+	tcp_event_output(ctx, (struct sock *)ctx->skaddr, NULL,
+			 TYPE_TCP_RECEIVE_RESET);
+	return 0;
+}
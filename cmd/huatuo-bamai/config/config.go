@@ -55,7 +55,8 @@ type BamaiConfig struct {
 	}
 
 	Task struct {
-		MaxRunningTask int `default:"10"`
+		MaxRunningTask int    `default:"10"`
+		ArtifactDir    string `default:"/var/lib/huatuo-bamai/artifacts"`
 	}
 
 	EventsWatch struct {
@@ -70,6 +71,27 @@ type BamaiConfig struct {
 		DockerAPIVersion      string `default:"1.24"`
 	}
 
+	Symbol struct {
+		SourceLines bool
+		Vmlinux     string
+	}
+
+	BPF struct {
+		StackDepth          int `default:"127"`
+		EventsLostThreshold uint64
+		RuntimeStats        bool
+		BTFPath             string
+		PinPath             string
+		PinHandover         bool
+	}
+
+	Governor struct {
+		Interval      int `default:"10"`
+		MaxEventRate  uint64
+		MaxCPUPercent int
+		PauseSeconds  int `default:"300"`
+	}
+
 	AutoTracing     autotracing.Config
 	EventTracing    events.Config
 	MetricCollector collector.Config
@@ -0,0 +1,283 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"huatuo-bamai/internal/flamegraph"
+	"huatuo-bamai/internal/server"
+	"huatuo-bamai/internal/server/response"
+	"huatuo-bamai/internal/storage/driver"
+	"huatuo-bamai/pkg/tracing"
+)
+
+// maxDiffDocuments is the max number of documents aggregated into one side
+// of a diff.
+const maxDiffDocuments = 100
+
+type FlamegraphHandler struct {
+	Handlers []server.Handle
+}
+
+func NewFlamegraphHandler() *FlamegraphHandler {
+	h := &FlamegraphHandler{}
+	h.Handlers = []server.Handle{
+		{Typ: server.HttpPost, Uri: "/diff", Handle: h.diff},
+	}
+	return h
+}
+
+// DiffProfileSource selects the profiles of one side of a diff, either one
+// document by id, or all documents of the tracer in the time range.
+type DiffProfileSource struct {
+	DocumentID  string    `json:"document_id,omitempty"`
+	TracerName  string    `json:"tracer_name,omitempty"`
+	Hostname    string    `json:"hostname,omitempty"`
+	ContainerID string    `json:"container_id,omitempty"`
+	Start       time.Time `json:"start,omitempty"`
+	End         time.Time `json:"end,omitempty"`
+	// OffCPU selects the off-cpu profiles of the documents, e.g. of cpuidle.
+	OffCPU bool `json:"offcpu,omitempty"`
+}
+
+// DiffReq is the request of the differential flamegraph. The filters of the
+// baseline default to the ones of the target, so comparing the same tracer
+// in two time ranges only needs the baseline start and end.
+type DiffReq struct {
+	Baseline DiffProfileSource `json:"baseline"`
+	Target   DiffProfileSource `json:"target"`
+}
+
+func (s *DiffProfileSource) inherit(from *DiffProfileSource) {
+	if s.DocumentID != "" {
+		return
+	}
+
+	if s.TracerName == "" {
+		s.TracerName = from.TracerName
+	}
+	if s.Hostname == "" {
+		s.Hostname = from.Hostname
+	}
+	if s.ContainerID == "" {
+		s.ContainerID = from.ContainerID
+	}
+	if !s.OffCPU {
+		s.OffCPU = from.OffCPU
+	}
+}
+
+func (s *DiffProfileSource) validate() error {
+	if s.DocumentID != "" {
+		return nil
+	}
+
+	if s.TracerName == "" {
+		return errors.New("document_id or tracer_name is required")
+	}
+	if s.Start.IsZero() || s.End.IsZero() || !s.Start.Before(s.End) {
+		return errors.New("invalid time range")
+	}
+	return nil
+}
+
+func (s *DiffProfileSource) query() driver.Query {
+	q := driver.Query{
+		Filters: []driver.Filter{
+			{Field: "tracer_name", Op: driver.OpEq, Value: s.TracerName},
+			{Field: "tracer_time", Op: driver.OpGte, Value: s.Start.UTC()},
+			{Field: "tracer_time", Op: driver.OpLte, Value: s.End.UTC()},
+		},
+		Sorts: []driver.Sort{{Field: "tracer_time", Desc: true}},
+		Limit: maxDiffDocuments,
+	}
+
+	if s.Hostname != "" {
+		q.Filters = append(q.Filters, driver.Filter{Field: "hostname", Op: driver.OpEq, Value: s.Hostname})
+	}
+	if s.ContainerID != "" {
+		q.Filters = append(q.Filters, driver.Filter{Field: "container_id", Op: driver.OpEq, Value: s.ContainerID})
+	}
+	return q
+}
+
+func (s *DiffProfileSource) documents(ctx *server.Context) ([]*tracing.Document, error) {
+	if s.DocumentID != "" {
+		document, err := tracing.GetDocument(ctx.Request().Context(), s.DocumentID)
+		if err != nil {
+			return nil, err
+		}
+		return []*tracing.Document{document}, nil
+	}
+
+	documents, err := tracing.QueryDocuments(ctx.Request().Context(), s.query())
+	if err != nil {
+		return nil, err
+	}
+	if len(documents) == 0 {
+		return nil, driver.ErrNotFound
+	}
+	return documents, nil
+}
+
+// documentProfile is the profile data of cpu tracers in a tracing document,
+// the on-cpu or the off-cpu one.
+type documentProfile struct {
+	Pprof     []byte                 `json:"pprof"`
+	FlameData []flamegraph.FrameData `json:"flamedata"`
+}
+
+// documentOffCPUProfile is the off-cpu profile data in a tracing document.
+type documentOffCPUProfile struct {
+	Pprof     []byte                 `json:"offcpu_pprof"`
+	FlameData []flamegraph.FrameData `json:"offcpu_flamedata"`
+}
+
+func parseDocumentProfile(document *tracing.Document, offCPU bool) (*documentProfile, error) {
+	// TracerData is a map once read back from the store, go through json
+	// to get the typed fields, []byte is base64 encoded in json.
+	data, err := json.Marshal(document.TracerData)
+	if err != nil {
+		return nil, err
+	}
+
+	var p documentProfile
+	if offCPU {
+		var off documentOffCPUProfile
+		err = json.Unmarshal(data, &off)
+		p = documentProfile(off)
+	} else {
+		err = json.Unmarshal(data, &p)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("document %s: %w", document.TracerID, err)
+	}
+
+	if len(p.Pprof) == 0 && len(p.FlameData) == 0 {
+		return nil, fmt.Errorf("document %s: no profile data", document.TracerID)
+	}
+	return &p, nil
+}
+
+func documentStacks(p *documentProfile, usePprof bool) ([]flamegraph.WeightedStack, error) {
+	if !usePprof {
+		return flamegraph.FrameDataStacks(p.FlameData)
+	}
+
+	prof, err := flamegraph.ReadProfileGzip(bytes.NewReader(p.Pprof))
+	if err != nil {
+		return nil, err
+	}
+	return flamegraph.ProfileStacks(prof, flamegraph.TimeSampleIndex(prof))
+}
+
+// profileStacks aggregates the stacks of the profiles, from the pprof data
+// if all profiles have it, otherwise from the flamegraph data.
+func profileStacks(profiles []*documentProfile, usePprof bool) ([]flamegraph.WeightedStack, error) {
+	var stacks []flamegraph.WeightedStack
+
+	for _, p := range profiles {
+		s, err := documentStacks(p, usePprof)
+		if err != nil {
+			return nil, err
+		}
+		stacks = append(stacks, s...)
+	}
+
+	return stacks, nil
+}
+
+func allPprof(profiles ...[]*documentProfile) bool {
+	for _, side := range profiles {
+		for _, p := range side {
+			if len(p.Pprof) == 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (h *FlamegraphHandler) loadProfiles(ctx *server.Context, source *DiffProfileSource) ([]*documentProfile, error) {
+	documents, err := source.documents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	profiles := make([]*documentProfile, 0, len(documents))
+	for _, document := range documents {
+		p, err := parseDocumentProfile(document, source.OffCPU)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, p)
+	}
+
+	return profiles, nil
+}
+
+func diffError(side string, err error) error {
+	switch {
+	case errors.Is(err, driver.ErrNotFound):
+		return response.ErrNotFound.WithMessage(side + " profile not found")
+	case errors.Is(err, tracing.ErrNoQueryableStore):
+		return response.ErrInvalidRequest.WithMessage(err.Error())
+	default:
+		return response.ErrInternal.WithMessage(fmt.Sprintf("%s: %v", side, err))
+	}
+}
+
+func (h *FlamegraphHandler) diff(ctx *server.Context) error {
+	var req DiffReq
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		handleBindError(ctx, err)
+		return nil
+	}
+
+	req.Baseline.inherit(&req.Target)
+	if err := req.Target.validate(); err != nil {
+		return response.ErrInvalidRequest.WithMessage("target: " + err.Error())
+	}
+	if err := req.Baseline.validate(); err != nil {
+		return response.ErrInvalidRequest.WithMessage("baseline: " + err.Error())
+	}
+
+	baseline, err := h.loadProfiles(ctx, &req.Baseline)
+	if err != nil {
+		return diffError("baseline", err)
+	}
+	target, err := h.loadProfiles(ctx, &req.Target)
+	if err != nil {
+		return diffError("target", err)
+	}
+
+	usePprof := allPprof(baseline, target)
+	left, err := profileStacks(baseline, usePprof)
+	if err != nil {
+		return diffError("baseline", err)
+	}
+	right, err := profileStacks(target, usePprof)
+	if err != nil {
+		return diffError("target", err)
+	}
+
+	response.Success(ctx, flamegraph.Diff(left, right))
+	return nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"huatuo-bamai/internal/flamegraph"
+	"huatuo-bamai/pkg/tracing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffProfileSource_InheritAndValidate(t *testing.T) {
+	now := time.Now()
+	target := DiffProfileSource{TracerName: "cpusys", Hostname: "node-1", Start: now.Add(-time.Minute), End: now, OffCPU: true}
+	baseline := DiffProfileSource{Start: now.Add(-time.Hour), End: now.Add(-time.Hour + time.Minute)}
+
+	baseline.inherit(&target)
+	require.Equal(t, "cpusys", baseline.TracerName)
+	require.Equal(t, "node-1", baseline.Hostname)
+	require.True(t, baseline.OffCPU)
+	require.NoError(t, baseline.validate())
+
+	q := baseline.query()
+	require.Len(t, q.Filters, 4)
+	require.Equal(t, maxDiffDocuments, q.Limit)
+
+	require.Error(t, (&DiffProfileSource{TracerName: "cpusys", Start: now, End: now}).validate())
+	require.Error(t, (&DiffProfileSource{}).validate())
+	require.NoError(t, (&DiffProfileSource{DocumentID: "id"}).validate())
+}
+
+func TestParseDocumentProfile(t *testing.T) {
+	// documents read back from the store carry the tracer data as a map.
+	raw, err := json.Marshal(map[string]any{
+		"pprof":     []byte{0x1f, 0x8b},
+		"flamedata": []flamegraph.FrameData{{Level: 0, Label: "total", Value: 1}},
+	})
+	require.NoError(t, err)
+
+	var data map[string]any
+	require.NoError(t, json.Unmarshal(raw, &data))
+
+	p, err := parseDocumentProfile(&tracing.Document{TracerID: "id", TracerData: data}, false)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x1f, 0x8b}, p.Pprof)
+	require.Len(t, p.FlameData, 1)
+
+	_, err = parseDocumentProfile(&tracing.Document{TracerID: "id", TracerData: map[string]any{}}, false)
+	require.Error(t, err)
+
+	// the off-cpu profile of the cpuidle documents.
+	_, err = parseDocumentProfile(&tracing.Document{TracerID: "id", TracerData: data}, true)
+	require.Error(t, err)
+
+	data["offcpu_flamedata"] = data["flamedata"]
+	off, err := parseDocumentProfile(&tracing.Document{TracerID: "id", TracerData: data}, true)
+	require.NoError(t, err)
+	require.Empty(t, off.Pprof)
+	require.Len(t, off.FlameData, 1)
+
+	require.False(t, allPprof([]*documentProfile{p}, []*documentProfile{{}}))
+}
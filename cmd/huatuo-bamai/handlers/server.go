@@ -39,6 +39,7 @@ func Start(addr string, mgrTracing *tracing.TracingManager, promReg *prometheus.
 
 	s.MustRegisterRoutes("/tasks", NewTaskHandler().Handlers)
 	s.MustRegisterRoutes("/tracers", NewTracerHandler(mgrTracing).Handlers)
+	s.MustRegisterRoutes("/flamegraph", NewFlamegraphHandler().Handlers)
 	s.MustRegisterRoutes("", NewContainerHandler().Handlers)
 	s.MustRegisterRoutes("", NewConfigHandler().Handlers)
 	evtCfg := config.Get().EventsWatch
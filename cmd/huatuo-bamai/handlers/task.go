@@ -37,6 +37,8 @@ func NewTaskHandler() *TaskHandler {
 		{Typ: server.HttpPost, Uri: "", Handle: h.create},
 		{Typ: server.HttpGet, Uri: "/:id", Handle: h.get},
 		{Typ: server.HttpDelete, Uri: "/:id", Handle: h.stop},
+		{Typ: server.HttpGet, Uri: "/:id/artifacts", Handle: h.listArtifacts},
+		{Typ: server.HttpGet, Uri: "/:id/artifacts/:name", Handle: h.downloadArtifact},
 	}
 	return h
 }
@@ -93,6 +95,10 @@ func (h *TaskHandler) get(ctx *server.Context) error {
 		responseData["error"] = result.TaskErr.Error()
 	}
 
+	if len(result.TaskArtifacts) > 0 {
+		responseData["artifacts"] = result.TaskArtifacts
+	}
+
 	response.Success(ctx, responseData)
 	return nil
 }
@@ -113,3 +119,49 @@ func (h *TaskHandler) stop(ctx *server.Context) error {
 	ctx.Status(http.StatusNoContent)
 	return nil
 }
+
+func (h *TaskHandler) listArtifacts(ctx *server.Context) error {
+	taskID := ctx.Param("id")
+	if taskID == "" {
+		return response.ErrInvalidRequest.WithMessage("missing task id")
+	}
+
+	artifacts, err := tracing.TaskArtifacts(taskID)
+	if err != nil {
+		if errors.Is(err, tracing.ErrTaskNotFound) {
+			return response.ErrNotFound.WithMessage("task not found")
+		}
+		return response.ErrInternal.WithMessage(err.Error())
+	}
+
+	if artifacts == nil {
+		artifacts = []tracing.TaskArtifact{}
+	}
+
+	response.Success(ctx, artifacts)
+	return nil
+}
+
+func (h *TaskHandler) downloadArtifact(ctx *server.Context) error {
+	taskID, name := ctx.Param("id"), ctx.Param("name")
+	if taskID == "" || name == "" {
+		return response.ErrInvalidRequest.WithMessage("missing task id or artifact name")
+	}
+
+	path, artifact, err := tracing.TaskArtifactPath(taskID, name)
+	if err != nil {
+		switch {
+		case errors.Is(err, tracing.ErrArtifactInvalidName):
+			return response.ErrInvalidRequest.WithMessage(err.Error())
+		case errors.Is(err, tracing.ErrTaskNotFound):
+			return response.ErrNotFound.WithMessage("task not found")
+		case errors.Is(err, tracing.ErrArtifactNotFound):
+			return response.ErrNotFound.WithMessage("artifact not found")
+		}
+		return response.ErrInternal.WithMessage(err.Error())
+	}
+
+	ctx.Header("X-Artifact-Sha256", artifact.SHA256)
+	ctx.FileAttachment(path, artifact.Name)
+	return nil
+}
@@ -31,6 +31,7 @@ func NewTracerHandler(mgrTracing *tracing.TracingManager) *TracerHandler {
 	h := &TracerHandler{tracingManager: mgrTracing}
 	h.Handlers = []server.Handle{
 		{Typ: server.HttpGet, Uri: "", Handle: h.list},
+		{Typ: server.HttpGet, Uri: "/:name", Handle: h.get},
 		{Typ: server.HttpPut, Uri: "/:name/start", Handle: h.start},
 		{Typ: server.HttpPut, Uri: "/:name/stop", Handle: h.stop},
 	}
@@ -42,6 +43,21 @@ func (h *TracerHandler) list(ctx *server.Context) error {
 	return nil
 }
 
+func (h *TracerHandler) get(ctx *server.Context) error {
+	name := ctx.Param("name")
+	if name == "" {
+		return response.ErrInvalidRequest.WithMessage("missing tracer name")
+	}
+
+	info, err := h.tracingManager.Info(name)
+	if err != nil {
+		return response.ErrNotFound.WithMessage(err.Error())
+	}
+
+	response.Success(ctx, info)
+	return nil
+}
+
 func (h *TracerHandler) start(ctx *server.Context) error {
 	name := ctx.Param("name")
 	if name == "" {
@@ -15,6 +15,9 @@
 package handlers
 
 import (
+	"strconv"
+
+	"huatuo-bamai/internal/bpf"
 	"huatuo-bamai/pkg/metric"
 	"huatuo-bamai/pkg/tracing"
 )
@@ -63,5 +66,43 @@ func (trace *tracingHitCollector) Update() ([]*metric.Data, error) {
 	}
 
 	hitMetric = append(hitMetric, metric.NewGaugeData("running", float64(runningTracers), "running tracing number", nil))
+
+	// the lost events of the tracers, and of the other bpf objects by the
+	// object names.
+	for owner, lost := range bpf.AllLostEvents() {
+		for cpu, count := range lost.PerCPU {
+			hitMetric = append(hitMetric, metric.NewCounterData(
+				"events_lost",
+				float64(count),
+				"events lost by the bpf event pipes",
+				map[string]string{"tracing": owner, "cpu": strconv.Itoa(cpu)},
+			))
+		}
+	}
+
+	// the stacks lost by the stack trace maps, e.g. the collisions of the
+	// buckets.
+	for owner, count := range bpf.AllStackErrors() {
+		hitMetric = append(hitMetric, metric.NewCounterData(
+			"stack_errors",
+			float64(count),
+			"stacks lost by the bpf stack trace maps",
+			map[string]string{"tracing": owner},
+		))
+	}
+
+	// the runtime stats of the bpf programs, by the tracers loading them.
+	for object, programs := range bpf.AllProgramStats() {
+		tracer := tracing.BpfObjectTracing(object)
+		for _, prog := range programs {
+			labels := map[string]string{"tracing": tracer, "program": prog.Name}
+			hitMetric = append(hitMetric,
+				metric.NewCounterData("bpf_run_time_ns", float64(prog.RunTimeNs),
+					"runtime of the bpf program in nanoseconds", labels),
+				metric.NewCounterData("bpf_run_count", float64(prog.RunCount),
+					"run count of the bpf program", labels),
+			)
+		}
+	}
 	return hitMetric, nil
 }
@@ -29,6 +29,7 @@ import (
 	"huatuo-bamai/cmd/huatuo-bamai/handlers"
 	_ "huatuo-bamai/core/autotracing"
 	_ "huatuo-bamai/core/events"
+	"huatuo-bamai/core/events/generic"
 	_ "huatuo-bamai/core/metrics"
 	"huatuo-bamai/internal/bpf"
 	"huatuo-bamai/internal/cgroups"
@@ -38,6 +39,7 @@ import (
 	"huatuo-bamai/internal/procfs"
 	"huatuo-bamai/internal/storage"
 	"huatuo-bamai/internal/storage/driver"
+	"huatuo-bamai/internal/symbol"
 	"huatuo-bamai/internal/utils/executil"
 	"huatuo-bamai/pkg/tracing"
 
@@ -83,7 +85,13 @@ func mainAction(ctx *cli.Context) error {
 		}
 	}
 
-	if err := bpf.NewManager(&bpf.Option{}); err != nil {
+	if err := bpf.NewManager(&bpf.Option{
+		StackDepth:   config.Get().BPF.StackDepth,
+		RuntimeStats: config.Get().BPF.RuntimeStats,
+		BTFPath:      config.Get().BPF.BTFPath,
+		PinPath:      config.Get().BPF.PinPath,
+		PinHandover:  config.Get().BPF.PinHandover,
+	}); err != nil {
 		return fmt.Errorf("failed to init bpf manager: %w", err)
 	}
 
@@ -99,6 +107,10 @@ func mainAction(ctx *cli.Context) error {
 		return fmt.Errorf("init podlist and sync module: %w", err)
 	}
 
+	if err := generic.Register(config.Get().EventTracing.Generic.Dir); err != nil {
+		return fmt.Errorf("register generic tracers: %w", err)
+	}
+
 	blacklisted := config.Get().BlackList
 	prom, err := InitMetricsCollector(blacklisted, config.Region)
 	if err != nil {
@@ -114,6 +126,15 @@ func mainAction(ctx *cli.Context) error {
 		return err
 	}
 
+	if err := mgr.StartGovernor(tracing.GovernorOption{
+		Interval:      time.Duration(config.Get().Governor.Interval) * time.Second,
+		MaxEventRate:  float64(config.Get().Governor.MaxEventRate),
+		MaxCPUPercent: float64(config.Get().Governor.MaxCPUPercent),
+		PauseDuration: time.Duration(config.Get().Governor.PauseSeconds) * time.Second,
+	}); err != nil {
+		return fmt.Errorf("start governor: %w", err)
+	}
+
 	handlers.Start(config.Get().APIServer.TCPAddr, mgr, prom)
 
 	// update cpu quota
@@ -137,6 +158,8 @@ func mainAction(ctx *cli.Context) error {
 		switch s {
 		case syscall.SIGQUIT, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM:
 			log.Infof("huatuo-bamai exited by signal %d", s)
+			// the pinned bpf objects are reused by the next agent.
+			bpf.KeepPinned()
 			_ = mgr.Stop()
 			bpf.Close()
 			pod.ManagerRelease()
@@ -327,6 +350,21 @@ func main() {
 			return fmt.Errorf("load config: %w", err)
 		}
 
+		if dir := config.Get().Task.ArtifactDir; dir != "" {
+			absDir, err := filepath.Abs(dir)
+			if err != nil {
+				return fmt.Errorf("task artifact dir %s: %w", dir, err)
+			}
+			tracing.TaskArtifactDir = absDir
+		}
+		tracing.SweepTaskArtifacts()
+
+		tracing.EventsLostThreshold = config.Get().BPF.EventsLostThreshold
+
+		if config.Get().Symbol.SourceLines {
+			symbol.EnableSourceLines(config.Get().Symbol.Vmlinux)
+		}
+
 		// set Region
 		config.Region = ctx.String("region")
 
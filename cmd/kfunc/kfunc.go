@@ -0,0 +1,309 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"huatuo-bamai/internal/bpf"
+	"huatuo-bamai/internal/command/container"
+	"huatuo-bamai/internal/kfunc"
+	"huatuo-bamai/internal/log"
+	"huatuo-bamai/internal/symbol"
+	"huatuo-bamai/pkg/types"
+)
+
+const (
+	maxDuration  = 300
+	maxRateLimit = 1000
+	maxEvents    = 10000
+)
+
+// document is the task document of the capture.
+type document struct {
+	Function    string         `json:"function"`
+	Probe       string         `json:"probe"`
+	Return      bool           `json:"return"`
+	Fields      []string       `json:"fields"`
+	ContainerID string         `json:"container_id,omitempty"`
+	PID         uint32         `json:"pid,omitempty"`
+	StartTime   time.Time      `json:"start_time"`
+	Duration    float64        `json:"duration_seconds"`
+	Events      []*kfunc.Event `json:"events"`
+	// Dropped are the events over the rate limit.
+	Dropped uint64 `json:"dropped"`
+	// Truncated is set when the events reach the max events.
+	Truncated bool `json:"truncated"`
+}
+
+// rateLimiter drops the events over the limit in a second, the bpf limits the
+// events the same way, but the cpus race on the counter.
+type rateLimiter struct {
+	limit  uint32
+	window time.Time
+	count  uint32
+}
+
+func (r *rateLimiter) allow(now time.Time) bool {
+	if now.Sub(r.window) >= time.Second {
+		r.window, r.count = now, 0
+	}
+	if r.count >= r.limit {
+		return false
+	}
+	r.count++
+	return true
+}
+
+func checkLimit(name string, value, limit int) error {
+	if value <= 0 || value > limit {
+		return fmt.Errorf("%s must be in (0, %d]", name, limit)
+	}
+	return nil
+}
+
+func mainAction(ctx *cli.Context) error {
+	optDuration := ctx.Int("duration")
+	optRateLimit := ctx.Int("rate-limit")
+	optMaxEvents := ctx.Int("max-events")
+	serverAddress := ctx.String("server-address")
+
+	if err := checkLimit("duration", optDuration, maxDuration); err != nil {
+		return err
+	}
+	if err := checkLimit("rate-limit", optRateLimit, maxRateLimit); err != nil {
+		return err
+	}
+	if err := checkLimit("max-events", optMaxEvents, maxEvents); err != nil {
+		return err
+	}
+
+	opts := &kfunc.Options{
+		Function:  ctx.String("function"),
+		Probe:     ctx.String("probe"),
+		Return:    ctx.Bool("return"),
+		Fields:    ctx.StringSlice("field"),
+		PID:       uint32(ctx.Uint("pid")),
+		Stack:     ctx.Bool("stack"),
+		RateLimit: uint32(optRateLimit),
+	}
+
+	containerID := ctx.String("container-id")
+	if containerID != "" {
+		c, err := container.GetContainerByID(serverAddress, containerID)
+		if err != nil {
+			return err
+		}
+		opts.Css = c.CgroupCss["cpu"]
+	}
+
+	if err := bpf.NewManager(&bpf.Option{
+		KeepaliveTimeout: optDuration,
+		StackDepth:       ctx.Int("stack-depth"),
+	}); err != nil {
+		return fmt.Errorf("init bpf err %w", err)
+	}
+	defer bpf.Close()
+
+	spec, err := bpf.KernelBTF()
+	if err != nil {
+		return fmt.Errorf("kernel btf err %w", err)
+	}
+
+	prog, err := kfunc.New(spec, opts)
+	if err != nil {
+		return err
+	}
+
+	b, err := bpf.LoadBpfFromSpec("kfunc", prog.CollectionSpec(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to load bpf: %w", err)
+	}
+	defer b.Close()
+
+	var kstacks *bpf.StackTraces[[]string]
+	if opts.Stack {
+		kstacks, err = bpf.NewStackTraces(b, kfunc.StacksMap, func(addrs []uint64) []string {
+			return symbol.DumpKernelBackTrace(addrs, bpf.StackDepth()).BackTrace
+		})
+		if err != nil {
+			return err
+		}
+		kstacks.EnableAging()
+	}
+
+	readCtx, cancel := context.WithTimeout(ctx.Context, time.Duration(optDuration)*time.Second)
+	defer cancel()
+
+	signalWait := make(chan os.Signal, 1)
+	signal.Notify(signalWait, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-signalWait:
+			cancel()
+		case <-readCtx.Done():
+		}
+	}()
+
+	reader, err := b.AttachAndEventPipe(readCtx, kfunc.EventsMap, 8192)
+	if err != nil {
+		return fmt.Errorf("attach err %w", err)
+	}
+	defer reader.Close()
+
+	doc := &document{
+		Function:    opts.Function,
+		Probe:       opts.Probe,
+		Return:      opts.Return,
+		Fields:      opts.Fields,
+		ContainerID: containerID,
+		PID:         opts.PID,
+		StartTime:   time.Now(),
+		Events:      []*kfunc.Event{},
+	}
+
+	limiter := &rateLimiter{limit: opts.RateLimit}
+	raw := make([]byte, prog.EventSize())
+	for {
+		if err := reader.ReadInto(raw); err != nil {
+			if errors.Is(err, types.ErrExitByCancelCtx) {
+				break
+			}
+			return fmt.Errorf("read event err %w", err)
+		}
+
+		if !limiter.allow(time.Now()) {
+			doc.Dropped++
+			continue
+		}
+
+		ev, err := prog.Decode(raw)
+		if err != nil {
+			return fmt.Errorf("decode event err %w", err)
+		}
+		if kstacks != nil {
+			ev.Stack, _ = kstacks.Get(ev.StackID)
+		}
+		doc.Events = append(doc.Events, ev)
+
+		if len(doc.Events) >= optMaxEvents {
+			doc.Truncated = true
+			break
+		}
+	}
+
+	doc.Duration = time.Since(doc.StartTime).Seconds()
+	if dropped, err := kfunc.Dropped(b); err == nil {
+		doc.Dropped += dropped
+	}
+
+	// the container id is best effort, the server may be unavailable.
+	if containers, err := container.GetAllContainers(serverAddress); err == nil {
+		cssContainers := make(map[uint64]string, len(containers))
+		for _, c := range containers {
+			cssContainers[c.CgroupCss["cpu"]] = c.ID
+		}
+		for _, ev := range doc.Events {
+			ev.ContainerID = cssContainers[ev.Css]
+		}
+	}
+
+	return json.NewEncoder(os.Stdout).Encode(doc)
+}
+
+func main() {
+	app := cli.NewApp()
+	app.Usage = "capture the calls of a kernel function, with the arguments and the stacks"
+	app.Flags = []cli.Flag{
+		&cli.StringFlag{
+			Name:     "function",
+			Required: true,
+			Usage:    "Kernel function, e.g. tcp_v4_connect",
+		},
+		&cli.StringFlag{
+			Name:  "probe",
+			Value: kfunc.ProbeKprobe,
+			Usage: "Probe type: kprobe or fentry",
+		},
+		&cli.BoolFlag{
+			Name:  "return",
+			Usage: "Also capture the return, the arguments are captured on the return by fentry",
+		},
+		&cli.StringSliceFlag{
+			Name:  "field",
+			Usage: "Argument field to capture, e.g. sk->__sk_common.skc_dport, arg1 or ret, repeatable",
+		},
+		&cli.StringFlag{
+			Name:  "container-id",
+			Value: "",
+			Usage: "Container's ID",
+		},
+		&cli.UintFlag{
+			Name:  "pid",
+			Value: 0,
+			Usage: "Task pid number",
+		},
+		&cli.BoolFlag{
+			Name:  "stack",
+			Usage: "Capture the kernel stack",
+		},
+		&cli.IntFlag{
+			Name:  "stack-depth",
+			Value: bpf.MaxStackDepth,
+			Usage: "Max depth of the stacks, up to 127",
+		},
+		&cli.IntFlag{
+			Name:  "duration",
+			Value: 10,
+			Usage: fmt.Sprintf("Tool duration(s), up to %d", maxDuration),
+		},
+		&cli.IntFlag{
+			Name:  "rate-limit",
+			Value: 100,
+			Usage: fmt.Sprintf("Max events per second, up to %d, the others are dropped", maxRateLimit),
+		},
+		&cli.IntFlag{
+			Name:  "max-events",
+			Value: 1000,
+			Usage: fmt.Sprintf("Stop after the events, up to %d", maxEvents),
+		},
+		&cli.StringFlag{
+			Name:  "server-address",
+			Value: "127.0.0.1:19704",
+			Usage: "huatuo-bamai server address",
+		},
+	}
+
+	app.Before = func(ctx *cli.Context) error {
+		log.SetOutput(io.Discard)
+		return nil
+	}
+
+	app.Action = mainAction
+	if err := app.Run(os.Args); err != nil {
+		fmt.Printf("kfunc: %v\n", err)
+		os.Exit(1)
+	}
+}
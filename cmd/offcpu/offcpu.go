@@ -0,0 +1,181 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"huatuo-bamai/internal/bpf"
+	"huatuo-bamai/internal/command/container"
+	"huatuo-bamai/internal/flamegraph"
+	"huatuo-bamai/internal/log"
+	"huatuo-bamai/pkg/tracing"
+)
+
+//go:generate $BPF_COMPILE $BPF_INCLUDE -s $BPF_DIR/offcpu.c -o offcpu.o
+
+//go:embed offcpu.o
+var offcpuBpfObj []byte
+
+const (
+	// pprofArtifactName is the pprof file name in the task artifact dir.
+	pprofArtifactName = "offcpu.pb.gz"
+)
+
+func mainAction(ctx *cli.Context) error {
+	optBpfObj := ctx.String("bpf-obj")
+	optPid := ctx.Uint64("pid")
+	optDuration := ctx.Int("duration")
+	serverAddress := ctx.String("server-address")
+
+	var targetCssAddr uint64
+	if containerID := ctx.String("container-id"); containerID != "" {
+		c, err := container.GetContainerByID(serverAddress, containerID)
+		if err != nil {
+			return err
+		}
+		targetCssAddr = c.CgroupCss["cpu"]
+	}
+
+	if err := bpf.NewManager(&bpf.Option{
+		KeepaliveTimeout: optDuration,
+		StackDepth:       ctx.Int("stack-depth"),
+	}); err != nil {
+		return fmt.Errorf("init bpf err %w", err)
+	}
+	defer bpf.Close()
+
+	b, err := bpf.LoadBpfFromBytes(optBpfObj, offcpuBpfObj, map[string]any{
+		"css":          targetCssAddr,
+		"pid":          optPid,
+		"min_block_ns": ctx.Uint64("min-block-us") * 1000,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load bpf: %w", err)
+	}
+	defer b.Close()
+
+	if err := b.Attach(); err != nil {
+		return fmt.Errorf("attach err %w", err)
+	}
+
+	start := time.Now()
+	signalWait := make(chan os.Signal, 1)
+	signal.Notify(signalWait, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case <-time.After(time.Duration(optDuration) * time.Second):
+	case <-ctx.Done():
+		return fmt.Errorf("caller requests stop")
+	case sig := <-signalWait:
+		return fmt.Errorf("received signal %s", sig)
+	}
+
+	// the container id label is best effort, the server may be unavailable.
+	cssContainers := map[uint64]string{}
+	if containers, err := container.GetAllContainers(serverAddress); err == nil {
+		for _, c := range containers {
+			cssContainers[c.CgroupCss["cpu"]] = c.ID
+		}
+	}
+
+	samples, err := resolveSamples(b, cssContainers)
+	if err != nil {
+		return fmt.Errorf("parsedata err %w", err)
+	}
+
+	if len(samples) == 0 {
+		return nil
+	}
+
+	output := newOutput(ctx.String("format"), ctx.String("pprof-output"),
+		os.Getenv(tracing.TaskArtifactDirEnv), start, time.Since(start))
+	if err := output.Write(samples); err != nil {
+		return fmt.Errorf("output profile err %w", err)
+	}
+
+	return nil
+}
+
+func main() {
+	app := cli.NewApp()
+	app.Usage = "off-cpu profiling, the blocked time weighted stacks"
+	app.Flags = []cli.Flag{
+		&cli.StringFlag{
+			Name:  "bpf-obj",
+			Value: "offcpu.o",
+			Usage: "case name",
+		},
+		&cli.StringFlag{
+			Name:  "container-id",
+			Value: "",
+			Usage: "Container's ID",
+		},
+		&cli.Uint64Flag{
+			Name:  "pid",
+			Value: 0,
+			Usage: "Task pid number",
+		},
+		&cli.IntFlag{
+			Name:  "duration",
+			Value: 5,
+			Usage: "Tool duration(s)",
+		},
+		&cli.IntFlag{
+			Name:  "stack-depth",
+			Value: bpf.MaxStackDepth,
+			Usage: "Max depth of the stacks, up to 127",
+		},
+		&cli.Uint64Flag{
+			Name:  "min-block-us",
+			Value: 1,
+			Usage: "Ignore the blocked time shorter than this (us)",
+		},
+		&cli.StringFlag{
+			Name:  "server-address",
+			Value: "127.0.0.1:19704",
+			Usage: "huatuo-bamai server address",
+		},
+		&cli.StringFlag{
+			Name:  "format",
+			Value: flamegraph.FormatJSON,
+			Usage: "Output format: json (flamegraph) or pprof (gzipped profile.proto)",
+		},
+		&cli.StringFlag{
+			Name:  "pprof-output",
+			Value: "",
+			Usage: "Write the pprof profile to this file, in addition to the json output",
+		},
+	}
+
+	app.Before = func(ctx *cli.Context) error {
+		log.SetOutput(io.Discard)
+		return nil
+	}
+
+	app.Action = mainAction
+	if err := app.Run(os.Args); err != nil {
+		fmt.Printf("offcpu: %v\n", err)
+		os.Exit(1)
+	}
+}
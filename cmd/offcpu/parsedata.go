@@ -0,0 +1,148 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+	"strconv"
+	"time"
+
+	"huatuo-bamai/internal/bpf"
+	"huatuo-bamai/internal/flamegraph"
+	"huatuo-bamai/internal/symbol"
+	"huatuo-bamai/internal/utils/bytesutil"
+)
+
+// offcpuKey is the key of the counts map, see bpf/offcpu.c.
+type offcpuKey struct {
+	Css      uint64
+	UstackID int32
+	KstackID int32
+	Pid      uint32
+	Name     [16]byte
+	_        [4]byte
+}
+
+// kernelStack is the symbolized kernel stack of a stack id.
+type kernelStack struct {
+	names  []string
+	frames []flamegraph.Frame
+}
+
+func resolveKernelStack(addrs []uint64) kernelStack {
+	var stack kernelStack
+
+	for _, v := range symbol.DumpKernelBackTrace(addrs, bpf.StackDepth()).BackTrace {
+		stack.names = append(stack.names, v+"_[k]")
+	}
+	stack.frames = flamegraph.KernelFrames(addrs, bpf.StackDepth())
+	return stack
+}
+
+// the user stacks are symbolized with the pid of every sample, the processes
+// with the same addresses share one stack id.
+func resolveUserStack(addrs []uint64) []uint64 {
+	return addrs
+}
+
+// resolveSamples reads the blocked time of stacks in ns, the stacks are
+// labeled with the container id by the cgroup css.
+func resolveSamples(b bpf.BPF, cssContainers map[uint64]string) ([]*flamegraph.StackSample, error) {
+	items, err := b.DumpMapByName("counts")
+	if err != nil || items == nil {
+		return nil, err
+	}
+
+	kstacks, err := bpf.NewStackTraces(b, "stacks", resolveKernelStack)
+	if err != nil {
+		return nil, err
+	}
+	ustacks, err := bpf.NewStackTraces(b, "stacks", resolveUserStack)
+	if err != nil {
+		return nil, err
+	}
+
+	samples := make([]*flamegraph.StackSample, 0, len(items))
+	u := symbol.NewUsym()
+	for _, v := range items {
+		var (
+			key     offcpuKey
+			blocked uint64
+		)
+
+		if err := binary.Read(bytes.NewReader(v.Key), binary.LittleEndian, &key); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(bytes.NewReader(v.Value), binary.LittleEndian, &blocked); err != nil {
+			return nil, err
+		}
+
+		comm := bytesutil.ToStr(key.Name[:])
+		sample := &flamegraph.StackSample{
+			Labels: map[string]string{
+				"comm": comm,
+				"pid":  strconv.FormatUint(uint64(key.Pid), 10),
+			},
+			Value: int64(blocked),
+		}
+		if id, ok := cssContainers[key.Css]; ok {
+			sample.Labels["container_id"] = id
+		}
+
+		if kstack, err := kstacks.Get(key.KstackID); err == nil {
+			sample.Names = append(sample.Names, kstack.names...)
+			sample.Frames = append(sample.Frames, kstack.frames...)
+		}
+
+		if addrs, err := ustacks.Get(key.UstackID); err == nil {
+			frames := flamegraph.UserFrames(u, addrs, key.Pid)
+			for _, frame := range frames {
+				sample.Names = append(sample.Names, frame.Name)
+			}
+			sample.Frames = append(sample.Frames, frames...)
+		}
+
+		sample.Names = append(sample.Names, comm)
+		samples = append(samples, sample)
+	}
+
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].Value < samples[j].Value
+	})
+	return samples, nil
+}
+
+// newOutput returns the output of the samples, the pprof profile is weighted
+// by the blocked time.
+func newOutput(format, pprofOutput, artifactDir string, start time.Time, duration time.Duration) *flamegraph.Output {
+	return &flamegraph.Output{
+		Format:       format,
+		PprofOutput:  pprofOutput,
+		ArtifactDir:  artifactDir,
+		ArtifactName: pprofArtifactName,
+		Profile: &flamegraph.ProfileOption{
+			SampleTypes:   []flamegraph.ValueType{{Type: "offcpu", Unit: "nanoseconds"}},
+			PeriodType:    flamegraph.ValueType{Type: "offcpu", Unit: "nanoseconds"},
+			Period:        1,
+			TimeNanos:     start.UnixNano(),
+			DurationNanos: duration.Nanoseconds(),
+		},
+		Values: func(s *flamegraph.StackSample) []int64 {
+			return []int64{s.Value}
+		},
+	}
+}
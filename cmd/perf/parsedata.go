@@ -17,82 +17,63 @@ package main
 import (
 	"bytes"
 	"encoding/binary"
-	"encoding/json"
-	"fmt"
 	"sort"
 	"strconv"
-	"strings"
+	"time"
 
 	"huatuo-bamai/internal/bpf"
 	"huatuo-bamai/internal/flamegraph"
 	"huatuo-bamai/internal/symbol"
 	"huatuo-bamai/internal/utils/bytesutil"
-
-	ingestv1 "github.com/grafana/pyroscope/api/gen/proto/go/ingester/v1"
-	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
-	phlaremodel "github.com/grafana/pyroscope/pkg/model"
 )
 
-// FlameData is flamegraph data
-var FlameData []flamegraph.FrameData
-
-const perfStackDepth = 20
-
 type eventdata struct {
-	Ustack     [perfStackDepth]uint64
-	Kstack     [perfStackDepth]uint64
-	UstackSize int64
-	KstackSize int64
-	Pid        uint32
-	Name       [16]byte
+	UstackID int32
+	KstackID int32
+	Pid      uint32
+	Name     [16]byte
 }
 
-// CgDumpTrace is an interface for dump stacks in cgusage case
-func CgDumpTrace(addrs []uint64) string {
-	stacks := symbol.DumpKernelBackTrace(addrs, perfStackDepth)
-	return strings.Join(stacks.BackTrace, "\n")
+// kernelStack is the symbolized kernel stack of a stack id.
+type kernelStack struct {
+	names  []string
+	frames []flamegraph.Frame
 }
 
-func convertLevels(levels []*querierv1.Level) []*flamegraph.Level {
-	var result []*flamegraph.Level
-	for _, l := range levels {
-		newLevel := &flamegraph.Level{
-			Values: l.Values,
-		}
-		result = append(result, newLevel)
-	}
-	return result
-}
+func resolveKernelStack(addrs []uint64) kernelStack {
+	var stack kernelStack
 
-func findOrAdd(strA string, b []string) (int, []string) {
-	var index int
-	found := false
-	for idxB, strB := range b {
-		if strA == strB {
-			index = idxB
-			found = true
-			break
+	for _, v := range symbol.DumpKernelBackTrace(addrs, bpf.StackDepth()).BackTrace {
+		if v != "" {
+			stack.names = append(stack.names, v+"_[k]")
 		}
 	}
-	if !found {
-		b = append(b, strA)
-		index = len(b) - 1
-	}
+	stack.frames = flamegraph.KernelFrames(addrs, bpf.StackDepth())
+	return stack
+}
 
-	return index, b
+// the user stacks are symbolized with the pid of every sample, the processes
+// with the same addresses share one stack id.
+func resolveUserStack(addrs []uint64) []uint64 {
+	return addrs
 }
 
-func parsedata(b bpf.BPF) error {
+func resolveSamples(b bpf.BPF) ([]*flamegraph.StackSample, error) {
 	items, err := b.DumpMapByName("counts")
 	if err != nil || items == nil {
-		return err
+		return nil, err
 	}
 
-	var keyValuePairs []struct {
-		Key   *eventdata
-		Value uint64
+	kstacks, err := bpf.NewStackTraces(b, "stacks", resolveKernelStack)
+	if err != nil {
+		return nil, err
+	}
+	ustacks, err := bpf.NewStackTraces(b, "stacks", resolveUserStack)
+	if err != nil {
+		return nil, err
 	}
 
+	samples := make([]*flamegraph.StackSample, 0, len(items))
 	u := symbol.NewUsym()
 	for _, v := range items {
 		ed := eventdata{}
@@ -100,141 +81,68 @@ func parsedata(b bpf.BPF) error {
 		buf := bytes.NewReader(v.Key)
 		err := binary.Read(buf, binary.LittleEndian, &ed)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		buf = bytes.NewReader(v.Value)
 		err = binary.Read(buf, binary.LittleEndian, &count)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		keyValuePairs = append(keyValuePairs, struct {
-			Key   *eventdata
-			Value uint64
-		}{
-			Key:   &ed,
-			Value: count,
-		})
-	}
-	sort.Slice(keyValuePairs, func(i, j int) bool {
-		return keyValuePairs[i].Value < keyValuePairs[j].Value
-	})
-
-	var stacktraces []*ingestv1.StacktraceSample
-	var functionNames []string
-
-	for k := range keyValuePairs {
-		sample := &ingestv1.StacktraceSample{}
-		var index int
-		kv := keyValuePairs[k]
-		sample.Value = int64(kv.Value)
-
-		if kv.Key.KstackSize > 0 {
-			kernelStack := CgDumpTrace(kv.Key.Kstack[:])
-			kstack := strings.Split(kernelStack, "\n")
-			for _, v := range kstack {
-				if v != "" {
-					index, functionNames = findOrAdd(v+"_[k]", functionNames)
-					sample.FunctionIds = append(sample.FunctionIds, int32(index))
-				}
-			}
+		comm := bytesutil.ToStr(ed.Name[:])
+		sample := &flamegraph.StackSample{
+			Labels: map[string]string{
+				"comm": comm,
+				"pid":  strconv.FormatUint(uint64(ed.Pid), 10),
+			},
+			Value: int64(count),
 		}
 
-		if kv.Key.UstackSize > 0 {
-			for _, addr := range &kv.Key.Ustack {
-				if addr == 0 {
-					break
-				}
-				usym := u.ResolveUstack(addr, kv.Key.Pid)
-				if usym != "" {
-					index, functionNames = findOrAdd(usym, functionNames)
-					sample.FunctionIds = append(sample.FunctionIds, int32(index))
-				}
-			}
+		if kstack, err := kstacks.Get(ed.KstackID); err == nil {
+			sample.Names = append(sample.Names, kstack.names...)
+			sample.Frames = append(sample.Frames, kstack.frames...)
 		}
 
-		sttitle := bytesutil.ToStr(kv.Key.Name[:])
-		index, functionNames = findOrAdd(sttitle, functionNames)
-		sample.FunctionIds = append(sample.FunctionIds, int32(index))
-
-		stacktraces = append(stacktraces, sample)
-	}
-
-	// Convert data formats
-	m := phlaremodel.NewTreeMerger()
-	sm := phlaremodel.NewStackTraceMerger()
-
-	sm.MergeStackTraces(stacktraces, functionNames)
-	if sm.Size() > 0 {
-		if err := m.MergeTreeBytes(sm.TreeBytes(-1)); err != nil {
-			return err
+		if addrs, err := ustacks.Get(ed.UstackID); err == nil {
+			frames := flamegraph.UserFrames(u, addrs, ed.Pid)
+			for _, frame := range frames {
+				sample.Names = append(sample.Names, frame.Name)
+			}
+			sample.Frames = append(sample.Frames, frames...)
 		}
-	} else {
-		return fmt.Errorf("phlaremodel: Error parsing stack data")
-	}
-
-	flame := phlaremodel.NewFlameGraph(m.Tree(), -1)
-	convertedLevels := convertLevels(flame.Levels)
-	tree := flamegraph.LevelsToTree(convertedLevels, flame.Names)
-	frame, label := flamegraph.TreeToNestedSetDataFrame(tree, "")
-
-	level := *frame.Fields[0]
-	value := *frame.Fields[1]
-	self := *frame.Fields[2]
-	labelf := *frame.Fields[3]
-
-	var (
-		levelarr []int64
-		valuearr []int64
-		selfarr  []int64
-		labelarr []string
-	)
-
-	for i := 0; i < level.Len(); i++ {
-		levelarr = append(levelarr, level.At(i).(int64))
-	}
 
-	for i := 0; i < value.Len(); i++ {
-		valuearr = append(valuearr, value.At(i).(int64))
+		sample.Names = append(sample.Names, comm)
+		samples = append(samples, sample)
 	}
 
-	for i := 0; i < self.Len(); i++ {
-		selfarr = append(selfarr, self.At(i).(int64))
-	}
-
-	labelVmp := label.GetValuesMap()
-	keys := make([]string, len(labelVmp))
-	for k, v := range labelVmp {
-		keys[v] = k
-	}
-
-	for i := 0; i < labelf.Len(); i++ {
-		formattedNum := fmt.Sprintf("%d", labelf.At(i))
-		number, _ := strconv.ParseInt(formattedNum, 10, 64)
-		labelarr = append(labelarr, keys[number])
-	}
-
-	DataSize := len(levelarr)
-
-	if len(valuearr) != DataSize || len(selfarr) != DataSize || len(labelarr) != DataSize {
-		return fmt.Errorf("Data length is not equal")
-	}
-
-	FlameData = make([]flamegraph.FrameData, DataSize)
-	for i := 0; i < DataSize; i++ {
-		FlameData[i] = flamegraph.FrameData{
-			Level: levelarr[i],
-			Value: valuearr[i],
-			Self:  selfarr[i],
-			Label: labelarr[i],
-		}
-	}
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].Value < samples[j].Value
+	})
+	return samples, nil
+}
 
-	// save
-	jsonData, err := json.Marshal(FlameData)
-	if err != nil {
-		return fmt.Errorf("JSON encoding error: %w", err)
+// newOutput returns the output of the samples, every sample of the pprof
+// profile carries both the count and the cpu time estimated by the sample
+// frequency.
+func newOutput(format, pprofOutput, artifactDir string, start time.Time, duration time.Duration) *flamegraph.Output {
+	period := int64(time.Second) / int64(perfSampleFreq)
+	return &flamegraph.Output{
+		Format:       format,
+		PprofOutput:  pprofOutput,
+		ArtifactDir:  artifactDir,
+		ArtifactName: pprofArtifactName,
+		Profile: &flamegraph.ProfileOption{
+			SampleTypes: []flamegraph.ValueType{
+				{Type: "samples", Unit: "count"},
+				{Type: "cpu", Unit: "nanoseconds"},
+			},
+			PeriodType:    flamegraph.ValueType{Type: "cpu", Unit: "nanoseconds"},
+			Period:        period,
+			TimeNanos:     start.UnixNano(),
+			DurationNanos: duration.Nanoseconds(),
+		},
+		Values: func(s *flamegraph.StackSample) []int64 {
+			return []int64{s.Value, s.Value * period}
+		},
 	}
-	fmt.Println(string(jsonData))
-	return err
 }
@@ -27,7 +27,9 @@ import (
 
 	"huatuo-bamai/internal/bpf"
 	"huatuo-bamai/internal/command/container"
+	"huatuo-bamai/internal/flamegraph"
 	"huatuo-bamai/internal/log"
+	"huatuo-bamai/pkg/tracing"
 )
 
 //go:generate $BPF_COMPILE $BPF_INCLUDE -s $BPF_DIR/perf.c -o perf.o
@@ -35,6 +37,13 @@ import (
 //go:embed perf.o
 var perfBpfObj []byte
 
+const (
+	perfSampleFreq = 99
+
+	// pprofArtifactName is the pprof file name in the task artifact dir.
+	pprofArtifactName = "profile.pb.gz"
+)
+
 func mainAction(ctx *cli.Context) error {
 	optBpfObj := ctx.String("bpf-obj")
 	optPid := ctx.Uint64("pid")
@@ -51,6 +60,7 @@ func mainAction(ctx *cli.Context) error {
 
 	if err := bpf.NewManager(&bpf.Option{
 		KeepaliveTimeout: optDuration,
+		StackDepth:       ctx.Int("stack-depth"),
 	}); err != nil {
 		return fmt.Errorf("init bpf err %w", err)
 	}
@@ -65,11 +75,12 @@ func mainAction(ctx *cli.Context) error {
 	opt := bpf.AttachOption{
 		ProgramName: "perf_event_sw_cpu_clock",
 	}
-	opt.PerfEvent.SampleFreq = 99
+	opt.PerfEvent.SampleFreq = perfSampleFreq
 	if err := b.AttachWithOptions([]bpf.AttachOption{opt}); err != nil {
 		return fmt.Errorf("attach err %w", err)
 	}
 
+	start := time.Now()
 	signalWait := make(chan os.Signal, 1)
 	signal.Notify(signalWait, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGINT, syscall.SIGTERM)
 
@@ -81,10 +92,21 @@ func mainAction(ctx *cli.Context) error {
 		return fmt.Errorf("received signal %s", sig)
 	}
 
-	if err := parsedata(b); err != nil {
+	samples, err := resolveSamples(b)
+	if err != nil {
 		return fmt.Errorf("parsedata err %w", err)
 	}
 
+	if len(samples) == 0 {
+		return nil
+	}
+
+	output := newOutput(ctx.String("format"), ctx.String("pprof-output"),
+		os.Getenv(tracing.TaskArtifactDirEnv), start, time.Since(start))
+	if err := output.Write(samples); err != nil {
+		return fmt.Errorf("output profile err %w", err)
+	}
+
 	return nil
 }
 
@@ -112,11 +134,26 @@ func main() {
 			Value: 5,
 			Usage: "Tool duration(s)",
 		},
+		&cli.IntFlag{
+			Name:  "stack-depth",
+			Value: bpf.MaxStackDepth,
+			Usage: "Max depth of the stacks, up to 127",
+		},
 		&cli.StringFlag{
 			Name:  "server-address",
 			Value: "127.0.0.1:19704",
 			Usage: "huatuo-bamai server address",
 		},
+		&cli.StringFlag{
+			Name:  "format",
+			Value: flamegraph.FormatJSON,
+			Usage: "Output format: json (flamegraph) or pprof (gzipped profile.proto)",
+		},
+		&cli.StringFlag{
+			Name:  "pprof-output",
+			Value: "",
+			Usage: "Write the pprof profile to this file, in addition to the json output",
+		},
 	}
 
 	app.Before = func(ctx *cli.Context) error {
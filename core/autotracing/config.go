@@ -45,6 +45,14 @@ type Config struct {
 		IntervalTracing       int64                  `default:"1800"`
 		RunTracingToolTimeout int64                  `default:"10"`
 		Filter                *ContainerFilterConfig `toml:"filter"`
+
+		// OffCPU captures the off-cpu profile, when the usage drops and
+		// the run queue latency rises.
+		OffCPU struct {
+			Enable                  bool
+			DeltaUsageDropThreshold int64 `default:"30"`
+			SchedLatencyThreshold   int64 `default:"5000"`
+		}
 	}
 
 	CPUSys struct {
@@ -79,6 +87,14 @@ type Config struct {
 		DumpProcessMaxNum   int `default:"10"`
 	}
 
+	Profiling struct {
+		SampleFreq       uint64  `default:"19"`
+		FlushInterval    int64   `default:"60"`
+		MaxCPUPercent    float64 `default:"2"`
+		PyroscopeAddress string
+		PyroscopeAppName string `default:"huatuo.cpu"`
+	}
+
 	// IssuesList for known issue filtering
 	IssuesList [][]string
 }
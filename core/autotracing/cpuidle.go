@@ -19,6 +19,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"math"
+	"os"
 	"os/exec"
 	"path"
 	"runtime"
@@ -31,6 +32,7 @@ import (
 	"huatuo-bamai/internal/log"
 	"huatuo-bamai/internal/matcher"
 	"huatuo-bamai/internal/pod"
+	"huatuo-bamai/internal/procfs"
 	"huatuo-bamai/pkg/tracing"
 	"huatuo-bamai/pkg/types"
 )
@@ -69,6 +71,17 @@ type containerCPUInfo struct {
 	id                   string
 	traceTime            time.Time
 	updateTime           time.Time
+	trigger              string
+	schedLatency         schedLatency
+}
+
+// schedLatency is the average run queue latency of the container tasks,
+// accumulated from /proc/<tid>/schedstat.
+type schedLatency struct {
+	prevWaitNs    uint64
+	prevTimeslice uint64
+	nowUs         int64
+	prevUs        int64
 }
 
 type cpuIdleThreshold struct {
@@ -79,8 +92,19 @@ type cpuIdleThreshold struct {
 	usageSys        int64
 	usageTotal      int64
 	intervalTracing int64
+	offCPU          bool
+	deltaUsageDrop  int64
+	schedLatencyUs  int64
 }
 
+const (
+	// cpuIdleTriggerUsage the cpu usage bursts, capture the on-cpu profile.
+	cpuIdleTriggerUsage = "usage"
+	// cpuIdleTriggerOffCPU the cpu usage drops and the latency rises, capture
+	// the off-cpu profile.
+	cpuIdleTriggerOffCPU = "offcpu"
+)
+
 // containersCPUIdleMap is the container information
 type containersCPUIdleMap map[string]*containerCPUInfo
 
@@ -129,6 +153,21 @@ func detectCPUIdleContainer(threshold *cpuIdleThreshold) (*containerCPUInfo, err
 			log.Debugf("container [%s], usage: %v", container.path, container.nowUsagePercentage)
 
 			if shouldCareThisCPUIdle(container, threshold) {
+				container.trigger = cpuIdleTriggerUsage
+				return container, nil
+			}
+
+			if !threshold.offCPU {
+				continue
+			}
+
+			if err := updateContainerSchedLatency(container); err != nil {
+				log.Debugf("cpuidle update container [%s] sched latency: %v", container.path, err)
+				continue
+			}
+
+			if shouldCareThisOffCPU(container, threshold) {
+				container.trigger = cpuIdleTriggerOffCPU
 				return container, nil
 			}
 		}
@@ -213,6 +252,62 @@ func updateContainerCpuUsage(container *containerCPUInfo) error {
 	return nil
 }
 
+func updateContainerSchedLatency(container *containerCPUInfo) error {
+	tids, err := cgroupMgr.Pids(container.path)
+	if err != nil {
+		return err
+	}
+
+	var waitNs, timeslice uint64
+	for _, tid := range tids {
+		proc, err := procfs.NewProc(int(tid))
+		if err != nil {
+			continue
+		}
+
+		stat, err := proc.Schedstat()
+		if err != nil {
+			continue
+		}
+
+		waitNs += stat.WaitingNanoseconds
+		timeslice += stat.RunTimeslices
+	}
+
+	latency := &container.schedLatency
+	prevWaitNs, prevTimeslice := latency.prevWaitNs, latency.prevTimeslice
+	latency.prevWaitNs, latency.prevTimeslice = waitNs, timeslice
+
+	// the first update, or the exited tasks make the sums go backwards.
+	if prevTimeslice == 0 || waitNs < prevWaitNs || timeslice <= prevTimeslice {
+		return fmt.Errorf("sched latency no baseline")
+	}
+
+	latency.prevUs = latency.nowUs
+	latency.nowUs = int64((waitNs-prevWaitNs)/(timeslice-prevTimeslice)) / 1000
+	return nil
+}
+
+// shouldCareThisOffCPU the cpu usage drops, but the tasks wait longer to run,
+// the container is likely blocked on locks, IO or futexes.
+func shouldCareThisOffCPU(container *containerCPUInfo, threshold *cpuIdleThreshold) bool {
+	nowtime := time.Now()
+	if int64(nowtime.Sub(container.traceTime).Seconds()) <= threshold.intervalTracing {
+		return false
+	}
+
+	latency := &container.schedLatency
+	if container.deltaUsagePercentage.total <= -threshold.deltaUsageDrop &&
+		latency.nowUs > threshold.schedLatencyUs &&
+		latency.nowUs > latency.prevUs {
+		container.traceTime = nowtime
+		container.prevUsage = cpuStats{}
+		return true
+	}
+
+	return false
+}
+
 func shouldCareThisCPUIdle(container *containerCPUInfo, threshold *cpuIdleThreshold) bool {
 	nowtime := time.Now()
 	intervalContinuousPerf := nowtime.Sub(container.traceTime)
@@ -233,19 +328,53 @@ func shouldCareThisCPUIdle(container *containerCPUInfo, threshold *cpuIdleThresh
 	return false
 }
 
-func runPerf(parent context.Context, containerId string, timeOut int64) ([]byte, error) {
+// runProfileTool runs the perf or offcpu tool, and returns the flamegraph json
+// output and the pprof profile which the tool writes into a temporary file.
+func runProfileTool(ctx context.Context, tool string, args ...string) (output, pprof []byte, err error) {
+	f, err := os.CreateTemp("", "huatuo-"+tool+"-*.pb.gz")
+	if err != nil {
+		return nil, nil, err
+	}
+	pprofPath := f.Name()
+	f.Close()
+	defer os.Remove(pprofPath)
+
+	args = append(args, "--pprof-output", pprofPath)
+	cmd := exec.CommandContext(ctx, path.Join(tracing.TaskBinDir, tool), args...)
+
+	output, err = cmd.CombinedOutput()
+	if err != nil {
+		return output, nil, err
+	}
+
+	pprof, err = os.ReadFile(pprofPath)
+	if err != nil {
+		log.Warnf("read %s pprof %s: %v", tool, pprofPath, err)
+	}
+
+	return output, pprof, nil
+}
+
+func runPerf(parent context.Context, containerId string, timeOut int64) (flamedata, pprof []byte, err error) {
 	ctx, cancel := context.WithTimeout(parent, time.Duration(timeOut+30)*time.Second)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, path.Join(tracing.TaskBinDir, "perf"),
+	return runProfileTool(ctx, "perf",
 		"--bpf-obj", "cpuidle.o",
 		"--container-id", containerId,
 		"--duration", strconv.FormatInt(timeOut, 10))
+}
 
-	return cmd.CombinedOutput()
+func runOffCPU(parent context.Context, containerId string, timeOut int64) (flamedata, pprof []byte, err error) {
+	ctx, cancel := context.WithTimeout(parent, time.Duration(timeOut+30)*time.Second)
+	defer cancel()
+
+	return runProfileTool(ctx, "offcpu",
+		"--container-id", containerId,
+		"--duration", strconv.FormatInt(timeOut, 10))
 }
 
-func buildAndSaveCPUIdleContainer(container *containerCPUInfo, threshold *cpuIdleThreshold, flamedata []byte) error {
+func buildAndSaveCPUIdleContainer(container *containerCPUInfo, threshold *cpuIdleThreshold, flamedata, pprof []byte) error {
 	tracerData := CPUIdleTracingData{
 		NowUser:             container.nowUsagePercentage.user,
 		DeltaUser:           container.deltaUsagePercentage.user,
@@ -259,10 +388,21 @@ func buildAndSaveCPUIdleContainer(container *containerCPUInfo, threshold *cpuIdl
 		DeltaUsage:          container.deltaUsagePercentage.total,
 		UsageThreshold:      threshold.usageTotal,
 		DeltaUsageThreshold: threshold.deltaTotal,
+		Trigger:             container.trigger,
 	}
 
-	if err := json.Unmarshal(flamedata, &tracerData.FlameData); err != nil {
-		return err
+	if container.trigger == cpuIdleTriggerOffCPU {
+		tracerData.SchedLatencyUs = container.schedLatency.nowUs
+		tracerData.SchedLatencyThresholdUs = threshold.schedLatencyUs
+		tracerData.OffCPUPprof = pprof
+		if err := json.Unmarshal(flamedata, &tracerData.OffCPUFlameData); err != nil {
+			return err
+		}
+	} else {
+		tracerData.Pprof = pprof
+		if err := json.Unmarshal(flamedata, &tracerData.FlameData); err != nil {
+			return err
+		}
 	}
 
 	log.Debugf("cpuidle flamedata %v", tracerData.FlameData)
@@ -291,7 +431,14 @@ type CPUIdleTracingData struct {
 	UsageThreshold      int64                  `json:"usage_threshold"`
 	DeltaUsage          int64                  `json:"deltausage"`
 	DeltaUsageThreshold int64                  `json:"deltausage_threshold"`
+	Trigger             string                 `json:"trigger"`
 	FlameData           []flamegraph.FrameData `json:"flamedata"`
+	Pprof               []byte                 `json:"pprof,omitempty"`
+	// the off-cpu profile, captured when the usage drops and latency rises.
+	SchedLatencyUs          int64                  `json:"sched_latency_us,omitempty"`
+	SchedLatencyThresholdUs int64                  `json:"sched_latency_threshold_us,omitempty"`
+	OffCPUFlameData         []flamegraph.FrameData `json:"offcpu_flamedata,omitempty"`
+	OffCPUPprof             []byte                 `json:"offcpu_pprof,omitempty"`
 }
 
 func (c *cpuIdleTracing) Start(ctx context.Context) error {
@@ -306,6 +453,9 @@ func (c *cpuIdleTracing) Start(ctx context.Context) error {
 		usageSys:        cfg.CPUIdle.SysThreshold,
 		usageTotal:      cfg.CPUIdle.UsageThreshold,
 		intervalTracing: cfg.CPUIdle.IntervalTracing,
+		offCPU:          cfg.CPUIdle.OffCPU.Enable,
+		deltaUsageDrop:  cfg.CPUIdle.OffCPU.DeltaUsageDropThreshold,
+		schedLatencyUs:  cfg.CPUIdle.OffCPU.SchedLatencyThreshold,
 	}
 
 	containerFilter, err := cfg.CPUIdle.Filter.Build()
@@ -327,22 +477,28 @@ func (c *cpuIdleTracing) Start(ctx context.Context) error {
 				continue
 			}
 
-			log.Infof("start perf container [%s], id [%s] with usage: %v, perf_run_timeout: %d",
-				container.path, container.id,
+			tool, run := "perf", runPerf
+			if container.trigger == cpuIdleTriggerOffCPU {
+				tool, run = "offcpu", runOffCPU
+			}
+
+			log.Infof("start %s container [%s], id [%s] with usage: %v, sched latency: %dus, perf_run_timeout: %d",
+				tool, container.path, container.id,
 				container.nowUsagePercentage,
+				container.schedLatency.nowUs,
 				perfRunTimeOut)
-			flamedata, err := runPerf(ctx, container.id, perfRunTimeOut)
+			flamedata, pprof, err := run(ctx, container.id, perfRunTimeOut)
 			if err != nil {
-				log.Debugf("perf err: %v, output: %v", err, string(flamedata))
+				log.Debugf("%s err: %v, output: %v", tool, err, string(flamedata))
 				return err
 			}
 
 			if len(flamedata) == 0 {
-				log.Infof("perf output is null for container id [%s]", container.id)
+				log.Infof("%s output is null for container id [%s]", tool, container.id)
 				continue
 			}
 
-			_ = buildAndSaveCPUIdleContainer(container, threshold, flamedata)
+			_ = buildAndSaveCPUIdleContainer(container, threshold, flamedata, pprof)
 		}
 	}
 }
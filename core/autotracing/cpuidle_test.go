@@ -0,0 +1,55 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package autotracing
+
+import (
+	"testing"
+	"time"
+)
+
+func TestShouldCareThisOffCPU(t *testing.T) {
+	threshold := &cpuIdleThreshold{
+		intervalTracing: 1800,
+		offCPU:          true,
+		deltaUsageDrop:  30,
+		schedLatencyUs:  5000,
+	}
+
+	tests := []struct {
+		name       string
+		deltaUsage int64
+		nowUs      int64
+		prevUs     int64
+		traceTime  time.Time
+		want       bool
+	}{
+		{"usage drops and latency rises", -40, 8000, 2000, time.Time{}, true},
+		{"usage drops a little", -10, 8000, 2000, time.Time{}, false},
+		{"latency under threshold", -40, 3000, 1000, time.Time{}, false},
+		{"latency not rising", -40, 8000, 9000, time.Time{}, false},
+		{"traced recently", -40, 8000, 2000, time.Now(), false},
+	}
+
+	for _, tt := range tests {
+		container := &containerCPUInfo{traceTime: tt.traceTime}
+		container.deltaUsagePercentage.total = tt.deltaUsage
+		container.schedLatency.nowUs = tt.nowUs
+		container.schedLatency.prevUs = tt.prevUs
+
+		if got := shouldCareThisOffCPU(container, threshold); got != tt.want {
+			t.Errorf("%s: shouldCareThisOffCPU()=%v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -19,8 +19,6 @@ import (
 	"context"
 	"encoding/json"
 	"os"
-	"os/exec"
-	"path"
 	"strconv"
 	"strings"
 	"time"
@@ -60,6 +58,7 @@ type CpuSysTracingData struct {
 	DeltaSys          int64                  `json:"deltasys"`
 	DeltaSysThreshold int64                  `json:"deltasys_threshold"`
 	FlameData         []flamegraph.FrameData `json:"flamedata"`
+	Pprof             []byte                 `json:"pprof,omitempty"`
 }
 
 type cpuSysThreshold struct {
@@ -125,23 +124,22 @@ func (c *cpuSysTracing) shouldCareThisEvent(threshold *cpuSysThreshold) bool {
 	return false
 }
 
-func runPerfSystemWide(parent context.Context, timeOut int64) ([]byte, error) {
+func runPerfSystemWide(parent context.Context, timeOut int64) (flamedata, pprof []byte, err error) {
 	ctx, cancel := context.WithTimeout(parent, time.Duration(timeOut+30)*time.Second)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, path.Join(tracing.TaskBinDir, "perf"),
+	return runProfileTool(ctx, "perf",
 		"--bpf-obj", "cpuidle.o",
 		"--duration", strconv.FormatInt(timeOut, 10))
-
-	return cmd.CombinedOutput()
 }
 
-func (c *cpuSysTracing) buildAndSaveCPUSystem(traceTime time.Time, threshold *cpuSysThreshold, flamedata []byte) error {
+func (c *cpuSysTracing) buildAndSaveCPUSystem(traceTime time.Time, threshold *cpuSysThreshold, flamedata, pprof []byte) error {
 	tracerData := CpuSysTracingData{
 		NowSys:            c.sysPercent,
 		SysThreshold:      threshold.usage,
 		DeltaSys:          c.sysPercentDelta,
 		DeltaSysThreshold: threshold.delta,
+		Pprof:             pprof,
 	}
 
 	if err := json.Unmarshal(flamedata, &tracerData.FlameData); err != nil {
@@ -186,7 +184,7 @@ func (c *cpuSysTracing) Start(ctx context.Context) error {
 
 			log.Infof("start perf system wide, cpu sys: %d, delta: %d, perf_run_timeout: %d",
 				c.sysPercent, c.sysPercentDelta, perfRunTimeOut)
-			flamedata, err := runPerfSystemWide(ctx, perfRunTimeOut)
+			flamedata, pprof, err := runPerfSystemWide(ctx, perfRunTimeOut)
 			if err != nil {
 				log.Debugf("perf err: %v, output: %v", err, string(flamedata))
 				return err
@@ -197,7 +195,7 @@ func (c *cpuSysTracing) Start(ctx context.Context) error {
 				continue
 			}
 
-			if err := c.buildAndSaveCPUSystem(traceTime, threshold, flamedata); err != nil {
+			if err := c.buildAndSaveCPUSystem(traceTime, threshold, flamedata, pprof); err != nil {
 				return err
 			}
 		}
@@ -0,0 +1,462 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package autotracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"time"
+
+	"huatuo-bamai/internal/bpf"
+	"huatuo-bamai/internal/flamegraph"
+	"huatuo-bamai/internal/log"
+	"huatuo-bamai/internal/pod"
+	"huatuo-bamai/internal/symbol"
+	"huatuo-bamai/internal/utils/bytesutil"
+	"huatuo-bamai/pkg/tracing"
+	"huatuo-bamai/pkg/types"
+
+	"golang.org/x/sys/unix"
+)
+
+//go:generate $BPF_COMPILE $BPF_INCLUDE -s $BPF_DIR/profiling.c -o $BPF_DIR/profiling.o
+
+func init() {
+	tracing.RegisterEventTracing("profiling", newProfiling)
+}
+
+func newProfiling() (*tracing.EventTracingAttr, error) {
+	return &tracing.EventTracingAttr{
+		TracingData: &profilingTracing{},
+		Interval:    10,
+		Flag:        tracing.FlagTracing,
+	}, nil
+}
+
+// profilingMaxStride is the lowest sampling rate, 1/64 of SampleFreq, before
+// the profiler is paused for a window.
+const profilingMaxStride = 64
+
+// profilingKey is the key of the counts map, see bpf/profiling.c.
+type profilingKey struct {
+	Css      uint64
+	UstackID int32
+	KstackID int32
+	Pid      uint32
+	Name     [16]byte
+	_        [4]byte
+}
+
+// profilingCtl is the struct ctl_t of bpf/profiling.c.
+type profilingCtl struct {
+	Stride    uint32
+	Active    uint32
+	MaxUsedNs uint64
+}
+
+type profilingStats struct {
+	Ticks     uint64
+	Samples   uint64
+	Dropped   uint64
+	Throttled uint64
+}
+
+// ProfilingTracingData is a fixed-interval cpu profile of a container, or of
+// the host processes when the container id is empty.
+type ProfilingTracingData struct {
+	DurationNanos int64  `json:"duration_ns"`
+	SampleFreq    uint64 `json:"sample_freq"`
+	Stride        uint32 `json:"stride"`
+	Samples       uint64 `json:"samples"`
+	Pprof         []byte `json:"pprof"`
+}
+
+// containerProfile is the profile of one container in a window.
+type containerProfile struct {
+	builder *flamegraph.ProfileBuilder
+	samples uint64
+}
+
+type profilingTracing struct {
+	bpf bpf.BPF
+	// stacks are of the counts maps, stacks_0 and stacks_1.
+	stacks   [2]*bpf.StackTraces[[]uint64]
+	usym     *symbol.Usym
+	pusher   *flamegraph.PyroscopeClient
+	hostname string
+	// ctl is the last written profiling_ctl.
+	ctl profilingCtl
+	// used is the bpf cost of the samples at the start of the window.
+	used uint64
+	// stats is the last total of profiling_stats over all cpus.
+	stats profilingStats
+}
+
+// nextStride adjusts the sampling stride by the overhead of the last window.
+// The stride is doubled when the overhead exceeds the limit, and halved when
+// it falls under the half of the limit. The profiler is paused for one window,
+// stride 0, if the overhead still exceeds the limit at the max stride.
+func nextStride(stride uint32, overhead, limit float64) uint32 {
+	switch {
+	case stride == 0:
+		return profilingMaxStride
+	case overhead > limit:
+		if stride >= profilingMaxStride {
+			return 0
+		}
+		return stride * 2
+	case overhead < limit/2 && stride > 1:
+		return stride / 2
+	default:
+		return stride
+	}
+}
+
+func (p *profilingTracing) setCtl(ctl profilingCtl) error {
+	buf := &bytes.Buffer{}
+	if err := binary.Write(buf, binary.LittleEndian, &ctl); err != nil {
+		return err
+	}
+
+	if err := p.bpf.WriteMapItems(p.bpf.MapIDByName("profiling_ctl"), []bpf.MapItem{
+		{Key: []byte{0, 0, 0, 0}, Value: buf.Bytes()},
+	}); err != nil {
+		return fmt.Errorf("set ctl %+v: %w", ctl, err)
+	}
+
+	p.ctl = ctl
+	return nil
+}
+
+// readUsed reads the total bpf cost of the samples, measured in the program.
+func (p *profilingTracing) readUsed() (uint64, error) {
+	value, err := p.bpf.ReadMap(p.bpf.MapIDByName("profiling_used"), []byte{0, 0, 0, 0})
+	if err != nil {
+		return 0, err
+	}
+	if len(value) < 8 {
+		return 0, fmt.Errorf("profiling_used value %v", value)
+	}
+	return binary.LittleEndian.Uint64(value), nil
+}
+
+// windowBudget returns the bpf cost allowed in a window, the cpu time of the
+// flush, mostly symbolization, is taken from the cap first.
+func windowBudget(window, flushCost time.Duration, limit float64) uint64 {
+	budget := time.Duration(float64(window)*limit/100) - flushCost
+	return uint64(max(budget, 0))
+}
+
+// readStats sums the per-cpu profiling_stats.
+func (p *profilingTracing) readStats() (profilingStats, error) {
+	var total profilingStats
+
+	value, err := p.bpf.ReadMap(p.bpf.MapIDByName("profiling_stats"), []byte{0, 0, 0, 0})
+	if err != nil {
+		return total, err
+	}
+
+	buf := bytes.NewReader(value)
+	for {
+		var s profilingStats
+		if err := binary.Read(buf, binary.LittleEndian, &s); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return total, err
+		}
+
+		total.Ticks += s.Ticks
+		total.Samples += s.Samples
+		total.Dropped += s.Dropped
+		total.Throttled += s.Throttled
+	}
+
+	return total, nil
+}
+
+// drainCounts switches the active counts and stacks maps, dumps and deletes
+// the aggregated stacks of the other ones, and groups them into one profile per
+// container id. The host processes use the empty id.
+func (p *profilingTracing) drainCounts(start time.Time, duration time.Duration) (map[string]*containerProfile, error) {
+	drained := p.ctl.Active
+	ctl := p.ctl
+	ctl.Active ^= 1
+	if err := p.setCtl(ctl); err != nil {
+		return nil, err
+	}
+
+	// the samples taken before the switch finish within the bpf syscalls.
+	mapID := p.bpf.MapIDByName(fmt.Sprintf("counts_%d", drained))
+
+	items, err := p.bpf.DumpMap(mapID)
+	if err != nil {
+		return nil, fmt.Errorf("dump counts: %w", err)
+	}
+
+	keys := make([][]byte, 0, len(items))
+	for _, item := range items {
+		keys = append(keys, item.Key)
+	}
+	if err := p.bpf.DeleteMapItems(mapID, keys); err != nil {
+		return nil, fmt.Errorf("delete counts: %w", err)
+	}
+
+	containers, err := pod.Containers()
+	if err != nil {
+		return nil, err
+	}
+	cssContainers := pod.BuildCssContainersID(containers, pod.SubSysCPU)
+
+	// the pids may be reused across windows, don't keep the symbols.
+	p.usym = symbol.NewUsym()
+
+	freq := cfg.Profiling.SampleFreq
+	period := int64(time.Second) / int64(freq) * int64(max(p.ctl.Stride, 1))
+	profiles := map[string]*containerProfile{}
+	stacks := p.stacks[drained]
+
+	for _, item := range items {
+		var (
+			key   profilingKey
+			count uint64
+		)
+
+		if err := binary.Read(bytes.NewReader(item.Key), binary.LittleEndian, &key); err != nil {
+			return nil, fmt.Errorf("read counts key: %w", err)
+		}
+		if err := binary.Read(bytes.NewReader(item.Value), binary.LittleEndian, &count); err != nil {
+			return nil, fmt.Errorf("read counts value: %w", err)
+		}
+
+		containerID := cssContainers[key.Css]
+		profile, ok := profiles[containerID]
+		if !ok {
+			profile = &containerProfile{}
+			profile.builder = flamegraph.NewProfileBuilder(&flamegraph.ProfileOption{
+				SampleTypes: []flamegraph.ValueType{
+					{Type: "samples", Unit: "count"},
+					{Type: "cpu", Unit: "nanoseconds"},
+				},
+				PeriodType:    flamegraph.ValueType{Type: "cpu", Unit: "nanoseconds"},
+				Period:        period,
+				TimeNanos:     start.UnixNano(),
+				DurationNanos: duration.Nanoseconds(),
+			})
+			profiles[containerID] = profile
+		}
+
+		var frames []flamegraph.Frame
+		if addrs, err := stacks.Get(key.KstackID); err == nil {
+			frames = append(frames, flamegraph.KernelFrames(addrs, bpf.StackDepth())...)
+		}
+		if addrs, err := stacks.Get(key.UstackID); err == nil {
+			frames = append(frames, flamegraph.UserFrames(p.usym, addrs, key.Pid)...)
+		}
+
+		profile.samples += count
+		if err := profile.builder.AddSample(frames,
+			[]int64{int64(count), int64(count) * period},
+			map[string]string{
+				"comm": bytesutil.ToStr(key.Name[:]),
+				"pid":  strconv.FormatUint(uint64(key.Pid), 10),
+			}); err != nil {
+			return nil, err
+		}
+	}
+
+	// the stack ids of the drained window are consumed, the samples of the
+	// active one are of the other stacks map.
+	if err := stacks.Reset(); err != nil {
+		return nil, fmt.Errorf("reset stacks: %w", err)
+	}
+
+	return profiles, nil
+}
+
+func (p *profilingTracing) output(ctx context.Context, containerID string, profile *containerProfile, start time.Time, duration time.Duration) error {
+	buf := &bytes.Buffer{}
+	if err := profile.builder.WriteGzip(buf); err != nil {
+		return err
+	}
+
+	if p.pusher != nil {
+		labels := map[string]string{"hostname": p.hostname}
+		if containerID != "" {
+			labels["container_id"] = containerID
+		}
+
+		return p.pusher.Push(ctx, &flamegraph.PyroscopePushRequest{
+			AppName:    cfg.Profiling.PyroscopeAppName,
+			Labels:     labels,
+			From:       start,
+			Until:      start.Add(duration),
+			SampleRate: cfg.Profiling.SampleFreq / uint64(max(p.ctl.Stride, 1)),
+			Profile:    buf.Bytes(),
+		})
+	}
+
+	return tracing.Save(&tracing.WriteRequest{
+		TracerName:  "profiling",
+		ContainerID: containerID,
+		TracerTime:  start,
+		TracerData: &ProfilingTracingData{
+			DurationNanos: duration.Nanoseconds(),
+			SampleFreq:    cfg.Profiling.SampleFreq,
+			Stride:        p.ctl.Stride,
+			Samples:       profile.samples,
+			Pprof:         buf.Bytes(),
+		},
+		TracerRunType: tracing.TracerRunTypeAutotracing,
+	})
+}
+
+func threadCPUTime() time.Duration {
+	var ru unix.Rusage
+	if err := unix.Getrusage(unix.RUSAGE_THREAD, &ru); err != nil {
+		return 0
+	}
+
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
+
+// flush writes the profiles of the last window, and returns the cpu time of
+// the flush.
+func (p *profilingTracing) flush(ctx context.Context, start time.Time, duration time.Duration) (time.Duration, error) {
+	// measure the cpu time of this thread, symbolization is the most of it.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	cpuStart := threadCPUTime()
+
+	stats, err := p.readStats()
+	if err != nil {
+		return 0, fmt.Errorf("read stats: %w", err)
+	}
+	if dropped := stats.Dropped - p.stats.Dropped; dropped > 0 {
+		log.Warnf("profiling: %d samples dropped, counts map is full", dropped)
+	}
+	if throttled := stats.Throttled - p.stats.Throttled; throttled > 0 {
+		log.Infof("profiling: %d samples skipped, the cpu budget of the window is used up", throttled)
+	}
+	p.stats = stats
+
+	profiles, err := p.drainCounts(start, duration)
+	if err != nil {
+		return 0, err
+	}
+
+	for containerID, profile := range profiles {
+		if err := p.output(ctx, containerID, profile, start, duration); err != nil {
+			log.Warnf("profiling: output profile of container [%s]: %v", containerID, err)
+		}
+	}
+
+	return threadCPUTime() - cpuStart, nil
+}
+
+func (p *profilingTracing) Start(ctx context.Context) error {
+	freq := cfg.Profiling.SampleFreq
+	flushInterval := time.Duration(cfg.Profiling.FlushInterval) * time.Second
+	if freq == 0 || flushInterval <= 0 {
+		return fmt.Errorf("invalid profiling SampleFreq %d or FlushInterval %v", freq, flushInterval)
+	}
+
+	b, err := bpf.LoadBpfContext(ctx, bpf.ThisBpfOBJ(), nil)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	p.bpf = b
+	p.stats = profilingStats{}
+	p.ctl = profilingCtl{}
+	for i := range p.stacks {
+		p.stacks[i], err = bpf.NewStackTraces(b, fmt.Sprintf("stacks_%d", i), func(addrs []uint64) []uint64 {
+			return addrs
+		})
+		if err != nil {
+			return err
+		}
+	}
+	p.hostname, _ = os.Hostname()
+	if addr := cfg.Profiling.PyroscopeAddress; addr != "" {
+		p.pusher = flamegraph.NewPyroscopeClient(addr, flushInterval)
+	}
+
+	if p.used, err = p.readUsed(); err != nil {
+		return fmt.Errorf("read used: %w", err)
+	}
+	if err := p.setCtl(profilingCtl{
+		Stride:    1,
+		MaxUsedNs: p.used + windowBudget(flushInterval, 0, cfg.Profiling.MaxCPUPercent),
+	}); err != nil {
+		return err
+	}
+
+	opt := bpf.AttachOption{
+		ProgramName: "perf_event_sw_cpu_clock",
+	}
+	opt.PerfEvent.SampleFreq = freq
+	if err := b.AttachWithOptions([]bpf.AttachOption{opt}); err != nil {
+		return fmt.Errorf("attach: %w", err)
+	}
+
+	log.Infof("profiling: start with sample freq %d Hz, flush interval %v", freq, flushInterval)
+
+	start := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return types.ErrExitByCancelCtx
+		case <-time.After(time.Until(start.Add(flushInterval))):
+			now := time.Now()
+			window := now.Sub(start)
+
+			flushCost, err := p.flush(ctx, start, window)
+			if err != nil {
+				return err
+			}
+
+			// the bpf cost is measured in the program.
+			used, err := p.readUsed()
+			if err != nil {
+				return fmt.Errorf("read used: %w", err)
+			}
+			cost := flushCost + time.Duration(used-p.used)
+			overhead := float64(cost) / float64(window) * 100
+			p.used = used
+
+			ctl := p.ctl
+			ctl.Stride = nextStride(p.ctl.Stride, overhead, cfg.Profiling.MaxCPUPercent)
+			ctl.MaxUsedNs = used + windowBudget(flushInterval, flushCost, cfg.Profiling.MaxCPUPercent)
+			if ctl.Stride != p.ctl.Stride {
+				log.Infof("profiling: overhead %.2f%%, limit %.2f%%, stride %d -> %d",
+					overhead, cfg.Profiling.MaxCPUPercent, p.ctl.Stride, ctl.Stride)
+			}
+			if err := p.setCtl(ctl); err != nil {
+				return err
+			}
+
+			start = now
+		}
+	}
+}
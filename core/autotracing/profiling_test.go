@@ -0,0 +1,61 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package autotracing
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextStride(t *testing.T) {
+	tests := []struct {
+		name     string
+		stride   uint32
+		overhead float64
+		want     uint32
+	}{
+		{"under limit at full rate", 1, 1.5, 1},
+		{"over limit", 1, 3, 2},
+		{"over limit again", 8, 2.5, 16},
+		{"over limit at max stride pauses", profilingMaxStride, 3, 0},
+		{"resume after pause", 0, 0, profilingMaxStride},
+		{"far under limit", 16, 0.5, 8},
+		{"between half and limit", 16, 1.5, 16},
+	}
+
+	for _, tt := range tests {
+		if got := nextStride(tt.stride, tt.overhead, 2); got != tt.want {
+			t.Errorf("%s: nextStride(%d, %.1f, 2)=%d, want %d", tt.name, tt.stride, tt.overhead, got, tt.want)
+		}
+	}
+}
+
+func TestWindowBudget(t *testing.T) {
+	tests := []struct {
+		name      string
+		flushCost time.Duration
+		want      uint64
+	}{
+		{"no flush cost", 0, uint64(1200 * time.Millisecond)},
+		{"flush cost taken first", 200 * time.Millisecond, uint64(time.Second)},
+		{"flush cost over the cap", 2 * time.Second, 0},
+	}
+
+	for _, tt := range tests {
+		if got := windowBudget(time.Minute, tt.flushCost, 2); got != tt.want {
+			t.Errorf("%s: windowBudget(1m, %v, 2)=%d, want %d", tt.name, tt.flushCost, got, tt.want)
+		}
+	}
+}
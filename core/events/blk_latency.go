@@ -0,0 +1,238 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"huatuo-bamai/internal/bpf"
+	"huatuo-bamai/internal/log"
+	"huatuo-bamai/internal/pod"
+	"huatuo-bamai/internal/procfs/sysfs"
+	"huatuo-bamai/internal/symbol"
+	"huatuo-bamai/internal/utils/bytesutil"
+	"huatuo-bamai/pkg/tracing"
+)
+
+//go:generate $BPF_COMPILE $BPF_INCLUDE -s $BPF_DIR/blk_latency.c -o $BPF_DIR/blk_latency.o
+
+const (
+	blkLatencyTracerName = "blk_latency"
+
+	// include/linux/blk_types.h
+	blkReqOpMask = 0xff
+	blkReqSync   = 1 << 11
+)
+
+// from enum req_op of include/linux/blk_types.h
+var blkReqOpMap = map[uint32]string{
+	0: "read",
+	1: "write",
+	2: "flush",
+	3: "discard",
+	5: "secure_erase",
+	9: "write_zeroes",
+}
+
+// blkLatencyPerfEvent is the struct blk_latency_event_t of
+// bpf/blk_latency.c.
+type blkLatencyPerfEvent struct {
+	Queue    uint64
+	Device   uint64
+	Sector   uint64
+	CSS      uint64
+	Len      uint32
+	CmdFlags uint32
+	Major    uint32
+	Minor    uint32
+	Pid      uint32
+	Tgid     uint32
+	StackID  int32
+	Error    int32
+	Comm     [bpf.TaskCommLen]byte
+}
+
+// BlkLatencyTracingData is a slow block request, the issuing task is the one
+// that submitted the first bio of the request, e.g. the kworker of the
+// writeback, and the container is the owner of the blkio cgroup. The stack at
+// the issue is only of the tasks and the devices with the slow requests in the
+// last minute.
+type BlkLatencyTracingData struct {
+	LatencyMs  float64 `json:"latency_ms"`
+	QueueMs    float64 `json:"queue_ms"`
+	DeviceMs   float64 `json:"device_ms"`
+	Threshold  uint64  `json:"threshold"`
+	Device     string  `json:"device"`
+	DeviceName string  `json:"device_name"`
+	Op         string  `json:"op"`
+	Sync       bool    `json:"sync"`
+	Size       uint32  `json:"size"`
+	Sector     uint64  `json:"sector"`
+	Error      int32   `json:"error"`
+	Comm       string  `json:"comm"`
+	Pid        uint32  `json:"pid"`
+	Tgid       uint32  `json:"tgid"`
+	Stack      string  `json:"stack,omitempty"`
+}
+
+type blkLatencyTracing struct {
+	stacks *bpf.StackTraces[string]
+
+	cssContainers map[uint64]*pod.Container
+	cacheTime     time.Time
+}
+
+func init() {
+	tracing.RegisterEventTracing(blkLatencyTracerName, newBlkLatency)
+}
+
+func newBlkLatency() (*tracing.EventTracingAttr, error) {
+	return &tracing.EventTracingAttr{
+		TracingData: &blkLatencyTracing{},
+		Interval:    10,
+		Flag:        tracing.FlagTracing,
+	}, nil
+}
+
+// Start starts the tracer.
+func (c *blkLatencyTracing) Start(ctx context.Context) error {
+	b, err := bpf.LoadBpfContext(ctx, bpf.ThisBpfOBJ(), map[string]any{
+		"latency_thresh": cfg.BlkLatency.Threshold,
+		"stacks_enabled": cfg.BlkLatency.EnableStacks,
+	})
+	if err != nil {
+		return fmt.Errorf("load bpf: %w", err)
+	}
+	defer b.Close()
+
+	if cfg.BlkLatency.EnableStacks {
+		c.stacks, err = bpf.NewStackTraces(b, "stacks", func(addrs []uint64) string {
+			return strings.Join(symbol.DumpKernelBackTrace(addrs, bpf.StackDepth()).BackTrace, "\n")
+		})
+		if err != nil {
+			return err
+		}
+		c.stacks.EnableAging()
+	}
+
+	childCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	reader, err := b.AttachAndEventPipe(childCtx, "blk_latency_events", 8192)
+	if err != nil {
+		return fmt.Errorf("attach and event pipe: %w", err)
+	}
+	defer reader.Close()
+
+	b.WaitDetachByBreaker(childCtx, cancel)
+
+	for {
+		select {
+		case <-childCtx.Done():
+			return nil
+		default:
+			var event blkLatencyPerfEvent
+			if err := reader.ReadInto(&event); err != nil {
+				return fmt.Errorf("read blk latency events: %w", err)
+			}
+
+			device := fmt.Sprintf("%d:%d", event.Major, event.Minor)
+			data := &BlkLatencyTracingData{
+				LatencyMs:  float64(event.Queue+event.Device) / float64(time.Millisecond),
+				QueueMs:    float64(event.Queue) / float64(time.Millisecond),
+				DeviceMs:   float64(event.Device) / float64(time.Millisecond),
+				Threshold:  cfg.BlkLatency.Threshold,
+				Device:     device,
+				DeviceName: blkDeviceName(device),
+				Op:         blkReqOpName(event.CmdFlags),
+				Sync:       event.CmdFlags&blkReqSync != 0,
+				Size:       event.Len,
+				Sector:     event.Sector,
+				Error:      event.Error,
+				Comm:       bytesutil.ToStr(event.Comm[:]),
+				Pid:        event.Pid,
+				Tgid:       event.Tgid,
+				Stack:      c.stack(event.StackID),
+			}
+
+			if err := tracing.Save(&tracing.WriteRequest{
+				TracerName:  blkLatencyTracerName,
+				ContainerID: c.containerID(event.CSS),
+				TracerTime:  time.Now(),
+				TracerData:  data,
+			}); err != nil {
+				log.Warnf("failed to save tracing data: %v", err)
+			}
+		}
+	}
+}
+
+func blkReqOpName(cmdFlags uint32) string {
+	op := cmdFlags & blkReqOpMask
+	if name, ok := blkReqOpMap[op]; ok {
+		return name
+	}
+	return fmt.Sprintf("op_%d", op)
+}
+
+// blkDeviceName returns the name of the block device major:minor, e.g. sda1,
+// empty if the device is removed.
+func blkDeviceName(device string) string {
+	link, err := os.Readlink(sysfs.Path("dev/block", device))
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(link)
+}
+
+// containerID returns the container id of the blkio cgroup css, empty for the
+// host.
+func (c *blkLatencyTracing) containerID(css uint64) string {
+	container, ok := c.cssContainers[css]
+	if !ok || time.Since(c.cacheTime) > cssCacheTTL {
+		containers, err := pod.Containers()
+		if err != nil {
+			log.Debugf("get containers: %v", err)
+			return ""
+		}
+		c.cssContainers = pod.BuildCssContainers(containers, pod.SubSysBlkIO)
+		c.cacheTime = time.Now()
+		container = c.cssContainers[css]
+	}
+
+	if container == nil {
+		return ""
+	}
+	return container.ID
+}
+
+// stack returns the stack of the id, -1 is no stack captured and the other
+// negative ids are the failures counted by the stack traces.
+func (c *blkLatencyTracing) stack(id int32) string {
+	if c.stacks == nil || id == -1 {
+		return ""
+	}
+
+	stack, err := c.stacks.Get(id)
+	if err != nil {
+		log.Debugf("blk_latency stack %d: %v", id, err)
+	}
+	return stack
+}
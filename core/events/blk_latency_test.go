@@ -0,0 +1,137 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"huatuo-bamai/internal/bpf"
+	"huatuo-bamai/internal/bpf/bpftest"
+	"huatuo-bamai/pkg/tracing"
+	"huatuo-bamai/pkg/types"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestBlkLatencyTracing(t *testing.T) {
+	be := bpftest.Install(t)
+	rec, stop := tracing.Record()
+	t.Cleanup(stop)
+
+	cfg.BlkLatency.Threshold = uint64(500 * time.Millisecond)
+	cfg.BlkLatency.EnableStacks = false
+
+	event := blkLatencyPerfEvent{
+		Queue:    uint64(100 * time.Millisecond),
+		Device:   uint64(600 * time.Millisecond),
+		Sector:   2048,
+		Len:      4096,
+		CmdFlags: 1 | blkReqSync, // write
+		Major:    8,
+		Minor:    1,
+		Pid:      1001,
+		Tgid:     1000,
+		StackID:  -1,
+	}
+	copy(event.Comm[:], "mysqld")
+
+	obj := be.Object("blk_latency.o").Records("blk_latency_events", &event)
+
+	c := &blkLatencyTracing{}
+	if err := c.Start(context.Background()); !errors.Is(err, types.ErrExitByCancelCtx) {
+		t.Errorf("Start() err=%v, want %v", err, types.ErrExitByCancelCtx)
+	}
+
+	consts := obj.Consts()
+	if consts["latency_thresh"] != uint64(500*time.Millisecond) || consts["stacks_enabled"] != false {
+		t.Errorf("consts=%v", consts)
+	}
+
+	data := rec.TracerData(blkLatencyTracerName)
+	if len(data) != 1 {
+		t.Fatalf("blk_latency tracing data=%d, want 1", len(data))
+	}
+	got, ok := data[0].(*BlkLatencyTracingData)
+	if !ok {
+		t.Fatalf("blk_latency tracing data=%T", data[0])
+	}
+
+	if got.LatencyMs != 700 || got.QueueMs != 100 || got.DeviceMs != 600 {
+		t.Errorf("latency=%v queue=%v device=%v, want 700, 100 and 600", got.LatencyMs, got.QueueMs, got.DeviceMs)
+	}
+	if got.Device != "8:1" || got.Op != "write" || !got.Sync || got.Size != 4096 || got.Sector != 2048 {
+		t.Errorf("request=%+v", got)
+	}
+	if got.Comm != "mysqld" || got.Pid != 1001 || got.Tgid != 1000 || got.Stack != "" {
+		t.Errorf("issuing task=%+v", got)
+	}
+}
+
+func TestBlkReqOpName(t *testing.T) {
+	for cmdFlags, want := range map[uint32]string{
+		0:              "read",
+		1 | blkReqSync: "write",
+		3:              "discard",
+		34:             "op_34",
+	} {
+		if got := blkReqOpName(cmdFlags); got != want {
+			t.Errorf("blkReqOpName(%#x)=%s, want %s", cmdFlags, got, want)
+		}
+	}
+}
+
+func TestBlkLatencyStackErrors(t *testing.T) {
+	be := bpftest.Install(t)
+	rec, stop := tracing.Record()
+	t.Cleanup(stop)
+
+	cfg.BlkLatency.Threshold = uint64(500 * time.Millisecond)
+	cfg.BlkLatency.EnableStacks = true
+	t.Cleanup(func() { cfg.BlkLatency.EnableStacks = false })
+
+	lost := func() (n uint64) {
+		for _, count := range bpf.AllStackErrors() {
+			n += count
+		}
+		return n
+	}
+	before := lost()
+
+	// the stack lost on the collision of the bucket.
+	obj := be.Object("blk_latency.o").Records("blk_latency_events", &blkLatencyPerfEvent{
+		Device:  uint64(600 * time.Millisecond),
+		StackID: -int32(unix.EEXIST),
+	})
+	obj.Map("stacks")
+
+	c := &blkLatencyTracing{}
+	if err := c.Start(context.Background()); !errors.Is(err, types.ErrExitByCancelCtx) {
+		t.Errorf("Start() err=%v, want %v", err, types.ErrExitByCancelCtx)
+	}
+	if consts := obj.Consts(); consts["stacks_enabled"] != true {
+		t.Errorf("consts=%v", consts)
+	}
+
+	data := rec.TracerData(blkLatencyTracerName)
+	if len(data) != 1 || data[0].(*BlkLatencyTracingData).Stack != "" {
+		t.Fatalf("blk_latency tracing data=%v, want one without the stack", data)
+	}
+	if got := lost() - before; got != 1 {
+		t.Errorf("stack errors=%d, want 1", got)
+	}
+}
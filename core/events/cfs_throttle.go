@@ -0,0 +1,344 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"huatuo-bamai/internal/bpf"
+	"huatuo-bamai/internal/log"
+	"huatuo-bamai/internal/pod"
+	"huatuo-bamai/internal/utils/bytesutil"
+	"huatuo-bamai/pkg/metric"
+	"huatuo-bamai/pkg/tracing"
+
+	"golang.org/x/sys/unix"
+)
+
+//go:generate $BPF_COMPILE $BPF_INCLUDE -s $BPF_DIR/cfs_throttle.c -o $BPF_DIR/cfs_throttle.o
+
+const cfsThrottleTracerName = "cfs_throttle"
+
+// cfsThrottlePerfEvent is the struct cfs_throttle_event_t of
+// bpf/cfs_throttle.c.
+type cfsThrottlePerfEvent struct {
+	CSS      uint64
+	Duration uint64
+	Quota    uint64
+	Period   uint64
+	Episodes uint64
+	CPU      uint32
+	Pad0     uint32
+}
+
+// cfsThrottleStat is the struct cfs_throttle_stat_t of bpf/cfs_throttle.c,
+// the zones are [0, 10)ms, [10, 50)ms, [50, 100)ms and [100, inf)ms.
+type cfsThrottleStat struct {
+	Episodes    uint64
+	ThrottledNs uint64
+	Zones       [4]uint64
+}
+
+// cfsThrottleThreadKey is the struct thread_key_t of bpf/cfs_throttle.c.
+type cfsThrottleThreadKey struct {
+	CSS  uint64
+	Pid  uint32
+	Pad0 uint32
+}
+
+// cfsThrottleThread is the struct thread_t of bpf/cfs_throttle.c.
+type cfsThrottleThread struct {
+	Runtime uint64
+	Start   uint64
+	Leaf    uint64
+	Tgid    uint32
+	Pad0    uint32
+	Comm    [bpf.TaskCommLen]byte
+}
+
+// CFSThrottleThread is an on-cpu thread of the throttled cgroup.
+type CFSThrottleThread struct {
+	Comm string `json:"comm"`
+	Pid  uint32 `json:"pid"`
+	Tgid uint32 `json:"tgid"`
+	// ContainerID is the container of the thread, empty for the others.
+	ContainerID string `json:"container_id,omitempty"`
+	// OnCPUMs is the on-cpu time in the window of the thread, at most
+	// ContainerInterval before the event.
+	OnCPUMs float64 `json:"oncpu_ms"`
+}
+
+// CFSThrottleTracingData is a long throttle episode of a container or a pod,
+// the throttled time of the cfs_rq of a cpu.
+type CFSThrottleTracingData struct {
+	// Cgroup is the throttled cgroup, "container" or "pod", empty for the
+	// others.
+	Cgroup     string  `json:"cgroup"`
+	DurationMs float64 `json:"duration_ms"`
+	Threshold  uint64  `json:"threshold"`
+	CPU        uint32  `json:"cpu"`
+	QuotaUs    uint64  `json:"quota_us"`
+	PeriodUs   uint64  `json:"period_us"`
+	// Episodes is the throttle episodes of the container so far.
+	Episodes   uint64              `json:"episodes"`
+	TopThreads []CFSThrottleThread `json:"top_threads"`
+}
+
+type cfsThrottleTracing struct {
+	running atomic.Bool
+	bpf     bpf.BPF
+
+	cssContainers map[uint64]*pod.Container
+	cacheTime     time.Time
+}
+
+func init() {
+	tracing.RegisterEventTracing(cfsThrottleTracerName, newCFSThrottle)
+}
+
+func newCFSThrottle() (*tracing.EventTracingAttr, error) {
+	return &tracing.EventTracingAttr{
+		TracingData: &cfsThrottleTracing{},
+		Interval:    10,
+		Flag:        tracing.FlagTracing | tracing.FlagMetric,
+	}, nil
+}
+
+// Update returns the throttle episodes of the containers.
+func (c *cfsThrottleTracing) Update() ([]*metric.Data, error) {
+	if !c.running.Load() {
+		return nil, nil
+	}
+
+	containers, err := pod.ContainersByType(pod.ContainerTypeNormal)
+	if err != nil {
+		return nil, fmt.Errorf("get containers: %w", err)
+	}
+	cssContainers := pod.BuildCssContainers(containers, pod.SubSysCPU)
+
+	items, err := c.bpf.DumpMapByName("cfs_throttle_stats")
+	if err != nil {
+		return nil, fmt.Errorf("dump cfs_throttle_stats: %w", err)
+	}
+
+	metrics := []*metric.Data{}
+	for _, item := range items {
+		var css uint64
+		if err := binary.Read(bytes.NewReader(item.Key), binary.LittleEndian, &css); err != nil {
+			return nil, fmt.Errorf("read cfs_throttle_stats key: %w", err)
+		}
+
+		container, ok := cssContainers[css]
+		if !ok {
+			continue
+		}
+
+		var stat cfsThrottleStat
+		if err := binary.Read(bytes.NewReader(item.Value), binary.LittleEndian, &stat); err != nil {
+			return nil, fmt.Errorf("read cfs_throttle_stats value: %w", err)
+		}
+
+		metrics = append(metrics,
+			metric.NewContainerCounterData(container, "episodes_total", float64(stat.Episodes),
+				"cfs bandwidth throttle episodes of the cpus for the containers", nil),
+			metric.NewContainerCounterData(container, "throttled_seconds_total", float64(stat.ThrottledNs)/float64(time.Second),
+				"cfs bandwidth throttled time of the cpus for the containers", nil))
+		for zone, count := range stat.Zones {
+			metrics = append(metrics,
+				metric.NewContainerCounterData(container, "episode_duration", float64(count),
+					"cfs bandwidth throttle episodes by the duration for the containers",
+					map[string]string{"zone": strconv.Itoa(zone)}))
+		}
+	}
+
+	return metrics, nil
+}
+
+// Start starts the tracer.
+func (c *cfsThrottleTracing) Start(ctx context.Context) error {
+	b, err := bpf.LoadBpfContext(ctx, bpf.ThisBpfOBJ(), map[string]any{
+		"duration_thresh":    cfg.CFSThrottle.Threshold,
+		"container_interval": uint64(cfg.CFSThrottle.ContainerInterval) * uint64(time.Second),
+	})
+	if err != nil {
+		return fmt.Errorf("load bpf: %w", err)
+	}
+	defer b.Close()
+
+	childCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	reader, err := b.AttachAndEventPipe(childCtx, "cfs_throttle_events", 8192)
+	if err != nil {
+		return fmt.Errorf("attach and event pipe: %w", err)
+	}
+	defer reader.Close()
+
+	b.WaitDetachByBreaker(childCtx, cancel)
+
+	c.bpf = b
+	c.running.Store(true)
+	defer c.running.Store(false)
+
+	for {
+		select {
+		case <-childCtx.Done():
+			return nil
+		default:
+			var event cfsThrottlePerfEvent
+			if err := reader.ReadInto(&event); err != nil {
+				return fmt.Errorf("read cfs throttle events: %w", err)
+			}
+
+			threads, err := c.topThreads(event.CSS, cfg.CFSThrottle.TopThreads)
+			if err != nil {
+				log.Debugf("cfs_throttle top threads: %v", err)
+			}
+
+			// the pod cgroup is resolved by the containers of its threads.
+			cgroup, containerID := "container", c.containerID(event.CSS)
+			if containerID == "" {
+				cgroup = ""
+				for _, thread := range threads {
+					if thread.ContainerID != "" {
+						cgroup, containerID = "pod", thread.ContainerID
+						break
+					}
+				}
+			}
+
+			if err := tracing.Save(&tracing.WriteRequest{
+				TracerName:  cfsThrottleTracerName,
+				ContainerID: containerID,
+				TracerTime:  time.Now(),
+				TracerData: &CFSThrottleTracingData{
+					Cgroup:     cgroup,
+					DurationMs: float64(event.Duration) / float64(time.Millisecond),
+					Threshold:  cfg.CFSThrottle.Threshold,
+					CPU:        event.CPU,
+					QuotaUs:    event.Quota / uint64(time.Microsecond),
+					PeriodUs:   event.Period / uint64(time.Microsecond),
+					Episodes:   event.Episodes,
+					TopThreads: threads,
+				},
+			}); err != nil {
+				log.Warnf("failed to save tracing data: %v", err)
+			}
+		}
+	}
+}
+
+// containerID returns the container id of the cpu cgroup css, empty for the
+// others, e.g. the pod and the system cgroups.
+func (c *cfsThrottleTracing) containerID(css uint64) string {
+	// the pod and the system cgroups always miss, refreshed once a second.
+	container, ok := c.cssContainers[css]
+	if (!ok && time.Since(c.cacheTime) > time.Second) || time.Since(c.cacheTime) > cssCacheTTL {
+		containers, err := pod.Containers()
+		if err != nil {
+			log.Debugf("get containers: %v", err)
+			return ""
+		}
+		c.cssContainers = pod.BuildCssContainers(containers, pod.SubSysCPU)
+		c.cacheTime = time.Now()
+		container = c.cssContainers[css]
+	}
+
+	if container == nil {
+		return ""
+	}
+	return container.ID
+}
+
+// monotonicNow returns the clock of bpf_ktime_get_ns.
+func monotonicNow() uint64 {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return 0
+	}
+	return uint64(unix.TimespecToNsec(ts))
+}
+
+// topThreads returns the top n on-cpu threads of the css, the threads of the
+// descendants included, and deletes all the threads of the css to start the
+// next window. The threads whose windows expire are skipped.
+func (c *cfsThrottleTracing) topThreads(css uint64, n int) ([]CFSThrottleThread, error) {
+	items, err := c.bpf.DumpMapByName("cfs_throttle_threads")
+	if err != nil {
+		return nil, fmt.Errorf("dump cfs_throttle_threads: %w", err)
+	}
+
+	now := monotonicNow()
+	window := uint64(cfg.CFSThrottle.ContainerInterval) * uint64(time.Second)
+
+	var (
+		threads []CFSThrottleThread
+		keys    [][]byte
+	)
+	for _, item := range items {
+		var key cfsThrottleThreadKey
+		if err := binary.Read(bytes.NewReader(item.Key), binary.LittleEndian, &key); err != nil {
+			return nil, fmt.Errorf("read cfs_throttle_threads key: %w", err)
+		}
+		if key.CSS != css {
+			continue
+		}
+
+		var thread cfsThrottleThread
+		if err := binary.Read(bytes.NewReader(item.Value), binary.LittleEndian, &thread); err != nil {
+			return nil, fmt.Errorf("read cfs_throttle_threads value: %w", err)
+		}
+
+		keys = append(keys, item.Key)
+		if now-thread.Start >= window {
+			continue
+		}
+
+		threads = append(threads, CFSThrottleThread{
+			Comm:        bytesutil.ToStr(thread.Comm[:]),
+			Pid:         key.Pid,
+			Tgid:        thread.Tgid,
+			ContainerID: c.containerID(thread.Leaf),
+			OnCPUMs:     float64(thread.Runtime) / float64(time.Millisecond),
+		})
+	}
+
+	if len(keys) > 0 {
+		if err := c.bpf.DeleteMapItems(c.bpf.MapIDByName("cfs_throttle_threads"), keys); err != nil {
+			log.Debugf("delete cfs_throttle_threads: %v", err)
+		}
+	}
+
+	slices.SortFunc(threads, func(a, b CFSThrottleThread) int {
+		switch {
+		case a.OnCPUMs > b.OnCPUMs:
+			return -1
+		case a.OnCPUMs < b.OnCPUMs:
+			return 1
+		}
+		return int(a.Pid) - int(b.Pid)
+	})
+	if len(threads) > n {
+		threads = threads[:n]
+	}
+	return threads, nil
+}
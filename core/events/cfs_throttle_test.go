@@ -0,0 +1,150 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"huatuo-bamai/internal/bpf/bpftest"
+	"huatuo-bamai/internal/pod"
+	"huatuo-bamai/pkg/tracing"
+	"huatuo-bamai/pkg/types"
+)
+
+func newCFSThrottleThread(runtime time.Duration, tgid uint32, comm string) *cfsThrottleThread {
+	thread := &cfsThrottleThread{Runtime: uint64(runtime), Start: monotonicNow(), Tgid: tgid}
+	copy(thread.Comm[:], comm)
+	return thread
+}
+
+func TestCFSThrottleTracing(t *testing.T) {
+	be := bpftest.Install(t)
+	rec, stop := tracing.Record()
+	t.Cleanup(stop)
+
+	cfg.CFSThrottle.Threshold = uint64(50 * time.Millisecond)
+	cfg.CFSThrottle.ContainerInterval = 10
+	cfg.CFSThrottle.TopThreads = 2
+
+	const css, otherCSS = 0xffff000000001000, 0xffff000000002000
+
+	obj := be.Object("cfs_throttle.o").Records("cfs_throttle_events", &cfsThrottlePerfEvent{
+		CSS:      css,
+		Duration: uint64(80 * time.Millisecond),
+		Quota:    uint64(200 * time.Millisecond),
+		Period:   uint64(100 * time.Millisecond),
+		Episodes: 7,
+		CPU:      2,
+	})
+	// the window of the thread expires before the event.
+	expired := newCFSThrottleThread(900*time.Millisecond, 1000, "idle")
+	expired.Start -= uint64(11 * time.Second)
+
+	obj.Map("cfs_throttle_threads").
+		Put(&cfsThrottleThreadKey{CSS: css, Pid: 1001}, newCFSThrottleThread(30*time.Millisecond, 1000, "java")).
+		Put(&cfsThrottleThreadKey{CSS: css, Pid: 1002}, newCFSThrottleThread(90*time.Millisecond, 1000, "GC thread")).
+		Put(&cfsThrottleThreadKey{CSS: css, Pid: 1003}, newCFSThrottleThread(10*time.Millisecond, 1000, "C2 compiler")).
+		Put(&cfsThrottleThreadKey{CSS: css, Pid: 1004}, expired).
+		Put(&cfsThrottleThreadKey{CSS: otherCSS, Pid: 2001}, newCFSThrottleThread(500*time.Millisecond, 2000, "stress"))
+
+	attr, _ := newCFSThrottle()
+	c := attr.TracingData.(*cfsThrottleTracing)
+	if err := c.Start(context.Background()); !errors.Is(err, types.ErrExitByCancelCtx) {
+		t.Errorf("Start() err=%v, want %v", err, types.ErrExitByCancelCtx)
+	}
+
+	consts := obj.Consts()
+	if consts["duration_thresh"] != uint64(50*time.Millisecond) || consts["container_interval"] != uint64(10*time.Second) {
+		t.Errorf("consts=%v", consts)
+	}
+
+	data := rec.TracerData(cfsThrottleTracerName)
+	if len(data) != 1 {
+		t.Fatalf("cfs_throttle tracing data=%d, want 1", len(data))
+	}
+	got, ok := data[0].(*CFSThrottleTracingData)
+	if !ok {
+		t.Fatalf("cfs_throttle tracing data=%T", data[0])
+	}
+
+	if got.Cgroup != "" || got.DurationMs != 80 || got.CPU != 2 || got.QuotaUs != 200000 || got.PeriodUs != 100000 || got.Episodes != 7 {
+		t.Errorf("cfs_throttle tracing data=%+v", got)
+	}
+
+	want := []CFSThrottleThread{
+		{Comm: "GC thread", Pid: 1002, Tgid: 1000, OnCPUMs: 90},
+		{Comm: "java", Pid: 1001, Tgid: 1000, OnCPUMs: 30},
+	}
+	if len(got.TopThreads) != len(want) {
+		t.Fatalf("top threads=%+v, want %+v", got.TopThreads, want)
+	}
+	for i := range want {
+		if got.TopThreads[i] != want[i] {
+			t.Errorf("top thread %d=%+v, want %+v", i, got.TopThreads[i], want[i])
+		}
+	}
+
+	// the threads of the throttled container start the next window.
+	if n := obj.Map("cfs_throttle_threads").Len(); n != 1 {
+		t.Errorf("cfs_throttle_threads=%d, want the other container only", n)
+	}
+	if c.running.Load() {
+		t.Errorf("running after the tracer stops")
+	}
+}
+
+func TestCFSThrottlePod(t *testing.T) {
+	be := bpftest.Install(t)
+	rec, stop := tracing.Record()
+	t.Cleanup(stop)
+
+	cfg.CFSThrottle.Threshold = uint64(50 * time.Millisecond)
+	cfg.CFSThrottle.ContainerInterval = 10
+	cfg.CFSThrottle.TopThreads = 2
+
+	// the pod is throttled, the threads are in the container of the pod.
+	const podCSS, containerCSS = 0xffff000000003000, 0xffff000000004000
+
+	obj := be.Object("cfs_throttle.o").Records("cfs_throttle_events", &cfsThrottlePerfEvent{
+		CSS:      podCSS,
+		Duration: uint64(60 * time.Millisecond),
+	})
+	thread := newCFSThrottleThread(40*time.Millisecond, 3000, "nginx")
+	thread.Leaf = containerCSS
+	obj.Map("cfs_throttle_threads").Put(&cfsThrottleThreadKey{CSS: podCSS, Pid: 3001}, thread)
+
+	attr, _ := newCFSThrottle()
+	c := attr.TracingData.(*cfsThrottleTracing)
+	c.cssContainers = map[uint64]*pod.Container{containerCSS: {ID: "container"}}
+	c.cacheTime = time.Now()
+	if err := c.Start(context.Background()); !errors.Is(err, types.ErrExitByCancelCtx) {
+		t.Errorf("Start() err=%v, want %v", err, types.ErrExitByCancelCtx)
+	}
+
+	data := rec.Requests()
+	if len(data) != 1 {
+		t.Fatalf("cfs_throttle tracing data=%d, want 1", len(data))
+	}
+	if data[0].ContainerID != "container" {
+		t.Errorf("container id=%q, want the container of the pod", data[0].ContainerID)
+	}
+	got := data[0].TracerData.(*CFSThrottleTracingData)
+	if got.Cgroup != "pod" || len(got.TopThreads) != 1 || got.TopThreads[0].ContainerID != "container" {
+		t.Errorf("cfs_throttle tracing data=%+v", got)
+	}
+}
@@ -36,14 +36,42 @@ type Config struct {
 		ExcludedNeighInvalidate bool `default:"true"`
 	}
 
+	TCPRetrans struct {
+		DocumentInterval int `default:"10"`
+		MaxRemotePorts   int `default:"64"`
+		MaxDocuments     int `default:"100"`
+		MaxConnections   int `default:"4096"`
+	}
+
 	Netdev struct {
 		DeviceList []string
 	}
 
+	SchedLatency struct {
+		Threshold         uint64 `default:"100000000"`
+		ContainerInterval int    `default:"10"`
+		EnableStacks      bool   `default:"false"`
+	}
+
+	CFSThrottle struct {
+		Threshold         uint64 `default:"50000000"`
+		ContainerInterval int    `default:"10"`
+		TopThreads        int    `default:"5"`
+	}
+
+	BlkLatency struct {
+		Threshold    uint64 `default:"500000000"`
+		EnableStacks bool   `default:"true"`
+	}
+
 	Ras struct {
 		MceThrBackoff int64 `default:"1800"`
 	}
 
+	Generic struct {
+		Dir string
+	}
+
 	IssuesList [][]string
 }
 
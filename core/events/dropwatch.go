@@ -28,6 +28,8 @@ import (
 	"huatuo-bamai/internal/utils/bytesutil"
 	"huatuo-bamai/internal/utils/netutil"
 	"huatuo-bamai/pkg/tracing"
+
+	"golang.org/x/sys/unix"
 )
 
 const (
@@ -66,33 +68,36 @@ var typeMap = map[uint8]string{
 }
 
 type perfEventT struct {
-	TgidPid            uint64                              `json:"tgid_pid"`
-	Saddr              uint32                              `json:"saddr"`
-	Daddr              uint32                              `json:"daddr"`
-	Sport              uint16                              `json:"sport"`
-	Dport              uint16                              `json:"dport"`
-	Seq                uint32                              `json:"seq"`
-	AckSeq             uint32                              `json:"ack_seq"`
-	PktLen             uint32                              `json:"pkt_len"`
-	StackSize          int64                               `json:"stack_size"`
-	Stack              [symbol.KsymbolStackMaxDepth]uint64 `json:"stack"`
-	NetdevQueueMapping uint32                              `json:"netdev_queue_mapping"`
-	NetdevFlags        uint32                              `json:"netdev_flags"`
-	NetdevName         [bpf.NetdevNameLen]byte             `json:"netdev_name"`
-	NetdevIfindex      uint32                              `json:"netdev_ifindex"`
-	SkMaxAckBacklog    uint32                              `json:"sk_max_ack_backlog"`
-	SkState            uint8                               `json:"sk_state"`
-	Type               uint8                               `json:"type"`
-	Pad0               uint16                              `json:"pad0"`
-	Pad1               uint32                              `json:"pad1"`
-	NetCookie          uint64                              `json:"net_cookie"`
-	Comm               [bpf.TaskCommLen]byte               `json:"comm"`
+	TgidPid            uint64                  `json:"tgid_pid"`
+	Saddr              [16]byte                `json:"saddr"`
+	Daddr              [16]byte                `json:"daddr"`
+	Sport              uint16                  `json:"sport"`
+	Dport              uint16                  `json:"dport"`
+	Seq                uint32                  `json:"seq"`
+	AckSeq             uint32                  `json:"ack_seq"`
+	PktLen             uint32                  `json:"pkt_len"`
+	StackID            int32                   `json:"stack_id"`
+	NetdevQueueMapping uint32                  `json:"netdev_queue_mapping"`
+	NetdevFlags        uint32                  `json:"netdev_flags"`
+	NetdevName         [bpf.NetdevNameLen]byte `json:"netdev_name"`
+	NetdevIfindex      uint32                  `json:"netdev_ifindex"`
+	SkMaxAckBacklog    uint32                  `json:"sk_max_ack_backlog"`
+	SkState            uint8                   `json:"sk_state"`
+	Type               uint8                   `json:"type"`
+	Family             uint16                  `json:"family"`
+	Reason             uint32                  `json:"reason"`
+	Pad2               uint32                  `json:"pad2"`
+	NetCookie          uint64                  `json:"net_cookie"`
+	Location           uint64                  `json:"location"`
+	Comm               [bpf.TaskCommLen]byte   `json:"comm"`
 }
 
 type DropWatchTracingData struct {
 	Type               string   `json:"type"`
+	Reason             string   `json:"reason"`
 	Comm               string   `json:"comm"`
 	Pid                uint64   `json:"pid"`
+	Family             string   `json:"family"`
 	Saddr              string   `json:"saddr"`
 	Daddr              string   `json:"daddr"`
 	Sport              uint16   `json:"sport"`
@@ -112,7 +117,10 @@ type DropWatchTracingData struct {
 	NetCookie          uint64   `json:"net_cookie"`
 }
 
-type dropWatchTracing struct{}
+type dropWatchTracing struct {
+	stacks  *bpf.StackTraces[string]
+	reasons netutil.DropReasons
+}
 
 //go:generate $BPF_COMPILE $BPF_INCLUDE -s $BPF_DIR/dropwatch.c -o $BPF_DIR/dropwatch.o
 
@@ -130,12 +138,25 @@ func newDropWatch() (*tracing.EventTracingAttr, error) {
 
 // Start starts the tracer.
 func (c *dropWatchTracing) Start(ctx context.Context) error {
-	b, err := bpf.LoadBpf(bpf.ThisBpfOBJ(), nil)
+	b, err := bpf.LoadBpfContext(ctx, bpf.ThisBpfOBJ(), nil)
 	if err != nil {
 		return fmt.Errorf("load bpf: %w", err)
 	}
 	defer b.Close()
 
+	// the drop reasons of the kernels >= 5.17.
+	if spec, err := bpf.KernelBTF(); err == nil {
+		c.reasons, _ = netutil.DropReasonsFromBTF(spec)
+	}
+
+	c.stacks, err = bpf.NewStackTraces(b, "stacks", func(addrs []uint64) string {
+		return strings.Join(symbol.DumpKernelBackTrace(addrs, bpf.StackDepth()).BackTrace, "\n")
+	})
+	if err != nil {
+		return err
+	}
+	c.stacks.EnableAging()
+
 	childCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
@@ -162,7 +183,7 @@ func (c *dropWatchTracing) Start(ctx context.Context) error {
 			// format
 			tracerData := c.formatEvent(&event)
 
-			if c.ignore(tracerData) {
+			if c.ignore(tracerData, event.Location) {
 				log.Debugf(logPrefix+"ignore dropwatch data: %v", tracerData)
 				continue
 			}
@@ -179,35 +200,28 @@ func (c *dropWatchTracing) Start(ctx context.Context) error {
 }
 
 func (c *dropWatchTracing) formatEvent(event *perfEventT) *DropWatchTracingData {
-	// hostname
-	saddr := netutil.Inetv4Ntop(event.Saddr).String()
-	daddr := netutil.Inetv4Ntop(event.Daddr).String()
-	srcHostname := "<nil>"
-	destHostname := "<nil>"
-	h, err := net.LookupAddr(saddr)
-	if err == nil && len(h) > 0 {
-		srcHostname = h[0]
-	}
-
-	h, err = net.LookupAddr(daddr)
-	if err == nil && len(h) > 0 {
-		destHostname = h[0]
-	}
+	saddr := netutil.InetNtop(event.Family, event.Saddr)
+	daddr := netutil.InetNtop(event.Family, event.Daddr)
 
 	// stack
-	stacks := strings.Join(symbol.DumpKernelBackTrace(event.Stack[:], symbol.KsymbolStackMaxDepth).BackTrace, "\n")
+	stacks, err := c.stacks.Get(event.StackID)
+	if err != nil {
+		log.Debugf(logPrefix+"stack: %v", err)
+	}
 
 	// tracer data
 	data := &DropWatchTracingData{
 		Type:               typeMap[event.Type],
+		Reason:             c.reasons.Name(event.Reason),
 		Comm:               bytesutil.ToStr(event.Comm[:]),
 		Pid:                event.TgidPid >> 32,
-		Saddr:              saddr,
-		Daddr:              daddr,
+		Family:             inetFamilyName(event.Family),
+		Saddr:              saddr.String(),
+		Daddr:              daddr.String(),
 		Sport:              netutil.Ntohs(event.Sport),
 		Dport:              netutil.Ntohs(event.Dport),
-		SrcHostname:        srcHostname,
-		DestHostname:       destHostname,
+		SrcHostname:        lookupHostname(saddr),
+		DestHostname:       lookupHostname(daddr),
 		Seq:                netutil.Ntohl(event.Seq),
 		AckSeq:             netutil.Ntohl(event.AckSeq),
 		PktLen:             event.PktLen,
@@ -225,8 +239,54 @@ func (c *dropWatchTracing) formatEvent(event *perfEventT) *DropWatchTracingData
 	return data
 }
 
-func (c *dropWatchTracing) ignore(data *DropWatchTracingData) bool {
-	stack := strings.Split(data.Stack, "\n")
+func inetFamilyName(family uint16) string {
+	switch family {
+	case unix.AF_INET:
+		return "ipv4"
+	case unix.AF_INET6:
+		return "ipv6"
+	default:
+		return "<nil>"
+	}
+}
+
+// lookupHostname returns the hostname of the IPv4 or IPv6 address, "<nil>" if
+// not found.
+func lookupHostname(ip net.IP) string {
+	if ip == nil || ip.IsUnspecified() {
+		return "<nil>"
+	}
+
+	h, err := net.LookupAddr(ip.String())
+	if err == nil && len(h) > 0 {
+		return h[0]
+	}
+	return "<nil>"
+}
+
+// dropCaller returns the frame of the caller of kfree_skb, the third of the
+// stack. The location of the event is the caller too, it is used when the stack
+// is lost, e.g. on the collisions of the stack trace map.
+func dropCaller(stack string, location uint64) string {
+	// the inlined functions of the source lines are not the frames.
+	var frames []string
+	for _, frame := range strings.Split(stack, "\n") {
+		if !strings.Contains(frame, " (inlined) ") {
+			frames = append(frames, frame)
+		}
+	}
+	if len(frames) >= 3 {
+		return frames[2]
+	}
+
+	if sym := symbol.KernelSymbol(location); sym.Name != "" {
+		return fmt.Sprintf("%s/+%d %s", sym.Name, location-sym.Addr, sym.Module)
+	}
+	return ""
+}
+
+func (c *dropWatchTracing) ignore(data *DropWatchTracingData, location uint64) bool {
+	caller := dropCaller(data.Stack, location)
 	// state: CLOSE_WAIT
 	// stack:
 	//	1. kfree_skb/ffffffff963047b0
@@ -235,7 +295,7 @@ func (c *dropWatchTracing) ignore(data *DropWatchTracingData) bool {
 	//	4. tcp_fin/ffffffff963ac200
 	//	5. ...
 	if data.SkState == "CLOSE_WAIT" {
-		if len(stack) >= 3 && strings.HasPrefix(stack[2], "skb_rbtree_purge/") {
+		if strings.HasPrefix(caller, "skb_rbtree_purge/") {
 			return true
 		}
 	}
@@ -247,7 +307,7 @@ func (c *dropWatchTracing) ignore(data *DropWatchTracingData) bool {
 	// 4. neigh_timer_handler/ffffffff96d3a870
 	// 5. ...
 	if cfg.Dropwatch.ExcludedNeighInvalidate {
-		if len(stack) >= 3 && strings.HasPrefix(stack[2], "neigh_invalidate/") {
+		if strings.HasPrefix(caller, "neigh_invalidate/") {
 			return true
 		}
 	}
@@ -265,8 +325,7 @@ func (c *dropWatchTracing) ignore(data *DropWatchTracingData) bool {
 	// 3. __bnxt_tx_int/ffffffffc045df90
 	// 4. bnxt_tx_int/ffffffffc045e250
 	// 5. ...
-	if len(stack) >= 3 &&
-		(strings.HasPrefix(stack[2], "bnxt_tx_int/") || strings.HasPrefix(stack[2], "__bnxt_tx_int/")) {
+	if strings.HasPrefix(caller, "bnxt_tx_int/") || strings.HasPrefix(caller, "__bnxt_tx_int/") {
 		return true
 	}
 
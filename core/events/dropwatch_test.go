@@ -0,0 +1,142 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"huatuo-bamai/internal/bpf/bpftest"
+	"huatuo-bamai/pkg/tracing"
+	"huatuo-bamai/pkg/types"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestDropWatchTracing(t *testing.T) {
+	be := bpftest.Install(t)
+	rec, stop := tracing.Record()
+	t.Cleanup(stop)
+
+	// 127.0.0.1:8080 -> 127.0.0.2:80 in the network order.
+	event := perfEventT{
+		TgidPid:         1000<<32 | 1001,
+		Saddr:           [16]byte{127, 0, 0, 1},
+		Daddr:           [16]byte{127, 0, 0, 2},
+		Family:          unix.AF_INET,
+		Sport:           binary.NativeEndian.Uint16([]byte{0x1f, 0x90}),
+		Dport:           binary.NativeEndian.Uint16([]byte{0x00, 0x50}),
+		PktLen:          60,
+		StackID:         -14, // -EFAULT, no stack.
+		SkMaxAckBacklog: 128,
+		SkState:         10, // LISTEN
+		Type:            typeTCPSynFlood,
+	}
+	copy(event.Comm[:], "nginx")
+	copy(event.NetdevName[:], "eth0")
+
+	obj := be.Object("dropwatch.o").Records("perf_events", &event)
+	obj.Map("stacks")
+
+	c := &dropWatchTracing{}
+	if err := c.Start(context.Background()); !errors.Is(err, types.ErrExitByCancelCtx) {
+		t.Errorf("Start() err=%v, want %v", err, types.ErrExitByCancelCtx)
+	}
+
+	data := rec.TracerData(tracerName)
+	if len(data) != 1 {
+		t.Fatalf("dropwatch tracing data=%d, want 1", len(data))
+	}
+	got, ok := data[0].(*DropWatchTracingData)
+	if !ok {
+		t.Fatalf("dropwatch tracing data=%T", data[0])
+	}
+
+	if got.Type != "syn_flood" || got.Comm != "nginx" || got.Pid != 1000 || got.SkState != "LISTEN" ||
+		got.MaxAckBacklog != 128 || got.NetdevName != "eth0" || got.Stack != "" {
+		t.Errorf("dropwatch tracing data=%+v", got)
+	}
+	if got.Family != "ipv4" || got.Saddr != "127.0.0.1" || got.Daddr != "127.0.0.2" || got.Sport != 8080 || got.Dport != 80 {
+		t.Errorf("dropwatch addrs=%s:%d -> %s:%d, want 127.0.0.1:8080 -> 127.0.0.2:80",
+			got.Saddr, got.Sport, got.Daddr, got.Dport)
+	}
+}
+
+func TestDropWatchTracingIPv6(t *testing.T) {
+	be := bpftest.Install(t)
+	rec, stop := tracing.Record()
+	t.Cleanup(stop)
+
+	// [2001:db8::1]:8080 -> [2001:db8::2]:80 in the network order.
+	event := perfEventT{
+		Saddr:   [16]byte{0x20, 0x01, 0x0d, 0xb8, 15: 0x01},
+		Daddr:   [16]byte{0x20, 0x01, 0x0d, 0xb8, 15: 0x02},
+		Sport:   binary.NativeEndian.Uint16([]byte{0x1f, 0x90}),
+		Dport:   binary.NativeEndian.Uint16([]byte{0x00, 0x50}),
+		StackID: -14, // -EFAULT, no stack.
+		SkState: 1,   // ESTABLISHED
+		Family:  unix.AF_INET6,
+		Type:    typeTCPCommonDrop,
+	}
+
+	obj := be.Object("dropwatch.o").Records("perf_events", &event)
+	obj.Map("stacks")
+
+	c := &dropWatchTracing{}
+	if err := c.Start(context.Background()); !errors.Is(err, types.ErrExitByCancelCtx) {
+		t.Errorf("Start() err=%v, want %v", err, types.ErrExitByCancelCtx)
+	}
+
+	data := rec.TracerData(tracerName)
+	if len(data) != 1 {
+		t.Fatalf("dropwatch tracing data=%d, want 1", len(data))
+	}
+	got := data[0].(*DropWatchTracingData)
+	if got.Family != "ipv6" || got.Saddr != "2001:db8::1" || got.Daddr != "2001:db8::2" ||
+		got.Sport != 8080 || got.Dport != 80 {
+		t.Errorf("dropwatch addrs=%s [%s]:%d -> [%s]:%d, want ipv6 [2001:db8::1]:8080 -> [2001:db8::2]:80",
+			got.Family, got.Saddr, got.Sport, got.Daddr, got.Dport)
+	}
+}
+
+func TestDropWatchTracingNoStacks(t *testing.T) {
+	be := bpftest.Install(t)
+	obj := be.Object("dropwatch.o").Records("perf_events", &perfEventT{})
+
+	c := &dropWatchTracing{}
+	if err := c.Start(context.Background()); err == nil {
+		t.Errorf("Start() without the stacks map, want error")
+	}
+	if !obj.Closed() {
+		t.Errorf("dropwatch.o should be closed")
+	}
+	if n := obj.Pending("perf_events"); n != 1 {
+		t.Errorf("pending records=%d, want 1", n)
+	}
+}
+
+func TestDropCaller(t *testing.T) {
+	stack := "kfree_skb/+0 [kernel]\n" +
+		"kfree_skb/+0 [kernel]\n" +
+		"__skb_queue_purge (inlined) include/linux/skbuff.h:3211\n" +
+		"skb_rbtree_purge/+16 [kernel] net/core/skbuff.c:3650\n" +
+		"tcp_fin/+200 [kernel]"
+
+	if caller := dropCaller(stack, 0); caller != "skb_rbtree_purge/+16 [kernel] net/core/skbuff.c:3650" {
+		t.Errorf("dropCaller()=%q, want the third frame", caller)
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package generic
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"huatuo-bamai/internal/bpf"
+	"huatuo-bamai/internal/log"
+	"huatuo-bamai/internal/symbol"
+	"huatuo-bamai/internal/utils/bytesutil"
+)
+
+// event is a decoded event.
+type event struct {
+	// values are the decoded fields by the names.
+	values map[string]any
+	css    uint64
+	hasCSS bool
+}
+
+// decoder decodes the raw events by the layout.
+type decoder struct {
+	layout  *layout
+	kstacks map[string]*bpf.StackTraces[string]
+	ustacks map[string]*bpf.StackTraces[[]uint64]
+	usym    *symbol.Usym
+}
+
+func newDecoder(b bpf.BPF, l *layout) (*decoder, error) {
+	d := &decoder{
+		layout:  l,
+		kstacks: make(map[string]*bpf.StackTraces[string]),
+		ustacks: make(map[string]*bpf.StackTraces[[]uint64]),
+	}
+
+	for _, f := range l.fields {
+		var err error
+
+		switch f.Type {
+		case "kstack":
+			if _, ok := d.kstacks[f.StackMap]; ok {
+				continue
+			}
+			d.kstacks[f.StackMap], err = bpf.NewStackTraces(b, f.StackMap, func(addrs []uint64) string {
+				return strings.Join(symbol.DumpKernelBackTrace(addrs, bpf.StackDepth()).BackTrace, "\n")
+			})
+		case "ustack":
+			if _, ok := d.ustacks[f.StackMap]; ok {
+				continue
+			}
+			// the user stacks are resolved by the pid of every event.
+			d.ustacks[f.StackMap], err = bpf.NewStackTraces(b, f.StackMap, func(addrs []uint64) []uint64 {
+				return addrs
+			})
+			d.usym = symbol.NewUsym()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", f.Name, err)
+		}
+	}
+
+	// the stack ids are resolved right after the events are read.
+	for _, stacks := range d.kstacks {
+		stacks.EnableAging()
+	}
+	for _, stacks := range d.ustacks {
+		stacks.EnableAging()
+	}
+
+	return d, nil
+}
+
+// decode decodes the raw event, the stacks are resolved if the decoder has
+// the stack trace maps.
+func (d *decoder) decode(raw []byte) (*event, error) {
+	if len(raw) < d.layout.size {
+		return nil, fmt.Errorf("event size %d, want %d", len(raw), d.layout.size)
+	}
+
+	ev := &event{values: make(map[string]any, len(d.layout.fields))}
+	for _, f := range d.layout.fields {
+		data := raw[f.offset : f.offset+f.size]
+
+		switch f.Type {
+		case "pad":
+			continue
+		case "u8":
+			ev.values[f.Name] = uint64(data[0])
+		case "s8":
+			ev.values[f.Name] = int64(int8(data[0]))
+		case "bool":
+			ev.values[f.Name] = data[0] != 0
+		case "u16":
+			ev.values[f.Name] = uint64(f.order.Uint16(data))
+		case "s16":
+			ev.values[f.Name] = int64(int16(f.order.Uint16(data)))
+		case "u32":
+			ev.values[f.Name] = uint64(f.order.Uint32(data))
+		case "s32":
+			ev.values[f.Name] = int64(int32(f.order.Uint32(data)))
+		case "u64":
+			ev.values[f.Name] = f.order.Uint64(data)
+		case "s64":
+			ev.values[f.Name] = int64(f.order.Uint64(data))
+		case "string", "comm":
+			ev.values[f.Name] = bytesutil.ToStr(data)
+		case "bytes":
+			ev.values[f.Name] = hex.EncodeToString(data)
+		case "ipv4":
+			ev.values[f.Name] = netip.AddrFrom4([4]byte(data)).String()
+		case "ipv6":
+			ev.values[f.Name] = netip.AddrFrom16([16]byte(data)).Unmap().String()
+		case "kstack", "ustack":
+			ev.values[f.Name] = int64(int32(f.order.Uint32(data)))
+		case "css":
+			ev.css = f.order.Uint64(data)
+			ev.hasCSS = true
+			ev.values[f.Name] = fmt.Sprintf("0x%x", ev.css)
+		}
+	}
+
+	// the stacks by the stack ids.
+	for _, f := range d.layout.fields {
+		switch f.Type {
+		case "kstack":
+			id, _ := ev.values[f.Name].(int64)
+			stack, err := d.kstacks[f.StackMap].Get(int32(id))
+			if err != nil {
+				log.Debugf("generic tracer field %s: %v", f.Name, err)
+			}
+			ev.values[f.Name] = stack
+		case "ustack":
+			id, _ := ev.values[f.Name].(int64)
+			addrs, err := d.ustacks[f.StackMap].Get(int32(id))
+			if err != nil {
+				log.Debugf("generic tracer field %s: %v", f.Name, err)
+			}
+			ev.values[f.Name] = d.resolveUstack(addrs, uint32(integerValue(ev.values[f.PID])))
+		}
+	}
+
+	return ev, nil
+}
+
+func (d *decoder) resolveUstack(addrs []uint64, pid uint32) string {
+	frames := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		frames = append(frames, d.usym.ResolveUstack(addr, pid))
+	}
+	return strings.Join(frames, "\n")
+}
+
+// integerValue returns the integer field as float64, 0 for the others.
+func integerValue(v any) float64 {
+	switch n := v.(type) {
+	case uint64:
+		return float64(n)
+	case int64:
+		return float64(n)
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package generic
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	spec := &Spec{Event: EventSpec{
+		Map:       "events",
+		ByteOrder: "little",
+		Fields: []FieldSpec{
+			{Name: "pid", Type: "u32"},
+			{Name: "ret", Type: "s32"},
+			{Name: "comm", Type: "comm"},
+			{Name: "dport", Type: "u16", ByteOrder: "big"},
+			{Name: "flag", Type: "bool"},
+			{Name: "_", Type: "pad", Size: 1},
+			{Name: "saddr", Type: "ipv4"},
+			{Name: "daddr", Type: "ipv6"},
+			{Name: "css", Type: "css"},
+			{Name: "raw", Type: "bytes", Size: 2},
+		},
+	}}
+
+	l, err := spec.layout()
+	if err != nil {
+		t.Fatalf("layout() error = %v", err)
+	}
+
+	raw := make([]byte, l.size)
+	binary.LittleEndian.PutUint32(raw[0:], 1234)
+	binary.LittleEndian.PutUint32(raw[4:], uint32(0xfffffffe)) // -2
+	copy(raw[8:], "curl\x00garbage")
+	binary.BigEndian.PutUint16(raw[24:], 443)
+	raw[26] = 1
+	copy(raw[28:], []byte{10, 0, 0, 1})
+	copy(raw[32:], []byte{0x20, 0x01, 0x0d, 0xb8, 15: 1})
+	binary.LittleEndian.PutUint64(raw[48:], 0xffff0001)
+	copy(raw[56:], []byte{0xab, 0xcd})
+
+	d := &decoder{layout: l}
+	ev, err := d.decode(raw)
+	if err != nil {
+		t.Fatalf("decode() error = %v", err)
+	}
+
+	want := map[string]any{
+		"pid":   uint64(1234),
+		"ret":   int64(-2),
+		"comm":  "curl",
+		"dport": uint64(443),
+		"flag":  true,
+		"saddr": "10.0.0.1",
+		"daddr": "2001:db8::1",
+		"css":   "0xffff0001",
+		"raw":   "abcd",
+	}
+	if len(ev.values) != len(want) {
+		t.Errorf("decode() = %v, want %v", ev.values, want)
+	}
+	for k, v := range want {
+		if ev.values[k] != v {
+			t.Errorf("decode() %s = %v (%T), want %v (%T)", k, ev.values[k], ev.values[k], v, v)
+		}
+	}
+	if !ev.hasCSS || ev.css != 0xffff0001 {
+		t.Errorf("decode() css = %x, %v", ev.css, ev.hasCSS)
+	}
+
+	if _, err := d.decode(raw[:10]); err == nil {
+		t.Errorf("decode() short event error = nil")
+	}
+}
+
+func TestCount(t *testing.T) {
+	tracer := &genericTracer{
+		spec: &Spec{
+			Name: "generic_count",
+			Counters: []CounterSpec{
+				{Name: "total", Labels: []string{"comm"}},
+				{Name: "bytes", Value: "len"},
+			},
+		},
+		counters: make(map[string]*counter),
+	}
+
+	for _, ev := range []*event{
+		{values: map[string]any{"comm": "curl", "len": uint64(100)}},
+		{values: map[string]any{"comm": "curl", "len": uint64(50)}},
+		{values: map[string]any{"comm": "wget", "len": uint64(10)}},
+	} {
+		tracer.count(ev, "")
+	}
+
+	got := make(map[string]float64)
+	for _, c := range tracer.counters {
+		got[c.name+"/"+c.labels["comm"]] = c.value
+	}
+
+	want := map[string]float64{"total/curl": 2, "total/wget": 1, "bytes/": 160}
+	if len(got) != len(want) {
+		t.Errorf("count() = %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("count() %s = %v, want %v", k, got[k], v)
+		}
+	}
+}
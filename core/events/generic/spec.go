@@ -0,0 +1,399 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package generic
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"huatuo-bamai/internal/pod"
+
+	"github.com/pelletier/go-toml"
+	"sigs.k8s.io/yaml"
+)
+
+// Spec describes a generic tracer, the bpf object, the attach points, the
+// event pipe and the binary layout of the events, e.g.
+//
+//	name: tcp_connect
+//	object: tcp_connect.o
+//	attach:
+//	  - program: kprobe_tcp_connect
+//	event:
+//	  map: events
+//	  fields:
+//	    - {name: pid, type: u32}
+//	    - {name: comm, type: comm}
+//	    - {name: daddr, type: ipv4}
+//	    - {name: dport, type: u16, byte_order: big}
+//	    - {name: css, type: css, subsys: cpu}
+//	counters:
+//	  - {name: connect_total, labels: [comm]}
+type Spec struct {
+	// Name is the tracer name, defaults to the spec file name.
+	Name string `json:"name" toml:"name"`
+	// Object is the bpf object in the directory of the spec, defaults to
+	// <name>.o.
+	Object string `json:"object" toml:"object"`
+	// Interval is the restart interval in seconds when the tracer exits.
+	Interval  int            `json:"interval" toml:"interval"`
+	Constants []ConstantSpec `json:"constants" toml:"constants"`
+	// Attach are the programs attached with options, all programs of the
+	// object are attached by the section names if empty.
+	Attach   []AttachSpec  `json:"attach" toml:"attach"`
+	Event    EventSpec     `json:"event" toml:"event"`
+	Counters []CounterSpec `json:"counters" toml:"counters"`
+}
+
+// ConstantSpec is a `volatile const` of the bpf object, rewritten on loading.
+type ConstantSpec struct {
+	Name string `json:"name" toml:"name"`
+	// Type is one of u8, u16, u32, u64, s8, s16, s32, s64 and bool.
+	Type  string `json:"type" toml:"type"`
+	Value any    `json:"value" toml:"value"`
+}
+
+// AttachSpec is an attach point, see bpf.AttachOption.
+type AttachSpec struct {
+	Program    string `json:"program" toml:"program"`
+	Symbol     string `json:"symbol" toml:"symbol"`
+	SampleFreq uint64 `json:"sample_freq" toml:"sample_freq"`
+	Path       string `json:"path" toml:"path"`
+	PID        int    `json:"pid" toml:"pid"`
+	CgroupPath string `json:"cgroup_path" toml:"cgroup_path"`
+}
+
+// EventSpec is the event pipe and the event struct.
+type EventSpec struct {
+	// Map is the perf event array, with the optional ring buffer of
+	// BPF_EVENT_PIPE, or the ring buffer.
+	Map          string `json:"map" toml:"map"`
+	PerCPUBuffer uint32 `json:"per_cpu_buffer" toml:"per_cpu_buffer"`
+	// ByteOrder is little, big or native, the default.
+	ByteOrder string      `json:"byte_order" toml:"byte_order"`
+	Fields    []FieldSpec `json:"fields" toml:"fields"`
+}
+
+// FieldSpec is a field of the event struct.
+type FieldSpec struct {
+	Name string `json:"name" toml:"name"`
+	// Type is one of:
+	//
+	//	u8, u16, u32, u64, s8, s16, s32, s64, bool: the integers.
+	//	string: char[size], until the first NUL.
+	//	comm: char[16], the task comm.
+	//	bytes: u8[size], in hex.
+	//	ipv4, ipv6: the addresses in the network byte order.
+	//	kstack, ustack: the s32 stack ids of the stack_map, the user stacks
+	//	  are resolved by the process of the pid field.
+	//	css: the u64 cgroup css, the events are attributed to the container.
+	//	pad: the padding of size bytes, not decoded.
+	Type string `json:"type" toml:"type"`
+	// Offset is the offset in the struct, defaults to the next offset
+	// aligned as the C compiler does.
+	Offset *int `json:"offset" toml:"offset"`
+	// Size is the size of string, bytes and pad.
+	Size int `json:"size" toml:"size"`
+	// ByteOrder overrides the byte order of the event for the integers.
+	ByteOrder string `json:"byte_order" toml:"byte_order"`
+	// StackMap is the stack trace map of kstack and ustack.
+	StackMap string `json:"stack_map" toml:"stack_map"`
+	// PID is the pid field of ustack.
+	PID string `json:"pid" toml:"pid"`
+	// Subsys is the cgroup subsystem of css, defaults to cpu.
+	Subsys string `json:"subsys" toml:"subsys"`
+	// Hidden fields are decoded for the counters only.
+	Hidden bool `json:"hidden" toml:"hidden"`
+}
+
+// CounterSpec is a counter of the events, exported as the metric of the
+// tracer, per container for the events attributed to containers.
+type CounterSpec struct {
+	Name string `json:"name" toml:"name"`
+	Help string `json:"help" toml:"help"`
+	// Labels are the fields as the labels.
+	Labels []string `json:"labels" toml:"labels"`
+	// Value is the integer field added to the counter, 1 if empty.
+	Value string `json:"value" toml:"value"`
+}
+
+// field is the decoded layout of a field.
+type field struct {
+	FieldSpec
+	offset int
+	size   int
+	order  binary.ByteOrder
+}
+
+// layout is the decoded layout of the event struct.
+type layout struct {
+	fields []field
+	size   int
+	// css is the index of the css field, -1 if none.
+	css int
+}
+
+// fieldSizes are the sizes and the alignments of the fixed size types.
+var fieldSizes = map[string][2]int{
+	"u8": {1, 1}, "s8": {1, 1}, "bool": {1, 1},
+	"u16": {2, 2}, "s16": {2, 2},
+	"u32": {4, 4}, "s32": {4, 4},
+	"u64": {8, 8}, "s64": {8, 8},
+	"comm":   {16, 1},
+	"ipv4":   {4, 4},
+	"ipv6":   {16, 4},
+	"kstack": {4, 4}, "ustack": {4, 4},
+	"css": {8, 8},
+}
+
+// LoadSpec loads the spec of yaml or toml.
+func LoadSpec(path string) (*Spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := &Spec{}
+	switch ext := filepath.Ext(path); ext {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, spec)
+	case ".toml":
+		err = toml.Unmarshal(data, spec)
+	default:
+		return nil, fmt.Errorf("unknown spec format: %s", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse spec %s: %w", path, err)
+	}
+
+	if spec.Name == "" {
+		spec.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if spec.Object == "" {
+		spec.Object = spec.Name + ".o"
+	}
+	if spec.Interval <= 0 {
+		spec.Interval = 10
+	}
+	if spec.Event.PerCPUBuffer == 0 {
+		spec.Event.PerCPUBuffer = 8192
+	}
+
+	if _, err := spec.layout(); err != nil {
+		return nil, fmt.Errorf("spec %s: %w", path, err)
+	}
+	if _, err := spec.constants(); err != nil {
+		return nil, fmt.Errorf("spec %s: %w", path, err)
+	}
+	return spec, nil
+}
+
+func byteOrder(order string) (binary.ByteOrder, error) {
+	switch order {
+	case "", "native":
+		return binary.NativeEndian, nil
+	case "little":
+		return binary.LittleEndian, nil
+	case "big":
+		return binary.BigEndian, nil
+	default:
+		return nil, fmt.Errorf("invalid byte order: %s", order)
+	}
+}
+
+// layout validates the spec and returns the layout of the event struct.
+func (s *Spec) layout() (*layout, error) {
+	if s.Event.Map == "" {
+		return nil, fmt.Errorf("no event map")
+	}
+	if len(s.Event.Fields) == 0 {
+		return nil, fmt.Errorf("no event fields")
+	}
+
+	order, err := byteOrder(s.Event.ByteOrder)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &layout{css: -1}
+	names := make(map[string]string)
+	offset, maxAlign := 0, 1
+
+	for _, fs := range s.Event.Fields {
+		f := field{FieldSpec: fs, order: order}
+
+		if f.Name == "" {
+			return nil, fmt.Errorf("field without name")
+		}
+		if _, ok := names[f.Name]; ok {
+			return nil, fmt.Errorf("duplicate field: %s", f.Name)
+		}
+		names[f.Name] = f.Type
+
+		if f.ByteOrder != "" {
+			if f.order, err = byteOrder(f.ByteOrder); err != nil {
+				return nil, fmt.Errorf("field %s: %w", f.Name, err)
+			}
+		}
+
+		align := 1
+		switch f.Type {
+		case "string", "bytes", "pad":
+			if f.Size <= 0 {
+				return nil, fmt.Errorf("field %s: %s needs the size", f.Name, f.Type)
+			}
+			f.size = f.Size
+		default:
+			sa, ok := fieldSizes[f.Type]
+			if !ok {
+				return nil, fmt.Errorf("field %s: invalid type %q", f.Name, f.Type)
+			}
+			f.size, align = sa[0], sa[1]
+		}
+
+		switch f.Type {
+		case "kstack", "ustack":
+			if f.StackMap == "" {
+				return nil, fmt.Errorf("field %s: %s needs the stack_map", f.Name, f.Type)
+			}
+		case "css":
+			if l.css >= 0 {
+				return nil, fmt.Errorf("field %s: only one css field", f.Name)
+			}
+			if f.Subsys == "" {
+				f.Subsys = pod.SubSysCPU
+			}
+			l.css = len(l.fields)
+		}
+
+		if f.Offset != nil {
+			if *f.Offset < 0 {
+				return nil, fmt.Errorf("field %s: invalid offset %d", f.Name, *f.Offset)
+			}
+			offset = *f.Offset
+		} else {
+			offset = (offset + align - 1) / align * align
+		}
+
+		f.offset = offset
+		offset += f.size
+		l.size = max(l.size, offset)
+		maxAlign = max(maxAlign, align)
+		l.fields = append(l.fields, f)
+	}
+
+	// the tail padding of the C struct.
+	l.size = (l.size + maxAlign - 1) / maxAlign * maxAlign
+
+	// the pid fields of the user stacks.
+	for _, f := range l.fields {
+		if f.Type != "ustack" {
+			continue
+		}
+		if typ, ok := names[f.PID]; !ok || !isInteger(typ) {
+			return nil, fmt.Errorf("field %s: ustack needs the integer pid field", f.Name)
+		}
+	}
+
+	// the counters.
+	for _, c := range s.Counters {
+		if c.Name == "" {
+			return nil, fmt.Errorf("counter without name")
+		}
+		for _, label := range c.Labels {
+			if _, ok := names[label]; !ok {
+				return nil, fmt.Errorf("counter %s: label field %s not found", c.Name, label)
+			}
+		}
+		if c.Value != "" && !isInteger(names[c.Value]) {
+			return nil, fmt.Errorf("counter %s: value field %s is not an integer", c.Name, c.Value)
+		}
+	}
+
+	return l, nil
+}
+
+func isInteger(typ string) bool {
+	return slices.Contains([]string{"u8", "u16", "u32", "u64", "s8", "s16", "s32", "s64"}, typ)
+}
+
+// constants returns the constants to rewrite, typed as the bpf variables.
+func (s *Spec) constants() (map[string]any, error) {
+	if len(s.Constants) == 0 {
+		return nil, nil
+	}
+
+	consts := make(map[string]any, len(s.Constants))
+	for _, c := range s.Constants {
+		v, err := constantValue(c.Type, c.Value)
+		if err != nil {
+			return nil, fmt.Errorf("constant %s: %w", c.Name, err)
+		}
+		consts[c.Name] = v
+	}
+	return consts, nil
+}
+
+func constantValue(typ string, value any) (any, error) {
+	if typ == "bool" {
+		b, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("invalid bool: %v", value)
+		}
+		return b, nil
+	}
+
+	var n int64
+	switch v := value.(type) {
+	case int:
+		n = int64(v)
+	case int64:
+		n = v
+	case uint64:
+		n = int64(v)
+	case float64: // yaml decodes the numbers by json.
+		if v != float64(int64(v)) {
+			return nil, fmt.Errorf("invalid integer: %v", value)
+		}
+		n = int64(v)
+	default:
+		return nil, fmt.Errorf("invalid integer: %v", value)
+	}
+
+	switch typ {
+	case "u8":
+		return uint8(n), nil
+	case "u16":
+		return uint16(n), nil
+	case "u32":
+		return uint32(n), nil
+	case "u64":
+		return uint64(n), nil
+	case "s8":
+		return int8(n), nil
+	case "s16":
+		return int16(n), nil
+	case "s32":
+		return int32(n), nil
+	case "s64":
+		return n, nil
+	default:
+		return nil, fmt.Errorf("invalid type %q", typ)
+	}
+}
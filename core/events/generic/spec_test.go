@@ -0,0 +1,185 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package generic
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const yamlSpec = `
+object: tcp.o
+constants:
+  - {name: threshold, type: u64, value: 1000}
+  - {name: enabled, type: bool, value: true}
+attach:
+  - {program: kprobe_tcp_connect, symbol: tcp_v4_connect}
+event:
+  map: events
+  fields:
+    - {name: pid, type: u32}
+    - {name: comm, type: comm}
+    - {name: dport, type: u16, byte_order: big}
+    - {name: css, type: css, subsys: memory}
+    - {name: daddr, type: ipv4}
+    - {name: latency, type: u64}
+counters:
+  - {name: connect_total, labels: [comm]}
+  - {name: latency_total, value: latency}
+`
+
+const tomlSpec = `
+name = "tcp_toml"
+
+[event]
+map = "events"
+byte_order = "little"
+
+[[event.fields]]
+name = "pid"
+type = "u32"
+
+[[event.fields]]
+name = "_"
+type = "pad"
+size = 4
+
+[[event.fields]]
+name = "ts"
+type = "u64"
+`
+
+func writeSpec(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadSpecYAML(t *testing.T) {
+	spec, err := LoadSpec(writeSpec(t, "tcp.yaml", yamlSpec))
+	if err != nil {
+		t.Fatalf("LoadSpec() error = %v", err)
+	}
+
+	if spec.Name != "tcp" || spec.Object != "tcp.o" || spec.Interval != 10 || spec.Event.PerCPUBuffer != 8192 {
+		t.Errorf("LoadSpec() = %+v, want the defaults", spec)
+	}
+	if len(spec.Attach) != 1 || spec.Attach[0].Symbol != "tcp_v4_connect" {
+		t.Errorf("LoadSpec() attach = %+v", spec.Attach)
+	}
+
+	consts, err := spec.constants()
+	if err != nil {
+		t.Fatalf("constants() error = %v", err)
+	}
+	if consts["threshold"] != uint64(1000) || consts["enabled"] != true {
+		t.Errorf("constants() = %v", consts)
+	}
+
+	l, err := spec.layout()
+	if err != nil {
+		t.Fatalf("layout() error = %v", err)
+	}
+
+	// pid 0, comm 4, dport 20, css 24, daddr 32, latency 40.
+	wantOffsets := []int{0, 4, 20, 24, 32, 40}
+	for i, f := range l.fields {
+		if f.offset != wantOffsets[i] {
+			t.Errorf("field %s offset = %d, want %d", f.Name, f.offset, wantOffsets[i])
+		}
+	}
+	if l.size != 48 {
+		t.Errorf("layout() size = %d, want 48", l.size)
+	}
+	if l.css != 3 {
+		t.Errorf("layout() css = %d, want 3", l.css)
+	}
+}
+
+func TestLoadSpecTOML(t *testing.T) {
+	spec, err := LoadSpec(writeSpec(t, "tcp.toml", tomlSpec))
+	if err != nil {
+		t.Fatalf("LoadSpec() error = %v", err)
+	}
+
+	if spec.Name != "tcp_toml" || spec.Object != "tcp_toml.o" {
+		t.Errorf("LoadSpec() name = %s, object = %s", spec.Name, spec.Object)
+	}
+
+	l, err := spec.layout()
+	if err != nil {
+		t.Fatalf("layout() error = %v", err)
+	}
+	if l.fields[2].offset != 8 || l.size != 16 {
+		t.Errorf("layout() ts offset = %d, size = %d, want 8, 16", l.fields[2].offset, l.size)
+	}
+}
+
+func TestLoadSpecInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"no map", "event: {fields: [{name: a, type: u32}]}", "no event map"},
+		{"no fields", "event: {map: events}", "no event fields"},
+		{"invalid type", "event: {map: events, fields: [{name: a, type: u128}]}", "invalid type"},
+		{"duplicate field", "event: {map: events, fields: [{name: a, type: u32}, {name: a, type: u32}]}", "duplicate field"},
+		{"string without size", "event: {map: events, fields: [{name: a, type: string}]}", "needs the size"},
+		{"stack without map", "event: {map: events, fields: [{name: a, type: kstack}]}", "needs the stack_map"},
+		{"ustack without pid", "event: {map: events, fields: [{name: a, type: ustack, stack_map: s}]}", "pid field"},
+		{"two css", "event: {map: events, fields: [{name: a, type: css}, {name: b, type: css}]}", "only one css"},
+		{"invalid byte order", "event: {map: events, byte_order: middle, fields: [{name: a, type: u32}]}", "invalid byte order"},
+		{"unknown label", "event: {map: events, fields: [{name: a, type: u32}]}\ncounters: [{name: c, labels: [b]}]", "label field b"},
+		{"value not integer", "event: {map: events, fields: [{name: a, type: comm}]}\ncounters: [{name: c, value: a}]", "not an integer"},
+		{"invalid constant", "constants: [{name: c, type: u32, value: x}]\nevent: {map: events, fields: [{name: a, type: u32}]}", "invalid integer"},
+		{"unknown key", "unknown: 1\nevent: {map: events, fields: [{name: a, type: u32}]}", "unknown"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadSpec(writeSpec(t, "invalid.yaml", tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadSpec() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegister(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "generic_register_test.yaml"),
+		[]byte("event: {map: events, fields: [{name: a, type: u32}]}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "README"), []byte("not a spec"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Register(dir); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	// the name is registered.
+	if err := Register(dir); err == nil {
+		t.Errorf("Register() again error = nil, want the registered error")
+	}
+}
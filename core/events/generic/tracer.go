@@ -0,0 +1,340 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package generic provides the generic tracers, which are loaded from the
+// user bpf objects and the specs, without any Go code.
+package generic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"huatuo-bamai/internal/bpf"
+	"huatuo-bamai/internal/log"
+	"huatuo-bamai/internal/pod"
+	"huatuo-bamai/pkg/metric"
+	"huatuo-bamai/pkg/tracing"
+)
+
+const (
+	cssCacheTTL = 5 * time.Second
+
+	// maxHostCounters bounds the counters without a container, the labels
+	// of the user specs may be of any cardinality. The counters of the
+	// containers are removed with the containers.
+	maxHostCounters = 4096
+)
+
+// Register registers the generic tracers of the specs, *.yaml, *.yml and
+// *.toml, in the directory. The bpf objects are in the same directory.
+func Register(dir string) error {
+	if dir == "" {
+		return nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("read generic tracers: %w", err)
+	}
+
+	for _, e := range entries {
+		if e.IsDir() || !slices.Contains([]string{".yaml", ".yml", ".toml"}, filepath.Ext(e.Name())) {
+			continue
+		}
+
+		spec, err := LoadSpec(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return err
+		}
+
+		if tracing.EventTracingRegistered(spec.Name) {
+			return fmt.Errorf("generic tracer %s: the name is registered", spec.Name)
+		}
+
+		t := &genericTracer{
+			spec:     spec,
+			dir:      dir,
+			counters: make(map[string]*counter),
+		}
+		tracing.RegisterEventTracing(spec.Name, t.attr)
+		log.Infof("register generic tracer %s: %s", spec.Name, e.Name())
+	}
+
+	return nil
+}
+
+// counter is a counter of the events with the same labels.
+type counter struct {
+	name        string
+	help        string
+	containerID string
+	labels      map[string]string
+	value       float64
+}
+
+type genericTracer struct {
+	spec *Spec
+	dir  string
+
+	cssContainers map[uint64]string
+	cacheTime     time.Time
+
+	mu               sync.Mutex
+	counters         map[string]*counter
+	hostCounters     int
+	hostCountersFull bool
+	// badEvents are the events not read or decoded, e.g. the short records
+	// of a spec not matching the bpf object, they are skipped.
+	badEvents uint64
+}
+
+func (t *genericTracer) attr() (*tracing.EventTracingAttr, error) {
+	if _, err := os.Stat(filepath.Join(t.dir, t.spec.Object)); err != nil {
+		return nil, fmt.Errorf("bpf object of %s: %w", t.spec.Name, err)
+	}
+
+	flag := tracing.FlagTracing
+	if len(t.spec.Counters) > 0 {
+		flag |= tracing.FlagMetric
+	}
+
+	return &tracing.EventTracingAttr{
+		TracingData: t,
+		Interval:    t.spec.Interval,
+		Flag:        flag,
+	}, nil
+}
+
+func (t *genericTracer) attachOptions() []bpf.AttachOption {
+	opts := make([]bpf.AttachOption, 0, len(t.spec.Attach))
+	for _, a := range t.spec.Attach {
+		opt := bpf.AttachOption{ProgramName: a.Program, Symbol: a.Symbol}
+		opt.PerfEvent.SampleFreq = a.SampleFreq
+		opt.Uprobe.Path = a.Path
+		opt.Uprobe.PID = a.PID
+		opt.Uprobe.CgroupPath = a.CgroupPath
+		opts = append(opts, opt)
+	}
+	return opts
+}
+
+func (t *genericTracer) Start(ctx context.Context) error {
+	layout, err := t.spec.layout()
+	if err != nil {
+		return err
+	}
+	consts, err := t.spec.constants()
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(filepath.Join(t.dir, t.spec.Object))
+	if err != nil {
+		return err
+	}
+
+	b, err := bpf.LoadBpfFromBytesContext(ctx, t.spec.Object, data, consts)
+	if err != nil {
+		return fmt.Errorf("load bpf: %w", err)
+	}
+	defer b.Close()
+
+	dec, err := newDecoder(b, layout)
+	if err != nil {
+		return err
+	}
+
+	childCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var reader bpf.PerfEventReader
+	if len(t.spec.Attach) == 0 {
+		reader, err = b.AttachAndEventPipe(childCtx, t.spec.Event.Map, t.spec.Event.PerCPUBuffer)
+	} else {
+		if err = b.AttachWithOptions(t.attachOptions()); err != nil {
+			return fmt.Errorf("attach: %w", err)
+		}
+		reader, err = b.EventPipeByName(childCtx, t.spec.Event.Map, t.spec.Event.PerCPUBuffer)
+	}
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	b.WaitDetachByBreaker(childCtx, cancel)
+
+	raw := make([]byte, layout.size)
+	for {
+		select {
+		case <-childCtx.Done():
+			return nil
+		default:
+			err := reader.ReadInto(raw)
+			if errors.Is(err, bpf.ErrBadEvent) {
+				t.badEvent(err)
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("read event: %w", err)
+			}
+
+			ev, err := dec.decode(raw)
+			if err != nil {
+				t.badEvent(err)
+				continue
+			}
+
+			t.handle(ev)
+		}
+	}
+}
+
+func (t *genericTracer) badEvent(err error) {
+	t.mu.Lock()
+	t.badEvents++
+	t.mu.Unlock()
+
+	log.Debugf("generic tracer %s: skip the bad event: %v", t.spec.Name, err)
+}
+
+// handle saves the event and updates the counters.
+func (t *genericTracer) handle(ev *event) {
+	var containerID string
+	if ev.hasCSS {
+		containerID = t.containerID(ev.css, t.cssSubsys())
+	}
+
+	t.count(ev, containerID)
+
+	values := make(map[string]any, len(ev.values))
+	for _, f := range t.spec.Event.Fields {
+		if v, ok := ev.values[f.Name]; ok && !f.Hidden {
+			values[f.Name] = v
+		}
+	}
+
+	if err := tracing.Save(&tracing.WriteRequest{
+		TracerName:  t.spec.Name,
+		ContainerID: containerID,
+		TracerTime:  time.Now(),
+		TracerData:  values,
+	}); err != nil {
+		log.Warnf("failed to save tracing data: %v", err)
+	}
+}
+
+func (t *genericTracer) cssSubsys() string {
+	for _, f := range t.spec.Event.Fields {
+		if f.Type == "css" && f.Subsys != "" {
+			return f.Subsys
+		}
+	}
+	return pod.SubSysCPU
+}
+
+func (t *genericTracer) containerID(css uint64, subsys string) string {
+	id, ok := t.cssContainers[css]
+	if !ok || time.Since(t.cacheTime) > cssCacheTTL {
+		containers, err := pod.Containers()
+		if err != nil {
+			log.Debugf("generic tracer %s: fetching the containers: %v", t.spec.Name, err)
+			return ""
+		}
+		t.cssContainers = pod.BuildCssContainersID(containers, subsys)
+		t.cacheTime = time.Now()
+		id = t.cssContainers[css]
+	}
+	return id
+}
+
+// count updates the counters by the event.
+func (t *genericTracer) count(ev *event, containerID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, c := range t.spec.Counters {
+		labels := make(map[string]string, len(c.Labels))
+		keys := []string{c.Name, containerID}
+		for _, label := range c.Labels {
+			labels[label] = fmt.Sprint(ev.values[label])
+			keys = append(keys, labels[label])
+		}
+
+		key := strings.Join(keys, "\x00")
+		cnt, ok := t.counters[key]
+		if !ok {
+			if containerID == "" {
+				if t.hostCounters >= maxHostCounters {
+					if !t.hostCountersFull {
+						log.Warnf("generic tracer %s: more than %d counters, the new labels are dropped",
+							t.spec.Name, maxHostCounters)
+						t.hostCountersFull = true
+					}
+					continue
+				}
+				t.hostCounters++
+			}
+
+			cnt = &counter{name: c.Name, help: c.Help, containerID: containerID, labels: labels}
+			t.counters[key] = cnt
+		}
+
+		if c.Value == "" {
+			cnt.value++
+		} else {
+			cnt.value += integerValue(ev.values[c.Value])
+		}
+	}
+}
+
+func (t *genericTracer) Update() ([]*metric.Data, error) {
+	containers, err := pod.Containers()
+	if err != nil {
+		return nil, fmt.Errorf("get containers: %w", err)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	metrics := make([]*metric.Data, 0, len(t.counters))
+	for key, c := range t.counters {
+		help := c.help
+		if help == "" {
+			help = fmt.Sprintf("%s of the generic tracer %s", c.name, t.spec.Name)
+		}
+
+		if c.containerID == "" {
+			metrics = append(metrics, metric.NewCounterData(c.name, c.value, help, c.labels))
+			continue
+		}
+
+		container, ok := containers[c.containerID]
+		if !ok { // the container is gone.
+			delete(t.counters, key)
+			continue
+		}
+		metrics = append(metrics, metric.NewContainerCounterData(container, c.name, c.value, help, c.labels))
+	}
+
+	metrics = append(metrics, metric.NewCounterData("bad_events_total", float64(t.badEvents),
+		fmt.Sprintf("events not decoded of the generic tracer %s", t.spec.Name), nil))
+	return metrics, nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package generic
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"huatuo-bamai/internal/bpf/bpftest"
+	"huatuo-bamai/pkg/tracing"
+	"huatuo-bamai/pkg/types"
+)
+
+func TestCountHostCounters(t *testing.T) {
+	tr := &genericTracer{
+		spec: &Spec{
+			Name:     "test",
+			Counters: []CounterSpec{{Name: "events_total", Labels: []string{"pid"}}},
+		},
+		counters: make(map[string]*counter),
+	}
+
+	for pid := range uint64(maxHostCounters + 10) {
+		tr.count(&event{values: map[string]any{"pid": pid}}, "")
+	}
+	if len(tr.counters) != maxHostCounters {
+		t.Errorf("host counters=%d, want %d", len(tr.counters), maxHostCounters)
+	}
+
+	// the existing labels are still counted, and so are the containers.
+	tr.count(&event{values: map[string]any{"pid": uint64(0)}}, "")
+	tr.count(&event{values: map[string]any{"pid": uint64(0)}}, "container")
+	if c := tr.counters["events_total\x00\x000"]; c == nil || c.value != 2 {
+		t.Errorf("counter of pid 0=%+v, want 2", c)
+	}
+	if len(tr.counters) != maxHostCounters+1 {
+		t.Errorf("counters=%d, want %d", len(tr.counters), maxHostCounters+1)
+	}
+}
+
+func TestStartSkipsBadEvents(t *testing.T) {
+	be := bpftest.Install(t)
+	rec, stop := tracing.Record()
+	t.Cleanup(stop)
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "generic_test.o"), []byte("elf"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	spec, err := LoadSpec(writeSpec(t, "generic_test.toml", "object = \"generic_test.o\"\n"+tomlSpec))
+	if err != nil {
+		t.Fatalf("LoadSpec() error=%v", err)
+	}
+
+	// a short record between the good ones.
+	type record struct {
+		Pid uint32
+		_   uint32
+		Ts  uint64
+	}
+	be.Object("generic_test.o").Records("events", &record{Pid: 1}, []byte{1, 2, 3}, &record{Pid: 2})
+
+	tr := &genericTracer{spec: spec, dir: dir, counters: make(map[string]*counter)}
+	if err := tr.Start(context.Background()); !errors.Is(err, types.ErrExitByCancelCtx) {
+		t.Errorf("Start() err=%v, want %v", err, types.ErrExitByCancelCtx)
+	}
+
+	if got := len(rec.TracerData(spec.Name)); got != 2 {
+		t.Errorf("events=%d, want 2", got)
+	}
+	if tr.badEvents != 1 {
+		t.Errorf("bad events=%d, want 1", tr.badEvents)
+	}
+}
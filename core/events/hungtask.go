@@ -80,13 +80,19 @@ func newHungTask() (*tracing.EventTracingAttr, error) {
 
 var hungtaskCounter int64
 
+// the backtraces by sysrq, replaced by the tests.
+var (
+	hungtaskCPUsBT             = kmsgutil.GetAllCPUsBT
+	hungtaskBlockedProcessesBT = kmsgutil.GetBlockedProcessesBT
+)
+
 func (c *hungTaskTracing) Update() ([]*metric.Data, error) {
 	c.data[0].Value = float64(atomic.LoadInt64(&hungtaskCounter))
 	return c.data, nil
 }
 
 func (c *hungTaskTracing) Start(ctx context.Context) error {
-	b, err := bpf.LoadBpf(bpf.ThisBpfOBJ(), nil)
+	b, err := bpf.LoadBpfContext(ctx, bpf.ThisBpfOBJ(), nil)
 	if err != nil {
 		return err
 	}
@@ -122,12 +128,12 @@ func (c *hungTaskTracing) Start(ctx context.Context) error {
 
 			c.nextAllowedTime = now.Add(c.backoff.Duration())
 
-			cpusBT, err := kmsgutil.GetAllCPUsBT()
+			cpusBT, err := hungtaskCPUsBT()
 			if err != nil {
 				cpusBT = err.Error()
 			}
 
-			blockedProcessesBT, err := kmsgutil.GetBlockedProcessesBT()
+			blockedProcessesBT, err := hungtaskBlockedProcessesBT()
 			if err != nil {
 				blockedProcessesBT = err.Error()
 			}
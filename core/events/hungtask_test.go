@@ -0,0 +1,72 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"huatuo-bamai/internal/bpf/bpftest"
+	"huatuo-bamai/pkg/tracing"
+	"huatuo-bamai/pkg/types"
+)
+
+func TestHungTaskTracing(t *testing.T) {
+	be := bpftest.Install(t)
+	rec, stop := tracing.Record()
+	t.Cleanup(stop)
+
+	cpusBT, blockedBT := hungtaskCPUsBT, hungtaskBlockedProcessesBT
+	t.Cleanup(func() { hungtaskCPUsBT, hungtaskBlockedProcessesBT = cpusBT, blockedBT })
+	hungtaskCPUsBT = func() (string, error) { return "cpu backtraces", nil }
+	hungtaskBlockedProcessesBT = func() (string, error) { return "", errors.New("no sysrq") }
+
+	event := hungTaskPerfEventData{Pid: 42}
+	copy(event.Comm[:], "kworker/0:1")
+	be.Object("hungtask.o").Records("hungtask_perf_events", &event, &event, &event)
+
+	attr, err := newHungTask()
+	if err != nil {
+		t.Fatalf("newHungTask() err=%v", err)
+	}
+
+	counter := atomic.LoadInt64(&hungtaskCounter)
+	c := attr.TracingData.(*hungTaskTracing)
+	if err := c.Start(context.Background()); !errors.Is(err, types.ErrExitByCancelCtx) {
+		t.Errorf("Start() err=%v, want %v", err, types.ErrExitByCancelCtx)
+	}
+
+	// every hung task is counted, but the backtraces are backed off.
+	if got := atomic.LoadInt64(&hungtaskCounter) - counter; got != 3 {
+		t.Errorf("hungtask counter +%d, want +3", got)
+	}
+
+	data := rec.TracerData("hungtask")
+	if len(data) != 1 {
+		t.Fatalf("hungtask tracing data=%d, want 1", len(data))
+	}
+
+	want := HungTaskTracerData{
+		Pid:                   42,
+		Comm:                  "kworker/0:1",
+		CPUsStack:             "cpu backtraces",
+		BlockedProcessesStack: "no sysrq",
+	}
+	if got, ok := data[0].(*HungTaskTracerData); !ok || *got != want {
+		t.Errorf("hungtask tracing data=%+v, want %+v", data[0], want)
+	}
+}
@@ -60,7 +60,7 @@ const cssCacheTTL = 5 * time.Second
 
 // Start detect work, load bpf and wait data form perfevent
 func (c *memoryReclaimTracing) Start(ctx context.Context) error {
-	b, err := bpf.LoadBpf(bpf.ThisBpfOBJ(), map[string]any{
+	b, err := bpf.LoadBpfContext(ctx, bpf.ThisBpfOBJ(), map[string]any{
 		"deltath": cfg.MemoryReclaim.BlockedThreshold,
 	})
 	if err != nil {
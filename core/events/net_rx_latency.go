@@ -18,6 +18,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"os"
 	"strings"
 	"syscall"
 	"time"
@@ -44,6 +45,7 @@ type NetTracingData struct {
 	Where   string `json:"where"`
 	Latency uint64 `json:"latency_ms"`
 	State   string `json:"state"`
+	Family  string `json:"family"`
 	Saddr   string `json:"saddr"`
 	Daddr   string `json:"daddr"`
 	Sport   uint16 `json:"sport"`
@@ -61,12 +63,13 @@ type netRcvPerfEvent struct {
 	PktLen  uint64
 	Sport   uint16
 	Dport   uint16
-	Saddr   uint32
-	Daddr   uint32
+	Saddr   [16]byte
+	Daddr   [16]byte
 	Seq     uint32
 	AckSeq  uint32
 	State   uint8
 	Where   uint8
+	Family  uint16
 }
 
 // from include/net/tcp_states.h
@@ -92,6 +95,7 @@ var toWhere = []string{
 	"TO_NETIF_RCV",
 	"TO_TCPV4_RCV",
 	"TO_USER_COPY",
+	"TO_TCPV6_RCV",
 }
 
 func init() {
@@ -106,6 +110,26 @@ func newNetRcvLat() (*tracing.EventTracingAttr, error) {
 	}, nil
 }
 
+// attachNetRecvLat attaches the programs, tcp_v6_rcv is optional. It is
+// missing without the ipv6 module or CONFIG_IPV6, the ipv4 is traced still.
+func attachNetRecvLat(b bpf.BPF) error {
+	// attached first, the failed attaching detaches all the programs.
+	if err := b.AttachWithOptions([]bpf.AttachOption{
+		{ProgramName: "tcp_v6_rcv_prog", Symbol: "tcp_v6_rcv"},
+	}); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		log.Infof("net_rx_latency: no tcp_v6_rcv, the ipv6 isn't traced: %v", err)
+	}
+
+	return b.AttachWithOptions([]bpf.AttachOption{
+		{ProgramName: "netif_receive_skb_prog", Symbol: "net/netif_receive_skb"},
+		{ProgramName: "tcp_v4_rcv_prog", Symbol: "tcp_v4_rcv"},
+		{ProgramName: "skb_copy_datagram_iovec_prog", Symbol: "skb/skb_copy_datagram_iovec"},
+	})
+}
+
 func (c *netRecvLatTracing) Start(ctx context.Context) error {
 	toNetIf := cfg.NetRxLatency.Driver2NetRx        // ms, before RPS to a core recv(__netif_receive_skb)
 	toTCPV4 := cfg.NetRxLatency.Driver2TCP          // ms, before RPS to TCP recv(tcp_v4_rcv)
@@ -141,7 +165,7 @@ func (c *netRecvLatTracing) Start(ctx context.Context) error {
 		"to_tcpv4":         toTCPV4 * 1000 * 1000,
 		"to_user_copy":     toUserCopy * 1000 * 1000,
 	}
-	b, err := bpf.LoadBpf(bpf.ThisBpfOBJ(), args)
+	b, err := bpf.LoadBpfContext(ctx, bpf.ThisBpfOBJ(), args)
 	if err != nil {
 		return err
 	}
@@ -150,12 +174,16 @@ func (c *netRecvLatTracing) Start(ctx context.Context) error {
 	childCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	reader, err := b.AttachAndEventPipe(childCtx, "net_recv_lat_event_map", 8192)
+	reader, err := b.EventPipeByName(childCtx, "net_recv_lat_event_map", 8192)
 	if err != nil {
 		return err
 	}
 	defer reader.Close()
 
+	if err := attachNetRecvLat(b); err != nil {
+		return err
+	}
+
 	b.WaitDetachByBreaker(childCtx, cancel)
 
 	// save host netns
@@ -198,7 +226,8 @@ func (c *netRecvLatTracing) Start(ctx context.Context) error {
 			where := toWhere[pd.Where]
 			lat := pd.Latency / 1000 / 1000 // ms
 			state := tcpStateMap[pd.State]
-			saddr, daddr := netutil.Inetv4Ntop(pd.Saddr).String(), netutil.Inetv4Ntop(pd.Daddr).String()
+			family := inetFamilyName(pd.Family)
+			saddr, daddr := netutil.InetNtop(pd.Family, pd.Saddr).String(), netutil.InetNtop(pd.Family, pd.Daddr).String()
 			sport, dport := netutil.Ntohs(pd.Sport), netutil.Ntohs(pd.Dport)
 			seq, ackSeq := netutil.Ntohl(pd.Seq), netutil.Ntohl(pd.AckSeq)
 			pktLen := pd.PktLen
@@ -224,6 +253,7 @@ func (c *netRecvLatTracing) Start(ctx context.Context) error {
 				Where:   where,
 				Latency: lat,
 				State:   state,
+				Family:  family,
 				Saddr:   saddr,
 				Daddr:   daddr,
 				Sport:   sport,
@@ -54,7 +54,7 @@ func newLACPTracing() (*tracing.EventTracingAttr, error) {
 }
 
 func (lacp *lacpTracing) Start(ctx context.Context) (err error) {
-	b, err := bpf.LoadBpf(bpf.ThisBpfOBJ(), nil)
+	b, err := bpf.LoadBpfContext(ctx, bpf.ThisBpfOBJ(), nil)
 	if err != nil {
 		return fmt.Errorf("load bpf: %w", err)
 	}
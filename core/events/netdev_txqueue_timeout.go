@@ -55,7 +55,7 @@ func newTxqueueTimeout() (*tracing.EventTracingAttr, error) {
 }
 
 func (c *txqueueTimeout) Start(ctx context.Context) error {
-	b, err := bpf.LoadBpf(bpf.ThisBpfOBJ(), nil)
+	b, err := bpf.LoadBpfContext(ctx, bpf.ThisBpfOBJ(), nil)
 	if err != nil {
 		return err
 	}
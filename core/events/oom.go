@@ -103,7 +103,7 @@ func (c *oomCollector) Update() ([]*metric.Data, error) {
 
 // Info return case's base info
 func (c *oomCollector) Start(ctx context.Context) error {
-	b, err := bpf.LoadBpf(bpf.ThisBpfOBJ(), nil)
+	b, err := bpf.LoadBpfContext(ctx, bpf.ThisBpfOBJ(), nil)
 	if err != nil {
 		return err
 	}
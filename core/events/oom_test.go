@@ -0,0 +1,88 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"huatuo-bamai/internal/bpf/bpftest"
+	"huatuo-bamai/pkg/tracing"
+	"huatuo-bamai/pkg/types"
+)
+
+func TestOOMTracing(t *testing.T) {
+	be := bpftest.Install(t)
+	rec, stop := tracing.Record()
+	t.Cleanup(stop)
+
+	var event perfEventData
+	copy(event.TriggerProcessName[:], "java")
+	copy(event.VictimProcessName[:], "stress")
+	event.TriggerPid = 100
+	event.VictimPid = 200
+	event.TriggerMemcgCSS = 0xffff0001
+	event.VictimMemcgCSS = 0xffff0002
+
+	obj := be.Object("oom.o").Records("oom_perf_events", &event, &event)
+
+	hostCounter := outOfMemoryCounterHost
+	c := &oomCollector{}
+	if err := c.Start(context.Background()); !errors.Is(err, types.ErrExitByCancelCtx) {
+		t.Errorf("Start() err=%v, want %v", err, types.ErrExitByCancelCtx)
+	}
+
+	if attaches := obj.Attaches(); len(attaches) != 1 {
+		t.Errorf("attaches=%v, want 1", attaches)
+	}
+	if !obj.Closed() {
+		t.Errorf("oom.o should be closed")
+	}
+
+	data := rec.TracerData("oom")
+	if len(data) != 2 {
+		t.Fatalf("oom tracing data=%d, want 2", len(data))
+	}
+
+	want := OOMTracingData{
+		TriggerMemcgCSS:    "0xffff0001",
+		TriggerPid:         100,
+		TriggerProcessName: "java",
+		VictimMemcgCSS:     "0xffff0002",
+		VictimPid:          200,
+		VictimProcessName:  "stress",
+	}
+	if got, ok := data[0].(*OOMTracingData); !ok || *got != want {
+		t.Errorf("oom tracing data=%+v, want %+v", data[0], want)
+	}
+
+	// the victims are not in the containers.
+	if got := outOfMemoryCounterHost - hostCounter; got != 2 {
+		t.Errorf("host oom counter +%v, want +2", got)
+	}
+}
+
+func TestOOMTracingLoadError(t *testing.T) {
+	errLoad := errors.New("operation not permitted")
+
+	be := bpftest.Install(t)
+	be.Object("oom.o").FailLoad(errLoad)
+
+	c := &oomCollector{}
+	if err := c.Start(context.Background()); !errors.Is(err, errLoad) {
+		t.Errorf("Start() err=%v, want %v", err, errLoad)
+	}
+}
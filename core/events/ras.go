@@ -558,7 +558,7 @@ func dispatchRasTracerData(data *rasEvent) (*RasTracingData, error) {
 }
 
 func (ras *rasTracing) Start(ctx context.Context) error {
-	b, err := bpf.LoadBpf(bpf.ThisBpfOBJ(), nil)
+	b, err := bpf.LoadBpfContext(ctx, bpf.ThisBpfOBJ(), nil)
 	if err != nil {
 		return fmt.Errorf("load bpf: %w", err)
 	}
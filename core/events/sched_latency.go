@@ -0,0 +1,214 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"huatuo-bamai/internal/bpf"
+	"huatuo-bamai/internal/log"
+	"huatuo-bamai/internal/pod"
+	"huatuo-bamai/internal/symbol"
+	"huatuo-bamai/internal/utils/bytesutil"
+	"huatuo-bamai/pkg/tracing"
+)
+
+//go:generate $BPF_COMPILE $BPF_INCLUDE -s $BPF_DIR/sched_latency.c -o $BPF_DIR/sched_latency.o
+
+const schedLatencyTracerName = "sched_latency"
+
+// schedLatencyPerfEvent is the struct sched_latency_event_t of
+// bpf/sched_latency.c.
+type schedLatencyPerfEvent struct {
+	Latency      uint64
+	CSS          uint64
+	RunningCSS   uint64
+	Pid          uint32
+	Tgid         uint32
+	RunningPid   uint32
+	RunningTgid  uint32
+	CPU          uint32
+	WakerStackID int32
+	StackSize    int32
+	WakerPid     uint32
+	Comm         [bpf.TaskCommLen]byte
+	RunningComm  [bpf.TaskCommLen]byte
+	WakerComm    [bpf.TaskCommLen]byte
+	Stack        [symbol.KsymbolStackMinDepth]uint64
+}
+
+// SchedLatencyTracingData is the wakeup-to-run latency outlier of a task, the
+// running task is the one that was on the cpu right before the delayed task,
+// and the stack is where the delayed task blocked or was preempted.
+type SchedLatencyTracingData struct {
+	LatencyMs          float64 `json:"latency_ms"`
+	Threshold          uint64  `json:"threshold"`
+	CPU                uint32  `json:"cpu"`
+	Comm               string  `json:"comm"`
+	Pid                uint32  `json:"pid"`
+	Tgid               uint32  `json:"tgid"`
+	Stack              string  `json:"stack,omitempty"`
+	RunningComm        string  `json:"running_comm"`
+	RunningPid         uint32  `json:"running_pid"`
+	RunningTgid        uint32  `json:"running_tgid"`
+	RunningContainerID string  `json:"running_container_id"`
+	// the waker is empty for the preempted tasks.
+	WakerComm  string `json:"waker_comm"`
+	WakerPid   uint32 `json:"waker_pid"`
+	WakerStack string `json:"waker_stack,omitempty"`
+}
+
+type schedLatencyTracing struct {
+	stacks *bpf.StackTraces[string]
+
+	cssContainers map[uint64]*pod.Container
+	cacheTime     time.Time
+}
+
+func init() {
+	tracing.RegisterEventTracing(schedLatencyTracerName, newSchedLatency)
+}
+
+func newSchedLatency() (*tracing.EventTracingAttr, error) {
+	return &tracing.EventTracingAttr{
+		TracingData: &schedLatencyTracing{},
+		Interval:    10,
+		Flag:        tracing.FlagTracing,
+	}, nil
+}
+
+// Start starts the tracer.
+func (c *schedLatencyTracing) Start(ctx context.Context) error {
+	b, err := bpf.LoadBpfContext(ctx, bpf.ThisBpfOBJ(), map[string]any{
+		"latency_thresh":     cfg.SchedLatency.Threshold,
+		"container_interval": uint64(cfg.SchedLatency.ContainerInterval) * uint64(time.Second),
+		"stacks_enabled":     cfg.SchedLatency.EnableStacks,
+	})
+	if err != nil {
+		return fmt.Errorf("load bpf: %w", err)
+	}
+	defer b.Close()
+
+	if cfg.SchedLatency.EnableStacks {
+		c.stacks, err = bpf.NewStackTraces(b, "stacks", func(addrs []uint64) string {
+			return strings.Join(symbol.DumpKernelBackTrace(addrs, bpf.StackDepth()).BackTrace, "\n")
+		})
+		if err != nil {
+			return err
+		}
+		c.stacks.EnableAging()
+	}
+
+	childCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	reader, err := b.AttachAndEventPipe(childCtx, "sched_latency_events", 8192)
+	if err != nil {
+		return fmt.Errorf("attach and event pipe: %w", err)
+	}
+	defer reader.Close()
+
+	b.WaitDetachByBreaker(childCtx, cancel)
+
+	for {
+		select {
+		case <-childCtx.Done():
+			return nil
+		default:
+			var event schedLatencyPerfEvent
+			if err := reader.ReadInto(&event); err != nil {
+				return fmt.Errorf("read sched latency events: %w", err)
+			}
+
+			containerID := c.containerID(event.CSS)
+			data := &SchedLatencyTracingData{
+				LatencyMs:          float64(event.Latency) / float64(time.Millisecond),
+				Threshold:          cfg.SchedLatency.Threshold,
+				CPU:                event.CPU,
+				Comm:               bytesutil.ToStr(event.Comm[:]),
+				Pid:                event.Pid,
+				Tgid:               event.Tgid,
+				Stack:              taskStack(&event),
+				RunningComm:        bytesutil.ToStr(event.RunningComm[:]),
+				RunningPid:         event.RunningPid,
+				RunningTgid:        event.RunningTgid,
+				RunningContainerID: c.containerID(event.RunningCSS),
+				WakerComm:          bytesutil.ToStr(event.WakerComm[:]),
+				WakerPid:           event.WakerPid,
+				WakerStack:         c.stack(event.WakerStackID),
+			}
+
+			if err := tracing.Save(&tracing.WriteRequest{
+				TracerName:  schedLatencyTracerName,
+				ContainerID: containerID,
+				TracerTime:  time.Now(),
+				TracerData:  data,
+			}); err != nil {
+				log.Warnf("failed to save tracing data: %v", err)
+			}
+		}
+	}
+}
+
+// containerID returns the container id of the cpu cgroup css, empty for the
+// host.
+func (c *schedLatencyTracing) containerID(css uint64) string {
+	container, ok := c.cssContainers[css]
+	if !ok || time.Since(c.cacheTime) > cssCacheTTL {
+		containers, err := pod.Containers()
+		if err != nil {
+			log.Debugf("get containers: %v", err)
+			return ""
+		}
+		c.cssContainers = pod.BuildCssContainers(containers, pod.SubSysCPU)
+		c.cacheTime = time.Now()
+		container = c.cssContainers[css]
+	}
+
+	if container == nil {
+		return ""
+	}
+	return container.ID
+}
+
+// taskStack returns the stack of the delayed task copied into the event.
+func taskStack(event *schedLatencyPerfEvent) string {
+	if event.StackSize <= 0 {
+		if event.StackSize < -1 {
+			log.Debugf("sched_latency stack of %d: %d", event.Pid, event.StackSize)
+		}
+		return ""
+	}
+
+	n := min(int(event.StackSize)/8, len(event.Stack))
+	return strings.Join(symbol.DumpKernelBackTrace(event.Stack[:n], n).BackTrace, "\n")
+}
+
+// stack returns the stack of the id, -1 is no stack captured and the other
+// negative ids are the failures counted by the stack traces.
+func (c *schedLatencyTracing) stack(id int32) string {
+	if c.stacks == nil || id == -1 {
+		return ""
+	}
+
+	stack, err := c.stacks.Get(id)
+	if err != nil {
+		log.Debugf("sched_latency stack %d: %v", id, err)
+	}
+	return stack
+}
@@ -0,0 +1,83 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"huatuo-bamai/internal/bpf/bpftest"
+	"huatuo-bamai/pkg/tracing"
+	"huatuo-bamai/pkg/types"
+)
+
+func TestSchedLatencyTracing(t *testing.T) {
+	be := bpftest.Install(t)
+	rec, stop := tracing.Record()
+	t.Cleanup(stop)
+
+	cfg.SchedLatency.Threshold = uint64(100 * time.Millisecond)
+	cfg.SchedLatency.ContainerInterval = 10
+	cfg.SchedLatency.EnableStacks = false
+
+	event := schedLatencyPerfEvent{
+		Latency:      uint64(200 * time.Millisecond),
+		Pid:          1001,
+		Tgid:         1000,
+		RunningPid:   2001,
+		RunningTgid:  2000,
+		CPU:          3,
+		WakerStackID: -1,
+		StackSize:    -1,
+		WakerPid:     3001,
+	}
+	copy(event.Comm[:], "nginx")
+	copy(event.RunningComm[:], "stress")
+	copy(event.WakerComm[:], "ksoftirqd/3")
+
+	obj := be.Object("sched_latency.o").Records("sched_latency_events", &event)
+
+	c := &schedLatencyTracing{}
+	if err := c.Start(context.Background()); !errors.Is(err, types.ErrExitByCancelCtx) {
+		t.Errorf("Start() err=%v, want %v", err, types.ErrExitByCancelCtx)
+	}
+
+	consts := obj.Consts()
+	if consts["latency_thresh"] != uint64(100*time.Millisecond) ||
+		consts["container_interval"] != uint64(10*time.Second) || consts["stacks_enabled"] != false {
+		t.Errorf("consts=%v", consts)
+	}
+
+	data := rec.TracerData(schedLatencyTracerName)
+	if len(data) != 1 {
+		t.Fatalf("sched_latency tracing data=%d, want 1", len(data))
+	}
+	got, ok := data[0].(*SchedLatencyTracingData)
+	if !ok {
+		t.Fatalf("sched_latency tracing data=%T", data[0])
+	}
+
+	if got.LatencyMs != 200 || got.CPU != 3 || got.Comm != "nginx" || got.Pid != 1001 || got.Tgid != 1000 || got.Stack != "" {
+		t.Errorf("delayed task=%+v", got)
+	}
+	if got.RunningComm != "stress" || got.RunningPid != 2001 || got.RunningTgid != 2000 {
+		t.Errorf("running task=%+v", got)
+	}
+	if got.WakerComm != "ksoftirqd/3" || got.WakerPid != 3001 || got.WakerStack != "" {
+		t.Errorf("waker=%+v", got)
+	}
+}
@@ -35,8 +35,7 @@ import (
 type softirqTracing struct{}
 
 type softirqPerfEvent struct {
-	Stack     [symbol.KsymbolStackMaxDepth]uint64
-	StackSize int64
+	StackID   int64
 	Now       uint64
 	StallTime uint64
 	Comm      [bpf.TaskCommLen]byte
@@ -70,12 +69,18 @@ func newSoftirq() (*tracing.EventTracingAttr, error) {
 func (c *softirqTracing) Start(ctx context.Context) error {
 	softirqThresh := cfg.Softirq.DisabledThreshold
 
-	b, err := bpf.LoadBpf(bpf.ThisBpfOBJ(), map[string]any{"softirq_thresh": softirqThresh})
+	b, err := bpf.LoadBpfContext(ctx, bpf.ThisBpfOBJ(), map[string]any{"softirq_thresh": softirqThresh})
 	if err != nil {
 		return fmt.Errorf("load bpf: %w", err)
 	}
 	defer b.Close()
 
+	stacks, err := bpf.NewStackTraces(b, "stacks", softirqDumpTrace)
+	if err != nil {
+		return err
+	}
+	stacks.EnableAging()
+
 	childCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
@@ -115,10 +120,9 @@ func (c *softirqTracing) Start(ctx context.Context) error {
 				continue
 			}
 
-			var stack string
-
-			if data.StackSize > 0 {
-				stack = softirqDumpTrace(data.Stack[:])
+			stack, err := stacks.Get(int32(data.StackID))
+			if err != nil {
+				log.Debugf("softirq_tracing stack: %v", err)
 			}
 
 			if err := tracing.Save(&tracing.WriteRequest{
@@ -142,7 +146,7 @@ func (c *softirqTracing) Start(ctx context.Context) error {
 
 // softirqDumpTrace is an interface for dump stacks in this case with offset and module info
 func softirqDumpTrace(addrs []uint64) string {
-	stacks := symbol.DumpKernelBackTrace(addrs, symbol.KsymbolStackMaxDepth)
+	stacks := symbol.DumpKernelBackTrace(addrs, bpf.StackDepth())
 	return strings.Join(stacks.BackTrace, "\n")
 }
 
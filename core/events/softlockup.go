@@ -80,7 +80,7 @@ func (c *softLockupTracing) Update() ([]*metric.Data, error) {
 }
 
 func (c *softLockupTracing) Start(ctx context.Context) error {
-	b, err := bpf.LoadBpf(bpf.ThisBpfOBJ(), nil)
+	b, err := bpf.LoadBpfContext(ctx, bpf.ThisBpfOBJ(), nil)
 	if err != nil {
 		return err
 	}
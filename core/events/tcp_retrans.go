@@ -0,0 +1,403 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"huatuo-bamai/internal/bpf"
+	"huatuo-bamai/internal/log"
+	"huatuo-bamai/internal/pod"
+	"huatuo-bamai/internal/utils/bytesutil"
+	"huatuo-bamai/internal/utils/netutil"
+	"huatuo-bamai/pkg/metric"
+	"huatuo-bamai/pkg/tracing"
+)
+
+//go:generate $BPF_COMPILE $BPF_INCLUDE -s $BPF_DIR/tcp_retrans.c -o $BPF_DIR/tcp_retrans.o
+
+const (
+	tcpRetransTracerName = "tcp_retrans"
+
+	// type
+	typeTCPRetransmit    = 1
+	typeTCPSendReset     = 2
+	typeTCPReceiveReset  = 3
+	tcpRemotePortsOthers = "others"
+)
+
+var tcpEventTypeMap = map[uint8]string{
+	typeTCPRetransmit:   "retransmit",
+	typeTCPSendReset:    "send_reset",
+	typeTCPReceiveReset: "receive_reset",
+}
+
+// tcpPerfEvent is the struct tcp_event_t of bpf/tcp_retrans.c, the local
+// side is the source.
+type tcpPerfEvent struct {
+	TgidPid      uint64
+	NetnsInode   uint32
+	Family       uint16
+	Pad0         uint16
+	Saddr        [16]byte
+	Daddr        [16]byte
+	Sport        uint16
+	Dport        uint16
+	SrttUs       uint32
+	TotalRetrans uint32
+	Retransmits  uint8
+	State        uint8
+	Type         uint8
+	Pad1         uint8
+	Comm         [bpf.TaskCommLen]byte
+}
+
+// TCPRetransTracingData is the document of a retransmission or a reset, the
+// events of the connection in the DocumentInterval are aggregated into Count.
+type TCPRetransTracingData struct {
+	Type         string `json:"type"`
+	Comm         string `json:"comm"`
+	Pid          uint64 `json:"pid"`
+	Family       string `json:"family"`
+	Saddr        string `json:"saddr"`
+	Daddr        string `json:"daddr"`
+	Sport        uint16 `json:"sport"`
+	Dport        uint16 `json:"dport"`
+	State        string `json:"state"`
+	Retransmits  uint8  `json:"retransmits"`
+	TotalRetrans uint32 `json:"total_retrans"`
+	SrttUs       uint32 `json:"srtt_us"`
+	NetnsInode   uint32 `json:"netns_inode"`
+	// Count is the events of the connection since the last document.
+	Count uint64 `json:"count"`
+}
+
+// tcpConnKey is a connection with the event type.
+type tcpConnKey struct {
+	typ          uint8
+	netnsInode   uint32
+	saddr, daddr [16]byte
+	sport, dport uint16
+}
+
+// tcpConnDocument is the pending document of a connection.
+type tcpConnDocument struct {
+	data        *TCPRetransTracingData
+	containerID string
+	// saved is the time of the last document, the events are aggregated
+	// until the DocumentInterval passes.
+	saved time.Time
+}
+
+// tcpCounterKey is the counter of the events by the remote port.
+type tcpCounterKey struct {
+	typ         uint8
+	containerID string
+	remotePort  string
+}
+
+type tcpRetransTracing struct {
+	mu        sync.Mutex
+	documents map[tcpConnKey]*tcpConnDocument
+	counters  map[tcpCounterKey]float64
+	// remotePorts are the remote ports counted by the container, the others
+	// over MaxRemotePorts are counted as tcpRemotePortsOthers.
+	remotePorts map[string]map[string]struct{}
+	// budgets are the documents saved by the container in the window from
+	// budgetTime, up to MaxDocuments.
+	budgets    map[string]int
+	budgetTime time.Time
+	// suppressed are the documents dropped over the budgets or the
+	// MaxConnections, the events are still counted.
+	suppressed uint64
+}
+
+func init() {
+	tracing.RegisterEventTracing(tcpRetransTracerName, newTCPRetrans)
+}
+
+func newTCPRetrans() (*tracing.EventTracingAttr, error) {
+	return &tracing.EventTracingAttr{
+		TracingData: &tcpRetransTracing{
+			documents:   make(map[tcpConnKey]*tcpConnDocument),
+			counters:    make(map[tcpCounterKey]float64),
+			remotePorts: make(map[string]map[string]struct{}),
+			budgets:     make(map[string]int),
+		},
+		Interval: 10,
+		Flag:     tracing.FlagTracing | tracing.FlagMetric,
+	}, nil
+}
+
+// Update returns the events by the containers and the remote ports.
+func (c *tcpRetransTracing) Update() ([]*metric.Data, error) {
+	containers, err := pod.Containers()
+	if err != nil {
+		return nil, fmt.Errorf("get containers: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	metrics := []*metric.Data{}
+	for key, count := range c.counters {
+		name := tcpEventTypeMap[key.typ] + "_total"
+		desc := fmt.Sprintf("TCP %s events by the remote port.", tcpEventTypeMap[key.typ])
+		labels := map[string]string{"remote_port": key.remotePort}
+
+		if key.containerID == "" {
+			metrics = append(metrics, metric.NewCounterData(name, count, desc, labels))
+			continue
+		}
+		if container, ok := containers[key.containerID]; ok {
+			metrics = append(metrics, metric.NewContainerCounterData(container, name, count, desc, labels))
+		}
+	}
+
+	metrics = append(metrics, metric.NewCounterData("suppressed_documents_total", float64(c.suppressed),
+		"TCP retransmission documents suppressed by the budgets.", nil))
+
+	// the counters of the removed containers.
+	for key := range c.counters {
+		if _, ok := containers[key.containerID]; key.containerID != "" && !ok {
+			delete(c.counters, key)
+			delete(c.remotePorts, key.containerID)
+		}
+	}
+
+	return metrics, nil
+}
+
+// Start starts the tracer.
+func (c *tcpRetransTracing) Start(ctx context.Context) error {
+	b, err := bpf.LoadBpfContext(ctx, bpf.ThisBpfOBJ(), nil)
+	if err != nil {
+		return fmt.Errorf("load bpf: %w", err)
+	}
+	defer b.Close()
+
+	childCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	reader, err := b.AttachAndEventPipe(childCtx, "tcp_events", 8192)
+	if err != nil {
+		return fmt.Errorf("attach and event pipe: %w", err)
+	}
+	defer reader.Close()
+
+	b.WaitDetachByBreaker(childCtx, cancel)
+
+	interval := time.Duration(cfg.TCPRetrans.DocumentInterval) * time.Second
+	if interval > 0 {
+		go c.flushLoop(childCtx, interval)
+	}
+	defer c.flush(time.Time{}, 0)
+
+	for {
+		select {
+		case <-childCtx.Done():
+			return nil
+		default:
+			var event tcpPerfEvent
+			if err := reader.ReadInto(&event); err != nil {
+				return fmt.Errorf("read tcp events: %w", err)
+			}
+
+			c.handle(&event, time.Now(), interval)
+		}
+	}
+}
+
+// containerByNetnsInode returns the container id of the net namespace, empty
+// for the host.
+func containerByNetnsInode(inode uint32) string {
+	container, err := pod.ContainerByNetNamespaceInode(uint64(inode))
+	if err != nil {
+		log.Debugf("get container by netns inode %d: %v", inode, err)
+		return ""
+	}
+	if container == nil {
+		return ""
+	}
+	return container.ID
+}
+
+// handle counts the event, and saves the document of the connection if the
+// last one is saved before the interval.
+func (c *tcpRetransTracing) handle(event *tcpPerfEvent, now time.Time, interval time.Duration) {
+	containerID := containerByNetnsInode(event.NetnsInode)
+	dport := netutil.Ntohs(event.Dport)
+
+	key := tcpConnKey{
+		typ:        event.Type,
+		netnsInode: event.NetnsInode,
+		saddr:      event.Saddr,
+		daddr:      event.Daddr,
+		sport:      event.Sport,
+		dport:      event.Dport,
+	}
+
+	c.mu.Lock()
+	c.count(event.Type, containerID, dport)
+
+	doc, ok := c.documents[key]
+	if !ok {
+		// a storm of the new connections, e.g. a port scan, is only counted.
+		if limit := cfg.TCPRetrans.MaxConnections; limit > 0 && len(c.documents) >= limit {
+			c.suppressed++
+			c.mu.Unlock()
+			return
+		}
+		doc = &tcpConnDocument{containerID: containerID}
+		c.documents[key] = doc
+	}
+
+	var count uint64
+	if doc.data != nil {
+		count = doc.data.Count
+	}
+	doc.data = &TCPRetransTracingData{
+		Type:         tcpEventTypeMap[event.Type],
+		Comm:         bytesutil.ToStr(event.Comm[:]),
+		Pid:          event.TgidPid >> 32,
+		Family:       inetFamilyName(event.Family),
+		Saddr:        netutil.InetNtop(event.Family, event.Saddr).String(),
+		Daddr:        netutil.InetNtop(event.Family, event.Daddr).String(),
+		Sport:        netutil.Ntohs(event.Sport),
+		Dport:        dport,
+		Retransmits:  event.Retransmits,
+		TotalRetrans: event.TotalRetrans,
+		SrttUs:       event.SrttUs,
+		NetnsInode:   event.NetnsInode,
+		Count:        count + 1,
+	}
+	if int(event.State) < len(tcpStateMap) {
+		doc.data.State = tcpStateMap[event.State]
+	}
+
+	var data *TCPRetransTracingData
+	if now.Sub(doc.saved) >= interval && c.takeBudget(containerID, now, interval) {
+		data, doc.data, doc.saved = doc.data, nil, now
+	}
+	c.mu.Unlock()
+
+	if data != nil {
+		saveTCPRetrans(data, containerID, now)
+	}
+}
+
+// takeBudget takes a document from the budget of the container, the budgets
+// are refilled every interval, at least a second.
+func (c *tcpRetransTracing) takeBudget(containerID string, now time.Time, interval time.Duration) bool {
+	limit := cfg.TCPRetrans.MaxDocuments
+	if limit <= 0 {
+		return true
+	}
+
+	window := max(interval, time.Second)
+	if now.Sub(c.budgetTime) >= window {
+		clear(c.budgets)
+		c.budgetTime = now
+	}
+
+	if c.budgets[containerID] >= limit {
+		c.suppressed++
+		return false
+	}
+	c.budgets[containerID]++
+	return true
+}
+
+// count counts the event by the container and the remote port, the remote
+// ports of a container are up to MaxRemotePorts.
+func (c *tcpRetransTracing) count(typ uint8, containerID string, remotePort uint16) {
+	port := strconv.Itoa(int(remotePort))
+
+	ports, ok := c.remotePorts[containerID]
+	if !ok {
+		ports = make(map[string]struct{})
+		c.remotePorts[containerID] = ports
+	}
+	if _, ok := ports[port]; !ok {
+		if len(ports) >= cfg.TCPRetrans.MaxRemotePorts {
+			port = tcpRemotePortsOthers
+		} else {
+			ports[port] = struct{}{}
+		}
+	}
+
+	c.counters[tcpCounterKey{typ: typ, containerID: containerID, remotePort: port}]++
+}
+
+func (c *tcpRetransTracing) flushLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			c.flush(now, interval)
+		}
+	}
+}
+
+// flush saves the aggregated documents of the connections whose last ones
+// are saved before the interval, and forgets the idle connections. The
+// documents over the budgets are dropped.
+func (c *tcpRetransTracing) flush(now time.Time, interval time.Duration) {
+	type pending struct {
+		data        *TCPRetransTracingData
+		containerID string
+	}
+	var docs []pending
+
+	saved := now
+	if saved.IsZero() {
+		saved = time.Now()
+	}
+
+	c.mu.Lock()
+	for key, doc := range c.documents {
+		if !now.IsZero() && now.Sub(doc.saved) < interval {
+			continue
+		}
+		if doc.data != nil && c.takeBudget(doc.containerID, saved, interval) {
+			docs = append(docs, pending{doc.data, doc.containerID})
+		}
+		delete(c.documents, key)
+	}
+	c.mu.Unlock()
+
+	for _, doc := range docs {
+		saveTCPRetrans(doc.data, doc.containerID, saved)
+	}
+}
+
+func saveTCPRetrans(data *TCPRetransTracingData, containerID string, now time.Time) {
+	if err := tracing.Save(&tracing.WriteRequest{
+		TracerName:  tcpRetransTracerName,
+		ContainerID: containerID,
+		TracerTime:  now,
+		TracerData:  data,
+	}); err != nil {
+		log.Warnf("failed to save tracing data: %v", err)
+	}
+}
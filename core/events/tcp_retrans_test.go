@@ -0,0 +1,239 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"huatuo-bamai/internal/bpf/bpftest"
+	"huatuo-bamai/pkg/tracing"
+	"huatuo-bamai/pkg/types"
+
+	"golang.org/x/sys/unix"
+)
+
+// newTCPEvent returns the event of 10.0.0.1:40000 -> 10.0.0.2:dport.
+func newTCPEvent(typ uint8, dport uint16) *tcpPerfEvent {
+	event := &tcpPerfEvent{
+		TgidPid:      1000<<32 | 1001,
+		Family:       unix.AF_INET,
+		Saddr:        [16]byte{10, 0, 0, 1},
+		Daddr:        [16]byte{10, 0, 0, 2},
+		Sport:        binary.NativeEndian.Uint16(binary.BigEndian.AppendUint16(nil, 40000)),
+		Dport:        binary.NativeEndian.Uint16(binary.BigEndian.AppendUint16(nil, dport)),
+		SrttUs:       2000,
+		TotalRetrans: 3,
+		Retransmits:  2,
+		State:        1, // ESTABLISHED
+		Type:         typ,
+	}
+	copy(event.Comm[:], "curl")
+	return event
+}
+
+func TestTCPRetransTracing(t *testing.T) {
+	be := bpftest.Install(t)
+	rec, stop := tracing.Record()
+	t.Cleanup(stop)
+
+	cfg.TCPRetrans.DocumentInterval = 10
+	cfg.TCPRetrans.MaxRemotePorts = 64
+
+	// a burst of the retransmissions, and a reset of the same connection.
+	be.Object("tcp_retrans.o").Records("tcp_events",
+		newTCPEvent(typeTCPRetransmit, 80),
+		newTCPEvent(typeTCPRetransmit, 80),
+		newTCPEvent(typeTCPRetransmit, 80),
+		newTCPEvent(typeTCPSendReset, 80),
+	)
+
+	attr, _ := newTCPRetrans()
+	c := attr.TracingData.(*tcpRetransTracing)
+	if err := c.Start(context.Background()); !errors.Is(err, types.ErrExitByCancelCtx) {
+		t.Errorf("Start() err=%v, want %v", err, types.ErrExitByCancelCtx)
+	}
+
+	// the first retransmission, the reset, and the rest of the burst flushed
+	// when the tracer stops.
+	data := rec.TracerData(tcpRetransTracerName)
+	if len(data) != 3 {
+		t.Fatalf("tcp_retrans tracing data=%d, want 3", len(data))
+	}
+
+	var retransmits, resets uint64
+	for _, d := range data {
+		got, ok := d.(*TCPRetransTracingData)
+		if !ok {
+			t.Fatalf("tcp_retrans tracing data=%T", d)
+		}
+		if got.Saddr != "10.0.0.1" || got.Daddr != "10.0.0.2" || got.Sport != 40000 || got.Dport != 80 {
+			t.Errorf("tcp_retrans addrs=%s:%d -> %s:%d, want 10.0.0.1:40000 -> 10.0.0.2:80",
+				got.Saddr, got.Sport, got.Daddr, got.Dport)
+		}
+		if got.Comm != "curl" || got.Pid != 1000 || got.State != "ESTABLISHED" || got.SrttUs != 2000 {
+			t.Errorf("tcp_retrans tracing data=%+v", got)
+		}
+
+		switch got.Type {
+		case "retransmit":
+			retransmits += got.Count
+		case "send_reset":
+			resets += got.Count
+		default:
+			t.Errorf("tcp_retrans type=%s", got.Type)
+		}
+	}
+	if retransmits != 3 || resets != 1 {
+		t.Errorf("retransmits=%d resets=%d, want 3 and 1", retransmits, resets)
+	}
+
+	want := map[tcpCounterKey]float64{
+		{typ: typeTCPRetransmit, remotePort: "80"}: 3,
+		{typ: typeTCPSendReset, remotePort: "80"}:  1,
+	}
+	if len(c.counters) != len(want) {
+		t.Errorf("counters=%v, want %v", c.counters, want)
+	}
+	for key, count := range want {
+		if c.counters[key] != count {
+			t.Errorf("counter %+v=%v, want %v", key, c.counters[key], count)
+		}
+	}
+}
+
+func TestTCPRetransIPv6(t *testing.T) {
+	rec, stop := tracing.Record()
+	t.Cleanup(stop)
+
+	event := newTCPEvent(typeTCPReceiveReset, 443)
+	event.Family = unix.AF_INET6
+	event.Saddr = [16]byte{0x20, 0x01, 0x0d, 0xb8, 15: 0x01}
+	event.Daddr = [16]byte{0x20, 0x01, 0x0d, 0xb8, 15: 0x02}
+
+	attr, _ := newTCPRetrans()
+	c := attr.TracingData.(*tcpRetransTracing)
+	c.handle(event, time.Unix(1000, 0), 10*time.Second)
+
+	data := rec.TracerData(tcpRetransTracerName)
+	if len(data) != 1 {
+		t.Fatalf("tcp_retrans tracing data=%d, want 1", len(data))
+	}
+	got := data[0].(*TCPRetransTracingData)
+	if got.Family != "ipv6" || got.Saddr != "2001:db8::1" || got.Daddr != "2001:db8::2" || got.Dport != 443 {
+		t.Errorf("tcp_retrans addrs=%s [%s]:%d -> [%s]:%d, want ipv6 [2001:db8::1]:40000 -> [2001:db8::2]:443",
+			got.Family, got.Saddr, got.Sport, got.Daddr, got.Dport)
+	}
+}
+
+func TestTCPRetransDocumentInterval(t *testing.T) {
+	rec, stop := tracing.Record()
+	t.Cleanup(stop)
+
+	attr, _ := newTCPRetrans()
+	c := attr.TracingData.(*tcpRetransTracing)
+
+	now := time.Unix(1000, 0)
+	for i := range 5 {
+		c.handle(newTCPEvent(typeTCPRetransmit, 443), now.Add(time.Duration(i)*time.Second), 10*time.Second)
+	}
+	if got := len(rec.TracerData(tcpRetransTracerName)); got != 1 {
+		t.Errorf("documents in the interval=%d, want 1", got)
+	}
+
+	// not flushed before the interval.
+	c.flush(now.Add(5*time.Second), 10*time.Second)
+	if got := len(rec.TracerData(tcpRetransTracerName)); got != 1 {
+		t.Errorf("documents before the interval=%d, want 1", got)
+	}
+
+	c.flush(now.Add(10*time.Second), 10*time.Second)
+	data := rec.TracerData(tcpRetransTracerName)
+	if len(data) != 2 {
+		t.Fatalf("documents after the interval=%d, want 2", len(data))
+	}
+	if got := data[1].(*TCPRetransTracingData).Count; got != 4 {
+		t.Errorf("aggregated count=%d, want 4", got)
+	}
+	if len(c.documents) != 0 {
+		t.Errorf("documents=%d, want the idle connections forgotten", len(c.documents))
+	}
+}
+
+func TestTCPRetransMaxRemotePorts(t *testing.T) {
+	cfg.TCPRetrans.MaxRemotePorts = 2
+
+	attr, _ := newTCPRetrans()
+	c := attr.TracingData.(*tcpRetransTracing)
+
+	for _, port := range []uint16{80, 443, 8080, 80, 9090} {
+		c.count(typeTCPRetransmit, "container", port)
+	}
+
+	want := map[string]float64{"80": 2, "443": 1, tcpRemotePortsOthers: 2}
+	for port, count := range want {
+		key := tcpCounterKey{typ: typeTCPRetransmit, containerID: "container", remotePort: port}
+		if c.counters[key] != count {
+			t.Errorf("remote port %s=%v, want %v", port, c.counters[key], count)
+		}
+	}
+	if len(c.counters) != len(want) {
+		t.Errorf("counters=%v", c.counters)
+	}
+}
+
+func TestTCPRetransBudgets(t *testing.T) {
+	rec, stop := tracing.Record()
+	t.Cleanup(stop)
+
+	cfg.TCPRetrans.MaxRemotePorts = 64
+	cfg.TCPRetrans.MaxDocuments = 2
+	cfg.TCPRetrans.MaxConnections = 3
+	t.Cleanup(func() {
+		cfg.TCPRetrans.MaxDocuments = 0
+		cfg.TCPRetrans.MaxConnections = 0
+	})
+
+	attr, _ := newTCPRetrans()
+	c := attr.TracingData.(*tcpRetransTracing)
+
+	// a port scan, the new connections are over the budget and the cap.
+	now := time.Unix(1000, 0)
+	for port := range uint16(5) {
+		c.handle(newTCPEvent(typeTCPSendReset, 1000+port), now, 10*time.Second)
+	}
+	if got := len(rec.TracerData(tcpRetransTracerName)); got != 2 {
+		t.Errorf("documents=%d, want the budget 2", got)
+	}
+	if len(c.documents) != 3 {
+		t.Errorf("pending connections=%d, want the cap 3", len(c.documents))
+	}
+	if c.suppressed != 3 {
+		t.Errorf("suppressed=%d, want 3", c.suppressed)
+	}
+	key := tcpCounterKey{typ: typeTCPSendReset, containerID: "", remotePort: "1004"}
+	if c.counters[key] != 1 {
+		t.Errorf("counter of the port over the cap=%v, want 1", c.counters[key])
+	}
+
+	// the budget is refilled in the next interval.
+	c.flush(now.Add(10*time.Second), 10*time.Second)
+	if got := len(rec.TracerData(tcpRetransTracerName)); got != 3 {
+		t.Errorf("documents after the interval=%d, want 3", got)
+	}
+}
@@ -59,7 +59,7 @@ func newRunqlatCollector() (*tracing.EventTracingAttr, error) {
 }
 
 func (c *runqlatCollector) Start(ctx context.Context) error {
-	b, err := bpf.LoadBpf(bpf.ThisBpfOBJ(), nil)
+	b, err := bpf.LoadBpfContext(ctx, bpf.ThisBpfOBJ(), nil)
 	if err != nil {
 		return err
 	}
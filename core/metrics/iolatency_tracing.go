@@ -75,7 +75,7 @@ type iolatencyTracing struct {
 }
 
 func (c *iolatencyTracing) Start(ctx context.Context) error {
-	b, err := bpf.LoadBpf(bpf.ThisBpfOBJ(), nil)
+	b, err := bpf.LoadBpfContext(ctx, bpf.ThisBpfOBJ(), nil)
 	if err != nil {
 		return fmt.Errorf("failed to load bpf: %w", err)
 	}
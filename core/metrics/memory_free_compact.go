@@ -86,7 +86,7 @@ func (c *reclaimCompact) Update() ([]*metric.Data, error) {
 
 // Start detect work, load bpf and wait data
 func (c *reclaimCompact) Start(ctx context.Context) error {
-	obj, err := bpf.LoadBpf(bpf.ThisBpfOBJ(), nil)
+	obj, err := bpf.LoadBpfContext(ctx, bpf.ThisBpfOBJ(), nil)
 	if err != nil {
 		return err
 	}
@@ -100,7 +100,7 @@ func (c *memoryCgroupReclaim) Update() ([]*metric.Data, error) {
 }
 
 func (c *memoryCgroupReclaim) Start(ctx context.Context) error {
-	obj, err := bpf.LoadBpf(bpf.ThisBpfOBJ(), nil)
+	obj, err := bpf.LoadBpfContext(ctx, bpf.ThisBpfOBJ(), nil)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,226 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+
+	"huatuo-bamai/internal/bpf"
+	"huatuo-bamai/internal/log"
+	"huatuo-bamai/internal/pod"
+	"huatuo-bamai/internal/utils/bytesutil"
+	"huatuo-bamai/internal/utils/netutil"
+	"huatuo-bamai/pkg/metric"
+	"huatuo-bamai/pkg/tracing"
+)
+
+//go:generate $BPF_COMPILE $BPF_INCLUDE -s $BPF_DIR/net_drop_reason.c -o $BPF_DIR/net_drop_reason.o
+
+// dropReasonKey is the struct drop_key_t of bpf/net_drop_reason.c.
+type dropReasonKey struct {
+	Reason     uint32
+	NetnsInode uint32
+	Protocol   uint16
+	L4Protocol uint8
+	Pad0       uint8
+	DevName    [bpf.NetdevNameLen]byte
+}
+
+// from include/uapi/linux/if_ether.h
+var ethProtocolMap = map[uint16]string{
+	0x0800: "ipv4",
+	0x0806: "arp",
+	0x86dd: "ipv6",
+	0x8100: "vlan",
+}
+
+// from include/uapi/linux/in.h
+var ipProtocolMap = map[uint8]string{
+	0:   "-",
+	1:   "icmp",
+	2:   "igmp",
+	6:   "tcp",
+	17:  "udp",
+	47:  "gre",
+	58:  "icmpv6",
+	132: "sctp",
+}
+
+type dropReasonCollector struct {
+	running   atomic.Bool
+	bpf       bpf.BPF
+	reasons   netutil.DropReasons
+	hostNetns uint64
+	// netnsContainers are the containers by the net namespaces in the last
+	// update, the drops of the vanished ones are deleted.
+	netnsContainers map[uint64]*pod.Container
+}
+
+func init() {
+	tracing.RegisterEventTracing("net_drop_reason", newDropReasonCollector)
+}
+
+func newDropReasonCollector() (*tracing.EventTracingAttr, error) {
+	return &tracing.EventTracingAttr{
+		TracingData: &dropReasonCollector{},
+		Interval:    10,
+		Flag:        tracing.FlagTracing | tracing.FlagMetric,
+	}, nil
+}
+
+func (c *dropReasonCollector) Start(ctx context.Context) error {
+	// the kernels < 5.17 have no drop reasons, the drops are still counted
+	// by the protocols, the netdev and the container.
+	spec, err := bpf.KernelBTF()
+	if err == nil {
+		c.reasons, err = netutil.DropReasonsFromBTF(spec)
+	}
+	if err != nil {
+		log.Infof("net_drop_reason: no drop reasons, counted as %s: %v", netutil.DropReasonUnknown, err)
+	}
+
+	c.hostNetns, err = netutil.NetNSInodeByPid(1)
+	if err != nil {
+		return fmt.Errorf("host net namespace: %w", err)
+	}
+
+	b, err := bpf.LoadBpfContext(ctx, bpf.ThisBpfOBJ(), nil)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	if err = b.Attach(); err != nil {
+		return err
+	}
+
+	childCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	b.WaitDetachByBreaker(childCtx, cancel)
+
+	c.bpf = b
+	c.running.Store(true)
+
+	// wait stop
+	<-childCtx.Done()
+	c.running.Store(false)
+	return nil
+}
+
+func protocolName[T uint8 | uint16](names map[T]string, protocol T) string {
+	if name, ok := names[protocol]; ok {
+		return name
+	}
+	return strconv.Itoa(int(protocol))
+}
+
+func (c *dropReasonCollector) Update() ([]*metric.Data, error) {
+	if !c.running.Load() {
+		return nil, nil
+	}
+
+	containers, err := pod.NormalContainers()
+	if err != nil {
+		return nil, err
+	}
+
+	netnsContainers := make(map[uint64]*pod.Container, len(containers))
+	for _, container := range containers {
+		netnsContainers[container.NetNamespaceInode] = container
+	}
+
+	items, err := c.bpf.DumpMapByName("drop_reasons")
+	if err != nil {
+		return nil, fmt.Errorf("dump map: %w", err)
+	}
+
+	// the netdev names are truncated from the kernel, and the older kernels
+	// count all the reasons as unknown, so the counters are summed by labels.
+	type counterKey struct {
+		containerID string
+		reason      string
+		protocol    string
+		l4Protocol  string
+		netdev      string
+	}
+	counters := make(map[counterKey]float64)
+	var deletedKeys [][]byte
+
+	for _, item := range items {
+		var key dropReasonKey
+		var count uint64
+
+		if err := binary.Read(bytes.NewReader(item.Key), binary.NativeEndian, &key); err != nil {
+			return nil, fmt.Errorf("read drop_reasons key: %w", err)
+		}
+		if err := binary.Read(bytes.NewReader(item.Value), binary.NativeEndian, &count); err != nil {
+			return nil, fmt.Errorf("read drop_reasons value: %w", err)
+		}
+
+		// the drops without a net namespace are of the host. the drops
+		// of the dead containers are deleted, and the ones of the other
+		// net namespaces are skipped, never counted into the host.
+		var containerID string
+		netns := uint64(key.NetnsInode)
+		if container, ok := netnsContainers[netns]; ok {
+			containerID = container.ID
+		} else if netns != 0 && netns != c.hostNetns {
+			if _, ok := c.netnsContainers[netns]; ok {
+				deletedKeys = append(deletedKeys, item.Key)
+			}
+			continue
+		}
+
+		counters[counterKey{
+			containerID: containerID,
+			reason:      c.reasons.Name(key.Reason),
+			protocol:    protocolName(ethProtocolMap, key.Protocol),
+			l4Protocol:  protocolName(ipProtocolMap, key.L4Protocol),
+			netdev:      bytesutil.ToStr(key.DevName[:]),
+		}] += float64(count)
+	}
+
+	if len(deletedKeys) > 0 {
+		if err := c.bpf.DeleteMapItems(c.bpf.MapIDByName("drop_reasons"), deletedKeys); err != nil {
+			return nil, fmt.Errorf("delete drop_reasons: %w", err)
+		}
+	}
+	c.netnsContainers = netnsContainers
+
+	data := []*metric.Data{}
+	for key, count := range counters {
+		labels := map[string]string{
+			"reason":      key.reason,
+			"protocol":    key.protocol,
+			"l4_protocol": key.l4Protocol,
+			"netdev":      key.netdev,
+		}
+
+		if key.containerID == "" {
+			data = append(data, metric.NewCounterData("packets_total", count, "packets dropped by the kernel by the reasons", labels))
+			continue
+		}
+		data = append(data, metric.NewContainerCounterData(containers[key.containerID], "packets_total", count,
+			"packets dropped by the kernel by the reasons", labels))
+	}
+
+	return data, nil
+}
@@ -186,7 +186,7 @@ func (netdev *netdevHw) updateIfaceSwDroppedStat() error {
 }
 
 func (netdev *netdevHw) Start(ctx context.Context) error {
-	prog, err := bpf.LoadBpf(bpf.ThisBpfOBJ(), nil)
+	prog, err := bpf.LoadBpfContext(ctx, bpf.ThisBpfOBJ(), nil)
 	if err != nil {
 		return err
 	}
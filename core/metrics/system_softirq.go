@@ -168,7 +168,7 @@ func (s *softirqLatency) Update() ([]*metric.Data, error) {
 }
 
 func (s *softirqLatency) Start(ctx context.Context) error {
-	b, err := bpf.LoadBpf(bpf.ThisBpfOBJ(), nil)
+	b, err := bpf.LoadBpfContext(ctx, bpf.ThisBpfOBJ(), nil)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,38 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bpf
+
+import "sync"
+
+// backend is the Backend of the bpf manager, nil loads the bpf objects into
+// the kernel.
+var backend struct {
+	sync.RWMutex
+	be Backend
+}
+
+func setBackend(be Backend) {
+	backend.Lock()
+	defer backend.Unlock()
+
+	backend.be = be
+}
+
+func currentBackend() Backend {
+	backend.RLock()
+	defer backend.RUnlock()
+
+	return backend.be
+}
@@ -20,6 +20,30 @@ import (
 
 type Option struct {
 	KeepaliveTimeout int
+	// StackDepth is the depth of the stacks collected by the stack trace
+	// maps, up to MaxStackDepth. Zero is MaxStackDepth.
+	StackDepth int
+	// RuntimeStats enables the run_time_ns and run_cnt stats of the programs
+	// by BPF_ENABLE_STATS, which costs a few ns for each program run.
+	RuntimeStats bool
+	// BTFPath is the directory or the archive of the BTF files keyed by the
+	// kernel release, for the kernels without /sys/kernel/btf/vmlinux.
+	BTFPath string
+	// PinPath is the path in the bpffs to pin the maps and the links, so
+	// that they survive the restarts. Empty disables the pinning.
+	PinPath string
+	// PinHandover attaches the new programs before detaching the pinned
+	// links which can't be updated.
+	PinHandover bool
+	// Backend loads the bpf objects instead of the kernel, e.g. the fake one
+	// of internal/bpf/bpftest for the tests of the tracers. The other options
+	// are ignored with the backend.
+	Backend Backend
+}
+
+// Backend loads the bpf objects by the names.
+type Backend interface {
+	LoadBpf(bpfName string, consts map[string]any) (BPF, error)
 }
 
 // The BPF APIs
@@ -38,10 +62,21 @@ type Option struct {
 // AttachOption is an option for attaching a program.
 type AttachOption struct {
 	ProgramName string
-	Symbol      string   // symbol for kprobe/kretprobe/tracepoint/raw_tracepoint
-	PerfEvent   struct { // BPF_PROG_TYPE_PERF_EVENT
+	// symbol for kprobe/kretprobe/tracepoint/raw_tracepoint,
+	// <symbol>[+<offset>] for uprobe/uretprobe, <provider>:<name> for usdt.
+	Symbol    string
+	PerfEvent struct { // BPF_PROG_TYPE_PERF_EVENT
 		SamplePeriod, SampleFreq uint64
 	}
+	Uprobe struct { // uprobe/uretprobe/usdt
+		// Path is the binary or the shared library.
+		Path string
+		// PID only traces the process, 0 for all.
+		PID int
+		// CgroupPath only traces the tasks of the cgroup v2, the bpf checks
+		// it by bpf_uprobe_filtered, see bpf/include/bpf_uprobe.h.
+		CgroupPath string
+	}
 }
 
 // Info is the info of a bpf.
@@ -58,9 +93,13 @@ type MapInfo struct {
 
 // ProgramInfo is the info of a program.
 type ProgramInfo struct {
-	ID          uint32
-	Name        string
-	SectionName string
+	ID          uint32 `json:"id"`
+	Name        string `json:"name"`
+	SectionName string `json:"section"`
+	// RunTimeNs and RunCount are the runtime stats of the program, they are
+	// zero when the stats are disabled.
+	RunTimeNs uint64 `json:"run_time_ns"`
+	RunCount  uint64 `json:"run_count"`
 }
 
 // MapItem describes a map element with key-value
@@ -19,6 +19,8 @@ package bpf
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -37,8 +39,28 @@ import (
 
 var DefaultBpfObjDir = "bpf"
 
+// uprobeFilterMap is the map of the uprobe filter, see bpf/include/bpf_uprobe.h.
+const uprobeFilterMap = "uprobe_filter"
+
 // NewManager initializes the bpf manager.
 func NewManager(opt *Option) error {
+	if opt != nil && opt.Backend != nil {
+		setBackend(opt.Backend)
+		return nil
+	}
+	if opt != nil && opt.StackDepth > 0 {
+		SetStackDepth(opt.StackDepth)
+	}
+	if opt != nil && opt.RuntimeStats {
+		enableRuntimeStats()
+	}
+	if opt != nil && opt.BTFPath != "" {
+		SetExternalBTF(opt.BTFPath)
+	}
+	if opt != nil && opt.PinPath != "" {
+		SetPinning(opt.PinPath, opt.PinHandover)
+	}
+
 	return unix.Setrlimit(unix.RLIMIT_MEMLOCK, &unix.Rlimit{
 		Cur: unix.RLIM_INFINITY,
 		Max: unix.RLIM_INFINITY,
@@ -46,7 +68,10 @@ func NewManager(opt *Option) error {
 }
 
 // Close closes the bpf manager.
-func Close() {}
+func Close() {
+	setBackend(nil)
+	disableRuntimeStats()
+}
 
 type mapSpec struct {
 	name string
@@ -58,6 +83,7 @@ type programSpec struct {
 	specType      ebpf.ProgramType
 	sectionName   string
 	sectionPrefix string
+	attachType    ebpf.AttachType
 	bProg         *ebpf.Program
 	links         map[string]link.Link
 }
@@ -69,6 +95,7 @@ type defaultBPF struct {
 	mapName2IDs     map[string]uint32
 	programName2IDs map[string]uint32
 	innerPerfEvent  *perfEventPMU
+	pin             *bpfPin
 }
 
 // _ is a type assertion
@@ -79,6 +106,9 @@ func LoadBpfFromBytes(bpfName string, bpfBytes []byte, consts map[string]any) (B
 	if err := validateName(bpfName); err != nil {
 		return nil, err
 	}
+	if be := currentBackend(); be != nil {
+		return be.LoadBpf(bpfName, consts)
+	}
 	return loadBpfFromReader(bpfName, bytes.NewReader(bpfBytes), consts)
 }
 
@@ -87,6 +117,9 @@ func LoadBpf(bpfName string, consts map[string]any) (BPF, error) {
 	if err := validateName(bpfName); err != nil {
 		return nil, err
 	}
+	if be := currentBackend(); be != nil {
+		return be.LoadBpf(bpfName, consts)
+	}
 	f, err := os.Open(filepath.Join(DefaultBpfObjDir, bpfName))
 	if err != nil {
 		return nil, err
@@ -96,6 +129,40 @@ func LoadBpf(bpfName string, consts map[string]any) (BPF, error) {
 	return loadBpfFromReader(bpfName, f, consts)
 }
 
+// LoadBpfContext loads the bpf like LoadBpf, the bpf object is owned by the
+// owner of ctx, see WithOwner.
+func LoadBpfContext(ctx context.Context, bpfName string, consts map[string]any) (BPF, error) {
+	setObjectOwner(ctx, bpfName)
+	b, err := LoadBpf(bpfName, consts)
+	if err != nil {
+		removeObjectOwner(bpfName)
+	}
+	return b, err
+}
+
+// LoadBpfFromBytesContext loads the bpf like LoadBpfFromBytes, the bpf object
+// is owned by the owner of ctx, see WithOwner.
+func LoadBpfFromBytesContext(ctx context.Context, bpfName string, bpfBytes []byte, consts map[string]any) (BPF, error) {
+	setObjectOwner(ctx, bpfName)
+	b, err := LoadBpfFromBytes(bpfName, bpfBytes, consts)
+	if err != nil {
+		removeObjectOwner(bpfName)
+	}
+	return b, err
+}
+
+// LoadBpfFromSpec loads the bpf from the collection spec, e.g. the programs
+// generated at runtime. The bpf is never pinned, the specs are of the tools.
+func LoadBpfFromSpec(bpfName string, specs *ebpf.CollectionSpec, consts map[string]any) (BPF, error) {
+	if err := validateName(bpfName); err != nil {
+		return nil, err
+	}
+	if be := currentBackend(); be != nil {
+		return be.LoadBpf(bpfName, consts)
+	}
+	return loadBpfFromSpec(bpfName, specs, false, consts)
+}
+
 // loadBpfFromReader loads the bpf from reader.
 func loadBpfFromReader(bpfName string, rd io.ReaderAt, consts map[string]any) (BPF, error) {
 	specs, err := ebpf.LoadCollectionSpecFromReader(rd)
@@ -103,6 +170,28 @@ func loadBpfFromReader(bpfName string, rd io.ReaderAt, consts map[string]any) (B
 		return nil, fmt.Errorf("can't parse the bpf file %s: %w", bpfName, err)
 	}
 
+	return loadBpfFromSpec(bpfName, specs, true, consts)
+}
+
+// loadBpfFromSpec loads the bpf from the collection spec, pinnable is false
+// if the spec isn't of a bpf object, see LoadBpfFromSpec.
+func loadBpfFromSpec(bpfName string, specs *ebpf.CollectionSpec, pinnable bool, consts map[string]any) (BPF, error) {
+	// the depth of the stack trace maps, the maps are preallocated, so they
+	// are of a single bucket if the bpf doesn't collect the stacks.
+	stacksDisabled := consts["stacks_enabled"] == false
+	for _, m := range specs.Maps {
+		if m.Type == ebpf.StackTrace {
+			m.ValueSize = uint32(stackDepth) * 8
+			if stacksDisabled {
+				m.MaxEntries = 1
+			}
+		}
+	}
+
+	// ring buffers or perf event arrays of the event pipes
+	consts = setupEventPipes(specs, consts)
+	setupRatelimits(specs)
+
 	// RewriteConstants
 	if consts != nil {
 		if err := specs.RewriteConstants(consts); err != nil {
@@ -110,8 +199,28 @@ func loadBpfFromReader(bpfName string, rd io.ReaderAt, consts map[string]any) (B
 		}
 	}
 
+	// the external btf for the kernels without btf.
+	opts, err := collectionOptions(bpfName, specs)
+	if err != nil {
+		return nil, err
+	}
+
+	// the pinned maps and links in the bpffs.
+	var pin *bpfPin
+	if pinPath, _, _ := pinningOption(); pinPath != "" && pinnable {
+		if pin, err = preparePin(pinPath, bpfName, specs, &opts); err != nil {
+			return nil, fmt.Errorf("can't pin the bpf %s: %w", bpfName, err)
+		}
+	}
+
 	// loads Maps and Programs into the kernel.
-	coll, err := ebpf.NewCollection(specs)
+	coll, err := ebpf.NewCollectionWithOptions(specs, opts)
+	if err != nil && pin != nil && errors.Is(err, ebpf.ErrMapIncompatible) {
+		log.Warnf("replace the pinned maps of %s: %v", bpfName, err)
+		if err = pin.reset(); err == nil {
+			coll, err = ebpf.NewCollectionWithOptions(specs, opts)
+		}
+	}
 	if err != nil {
 		return nil, fmt.Errorf("can't new the bpf collection: %w", err)
 	}
@@ -121,6 +230,7 @@ func loadBpfFromReader(bpfName string, rd io.ReaderAt, consts map[string]any) (B
 		name:         bpfName,
 		mapSpecs:     make(map[uint32]mapSpec),
 		programSpecs: make(map[uint32]programSpec),
+		pin:          pin,
 	}
 
 	// maps
@@ -178,6 +288,7 @@ func loadBpfFromReader(bpfName string, rd io.ReaderAt, consts map[string]any) (B
 			specType:      spec.Type,
 			sectionName:   spec.SectionName,
 			sectionPrefix: strings.SplitN(spec.SectionName, "/", 2)[0],
+			attachType:    spec.AttachType,
 			bProg:         bProg,
 			links:         make(map[string]link.Link),
 		}
@@ -185,8 +296,10 @@ func loadBpfFromReader(bpfName string, rd io.ReaderAt, consts map[string]any) (B
 
 	// mapName2IDs
 	b.mapName2IDs = make(map[string]uint32, len(b.mapSpecs))
+	maps := make(map[string]*ebpf.Map, len(b.mapSpecs))
 	for id, m := range b.mapSpecs {
 		b.mapName2IDs[m.name] = id
+		maps[m.name] = m.bMap
 	}
 
 	// programName2IDs
@@ -195,6 +308,16 @@ func loadBpfFromReader(bpfName string, rd io.ReaderAt, consts map[string]any) (B
 		b.programName2IDs[p.name] = id
 	}
 
+	if pin != nil {
+		if err := pin.loadLinks(); err != nil {
+			b.Close()
+			return nil, fmt.Errorf("can't load the pinned links of %s: %w", bpfName, err)
+		}
+	}
+
+	registerPrograms(b)
+	registerRatelimits(bpfName, findRatelimits(specs, maps))
+
 	log.Debugf("loaded bpf: %s", b)
 
 	// auto clean
@@ -239,11 +362,7 @@ func (b *defaultBPF) Info() (*Info, error) {
 
 	// programs
 	for id, p := range b.programSpecs {
-		info.ProgramsInfo = append(info.ProgramsInfo, ProgramInfo{
-			ID:          id,
-			Name:        p.name,
-			SectionName: p.sectionName,
-		})
+		info.ProgramsInfo = append(info.ProgramsInfo, programInfo(id, p))
 	}
 
 	return info, nil
@@ -251,6 +370,16 @@ func (b *defaultBPF) Info() (*Info, error) {
 
 // Close the bpf.
 func (b *defaultBPF) Close() error {
+	unregisterPrograms(b)
+	unregisterRatelimits(b.name, b.mapSpecs)
+	removeObjectOwner(b.name)
+
+	if b.pin != nil {
+		_, _, keep := pinningOption()
+		b.pin.close(keep)
+		b.pin = nil
+	}
+
 	for _, m := range b.mapSpecs {
 		m.bMap.Close()
 	}
@@ -290,6 +419,14 @@ func (b *defaultBPF) AttachWithOptions(opts []AttachOption) error {
 				return fmt.Errorf("attach tracepoint with options %v: %w", opt, err)
 			}
 		case ebpf.Kprobe:
+			if isUprobeSection(spec.sectionPrefix) {
+				// opt.Symbol: <symbol>[+<offset>] or <provider>:<name>
+				if err = b.attachUprobeWithOption(progID, &opt); err != nil {
+					return fmt.Errorf("attach %s with options %v: %w", spec.sectionPrefix, opt, err)
+				}
+				continue
+			}
+
 			// opt.Symbol: <symbol>[+<offset>]
 			// opt.Symbol: <symbol>
 			if err = b.attachKprobe(progID, opt.Symbol, spec.sectionPrefix == "kretprobe"); err != nil {
@@ -304,11 +441,25 @@ func (b *defaultBPF) AttachWithOptions(opts []AttachOption) error {
 			if err = b.attachPerfEvent(progID, opt.PerfEvent.SamplePeriod, opt.PerfEvent.SampleFreq); err != nil {
 				return fmt.Errorf("attach perf event with options %v: %w", opt, err)
 			}
+		case ebpf.Tracing:
+			// the target of fentry/fexit/tp_btf is resolved on loading.
+			if opt.Symbol != "" && opt.Symbol != tracingTarget(spec.sectionName) {
+				return fmt.Errorf("bpf %s: the target of %s is fixed on loading: %s", b, spec.sectionName, opt.Symbol)
+			}
+
+			if err = b.attachTracing(progID); err != nil {
+				return fmt.Errorf("attach tracing with options %v: %w", opt, err)
+			}
 		default:
 			return fmt.Errorf("bpf %s: unsupported program type: %s", b, spec.specType)
 		}
 	}
 
+	progNames := make([]string, 0, len(opts))
+	for _, opt := range opts {
+		progNames = append(progNames, opt.ProgramName)
+	}
+	b.attached(progNames)
 	return nil
 }
 
@@ -335,6 +486,16 @@ func (b *defaultBPF) Attach() error {
 				return fmt.Errorf("attach tracepoint: %w", err)
 			}
 		case ebpf.Kprobe:
+			if isUprobeSection(spec.sectionPrefix) {
+				// section: uprobe/<path>:<symbol>[+<offset>]
+				// section: uretprobe/<path>:<symbol>
+				// section: usdt/<path>:<provider>:<name>
+				if err = b.attachUprobeWithOption(progID, &AttachOption{}); err != nil {
+					return fmt.Errorf("attach %s: %w", spec.sectionPrefix, err)
+				}
+				continue
+			}
+
 			// section: kprobe/<symbol>[+<offset>]
 			// section: kretprobe/<symbol>
 			symbols := strings.SplitN(spec.sectionName, "/", 2)
@@ -355,11 +516,23 @@ func (b *defaultBPF) Attach() error {
 			if err = b.attachRawTracepoint(progID, symbols[1]); err != nil {
 				return fmt.Errorf("attach raw tracepoint: %w", err)
 			}
+		case ebpf.Tracing:
+			// section: fentry/<symbol>
+			// section: fexit/<symbol>
+			// section: tp_btf/<symbol>
+			if err = b.attachTracing(progID); err != nil {
+				return fmt.Errorf("attach tracing: %w", err)
+			}
 		default:
 			return fmt.Errorf("bpf %s: unsupported program type: %s", b, spec.specType)
 		}
 	}
 
+	progNames := make([]string, 0, len(b.programSpecs))
+	for _, spec := range b.programSpecs {
+		progNames = append(progNames, spec.name)
+	}
+	b.attached(progNames)
 	return nil
 }
 
@@ -385,6 +558,9 @@ func (b *defaultBPF) attachKprobe(progID uint32, symbol string, isRetprobe bool)
 		}
 
 		linkKey := fmt.Sprintf("%s+%d", symOffsets[0], offset)
+		if b.takeOverPinnedLink(spec, linkKey) {
+			return nil
+		}
 		if _, ok := spec.links[linkKey]; ok {
 			return fmt.Errorf("bpf %s: duplicate symbol: %s", b, symbol)
 		}
@@ -397,10 +573,13 @@ func (b *defaultBPF) attachKprobe(progID uint32, symbol string, isRetprobe bool)
 			return fmt.Errorf("can't attach kprobe %s in %v: %w", symbol, spec.bProg, err)
 		}
 
-		spec.links[linkKey] = l
+		b.addLink(spec, linkKey, l)
 		log.Debugf("attach kprobe %s in %v, links: %v", symbol, spec.bProg, spec.links)
 	} else { // kretprobe
 		linkKey := symbol
+		if b.takeOverPinnedLink(spec, linkKey) {
+			return nil
+		}
 		if _, ok := spec.links[linkKey]; ok {
 			return fmt.Errorf("bpf %s: duplicate symbol: %s", b, symbol)
 		}
@@ -410,7 +589,7 @@ func (b *defaultBPF) attachKprobe(progID uint32, symbol string, isRetprobe bool)
 			return fmt.Errorf("can't attach kretprobe %s in %v: %w", symbol, spec.bProg, err)
 		}
 
-		spec.links[linkKey] = l
+		b.addLink(spec, linkKey, l)
 		log.Debugf("attach kretprobe %s in %v, links: %v", symbol, spec.bProg, spec.links)
 	}
 
@@ -421,6 +600,9 @@ func (b *defaultBPF) attachTracepoint(progID uint32, system, symbol string) erro
 	spec := b.programSpecs[progID]
 
 	linkKey := fmt.Sprintf("%s/%s", system, symbol)
+	if b.takeOverPinnedLink(spec, linkKey) {
+		return nil
+	}
 	if _, ok := spec.links[linkKey]; ok {
 		return fmt.Errorf("bpf %s: duplicate symbol: %s", b, symbol)
 	}
@@ -430,7 +612,7 @@ func (b *defaultBPF) attachTracepoint(progID uint32, system, symbol string) erro
 		return fmt.Errorf("can't attach tracepoint %s/%s in %v: %w", system, symbol, spec.bProg, err)
 	}
 
-	spec.links[linkKey] = l
+	b.addLink(spec, linkKey, l)
 	log.Debugf("attach tracepoint %s/%s in %v, links: %v", system, symbol, spec.bProg, spec.links)
 	return nil
 }
@@ -439,6 +621,9 @@ func (b *defaultBPF) attachRawTracepoint(progID uint32, symbol string) error {
 	spec := b.programSpecs[progID]
 
 	linkKey := symbol
+	if b.takeOverPinnedLink(spec, linkKey) {
+		return nil
+	}
 	if _, ok := spec.links[linkKey]; ok {
 		return fmt.Errorf("bpf %s: duplicate symbol: %s", b, symbol)
 	}
@@ -451,11 +636,240 @@ func (b *defaultBPF) attachRawTracepoint(progID uint32, symbol string) error {
 		return fmt.Errorf("can't attach raw tracepoint %s in %v: %w", symbol, spec.bProg, err)
 	}
 
-	spec.links[linkKey] = l
+	b.addLink(spec, linkKey, l)
 	log.Debugf("attach raw tracepoint %s in %v, links: %v", symbol, spec.bProg, spec.links)
 	return nil
 }
 
+// addLink adds the attached link of the program, and pins it if pinning.
+func (b *defaultBPF) addLink(spec programSpec, linkKey string, l link.Link) {
+	spec.links[linkKey] = l
+	if b.pin != nil {
+		b.pin.pinLink(spec.name, linkKey, l)
+	}
+}
+
+// takeOverPinnedLink updates the pinned link attached by the previous agent
+// to the program.
+func (b *defaultBPF) takeOverPinnedLink(spec programSpec, linkKey string) bool {
+	if b.pin == nil {
+		return false
+	}
+
+	l, ok := b.pin.takeOverLink(spec.name, linkKey, spec.bProg)
+	if !ok {
+		return false
+	}
+
+	spec.links[linkKey] = l
+	log.Debugf("take over the pinned link %s in %v", linkKey, spec.bProg)
+	return true
+}
+
+// attached releases the pinned links of the programs not taken over after
+// attaching.
+func (b *defaultBPF) attached(progNames []string) {
+	if b.pin == nil {
+		return
+	}
+
+	b.pin.releaseLinks(progNames)
+}
+
+// tracingTarget returns the target in the section name of fentry/fexit/tp_btf.
+func tracingTarget(sectionName string) string {
+	if _, target, ok := strings.Cut(sectionName, "/"); ok {
+		return target
+	}
+	return ""
+}
+
+func (b *defaultBPF) attachTracing(progID uint32) error {
+	spec := b.programSpecs[progID]
+
+	linkKey := spec.sectionName
+	if b.takeOverPinnedLink(spec, linkKey) {
+		return nil
+	}
+	if _, ok := spec.links[linkKey]; ok {
+		return fmt.Errorf("bpf %s: duplicate symbol: %s", b, linkKey)
+	}
+
+	l, err := link.AttachTracing(link.TracingOptions{
+		Program:    spec.bProg,
+		AttachType: spec.attachType,
+	})
+	if err != nil {
+		return fmt.Errorf("can't attach %s in %v: %w", spec.sectionName, spec.bProg, err)
+	}
+
+	b.addLink(spec, linkKey, l)
+	log.Debugf("attach %s in %v, links: %v", spec.sectionName, spec.bProg, spec.links)
+	return nil
+}
+
+func isUprobeSection(prefix string) bool {
+	return prefix == "uprobe" || prefix == "uretprobe" || prefix == "usdt"
+}
+
+// uprobeSectionTarget returns the binary and the symbol in the section name.
+//
+//	uprobe/<path>:<symbol>[+<offset>]
+//	uretprobe/<path>:<symbol>
+//	usdt/<path>:<provider>:<name>
+func uprobeSectionTarget(spec *programSpec) (path, symbol string) {
+	_, target, _ := strings.Cut(spec.sectionName, "/")
+
+	// the path may have ':'.
+	n := 1
+	if spec.sectionPrefix == "usdt" {
+		n = 2
+	}
+
+	fields := strings.Split(target, ":")
+	if len(fields) <= n {
+		return "", ""
+	}
+	return strings.Join(fields[:len(fields)-n], ":"), strings.Join(fields[len(fields)-n:], ":")
+}
+
+// attachUprobeWithOption attaches the uprobe/uretprobe/usdt, the binary and
+// the symbol default to the ones in the section name.
+func (b *defaultBPF) attachUprobeWithOption(progID uint32, opt *AttachOption) error {
+	spec := b.programSpecs[progID]
+
+	path, symbol := uprobeSectionTarget(&spec)
+	if opt.Uprobe.Path != "" {
+		path = opt.Uprobe.Path
+	}
+	if opt.Symbol != "" {
+		symbol = opt.Symbol
+	}
+
+	if path == "" || symbol == "" {
+		return fmt.Errorf("bpf %s: %s needs the binary path and the symbol", b, spec.sectionName)
+	}
+
+	if opt.Uprobe.CgroupPath != "" {
+		if err := b.setUprobeCgroupFilter(opt.Uprobe.CgroupPath); err != nil {
+			return err
+		}
+	}
+
+	if spec.sectionPrefix == "usdt" {
+		provider, name, ok := strings.Cut(symbol, ":")
+		if !ok {
+			return fmt.Errorf("bpf %s: invalid usdt: %s", b, symbol)
+		}
+		return b.attachUSDT(progID, path, provider, name, opt.Uprobe.PID)
+	}
+
+	return b.attachUprobe(progID, path, symbol, spec.sectionPrefix == "uretprobe", opt.Uprobe.PID)
+}
+
+func (b *defaultBPF) attachUprobe(progID uint32, path, symbol string, isRetprobe bool, pid int) error {
+	spec := b.programSpecs[progID]
+
+	// : <symbol>[+<offset>]
+	var offset uint64
+
+	symOffsets := strings.Split(symbol, "+")
+	if len(symOffsets) > 2 {
+		return fmt.Errorf("bpf %s: invalid symbol: %s", b, symbol)
+	} else if len(symOffsets) == 2 {
+		var err error
+		if offset, err = strconv.ParseUint(symOffsets[1], 0, 64); err != nil {
+			return fmt.Errorf("bpf %s: invalid symbol: %s", b, symbol)
+		}
+	}
+
+	linkKey := fmt.Sprintf("%s:%s+%d@%d", path, symOffsets[0], offset, pid)
+	if b.takeOverPinnedLink(spec, linkKey) {
+		return nil
+	}
+	if _, ok := spec.links[linkKey]; ok {
+		return fmt.Errorf("bpf %s: duplicate symbol: %s", b, symbol)
+	}
+
+	ex, err := link.OpenExecutable(path)
+	if err != nil {
+		return fmt.Errorf("can't open executable %s: %w", path, err)
+	}
+
+	opts := &link.UprobeOptions{Offset: offset, PID: pid}
+
+	var l link.Link
+	if isRetprobe {
+		l, err = ex.Uretprobe(symOffsets[0], spec.bProg, opts)
+	} else {
+		l, err = ex.Uprobe(symOffsets[0], spec.bProg, opts)
+	}
+	if err != nil {
+		return fmt.Errorf("can't attach %s %s:%s in %v: %w", spec.sectionPrefix, path, symbol, spec.bProg, err)
+	}
+
+	b.addLink(spec, linkKey, l)
+	log.Debugf("attach %s %s:%s in %v, links: %v", spec.sectionPrefix, path, symbol, spec.bProg, spec.links)
+	return nil
+}
+
+func (b *defaultBPF) attachUSDT(progID uint32, path, provider, name string, pid int) error {
+	spec := b.programSpecs[progID]
+
+	linkKey := fmt.Sprintf("%s:%s:%s@%d", path, provider, name, pid)
+	if b.takeOverPinnedLink(spec, linkKey) {
+		return nil
+	}
+	if _, ok := spec.links[linkKey]; ok {
+		return fmt.Errorf("bpf %s: duplicate usdt: %s:%s", b, provider, name)
+	}
+
+	probe, err := findUSDTProbe(path, provider, name)
+	if err != nil {
+		return err
+	}
+
+	ex, err := link.OpenExecutable(path)
+	if err != nil {
+		return fmt.Errorf("can't open executable %s: %w", path, err)
+	}
+
+	// the probe is located by the address, the kernel increments the
+	// semaphore if any, so that the argument preparation is not skipped.
+	l, err := ex.Uprobe(fmt.Sprintf("usdt_%s_%s", provider, name), spec.bProg, &link.UprobeOptions{
+		Address:      probe.Offset,
+		PID:          pid,
+		RefCtrOffset: probe.SemaphoreOffset,
+	})
+	if err != nil {
+		return fmt.Errorf("can't attach usdt %s:%s:%s in %v: %w", path, provider, name, spec.bProg, err)
+	}
+
+	b.addLink(spec, linkKey, l)
+	log.Debugf("attach usdt %s:%s:%s (args %q) in %v, links: %v", path, provider, name, probe.Args, spec.bProg, spec.links)
+	return nil
+}
+
+// setUprobeCgroupFilter sets the cgroup v2 of which the tasks are traced by
+// the uprobes, the filter is shared by all programs of the bpf.
+func (b *defaultBPF) setUprobeCgroupFilter(cgroupPath string) error {
+	mapID := b.MapIDByName(uprobeFilterMap)
+	if mapID == 0 {
+		return fmt.Errorf("bpf %s: the cgroup filter needs the %s map", b, uprobeFilterMap)
+	}
+
+	// the cgroup id is the inode number of the cgroup v2 directory.
+	var st unix.Stat_t
+	if err := unix.Stat(cgroupPath, &st); err != nil {
+		return fmt.Errorf("stat cgroup %s: %w", cgroupPath, err)
+	}
+
+	key := make([]byte, 4)
+	value := make([]byte, 8)
+	binary.NativeEndian.PutUint64(value, st.Ino)
+	return b.WriteMapItems(mapID, []MapItem{{Key: key, Value: value}})
+}
+
 func (b *defaultBPF) attachPerfEvent(progID uint32, samplePeriod, sampleFreq uint64) error {
 	if b.innerPerfEvent != nil {
 		return fmt.Errorf("bpf %s duplicated symbol: %s", b, perfEventPmuSysbmol)
@@ -486,8 +900,13 @@ func (b *defaultBPF) attachPerfEvent(progID uint32, samplePeriod, sampleFreq uin
 
 // Detach all programs.
 func (b *defaultBPF) Detach() error {
+	_, _, keep := pinningOption()
+
 	for _, spec := range b.programSpecs {
-		for _, l := range spec.links {
+		for key, l := range spec.links {
+			if b.pin != nil && !keep {
+				b.pin.unpinLink(spec.name, key)
+			}
 			err := l.Close()
 			log.Debugf("detach %s in %v: %v", spec.sectionName, spec.bProg, err)
 		}
@@ -506,8 +925,31 @@ func (b *defaultBPF) Loaded() (bool, error) {
 }
 
 // EventPipe gets event-pipe and returns a PerfEventReader.
+//
+// The ring buffer is read if the map is a BPF_MAP_TYPE_RINGBUF, or the perf
+// event array declared by BPF_EVENT_PIPE goes with the ring buffer on the
+// kernels supporting it. The size of the ring buffer is fixed on loading,
+// perCPUBuffer is only for the perf event arrays.
 func (b *defaultBPF) EventPipe(ctx context.Context, mapID, perCPUBuffer uint32) (PerfEventReader, error) {
-	reader, err := newPerfEventReader(ctx, b.mapSpecs[mapID].bMap, int(perCPUBuffer))
+	m := b.mapSpecs[mapID]
+	if m.bMap == nil {
+		return nil, fmt.Errorf("map %d not found", mapID)
+	}
+
+	// the lost events are accounted to the bpf object by default.
+	ctx = WithOwner(ctx, ownerOf(ctx, b.name))
+
+	if ring := b.eventPipeRingbuf(m); ring != nil {
+		reader, err := newRingbufEventReader(ctx, ring, b.eventPipeLost(m))
+		if err != nil {
+			return nil, err
+		}
+
+		log.Debugf("event-pipe %d, ringbuf %d bytes", mapID, ring.MaxEntries())
+		return reader, nil
+	}
+
+	reader, err := newPerfEventReader(ctx, m.bMap, int(perCPUBuffer))
 	if err != nil {
 		return nil, err
 	}
@@ -516,6 +958,30 @@ func (b *defaultBPF) EventPipe(ctx context.Context, mapID, perCPUBuffer uint32)
 	return reader, nil
 }
 
+// eventPipeRingbuf returns the ring buffer of the event pipe, nil for the
+// perf event arrays.
+func (b *defaultBPF) eventPipeRingbuf(m mapSpec) *ebpf.Map {
+	if m.bMap.Type() == ebpf.RingBuf {
+		return m.bMap
+	}
+
+	ring, ok := b.mapSpecs[b.MapIDByName(m.name+eventPipeRingbufSuffix)]
+	if ok && ring.bMap.Type() == ebpf.RingBuf {
+		return ring.bMap
+	}
+	return nil
+}
+
+// eventPipeLost returns the per-cpu lost counters of the event pipe declared
+// by BPF_EVENT_PIPE.
+func (b *defaultBPF) eventPipeLost(m mapSpec) *ebpf.Map {
+	lost, ok := b.mapSpecs[b.MapIDByName(m.name+eventPipeLostSuffix)]
+	if ok && lost.bMap.Type() == ebpf.PerCPUArray {
+		return lost.bMap
+	}
+	return nil
+}
+
 // EventPipeByName gets event-pipe by the mapName and returns a PerfEventReader.
 func (b *defaultBPF) EventPipeByName(ctx context.Context, mapName string, perCPUBuffer uint32) (PerfEventReader, error) {
 	return b.EventPipe(ctx, b.MapIDByName(mapName), perCPUBuffer)
@@ -0,0 +1,294 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bpftest
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+
+	"huatuo-bamai/internal/bpf"
+	"huatuo-bamai/pkg/types"
+)
+
+// fakeBPF is a load of the scripted object. The maps and the event pipes are
+// numbered by the names from 1, and so are the programs.
+type fakeBPF struct {
+	o *Object
+
+	mapIDs   map[string]uint32
+	mapNames map[uint32]string
+	progIDs  map[string]uint32
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// _ is a type assertion
+var _ bpf.BPF = (*fakeBPF)(nil)
+
+func sortedNames[V any](m map[string]V) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newFakeBPF creates the load of the object, the object is locked.
+func newFakeBPF(o *Object) *fakeBPF {
+	b := &fakeBPF{
+		o:        o,
+		mapIDs:   make(map[string]uint32),
+		mapNames: make(map[uint32]string),
+		progIDs:  make(map[string]uint32),
+	}
+
+	names := sortedNames(o.maps)
+	for _, name := range sortedNames(o.pipes) {
+		if _, ok := o.maps[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	for i, name := range names {
+		b.mapIDs[name] = uint32(i + 1)
+		b.mapNames[uint32(i+1)] = name
+	}
+
+	for i, name := range sortedNames(o.programs) {
+		b.progIDs[name] = uint32(i + 1)
+	}
+	return b
+}
+
+// Name returns the bpf name.
+func (b *fakeBPF) Name() string {
+	return b.o.name
+}
+
+// MapIDByName gets mapID by Name.
+func (b *fakeBPF) MapIDByName(name string) uint32 {
+	return b.mapIDs[name]
+}
+
+// ProgIDByName gets progID by Name.
+func (b *fakeBPF) ProgIDByName(name string) uint32 {
+	return b.progIDs[name]
+}
+
+// String returns the bpf string.
+func (b *fakeBPF) String() string {
+	return fmt.Sprintf("%s#%d#%d", b.o.name, len(b.mapIDs), len(b.progIDs))
+}
+
+// Info gets bpf information.
+func (b *fakeBPF) Info() (*bpf.Info, error) {
+	info := &bpf.Info{}
+	for name, id := range b.mapIDs {
+		info.MapsInfo = append(info.MapsInfo, bpf.MapInfo{ID: id, Name: name})
+	}
+	for name, id := range b.progIDs {
+		info.ProgramsInfo = append(info.ProgramsInfo, bpf.ProgramInfo{ID: id, Name: name})
+	}
+	return info, nil
+}
+
+// Close the bpf.
+func (b *fakeBPF) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+
+	b.o.mu.Lock()
+	defer b.o.mu.Unlock()
+
+	b.o.closes++
+	return nil
+}
+
+// AttachWithOptions attaches programs with options.
+func (b *fakeBPF) AttachWithOptions(opts []bpf.AttachOption) error {
+	for _, opt := range opts {
+		if b.progIDs[opt.ProgramName] == 0 && len(b.progIDs) != 0 {
+			return fmt.Errorf("program %s not found", opt.ProgramName)
+		}
+	}
+
+	b.o.mu.Lock()
+	defer b.o.mu.Unlock()
+
+	b.o.attaches = append(b.o.attaches, AttachCall{Options: append([]bpf.AttachOption{}, opts...)})
+	return nil
+}
+
+// Attach the default programs.
+func (b *fakeBPF) Attach() error {
+	b.o.mu.Lock()
+	defer b.o.mu.Unlock()
+
+	b.o.attaches = append(b.o.attaches, AttachCall{})
+	return nil
+}
+
+// Detach all programs.
+func (b *fakeBPF) Detach() error {
+	b.o.mu.Lock()
+	defer b.o.mu.Unlock()
+
+	b.o.detaches++
+	return nil
+}
+
+// Loaded checks bpf is still loaded.
+func (b *fakeBPF) Loaded() (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return !b.closed, nil
+}
+
+// EventPipe gets event-pipe and returns a PerfEventReader.
+func (b *fakeBPF) EventPipe(ctx context.Context, mapID, perCPUBuffer uint32) (bpf.PerfEventReader, error) {
+	name, ok := b.mapNames[mapID]
+	if !ok {
+		return nil, fmt.Errorf("map %d not found", mapID)
+	}
+	return &perfEventReader{ctx: ctx, o: b.o, mapName: name}, nil
+}
+
+// EventPipeByName gets event-pipe by the mapName and returns a PerfEventReader.
+func (b *fakeBPF) EventPipeByName(ctx context.Context, mapName string, perCPUBuffer uint32) (bpf.PerfEventReader, error) {
+	return b.EventPipe(ctx, b.MapIDByName(mapName), perCPUBuffer)
+}
+
+// AttachAndEventPipe attaches and event-pipe and returns a PerfEventReader.
+func (b *fakeBPF) AttachAndEventPipe(ctx context.Context, mapName string, perCPUBuffer uint32) (bpf.PerfEventReader, error) {
+	if err := b.Attach(); err != nil {
+		return nil, err
+	}
+	return b.EventPipeByName(ctx, mapName, perCPUBuffer)
+}
+
+// mapByID returns the in-memory map, the event pipes have no items.
+func (b *fakeBPF) mapByID(mapID uint32) (*Map, error) {
+	name, ok := b.mapNames[mapID]
+	if !ok {
+		return nil, fmt.Errorf("map %d not found", mapID)
+	}
+
+	b.o.mu.Lock()
+	defer b.o.mu.Unlock()
+
+	if m, ok := b.o.maps[name]; ok {
+		return m, nil
+	}
+	return &Map{items: make(map[string][]byte)}, nil
+}
+
+// ReadMap reads the value of the key, it is nil if the key doesn't exist.
+func (b *fakeBPF) ReadMap(mapID uint32, key []byte) ([]byte, error) {
+	m, err := b.mapByID(mapID)
+	if err != nil {
+		return nil, err
+	}
+
+	value, _ := m.Get(key)
+	return value, nil
+}
+
+// WriteMapItems writes the value content corresponding to a key to a map.
+func (b *fakeBPF) WriteMapItems(mapID uint32, items []bpf.MapItem) error {
+	m, err := b.mapByID(mapID)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		m.Put(item.Key, item.Value)
+	}
+	return nil
+}
+
+// DeleteMapItems deletes multiple items from a BPF map by keys.
+func (b *fakeBPF) DeleteMapItems(mapID uint32, keys [][]byte) error {
+	m, err := b.mapByID(mapID)
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		if !m.Delete(k) {
+			return fmt.Errorf("map %d, key %v: delete: key does not exist", mapID, k)
+		}
+	}
+	return nil
+}
+
+// DumpMap dump all the context of the map
+func (b *fakeBPF) DumpMap(mapID uint32) ([]bpf.MapItem, error) {
+	m, err := b.mapByID(mapID)
+	if err != nil {
+		return nil, err
+	}
+	return m.Items(), nil
+}
+
+// DumpMapByName dump all the context of the map.
+func (b *fakeBPF) DumpMapByName(mapName string) ([]bpf.MapItem, error) {
+	return b.DumpMap(b.MapIDByName(mapName))
+}
+
+// WaitDetachByBreaker check the bpf's status.
+func (b *fakeBPF) WaitDetachByBreaker(ctx context.Context, cancel context.CancelFunc) {
+}
+
+// perfEventReader reads the scripted records of the event pipe.
+type perfEventReader struct {
+	ctx     context.Context
+	o       *Object
+	mapName string
+}
+
+// ReadInto reads the next record into pdata, the same as the perf event
+// reader. It returns types.ErrExitByCancelCtx when the ctx is canceled, or
+// all the records are read.
+func (r *perfEventReader) ReadInto(pdata any) error {
+	if r.ctx.Err() != nil {
+		return types.ErrExitByCancelCtx
+	}
+
+	record, ok := r.o.next(r.mapName)
+	if !ok {
+		return types.ErrExitByCancelCtx
+	}
+
+	if err := binary.Read(bytes.NewReader(record), binary.NativeEndian, pdata); err != nil {
+		return fmt.Errorf("failed to parse the event: %w: %w", bpf.ErrBadEvent, err)
+	}
+	return nil
+}
+
+// Close the PerfEventReader.
+func (r *perfEventReader) Close() error {
+	return nil
+}
@@ -0,0 +1,310 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bpftest is a fake bpf backend for the tests of the tracers, which
+// loads the bpf objects without root, a kernel or the compiled objects.
+//
+// The objects are scripted by the tests before the tracers load them: the
+// records read from the event pipes, and the items of the maps. The fake
+// records the attach calls, the constants and the closing of every object.
+//
+//	be := bpftest.Install(t)
+//	be.Object("oom.o").Records("oom_perf_events", &event)
+//	err := tracer.Start(ctx) // returns once the records are read.
+package bpftest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"huatuo-bamai/internal/bpf"
+)
+
+// Backend is the fake bpf backend.
+type Backend struct {
+	mu      sync.Mutex
+	objects map[string]*Object
+}
+
+// _ is a type assertion
+var _ bpf.Backend = (*Backend)(nil)
+
+// New creates a fake bpf backend.
+func New() *Backend {
+	return &Backend{objects: make(map[string]*Object)}
+}
+
+// Install installs a fake bpf backend into the bpf manager, which is closed
+// when the test ends.
+func Install(tb testing.TB) *Backend {
+	tb.Helper()
+
+	be := New()
+	if err := bpf.NewManager(&bpf.Option{Backend: be}); err != nil {
+		tb.Fatalf("install the fake bpf backend: %v", err)
+	}
+	tb.Cleanup(bpf.Close)
+	return be
+}
+
+// Object returns the script of the bpf object, e.g. "oom.o", it is created on
+// the first call.
+func (be *Backend) Object(name string) *Object {
+	be.mu.Lock()
+	defer be.mu.Unlock()
+
+	o, ok := be.objects[name]
+	if !ok {
+		o = &Object{
+			name:     name,
+			maps:     make(map[string]*Map),
+			pipes:    make(map[string][][]byte),
+			programs: make(map[string]bool),
+		}
+		be.objects[name] = o
+	}
+	return o
+}
+
+// LoadBpf loads the scripted bpf object.
+func (be *Backend) LoadBpf(bpfName string, consts map[string]any) (bpf.BPF, error) {
+	o := be.Object(bpfName)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.loadErr != nil {
+		return nil, o.loadErr
+	}
+	o.loads++
+	o.consts = consts
+	return newFakeBPF(o), nil
+}
+
+// AttachCall is a call of attaching the programs, the Options are nil for the
+// default programs by Attach.
+type AttachCall struct {
+	Options []bpf.AttachOption
+}
+
+// Object is the script of a bpf object, it is shared by the loads of the
+// object, e.g. when the tracer is restarted.
+type Object struct {
+	name string
+
+	mu       sync.Mutex
+	loadErr  error
+	maps     map[string]*Map
+	pipes    map[string][][]byte
+	programs map[string]bool
+
+	loads    int
+	consts   map[string]any
+	attaches []AttachCall
+	detaches int
+	closes   int
+}
+
+// FailLoad makes the loads of the object fail with the err.
+func (o *Object) FailLoad(err error) *Object {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.loadErr = err
+	return o
+}
+
+// Programs declares the programs of the object, for ProgIDByName.
+func (o *Object) Programs(names ...string) *Object {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, name := range names {
+		o.programs[name] = true
+	}
+	return o
+}
+
+// Map returns the in-memory map of the object, it is created on the first
+// call.
+func (o *Object) Map(name string) *Map {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	m, ok := o.maps[name]
+	if !ok {
+		m = &Map{items: make(map[string][]byte)}
+		o.maps[name] = m
+	}
+	return m
+}
+
+// Records appends the records read from the event pipe of the map, in the
+// order. A record is the raw bytes, or encoded by binary.Write in the native
+// endian, it panics if the record can't be encoded.
+//
+// The reader of the event pipe returns types.ErrExitByCancelCtx once all the
+// records are read, as if the tracer is stopped. No records declares the
+// event pipe only.
+func (o *Object) Records(mapName string, records ...any) *Object {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if _, ok := o.pipes[mapName]; !ok {
+		o.pipes[mapName] = nil
+	}
+	for _, record := range records {
+		o.pipes[mapName] = append(o.pipes[mapName], bytes.Clone(encode(record)))
+	}
+	return o
+}
+
+// Pending returns the records not read yet of the event pipe of the map.
+func (o *Object) Pending(mapName string) int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return len(o.pipes[mapName])
+}
+
+// Loads returns the number of the loads.
+func (o *Object) Loads() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.loads
+}
+
+// Consts returns the constants of the last load.
+func (o *Object) Consts() map[string]any {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.consts
+}
+
+// Attaches returns the attach calls.
+func (o *Object) Attaches() []AttachCall {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return append([]AttachCall(nil), o.attaches...)
+}
+
+// Detaches returns the number of the detach calls.
+func (o *Object) Detaches() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.detaches
+}
+
+// Closed reports whether all the loads are closed.
+func (o *Object) Closed() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.loads > 0 && o.closes >= o.loads
+}
+
+// next pops the next record of the event pipe.
+func (o *Object) next(mapName string) ([]byte, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	records := o.pipes[mapName]
+	if len(records) == 0 {
+		return nil, false
+	}
+	o.pipes[mapName] = records[1:]
+	return records[0], true
+}
+
+// Map is an in-memory bpf map, the keys and the values are the raw bytes, or
+// encoded by binary.Write in the native endian.
+type Map struct {
+	mu    sync.Mutex
+	items map[string][]byte
+}
+
+func encode(v any) []byte {
+	if raw, ok := v.([]byte); ok {
+		return raw
+	}
+
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.NativeEndian, v); err != nil {
+		panic(fmt.Sprintf("bpftest: encode %T: %v", v, err))
+	}
+	return buf.Bytes()
+}
+
+// Put puts the item into the map.
+func (m *Map) Put(key, value any) *Map {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.items[string(encode(key))] = bytes.Clone(encode(value))
+	return m
+}
+
+// Get gets the value of the key.
+func (m *Map) Get(key any) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	value, ok := m.items[string(encode(key))]
+	return bytes.Clone(value), ok
+}
+
+// Delete deletes the key, it reports whether the key is in the map.
+func (m *Map) Delete(key any) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k := string(encode(key))
+	_, ok := m.items[k]
+	delete(m.items, k)
+	return ok
+}
+
+// Items returns the items ordered by the keys.
+func (m *Map) Items() []bpf.MapItem {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]string, 0, len(m.items))
+	for k := range m.items {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	items := make([]bpf.MapItem, 0, len(keys))
+	for _, k := range keys {
+		items = append(items, bpf.MapItem{Key: []byte(k), Value: bytes.Clone(m.items[k])})
+	}
+	return items
+}
+
+// Len returns the number of the items.
+func (m *Map) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.items)
+}
@@ -0,0 +1,146 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bpftest
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+
+	"huatuo-bamai/internal/bpf"
+	"huatuo-bamai/pkg/types"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadBpf(t *testing.T) {
+	be := Install(t)
+	obj := be.Object("test.o").Programs("kprobe_a", "kprobe_b")
+	obj.Map("counts").Put(uint32(1), uint64(10))
+
+	b, err := bpf.LoadBpf("test.o", map[string]any{"filter_pid": uint32(1)})
+	require.NoError(t, err)
+	assert.Equal(t, "test.o", b.Name())
+	assert.Equal(t, 1, obj.Loads())
+	assert.Equal(t, map[string]any{"filter_pid": uint32(1)}, obj.Consts())
+
+	assert.NotZero(t, b.ProgIDByName("kprobe_a"))
+	assert.Zero(t, b.ProgIDByName("kprobe_c"))
+
+	require.NoError(t, b.AttachWithOptions([]bpf.AttachOption{{ProgramName: "kprobe_a", Symbol: "tcp_drop"}}))
+	assert.Error(t, b.AttachWithOptions([]bpf.AttachOption{{ProgramName: "kprobe_c"}}))
+	require.NoError(t, b.Attach())
+	assert.Equal(t, []AttachCall{
+		{Options: []bpf.AttachOption{{ProgramName: "kprobe_a", Symbol: "tcp_drop"}}},
+		{},
+	}, obj.Attaches())
+
+	require.NoError(t, b.Close())
+	assert.True(t, obj.Closed())
+
+	loaded, err := b.Loaded()
+	require.NoError(t, err)
+	assert.False(t, loaded)
+}
+
+func TestMaps(t *testing.T) {
+	be := Install(t)
+	m := be.Object("test.o").Map("counts")
+	m.Put(uint32(2), uint64(20)).Put(uint32(1), uint64(10))
+
+	b, err := bpf.LoadBpf("test.o", nil)
+	require.NoError(t, err)
+	defer b.Close()
+
+	id := b.MapIDByName("counts")
+	require.NotZero(t, id)
+	assert.Zero(t, b.MapIDByName("nonexistent"))
+
+	key := make([]byte, 4)
+	binary.NativeEndian.PutUint32(key, 1)
+	value, err := b.ReadMap(id, key)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(10), binary.NativeEndian.Uint64(value))
+
+	// the nonexistent key is nil, the same as the kernel maps.
+	binary.NativeEndian.PutUint32(key, 3)
+	value, err = b.ReadMap(id, key)
+	require.NoError(t, err)
+	assert.Nil(t, value)
+
+	require.NoError(t, b.WriteMapItems(id, []bpf.MapItem{{Key: key, Value: make([]byte, 8)}}))
+	items, err := b.DumpMapByName("counts")
+	require.NoError(t, err)
+	assert.Len(t, items, 3)
+
+	require.NoError(t, b.DeleteMapItems(id, [][]byte{key}))
+	assert.Error(t, b.DeleteMapItems(id, [][]byte{key}))
+	assert.Equal(t, 2, m.Len())
+
+	_, err = b.DumpMap(100)
+	assert.Error(t, err)
+}
+
+func TestEventPipe(t *testing.T) {
+	type event struct {
+		Pid  uint32
+		Comm [4]byte
+	}
+
+	be := Install(t)
+	obj := be.Object("test.o").Records("events", &event{Pid: 1, Comm: [4]byte{'a'}}, []byte{2, 0, 0, 0, 'b', 0, 0, 0})
+
+	b, err := bpf.LoadBpf("test.o", nil)
+	require.NoError(t, err)
+	defer b.Close()
+
+	reader, err := b.AttachAndEventPipe(context.Background(), "events", 8192)
+	require.NoError(t, err)
+	defer reader.Close()
+
+	var ev event
+	require.NoError(t, reader.ReadInto(&ev))
+	assert.Equal(t, event{Pid: 1, Comm: [4]byte{'a'}}, ev)
+	require.NoError(t, reader.ReadInto(&ev))
+	assert.Equal(t, uint32(2), ev.Pid)
+
+	// all the records are read.
+	assert.ErrorIs(t, reader.ReadInto(&ev), types.ErrExitByCancelCtx)
+	assert.Zero(t, obj.Pending("events"))
+	assert.Len(t, obj.Attaches(), 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	obj.Records("events", &ev)
+	reader, err = b.EventPipeByName(ctx, "events", 8192)
+	require.NoError(t, err)
+	cancel()
+	assert.ErrorIs(t, reader.ReadInto(&ev), types.ErrExitByCancelCtx)
+	assert.Equal(t, 1, obj.Pending("events"))
+
+	_, err = b.EventPipeByName(ctx, "nonexistent", 8192)
+	assert.Error(t, err)
+}
+
+func TestBackendClosed(t *testing.T) {
+	be := New()
+	require.NoError(t, bpf.NewManager(&bpf.Option{Backend: be}))
+	bpf.Close()
+
+	// the default backend loads the object from the file.
+	_, err := bpf.LoadBpf("test.o", nil)
+	assert.Error(t, err)
+	assert.Zero(t, be.Object("test.o").Loads())
+}
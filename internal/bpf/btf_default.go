@@ -0,0 +1,279 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !didi
+
+package bpf
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"huatuo-bamai/internal/log"
+	"huatuo-bamai/pkg/types"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+	"golang.org/x/sys/unix"
+)
+
+// The BTF status of the loaded bpf objects.
+const (
+	// BTFKernel is loaded with the BTF of the kernel, /sys/kernel/btf/vmlinux.
+	BTFKernel = "kernel"
+	// BTFExternal is loaded with the BTF found in the external BTF path.
+	BTFExternal = "external"
+	// BTFMissing is not loaded, the CO-RE object needs the BTF but there
+	// is none for the kernel.
+	BTFMissing = "missing"
+)
+
+// ErrBTFNotFound is returned when there is no BTF for the kernel release in
+// the external BTF path.
+var ErrBTFNotFound = errors.New("btf not found")
+
+var (
+	kernelBTFPath = "/sys/kernel/btf/vmlinux"
+
+	externalBTF = struct {
+		sync.Mutex
+		path    string
+		release string
+		loaded  bool
+		spec    *btf.Spec
+		err     error
+	}{}
+
+	btfStatus = struct {
+		sync.Mutex
+		objects map[string]string
+	}{objects: make(map[string]string)}
+)
+
+// SetExternalBTF sets the directory or the tar(.gz) archive of the BTF files
+// keyed by the kernel release, e.g. the BTFHub archive, for the kernels
+// without /sys/kernel/btf/vmlinux. The file <release>.btf, or the archive
+// <release>.btf.tar.gz holding it, is searched recursively in the directory.
+func SetExternalBTF(path string) {
+	externalBTF.Lock()
+	defer externalBTF.Unlock()
+
+	externalBTF.path = path
+	externalBTF.loaded = false
+	externalBTF.spec = nil
+	externalBTF.err = nil
+}
+
+// BTFStatusOf returns the BTF status of the bpf object, BTFKernel, BTFExternal
+// or BTFMissing, empty if the object is not loaded.
+func BTFStatusOf(object string) string {
+	btfStatus.Lock()
+	defer btfStatus.Unlock()
+
+	return btfStatus.objects[object]
+}
+
+func setBTFStatus(object, status string) {
+	btfStatus.Lock()
+	defer btfStatus.Unlock()
+
+	btfStatus.objects[object] = status
+}
+
+// kernelTypes returns the external BTF for the kernel without the BTF, nil if
+// the kernel has the BTF.
+func kernelTypes() (*btf.Spec, error) {
+	if _, err := os.Stat(kernelBTFPath); err == nil {
+		return nil, nil
+	}
+
+	externalBTF.Lock()
+	defer externalBTF.Unlock()
+
+	if externalBTF.path == "" {
+		return nil, fmt.Errorf("no %s and no external btf: %w", kernelBTFPath, ErrBTFNotFound)
+	}
+
+	release, err := kernelRelease()
+	if err != nil {
+		return nil, err
+	}
+
+	if !externalBTF.loaded || externalBTF.release != release {
+		externalBTF.spec, externalBTF.err = loadExternalBTF(externalBTF.path, release)
+		externalBTF.release = release
+		externalBTF.loaded = true
+
+		if externalBTF.err == nil {
+			log.Infof("loaded external btf of %s from %s", release, externalBTF.path)
+		}
+	}
+
+	return externalBTF.spec, externalBTF.err
+}
+
+// KernelBTF returns the BTF of the running kernel, /sys/kernel/btf/vmlinux or
+// the external one, to resolve the kernel types at runtime.
+func KernelBTF() (*btf.Spec, error) {
+	spec, err := kernelTypes()
+	if err != nil {
+		return nil, err
+	}
+	if spec != nil {
+		return spec, nil
+	}
+	return btf.LoadKernelSpec()
+}
+
+func kernelRelease() (string, error) {
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		return "", fmt.Errorf("uname: %w", err)
+	}
+	return unix.ByteSliceToString(uts.Release[:]), nil
+}
+
+// loadExternalBTF loads the BTF of the kernel release from the directory or
+// the archive.
+func loadExternalBTF(path, release string) (*btf.Spec, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !fi.IsDir() {
+		return loadBTFArchive(path, release)
+	}
+
+	var found string
+	err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if name := d.Name(); name == release+".btf" || isBTFArchive(name, release) {
+			found = p
+			return fs.SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("search btf in %s: %w", path, err)
+	}
+
+	if found == "" {
+		return nil, fmt.Errorf("%s in %s: %w", release, path, ErrBTFNotFound)
+	}
+	if strings.HasSuffix(found, ".btf") {
+		return btf.LoadSpec(found)
+	}
+	return loadBTFArchive(found, release)
+}
+
+func isBTFArchive(name, release string) bool {
+	for _, ext := range []string{".tar", ".tar.gz", ".tgz"} {
+		if name == release+".btf"+ext {
+			return true
+		}
+	}
+	return false
+}
+
+// loadBTFArchive loads <release>.btf in the tar or the tar.gz archive.
+func loadBTFArchive(path, release string) (*btf.Spec, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rd io.Reader = f
+	if strings.HasSuffix(path, ".gz") || strings.HasSuffix(path, ".tgz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("gzip %s: %w", path, err)
+		}
+		defer gz.Close()
+		rd = gz
+	}
+
+	tr := tar.NewReader(rd)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%s in %s: %w", release, path, ErrBTFNotFound)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("tar %s: %w", path, err)
+		}
+
+		if hdr.Typeflag != tar.TypeReg || filepath.Base(hdr.Name) != release+".btf" {
+			continue
+		}
+
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("read %s in %s: %w", hdr.Name, path, err)
+		}
+		return btf.LoadSpecFromReader(bytes.NewReader(data))
+	}
+}
+
+// hasCORERelocations reports whether the programs need the BTF of the kernel.
+func hasCORERelocations(specs *ebpf.CollectionSpec) bool {
+	for _, prog := range specs.Programs {
+		for i := range prog.Instructions {
+			if btf.CORERelocationMetadata(&prog.Instructions[i]) != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// collectionOptions returns the options to load the bpf object, with the
+// external BTF for the kernel without the BTF.
+func collectionOptions(bpfName string, specs *ebpf.CollectionSpec) (ebpf.CollectionOptions, error) {
+	var opts ebpf.CollectionOptions
+
+	if !hasCORERelocations(specs) {
+		return opts, nil
+	}
+
+	spec, err := kernelTypes()
+	if err != nil {
+		setBTFStatus(bpfName, BTFMissing)
+		log.Warnf("skip loading %s without btf: %v", bpfName, err)
+		return opts, fmt.Errorf("%s needs btf: %w: %w", bpfName, err, types.ErrNotSupported)
+	}
+
+	if spec == nil {
+		setBTFStatus(bpfName, BTFKernel)
+	} else {
+		setBTFStatus(bpfName, BTFExternal)
+		opts.Programs.KernelTypes = spec
+	}
+	return opts, nil
+}
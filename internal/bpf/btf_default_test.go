@@ -0,0 +1,159 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !didi
+
+package bpf
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func rawBTF(t *testing.T) []byte {
+	t.Helper()
+
+	b, err := btf.NewBuilder([]btf.Type{&btf.Int{Name: "huatuo_int", Size: 4, Encoding: btf.Signed}})
+	require.NoError(t, err)
+	data, err := b.Marshal(nil, nil)
+	require.NoError(t, err)
+	return data
+}
+
+func writeBTFArchive(t *testing.T, path, name string, data []byte) {
+	t.Helper()
+
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	require.NoError(t, tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Mode:     0o644,
+		Size:     int64(len(data)),
+		Typeflag: tar.TypeReg,
+	}))
+	_, err = tw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+}
+
+func assertHuatuoBTF(t *testing.T, spec *btf.Spec) {
+	t.Helper()
+
+	var typ *btf.Int
+	require.NoError(t, spec.TypeByName("huatuo_int", &typ))
+	assert.Equal(t, uint32(4), typ.Size)
+}
+
+func TestLoadExternalBTF_Dir(t *testing.T) {
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "centos", "7", "x86_64")
+	require.NoError(t, os.MkdirAll(sub, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(sub, "3.10.0-1160.el7.x86_64.btf"), rawBTF(t), 0o644))
+
+	spec, err := loadExternalBTF(dir, "3.10.0-1160.el7.x86_64")
+	require.NoError(t, err)
+	assertHuatuoBTF(t, spec)
+
+	_, err = loadExternalBTF(dir, "4.18.0-305.el8.x86_64")
+	assert.ErrorIs(t, err, ErrBTFNotFound)
+}
+
+func TestLoadExternalBTF_Archive(t *testing.T) {
+	dir := t.TempDir()
+	data := rawBTF(t)
+
+	// the archive of a kernel release in the directory.
+	writeBTFArchive(t, filepath.Join(dir, "3.10.0-1160.el7.x86_64.btf.tar.gz"),
+		"3.10.0-1160.el7.x86_64.btf", data)
+	spec, err := loadExternalBTF(dir, "3.10.0-1160.el7.x86_64")
+	require.NoError(t, err)
+	assertHuatuoBTF(t, spec)
+
+	// the archive of all kernel releases.
+	archive := filepath.Join(t.TempDir(), "btfhub.tgz")
+	writeBTFArchive(t, archive, "centos/8/x86_64/4.18.0-305.el8.x86_64.btf", data)
+	spec, err = loadExternalBTF(archive, "4.18.0-305.el8.x86_64")
+	require.NoError(t, err)
+	assertHuatuoBTF(t, spec)
+
+	_, err = loadExternalBTF(archive, "3.10.0-1160.el7.x86_64")
+	assert.ErrorIs(t, err, ErrBTFNotFound)
+}
+
+func TestKernelTypes(t *testing.T) {
+	release, err := kernelRelease()
+	require.NoError(t, err)
+
+	oldPath := kernelBTFPath
+	defer func() {
+		kernelBTFPath = oldPath
+		SetExternalBTF("")
+	}()
+
+	// the kernel has btf.
+	kernelBTFPath = filepath.Join(t.TempDir(), "vmlinux")
+	require.NoError(t, os.WriteFile(kernelBTFPath, nil, 0o644))
+	spec, err := kernelTypes()
+	require.NoError(t, err)
+	assert.Nil(t, spec)
+
+	// no btf of the kernel, and no external btf.
+	kernelBTFPath = filepath.Join(t.TempDir(), "vmlinux")
+	SetExternalBTF("")
+	_, err = kernelTypes()
+	assert.ErrorIs(t, err, ErrBTFNotFound)
+
+	dir := t.TempDir()
+	SetExternalBTF(dir)
+	_, err = kernelTypes()
+	assert.ErrorIs(t, err, ErrBTFNotFound)
+
+	// the external btf.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, release+".btf"), rawBTF(t), 0o644))
+	SetExternalBTF(dir)
+	spec, err = kernelTypes()
+	require.NoError(t, err)
+	assertHuatuoBTF(t, spec)
+}
+
+func TestCollectionOptions_NoCORE(t *testing.T) {
+	specs := &ebpf.CollectionSpec{
+		Programs: map[string]*ebpf.ProgramSpec{
+			"prog": {
+				Type:         ebpf.XDP,
+				Instructions: asm.Instructions{asm.Mov.Imm(asm.R0, 2), asm.Return()},
+			},
+		},
+	}
+	assert.False(t, hasCORERelocations(specs))
+
+	opts, err := collectionOptions("no_core.o", specs)
+	require.NoError(t, err)
+	assert.Nil(t, opts.Programs.KernelTypes)
+	assert.Empty(t, BTFStatusOf("no_core.o"))
+}
@@ -0,0 +1,73 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bpf
+
+import (
+	"maps"
+	"sync"
+)
+
+// LostEvents is the number of the events lost by the event pipes, when the
+// perf buffers or the ring buffers are full.
+type LostEvents struct {
+	Total  uint64         `json:"total"`
+	PerCPU map[int]uint64 `json:"per_cpu,omitempty"`
+}
+
+var lostEvents = struct {
+	sync.Mutex
+	owners map[string]*LostEvents
+}{owners: make(map[string]*LostEvents)}
+
+func addLostEvents(owner string, cpu int, n uint64) {
+	if n == 0 {
+		return
+	}
+
+	lostEvents.Lock()
+	defer lostEvents.Unlock()
+
+	lost, ok := lostEvents.owners[owner]
+	if !ok {
+		lost = &LostEvents{PerCPU: make(map[int]uint64)}
+		lostEvents.owners[owner] = lost
+	}
+	lost.Total += n
+	lost.PerCPU[cpu] += n
+}
+
+// LostEventsOf returns the events lost by the event pipes of the owner.
+func LostEventsOf(owner string) LostEvents {
+	lostEvents.Lock()
+	defer lostEvents.Unlock()
+
+	lost, ok := lostEvents.owners[owner]
+	if !ok {
+		return LostEvents{}
+	}
+	return LostEvents{Total: lost.Total, PerCPU: maps.Clone(lost.PerCPU)}
+}
+
+// AllLostEvents returns the events lost by the event pipes of all owners.
+func AllLostEvents() map[string]LostEvents {
+	lostEvents.Lock()
+	defer lostEvents.Unlock()
+
+	all := make(map[string]LostEvents, len(lostEvents.owners))
+	for owner, lost := range lostEvents.owners {
+		all[owner] = LostEvents{Total: lost.Total, PerCPU: maps.Clone(lost.PerCPU)}
+	}
+	return all
+}
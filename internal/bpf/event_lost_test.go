@@ -0,0 +1,40 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bpf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLostEvents(t *testing.T) {
+	owner := t.Name()
+
+	assert.Equal(t, LostEvents{}, LostEventsOf(owner))
+
+	addLostEvents(owner, 0, 3)
+	addLostEvents(owner, 2, 4)
+	addLostEvents(owner, 2, 1)
+	addLostEvents(owner, 1, 0)
+
+	lost := LostEventsOf(owner)
+	assert.Equal(t, LostEvents{Total: 8, PerCPU: map[int]uint64{0: 3, 2: 5}}, lost)
+
+	// the snapshot is a copy.
+	lost.PerCPU[0] = 100
+	assert.Equal(t, uint64(3), LostEventsOf(owner).PerCPU[0])
+	assert.Equal(t, uint64(8), AllLostEvents()[owner].Total)
+}
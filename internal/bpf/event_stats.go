@@ -0,0 +1,152 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bpf
+
+import (
+	"runtime"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// EventStats are the events read from the event pipes of an owner, and the
+// cpu time spent by the owner processing them, from the return of a read to
+// the next read.
+type EventStats struct {
+	// Events are the events passed to the owner.
+	Events uint64 `json:"events"`
+	// Sampled are the events dropped by the sampling.
+	Sampled uint64 `json:"sampled"`
+	// ProcessNs is the cpu time of the thread of the owner, the time
+	// blocked, e.g. on the I/O of saving the events, is not counted.
+	ProcessNs uint64 `json:"process_ns"`
+	// Sampling passes one in every Sampling events, 0 or 1 passes all.
+	Sampling uint64 `json:"sampling"`
+}
+
+type eventOwner struct {
+	stats EventStats
+	seq   uint64
+}
+
+var eventStats = struct {
+	sync.Mutex
+	owners map[string]*eventOwner
+}{owners: make(map[string]*eventOwner)}
+
+func eventOwnerOf(owner string) *eventOwner {
+	o, ok := eventStats.owners[owner]
+	if !ok {
+		o = &eventOwner{}
+		eventStats.owners[owner] = o
+	}
+	return o
+}
+
+// SetEventSampling passes one in every n events of the event pipes of the
+// owner, 0 or 1 passes all. The sampling is in the user space only: the events
+// are dropped after read from the perf buffer and never parsed, which saves
+// the processing of the owner, but not the cost of the bpf and the buffer.
+func SetEventSampling(owner string, n uint64) {
+	eventStats.Lock()
+	defer eventStats.Unlock()
+
+	eventOwnerOf(owner).stats.Sampling = n
+}
+
+// EventStatsOf returns the event stats of the owner.
+func EventStatsOf(owner string) EventStats {
+	eventStats.Lock()
+	defer eventStats.Unlock()
+
+	if o, ok := eventStats.owners[owner]; ok {
+		return o.stats
+	}
+	return EventStats{}
+}
+
+// eventPipeStats accounts the events of a reader to the owner.
+type eventPipeStats struct {
+	owner string
+	// cpu is the cpu time of the thread when the last event is returned.
+	cpu      time.Duration
+	returned bool
+	locked   bool
+}
+
+func threadCPUTime() time.Duration {
+	var ru unix.Rusage
+	if err := unix.Getrusage(unix.RUSAGE_THREAD, &ru); err != nil {
+		return 0
+	}
+
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
+
+// begin accounts the cpu time since the last event is returned, it is called
+// when the owner reads the next one.
+func (s *eventPipeStats) begin() {
+	if s.owner == "" || !s.returned {
+		return
+	}
+
+	elapsed := threadCPUTime() - s.cpu
+	s.returned = false
+	if elapsed <= 0 {
+		return
+	}
+
+	eventStats.Lock()
+	defer eventStats.Unlock()
+
+	eventOwnerOf(s.owner).stats.ProcessNs += uint64(elapsed.Nanoseconds())
+}
+
+// pass accounts an event read from the perf buffer, and reports whether it is
+// passed by the sampling.
+func (s *eventPipeStats) pass() bool {
+	if s.owner == "" {
+		return true
+	}
+
+	eventStats.Lock()
+	defer eventStats.Unlock()
+
+	o := eventOwnerOf(s.owner)
+	o.seq++
+	if o.stats.Sampling > 1 && o.seq%o.stats.Sampling != 0 {
+		o.stats.Sampled++
+		return false
+	}
+	o.stats.Events++
+	return true
+}
+
+// end marks the event returned to the owner. The reading goroutine is locked
+// to its thread, so that the cpu time of the thread is of the owner only, the
+// thread exits with the goroutine.
+func (s *eventPipeStats) end() {
+	if s.owner == "" {
+		return
+	}
+
+	if !s.locked {
+		runtime.LockOSThread()
+		s.locked = true
+	}
+	s.cpu = threadCPUTime()
+	s.returned = true
+}
@@ -0,0 +1,77 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bpf
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventPipeStats(t *testing.T) {
+	owner := "event_stats_test"
+	t.Cleanup(func() {
+		eventStats.Lock()
+		delete(eventStats.owners, owner)
+		eventStats.Unlock()
+	})
+
+	// the reading goroutine is locked to its thread by the stats.
+	done := make(chan struct{})
+	s := &eventPipeStats{owner: owner}
+	go func() {
+		defer close(done)
+
+		s.begin()
+		for range 4 {
+			assert.True(t, s.pass())
+		}
+		s.end()
+
+		// the cpu time is counted, but not the time blocked.
+		for begin := threadCPUTime(); threadCPUTime()-begin < 5*time.Millisecond; {
+		}
+		time.Sleep(50 * time.Millisecond)
+		s.begin()
+	}()
+	<-done
+
+	stats := EventStatsOf(owner)
+	assert.Equal(t, uint64(4), stats.Events)
+	assert.Zero(t, stats.Sampled)
+	assert.GreaterOrEqual(t, stats.ProcessNs, uint64(5*time.Millisecond))
+	assert.Less(t, stats.ProcessNs, uint64(50*time.Millisecond))
+
+	// one in every 4 events.
+	SetEventSampling(owner, 4)
+	passed := 0
+	for range 8 {
+		if s.pass() {
+			passed++
+		}
+	}
+	assert.Equal(t, 2, passed)
+
+	stats = EventStatsOf(owner)
+	assert.Equal(t, uint64(6), stats.Events)
+	assert.Equal(t, uint64(6), stats.Sampled)
+	assert.Equal(t, uint64(4), stats.Sampling)
+
+	// no owner, no stats.
+	anonymous := &eventPipeStats{}
+	assert.True(t, anonymous.pass())
+	assert.Equal(t, EventStats{}, EventStatsOf(""))
+}
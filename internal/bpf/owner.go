@@ -0,0 +1,130 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bpf
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+)
+
+type ownerKey struct{}
+
+// WithOwner returns a copy of ctx of the owner, e.g. the tracer name. The bpf
+// objects loaded by LoadBpfContext with it are owned by the owner, and the
+// events lost by the event pipes created with it are accounted to the owner.
+// The name of the bpf object is the owner of the event pipes by default.
+func WithOwner(ctx context.Context, owner string) context.Context {
+	return context.WithValue(ctx, ownerKey{}, owner)
+}
+
+func ownerOf(ctx context.Context, fallback string) string {
+	if owner, ok := ctx.Value(ownerKey{}).(string); ok && owner != "" {
+		return owner
+	}
+	return fallback
+}
+
+// objectOwners are the owners of the bpf objects by the object names, and
+// the objects of the owners, they are recorded when the objects are loaded
+// and removed when the last loads of them are closed.
+var objectOwners = struct {
+	sync.Mutex
+	owners  map[string]*objectOwner
+	objects map[string]map[string]struct{}
+}{
+	owners:  make(map[string]*objectOwner),
+	objects: make(map[string]map[string]struct{}),
+}
+
+type objectOwner struct {
+	owner string
+	// refs are the loads of the object not closed.
+	refs int
+}
+
+func setObjectOwner(ctx context.Context, object string) {
+	owner := ownerOf(ctx, "")
+	if owner == "" {
+		return
+	}
+
+	objectOwners.Lock()
+	defer objectOwners.Unlock()
+
+	o, ok := objectOwners.owners[object]
+	if ok && o.owner == owner {
+		o.refs++
+		return
+	}
+	if ok {
+		deleteOwnerObject(o.owner, object)
+	}
+
+	objectOwners.owners[object] = &objectOwner{owner: owner, refs: 1}
+	objects, ok := objectOwners.objects[owner]
+	if !ok {
+		objects = make(map[string]struct{})
+		objectOwners.objects[owner] = objects
+	}
+	objects[object] = struct{}{}
+}
+
+// removeObjectOwner removes a load of the object, the owner is forgotten with
+// the last one.
+func removeObjectOwner(object string) {
+	objectOwners.Lock()
+	defer objectOwners.Unlock()
+
+	o, ok := objectOwners.owners[object]
+	if !ok {
+		return
+	}
+	if o.refs--; o.refs > 0 {
+		return
+	}
+
+	delete(objectOwners.owners, object)
+	deleteOwnerObject(o.owner, object)
+}
+
+func deleteOwnerObject(owner, object string) {
+	delete(objectOwners.objects[owner], object)
+	if len(objectOwners.objects[owner]) == 0 {
+		delete(objectOwners.objects, owner)
+	}
+}
+
+// ObjectOwner returns the owner of the bpf object, empty if the object is
+// not loaded with an owner.
+func ObjectOwner(object string) string {
+	objectOwners.Lock()
+	defer objectOwners.Unlock()
+
+	if o, ok := objectOwners.owners[object]; ok {
+		return o.owner
+	}
+	return ""
+}
+
+// OwnerObjects returns the bpf objects loaded by the owner in order, empty if
+// the owner loaded none.
+func OwnerObjects(owner string) []string {
+	objectOwners.Lock()
+	defer objectOwners.Unlock()
+
+	return slices.Sorted(maps.Keys(objectOwners.objects[owner]))
+}
@@ -0,0 +1,59 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bpf
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOwner(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, "oom.o", ownerOf(ctx, "oom.o"))
+
+	// the objects loaded without an owner aren't recorded.
+	setObjectOwner(ctx, "test_owner.o")
+	assert.Empty(t, ObjectOwner("test_owner.o"))
+
+	ctx = WithOwner(ctx, "test_owner")
+	assert.Equal(t, "test_owner", ownerOf(ctx, "oom.o"))
+
+	setObjectOwner(ctx, "test_owner.o")
+	assert.Equal(t, "test_owner", ObjectOwner("test_owner.o"))
+	assert.Equal(t, []string{"test_owner.o"}, OwnerObjects("test_owner"))
+	assert.Empty(t, OwnerObjects("other"))
+
+	// all the objects of the owner, forgotten when the last loads close.
+	setObjectOwner(ctx, "test_owner_2.o")
+	setObjectOwner(ctx, "test_owner.o")
+	assert.Equal(t, []string{"test_owner.o", "test_owner_2.o"}, OwnerObjects("test_owner"))
+
+	removeObjectOwner("test_owner_2.o")
+	removeObjectOwner("test_owner.o")
+	assert.Equal(t, []string{"test_owner.o"}, OwnerObjects("test_owner"))
+
+	removeObjectOwner("test_owner.o")
+	assert.Empty(t, ObjectOwner("test_owner.o"))
+	assert.Empty(t, OwnerObjects("test_owner"))
+
+	// the object loaded by another owner.
+	setObjectOwner(ctx, "test_owner.o")
+	setObjectOwner(WithOwner(ctx, "other"), "test_owner.o")
+	assert.Equal(t, "other", ObjectOwner("test_owner.o"))
+	assert.Empty(t, OwnerObjects("test_owner"))
+	removeObjectOwner("test_owner.o")
+}
@@ -14,9 +14,16 @@
 
 package bpf
 
+import "errors"
+
+// ErrBadEvent is the event not parsed into the data, e.g. a short record, the
+// reader is still usable.
+var ErrBadEvent = errors.New("bad event")
+
 // PerfEventReader reads the eBPF perf_event.
 type PerfEventReader interface {
-	// ReadInto reads the eBPF perf_event into pdata.
+	// ReadInto reads the eBPF perf_event into pdata, the errors of the
+	// events not parsed wrap ErrBadEvent.
 	ReadInto(pdata any) error
 
 	// Close the PerfEventReader.
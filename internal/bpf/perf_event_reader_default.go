@@ -36,6 +36,9 @@ type perfEventReader struct {
 	ctx       context.Context
 	rd        *perf.Reader
 	cancelCtx context.CancelFunc
+	// owner is accounted the lost samples and the event stats.
+	owner string
+	stats eventPipeStats
 }
 
 // _ is a type assertion
@@ -49,7 +52,13 @@ func newPerfEventReader(ctx context.Context, array *ebpf.Map, perCPUBuffer int)
 	}
 
 	readerCtx, cancel := context.WithCancel(ctx)
-	return &perfEventReader{ctx: readerCtx, rd: rd, cancelCtx: cancel}, nil
+	return &perfEventReader{
+		ctx:       readerCtx,
+		rd:        rd,
+		cancelCtx: cancel,
+		owner:     ownerOf(ctx, ""),
+		stats:     eventPipeStats{owner: ownerOf(ctx, "")},
+	}, nil
 }
 
 // Close the perfEventReader.
@@ -62,6 +71,8 @@ func (r *perfEventReader) Close() error {
 
 // ReadInto reads the eBPF perf_event into pdata.
 func (r *perfEventReader) ReadInto(pdata any) error {
+	r.stats.begin()
+
 	for {
 		select {
 		case <-r.ctx.Done():
@@ -82,14 +93,20 @@ func (r *perfEventReader) ReadInto(pdata any) error {
 			}
 
 			if record.LostSamples != 0 {
+				addLostEvents(r.owner, record.CPU, record.LostSamples)
+				continue
+			}
+
+			if !r.stats.pass() {
 				continue
 			}
 
 			// parse the event
 			if err := binary.Read(bytes.NewBuffer(record.RawSample), binary.NativeEndian, pdata); err != nil {
-				return fmt.Errorf("failed to parse the event: %w", err)
+				return fmt.Errorf("failed to parse the event: %w: %w", ErrBadEvent, err)
 			}
 
+			r.stats.end()
 			return nil
 		}
 	}
@@ -0,0 +1,348 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !didi
+
+package bpf
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"huatuo-bamai/internal/log"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+)
+
+// The pinned bpf objects in the bpffs:
+//
+//	<PinPath>/<object>/maps/<map>
+//	<PinPath>/<object>/owners/<owner>
+//	<PinPath>/<object>/links/<program>/<link>
+//
+// The data maps are shared by the agents as long as they are compatible, so
+// the histograms and the states survive the restarts and the upgrades. The
+// programs are always loaded by every agent, they are bound to the maps of
+// the event pipes and the data sections of their own. The pinned links keep
+// the programs of the previous agent attached, the new programs take them
+// over by updating the links, or by attaching the new links and closing the
+// pinned ones when the links can't be updated. The owners are the empty dirs
+// of the owners loading the object, see WithOwner, so that the pins of the
+// disabled tracers are found before they load the objects.
+const (
+	pinMapsDir   = "maps"
+	pinOwnersDir = "owners"
+	pinLinksDir  = "links"
+)
+
+var pinning = struct {
+	sync.Mutex
+	path     string
+	handover bool
+	keep     bool
+}{}
+
+// SetPinning pins the maps and the links of the loaded bpf objects under the
+// path in the bpffs, empty to disable the pinning. In the handover mode, the
+// pinned links which can't be updated are closed after the new programs are
+// attached, rather than before.
+func SetPinning(path string, handover bool) {
+	pinning.Lock()
+	defer pinning.Unlock()
+
+	pinning.path = path
+	pinning.handover = handover
+	pinning.keep = false
+}
+
+// KeepPinned keeps the pinned objects when the bpf objects are closed, so
+// that they are reused by the next agent. It is called before the agent
+// exits, otherwise the pins are removed when the tracers are stopped.
+func KeepPinned() {
+	pinning.Lock()
+	defer pinning.Unlock()
+
+	pinning.keep = true
+}
+
+func pinningOption() (path string, handover, keep bool) {
+	pinning.Lock()
+	defer pinning.Unlock()
+
+	return pinning.path, pinning.handover, pinning.keep
+}
+
+// pinObjectName returns the name of the object in the bpffs, which rejects
+// the names with dots.
+func pinObjectName(bpfName string) string {
+	return strings.ReplaceAll(strings.TrimSuffix(bpfName, ".o"), ".", "_")
+}
+
+// RemovePinsOf removes the pinned maps and links of the bpf objects loaded by
+// the owner, e.g. of the disabled tracers. The programs are detached once
+// they are not used by any agent.
+func RemovePinsOf(owner string) error {
+	path, _, _ := pinningOption()
+	if path == "" || owner == "" {
+		return nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil
+	}
+
+	var errs []error
+	for _, e := range entries {
+		dir := filepath.Join(path, e.Name())
+		if _, err := os.Stat(filepath.Join(dir, pinOwnersDir, escapePinName(owner))); err != nil {
+			continue
+		}
+
+		log.Infof("remove the pins of %s: %s", owner, dir)
+		if err := os.RemoveAll(dir); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// escapePinName escapes the link key as a file name in the bpffs.
+func escapePinName(name string) string {
+	return strings.ReplaceAll(url.PathEscape(name), ".", "%2E")
+}
+
+func unescapePinName(name string) (string, error) {
+	return url.PathUnescape(name)
+}
+
+// pinnableMap reports whether the map is pinned, the maps of the event pipes
+// are owned by the readers of the agent and the data sections by the
+// programs of the agent.
+func pinnableMap(spec *ebpf.MapSpec) bool {
+	if strings.HasPrefix(spec.Name, ".") || spec.Name == "" {
+		return false
+	}
+
+	switch spec.Type {
+	case ebpf.PerfEventArray, ebpf.RingBuf, ebpf.ArrayOfMaps, ebpf.HashOfMaps:
+		return false
+	}
+
+	return !strings.HasSuffix(spec.Name, eventPipeRingbufSuffix) &&
+		!strings.HasSuffix(spec.Name, eventPipeLostSuffix)
+}
+
+// bpfPin is the pinned state of a loaded bpf object.
+type bpfPin struct {
+	objDir   string
+	handover bool
+	// links are the pinned links of the previous agent not taken over yet.
+	links map[string]map[string]link.Link
+	// stale are the pinned links replaced by the new links, closed after
+	// attaching in the handover mode.
+	stale []link.Link
+}
+
+// preparePin prepares the pinning of the bpf object, the pinned maps are
+// reused by the collection options.
+func preparePin(pinPath, bpfName string, specs *ebpf.CollectionSpec, opts *ebpf.CollectionOptions) (*bpfPin, error) {
+	_, handover, _ := pinningOption()
+
+	objDir := filepath.Join(pinPath, pinObjectName(bpfName))
+	pin := &bpfPin{
+		objDir:   objDir,
+		handover: handover,
+		links:    make(map[string]map[string]link.Link),
+	}
+
+	pin.removeStaleEntries()
+	if err := os.MkdirAll(filepath.Join(objDir, pinMapsDir), 0o700); err != nil {
+		return nil, fmt.Errorf("mkdir %s: %w", objDir, err)
+	}
+	if owner := ObjectOwner(bpfName); owner != "" {
+		if err := os.MkdirAll(filepath.Join(objDir, pinOwnersDir, escapePinName(owner)), 0o700); err != nil {
+			return nil, fmt.Errorf("mkdir the owner %s: %w", owner, err)
+		}
+	}
+
+	for _, spec := range specs.Maps {
+		if pinnableMap(spec) {
+			spec.Pinning = ebpf.PinByName
+		}
+	}
+
+	opts.Maps.PinPath = filepath.Join(objDir, pinMapsDir)
+	return pin, nil
+}
+
+// reset removes the pinned maps incompatible with the object.
+func (pin *bpfPin) reset() error {
+	if err := os.RemoveAll(filepath.Join(pin.objDir, pinMapsDir)); err != nil {
+		return err
+	}
+	return os.MkdirAll(filepath.Join(pin.objDir, pinMapsDir), 0o700)
+}
+
+// removeStaleEntries removes the pins of the unknown entries, e.g. the
+// programs and the links pinned by the versions of the old agents.
+func (pin *bpfPin) removeStaleEntries() {
+	entries, err := os.ReadDir(pin.objDir)
+	if err != nil {
+		return
+	}
+
+	for _, e := range entries {
+		switch e.Name() {
+		case pinMapsDir, pinOwnersDir, pinLinksDir:
+			continue
+		}
+
+		stale := filepath.Join(pin.objDir, e.Name())
+		if err := os.RemoveAll(stale); err != nil {
+			log.Warnf("remove the stale pins %s: %v", stale, err)
+			continue
+		}
+		log.Infof("removed the stale pins: %s", stale)
+	}
+}
+
+// loadLinks loads the pinned links of the previous agent.
+func (pin *bpfPin) loadLinks() error {
+	dir := filepath.Join(pin.objDir, pinLinksDir)
+
+	progs, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	for _, prog := range progs {
+		files, err := os.ReadDir(filepath.Join(dir, prog.Name()))
+		if err != nil {
+			return err
+		}
+
+		for _, f := range files {
+			key, err := unescapePinName(f.Name())
+			if err != nil {
+				return fmt.Errorf("invalid pinned link %s: %w", f.Name(), err)
+			}
+
+			l, err := link.LoadPinnedLink(filepath.Join(dir, prog.Name(), f.Name()), nil)
+			if err != nil {
+				return fmt.Errorf("load pinned link %s: %w", f.Name(), err)
+			}
+
+			if pin.links[prog.Name()] == nil {
+				pin.links[prog.Name()] = make(map[string]link.Link)
+			}
+			pin.links[prog.Name()][key] = l
+		}
+	}
+
+	return nil
+}
+
+// takeOverLink updates the pinned link of the program to the new program.
+// It returns false if there is no pinned link, or the link can't be updated,
+// e.g. the kprobes and the tracepoints. The link which can't be updated is
+// closed now, or after the new link is attached in the handover mode.
+func (pin *bpfPin) takeOverLink(prog, key string, p *ebpf.Program) (link.Link, bool) {
+	l, ok := pin.links[prog][key]
+	if !ok {
+		return nil, false
+	}
+	delete(pin.links[prog], key)
+
+	err := l.Update(p)
+	if err == nil {
+		return l, true
+	}
+
+	log.Debugf("can't update the pinned link %s of %s: %v", key, prog, err)
+	if pin.handover {
+		pin.stale = append(pin.stale, l)
+	} else {
+		pin.unpinLink(prog, key)
+		l.Close()
+	}
+	return nil, false
+}
+
+// pinLink pins the attached link, the links not supporting the pinning, e.g.
+// the perf event ioctl links of the old kernels, are not taken over.
+func (pin *bpfPin) pinLink(prog, key string, l link.Link) {
+	dir := filepath.Join(pin.objDir, pinLinksDir, prog)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		log.Warnf("mkdir %s: %v", dir, err)
+		return
+	}
+
+	path := filepath.Join(dir, escapePinName(key))
+	_ = os.Remove(path)
+
+	if err := l.Pin(path); err != nil {
+		if !errors.Is(err, ebpf.ErrNotSupported) {
+			log.Warnf("pin link %s: %v", path, err)
+		}
+		return
+	}
+}
+
+// unpinLink removes the pinned link of the program.
+func (pin *bpfPin) unpinLink(prog, key string) {
+	_ = os.Remove(filepath.Join(pin.objDir, pinLinksDir, prog, escapePinName(key)))
+}
+
+// releaseLinks detaches the pinned links of the programs which are not
+// attached again, and the replaced ones.
+func (pin *bpfPin) releaseLinks(progs []string) {
+	for _, prog := range progs {
+		for key, l := range pin.links[prog] {
+			pin.unpinLink(prog, key)
+			l.Close()
+			log.Infof("detach the stale pinned link %s of %s", key, prog)
+		}
+		delete(pin.links, prog)
+	}
+
+	for _, l := range pin.stale {
+		l.Close()
+	}
+	pin.stale = nil
+}
+
+// close closes the pinned objects, and removes them unless they are kept for
+// the next agent.
+func (pin *bpfPin) close(keep bool) {
+	for _, links := range pin.links {
+		for _, l := range links {
+			l.Close()
+		}
+	}
+	pin.links = nil
+	pin.releaseLinks(nil)
+
+	if !keep {
+		if err := os.RemoveAll(pin.objDir); err != nil {
+			log.Warnf("remove the pins %s: %v", pin.objDir, err)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !didi
+
+package bpf
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func mountBpffs(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := unix.Mount("bpf", dir, "bpf", 0, ""); err != nil {
+		t.Skipf("skipping: can't mount bpffs: %v", err)
+	}
+	t.Cleanup(func() { _ = unix.Unmount(dir, 0) })
+	return dir
+}
+
+// loadPinnedMinimal loads the minimal object, another version is the object
+// with a trailing byte.
+func loadPinnedMinimal(t *testing.T, newVersion bool) *defaultBPF {
+	t.Helper()
+
+	objBytes := loadMinimalObjBytes(t)
+	if newVersion {
+		objBytes = append(objBytes, 0)
+	}
+
+	obj, err := LoadBpfFromBytes("test_minimal.elf", objBytes, nil)
+	if errors.Is(err, ebpf.ErrNotSupported) {
+		t.Skipf("skipping: ebpf not supported: %v", err)
+	}
+	require.NoError(t, err)
+
+	b, ok := obj.(*defaultBPF)
+	require.True(t, ok, "expected *defaultBPF, got %T", obj)
+	require.NotNil(t, b.pin)
+	return b
+}
+
+func attachRawTracepoint(t *testing.T, b *defaultBPF) {
+	t.Helper()
+
+	require.NoError(t, b.AttachWithOptions([]AttachOption{
+		{ProgramName: "test_raw_tracepoint", Symbol: "sys_enter"},
+	}))
+}
+
+func counterValue(t *testing.T, b *defaultBPF) uint64 {
+	t.Helper()
+
+	value, err := b.ReadMap(b.MapIDByName("counter_map"), make([]byte, 4))
+	require.NoError(t, err)
+	return binary.NativeEndian.Uint64(value)
+}
+
+// pinnedLinkProgram returns the program id of the pinned link.
+func pinnedLinkProgram(t *testing.T, objDir string) uint32 {
+	t.Helper()
+
+	l, err := link.LoadPinnedLink(filepath.Join(objDir, pinLinksDir, "test_raw_tracepoint", "sys_enter"), nil)
+	require.NoError(t, err)
+	defer l.Close()
+
+	info, err := l.Info()
+	require.NoError(t, err)
+	return uint32(info.Program)
+}
+
+func TestPinning_ReuseAndCleanup(t *testing.T) {
+	pinPath := mountBpffs(t)
+	SetPinning(pinPath, false)
+	defer SetPinning("", false)
+
+	objDir := filepath.Join(pinPath, "test_minimal_elf")
+
+	// the first agent.
+	b := loadPinnedMinimal(t, false)
+	attachRawTracepoint(t, b)
+
+	value := make([]byte, 8)
+	binary.NativeEndian.PutUint64(value, 42)
+	require.NoError(t, b.WriteMapItems(b.MapIDByName("counter_map"), []MapItem{
+		{Key: make([]byte, 4), Value: value},
+	}))
+
+	KeepPinned()
+	require.NoError(t, b.Close())
+
+	assert.FileExists(t, filepath.Join(objDir, pinMapsDir, "counter_map"))
+	assert.NoFileExists(t, filepath.Join(objDir, pinMapsDir, "events"))
+	assert.FileExists(t, filepath.Join(objDir, pinLinksDir, "test_raw_tracepoint", "sys_enter"))
+
+	// the next agent reuses the data maps, and its own programs, bound to
+	// its own event pipes, take over the pinned links.
+	SetPinning(pinPath, false)
+	b = loadPinnedMinimal(t, false)
+	assert.Equal(t, uint64(42), counterValue(t, b))
+	assert.Contains(t, b.pin.links["test_raw_tracepoint"], "sys_enter")
+	attachRawTracepoint(t, b)
+
+	progID := b.ProgIDByName("test_raw_tracepoint")
+	assert.Contains(t, b.programSpecs[progID].links, "sys_enter")
+	assert.Empty(t, b.pin.links["test_raw_tracepoint"])
+	assert.Equal(t, progID, pinnedLinkProgram(t, objDir))
+
+	KeepPinned()
+	require.NoError(t, b.Close())
+
+	// the next agent of another version reuses the maps, and removes the
+	// pins of the old layouts.
+	stale := filepath.Join(objDir, "cf6cec04f91daff8")
+	require.NoError(t, os.MkdirAll(filepath.Join(stale, "progs"), 0o700))
+
+	SetPinning(pinPath, false)
+	b = loadPinnedMinimal(t, true)
+	assert.Equal(t, uint64(42), counterValue(t, b))
+	assert.NoDirExists(t, stale)
+
+	// the pins are removed when the tracer is stopped.
+	require.NoError(t, b.Close())
+	assert.NoDirExists(t, objDir)
+}
+
+func TestPinning_Handover(t *testing.T) {
+	pinPath := mountBpffs(t)
+	SetPinning(pinPath, true)
+	defer SetPinning("", false)
+
+	objDir := filepath.Join(pinPath, "test_minimal_elf")
+
+	old := loadPinnedMinimal(t, false)
+	defer old.Close()
+	attachRawTracepoint(t, old)
+	oldID := old.ProgIDByName("test_raw_tracepoint")
+
+	// the link of the old program is kept until the new one is attached.
+	b := loadPinnedMinimal(t, true)
+	defer b.Close()
+	assert.Equal(t, oldID, pinnedLinkProgram(t, objDir))
+
+	attachRawTracepoint(t, b)
+	assert.Equal(t, b.ProgIDByName("test_raw_tracepoint"), pinnedLinkProgram(t, objDir))
+	assert.Empty(t, b.pin.stale)
+}
+
+func TestRemovePins(t *testing.T) {
+	pinPath := mountBpffs(t)
+	SetPinning(pinPath, false)
+	defer SetPinning("", false)
+
+	setObjectOwner(WithOwner(t.Context(), "minimal"), "test_minimal.elf")
+	b := loadPinnedMinimal(t, false)
+	KeepPinned()
+	require.NoError(t, b.Close())
+
+	objDir := filepath.Join(pinPath, "test_minimal_elf")
+	assert.DirExists(t, filepath.Join(objDir, pinOwnersDir, "minimal"))
+
+	require.NoError(t, RemovePinsOf("other"))
+	assert.DirExists(t, objDir)
+	require.NoError(t, RemovePinsOf("minimal"))
+	assert.NoDirExists(t, objDir)
+}
+
+func TestPinNames(t *testing.T) {
+	for _, key := range []string{"sys_enter", "syscalls/sys_enter_openat", "/usr/lib/libc.so.6:malloc+0@0", "foo.isra.0+0"} {
+		name := escapePinName(key)
+		assert.NotContains(t, name, "/")
+		assert.NotContains(t, name, ".")
+
+		got, err := unescapePinName(name)
+		require.NoError(t, err)
+		assert.Equal(t, key, got)
+	}
+
+	assert.Equal(t, "oom", pinObjectName("oom.o"))
+	assert.Equal(t, "test_minimal_elf", pinObjectName("test_minimal.elf"))
+}
+
+func TestPinnableMap(t *testing.T) {
+	assert.True(t, pinnableMap(&ebpf.MapSpec{Name: "counter_map", Type: ebpf.Array}))
+	assert.False(t, pinnableMap(&ebpf.MapSpec{Name: ".rodata", Type: ebpf.Array}))
+	assert.False(t, pinnableMap(&ebpf.MapSpec{Name: "events", Type: ebpf.PerfEventArray}))
+	assert.False(t, pinnableMap(&ebpf.MapSpec{Name: "events_ringbuf", Type: ebpf.Array}))
+	assert.False(t, pinnableMap(&ebpf.MapSpec{Name: "events_lost", Type: ebpf.PerCPUArray}))
+}
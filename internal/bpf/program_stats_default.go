@@ -0,0 +1,166 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !didi
+
+package bpf
+
+import (
+	"io"
+	"sort"
+	"sync"
+
+	"huatuo-bamai/internal/log"
+
+	"github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
+)
+
+// runtimeStats is the fd of BPF_ENABLE_STATS, the kernel accounts the
+// run_time_ns and run_cnt of the programs until it is closed.
+var runtimeStats struct {
+	sync.Mutex
+	closer io.Closer
+}
+
+// enableRuntimeStats enables the runtime stats of the programs, kernel 5.8+.
+// The stats can also be enabled by the sysctl kernel.bpf_stats_enabled.
+func enableRuntimeStats() {
+	runtimeStats.Lock()
+	defer runtimeStats.Unlock()
+
+	if runtimeStats.closer != nil {
+		return
+	}
+
+	closer, err := ebpf.EnableStats(uint32(unix.BPF_STATS_RUN_TIME))
+	if err != nil {
+		log.Warnf("can't enable the bpf runtime stats: %v", err)
+		return
+	}
+	runtimeStats.closer = closer
+}
+
+func disableRuntimeStats() {
+	runtimeStats.Lock()
+	defer runtimeStats.Unlock()
+
+	if runtimeStats.closer != nil {
+		runtimeStats.closer.Close()
+		runtimeStats.closer = nil
+	}
+}
+
+// RuntimeStatsEnabled reports whether the runtime stats are enabled by the
+// bpf manager.
+func RuntimeStatsEnabled() bool {
+	runtimeStats.Lock()
+	defer runtimeStats.Unlock()
+
+	return runtimeStats.closer != nil
+}
+
+type loadedProgram struct {
+	object string
+	spec   programSpec
+}
+
+// loadedPrograms are the programs of the loaded bpf objects by the program
+// IDs, they are removed when the objects are closed.
+var loadedPrograms = struct {
+	sync.Mutex
+	programs map[uint32]loadedProgram
+}{programs: make(map[uint32]loadedProgram)}
+
+func registerPrograms(b *defaultBPF) {
+	loadedPrograms.Lock()
+	defer loadedPrograms.Unlock()
+
+	for id, p := range b.programSpecs {
+		loadedPrograms.programs[id] = loadedProgram{object: b.name, spec: p}
+	}
+}
+
+func unregisterPrograms(b *defaultBPF) {
+	loadedPrograms.Lock()
+	defer loadedPrograms.Unlock()
+
+	for id, p := range b.programSpecs {
+		if lp, ok := loadedPrograms.programs[id]; ok && lp.spec.bProg == p.bProg {
+			delete(loadedPrograms.programs, id)
+		}
+	}
+}
+
+// programInfo returns the info of the program with the runtime stats.
+func programInfo(id uint32, p programSpec) ProgramInfo {
+	info := ProgramInfo{
+		ID:          id,
+		Name:        p.name,
+		SectionName: p.sectionName,
+	}
+
+	pi, err := p.bProg.Info()
+	if err != nil {
+		return info
+	}
+	if runtime, ok := pi.Runtime(); ok {
+		info.RunTimeNs = uint64(runtime.Nanoseconds())
+	}
+	if count, ok := pi.RunCount(); ok {
+		info.RunCount = count
+	}
+	return info
+}
+
+// ProgramStatsOf returns the programs with the runtime stats of the loaded
+// bpf object, sorted by the program names.
+func ProgramStatsOf(object string) []ProgramInfo {
+	loadedPrograms.Lock()
+	defer loadedPrograms.Unlock()
+
+	var stats []ProgramInfo
+	for id, lp := range loadedPrograms.programs {
+		if lp.object == object {
+			stats = append(stats, programInfo(id, lp.spec))
+		}
+	}
+	sortPrograms(stats)
+	return stats
+}
+
+// AllProgramStats returns the programs with the runtime stats of all loaded
+// bpf objects by the object names.
+func AllProgramStats() map[string][]ProgramInfo {
+	loadedPrograms.Lock()
+	defer loadedPrograms.Unlock()
+
+	all := make(map[string][]ProgramInfo)
+	for id, lp := range loadedPrograms.programs {
+		all[lp.object] = append(all[lp.object], programInfo(id, lp.spec))
+	}
+	for _, stats := range all {
+		sortPrograms(stats)
+	}
+	return all
+}
+
+func sortPrograms(stats []ProgramInfo) {
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Name != stats[j].Name {
+			return stats[i].Name < stats[j].Name
+		}
+		return stats[i].ID < stats[j].ID
+	})
+}
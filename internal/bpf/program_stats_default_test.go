@@ -0,0 +1,106 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !didi
+
+package bpf
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/link"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func statsBPF(t *testing.T, name string) *defaultBPF {
+	t.Helper()
+
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Name:    "stats_prog",
+		License: "GPL",
+		Type:    ebpf.XDP,
+		Instructions: asm.Instructions{
+			asm.Mov.Imm(asm.R0, 2), // XDP_PASS
+			asm.Return(),
+		},
+	})
+	if errors.Is(err, ebpf.ErrNotSupported) {
+		t.Skipf("skipping: ebpf not supported: %v", err)
+	}
+	require.NoError(t, err)
+
+	info, err := prog.Info()
+	require.NoError(t, err)
+	id, ok := info.ID()
+	require.True(t, ok)
+
+	b := &defaultBPF{
+		name: name,
+		programSpecs: map[uint32]programSpec{
+			uint32(id): {
+				name:        "stats_prog",
+				specType:    ebpf.XDP,
+				sectionName: "xdp",
+				bProg:       prog,
+				links:       make(map[string]link.Link),
+			},
+		},
+	}
+	registerPrograms(b)
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+func TestProgramStats(t *testing.T) {
+	enableRuntimeStats()
+	if !RuntimeStatsEnabled() {
+		t.Skip("skipping: BPF_ENABLE_STATS not supported")
+	}
+	defer disableRuntimeStats()
+
+	b := statsBPF(t, "stats_test.o")
+	for _, p := range b.programSpecs {
+		for range 10 {
+			_, err := p.bProg.Run(&ebpf.RunOptions{Data: emptyBpfContext})
+			require.NoError(t, err)
+		}
+	}
+
+	stats := ProgramStatsOf("stats_test.o")
+	require.Len(t, stats, 1)
+	assert.Equal(t, "stats_prog", stats[0].Name)
+	assert.Equal(t, "xdp", stats[0].SectionName)
+	assert.GreaterOrEqual(t, stats[0].RunCount, uint64(10))
+	assert.NotZero(t, stats[0].RunTimeNs)
+
+	assert.Equal(t, stats, AllProgramStats()["stats_test.o"])
+
+	info, err := b.Info()
+	require.NoError(t, err)
+	require.Len(t, info.ProgramsInfo, 1)
+	assert.GreaterOrEqual(t, info.ProgramsInfo[0].RunCount, uint64(10))
+
+	require.NoError(t, b.Close())
+	assert.Empty(t, ProgramStatsOf("stats_test.o"))
+	assert.NotContains(t, AllProgramStats(), "stats_test.o")
+}
+
+func TestProgramStats_Unknown(t *testing.T) {
+	statsBPF(t, "stats_unknown.o")
+	assert.Empty(t, ProgramStatsOf("not_loaded.o"))
+}
@@ -0,0 +1,308 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !didi
+
+package bpf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+	"github.com/cilium/ebpf/features"
+	"golang.org/x/sys/unix"
+)
+
+// The struct bpf_ratelimit, see bpf/include/bpf_ratelimit.h.
+const (
+	ratelimitStruct = "bpf_ratelimit"
+	// ratelimitMapPrefix is the name prefix of the maps declared by
+	// BPF_RATELIMIT_IN_MAP.
+	ratelimitMapPrefix = "bpf_rlimit_"
+
+	ratelimitInterval     = 0
+	ratelimitBurst        = 16
+	ratelimitTotalEvents  = 48
+	ratelimitTotalNmissed = 56
+	ratelimitSize         = 72
+)
+
+// RateLimit is a struct bpf_ratelimit of a bpf object, the events over the
+// burst in the interval are dropped by the bpf.
+type RateLimit struct {
+	Name string `json:"name"`
+	// Interval is in seconds.
+	Interval     uint64 `json:"interval"`
+	Burst        uint64 `json:"burst"`
+	TotalEvents  uint64 `json:"total_events"`
+	TotalNmissed uint64 `json:"total_nmissed"`
+	// Overridden is set when the burst is set by SetRateLimitBurst.
+	Overridden bool `json:"overridden"`
+}
+
+// ratelimit is the location of a struct bpf_ratelimit, in a map declared by
+// BPF_RATELIMIT_IN_MAP, or a global variable declared by BPF_RATELIMIT in the
+// .data or .bss map. The maps are mmapped to set the interval and the burst,
+// the other fields and variables are updated by the bpf meanwhile.
+type ratelimit struct {
+	name   string
+	bMap   *ebpf.Map
+	offset int
+	mem    []byte
+
+	// the interval and the burst before overridden.
+	overridden              bool
+	origInterval, origBurst uint64
+}
+
+// loadedRatelimits are the ratelimits of the loaded bpf objects, they are
+// removed when the objects are closed.
+var loadedRatelimits = struct {
+	sync.Mutex
+	objects map[string][]*ratelimit
+}{objects: make(map[string][]*ratelimit)}
+
+func isRatelimit(typ btf.Type) bool {
+	s, ok := btf.UnderlyingType(typ).(*btf.Struct)
+	return ok && s.Name == ratelimitStruct && s.Size == ratelimitSize
+}
+
+// isRatelimitMap reports whether the map holds ratelimits, see findRatelimits.
+func isRatelimitMap(spec *ebpf.MapSpec) bool {
+	if spec.Type != ebpf.Array || spec.KeySize != 4 {
+		return false
+	}
+
+	if strings.HasPrefix(spec.Name, ratelimitMapPrefix) && spec.ValueSize == ratelimitSize {
+		return true
+	}
+
+	ds, ok := spec.Value.(*btf.Datasec)
+	if !ok || (spec.Name != ".data" && spec.Name != ".bss") {
+		return false
+	}
+	for _, v := range ds.Vars {
+		if vv, ok := v.Type.(*btf.Var); ok && isRatelimit(vv.Type) {
+			return true
+		}
+	}
+	return false
+}
+
+// setupRatelimits makes the maps holding the ratelimits mmapable, on the
+// kernels >= 5.5. Otherwise the ratelimits can't be set, see setBurst.
+func setupRatelimits(specs *ebpf.CollectionSpec) {
+	if features.HaveMapFlag(features.BPF_F_MMAPABLE) != nil {
+		return
+	}
+
+	for _, spec := range specs.Maps {
+		if isRatelimitMap(spec) {
+			spec.Flags |= unix.BPF_F_MMAPABLE
+		}
+	}
+}
+
+// findRatelimits finds the ratelimits in the maps of the collection spec, the
+// maps are the loaded ones by the names.
+func findRatelimits(specs *ebpf.CollectionSpec, maps map[string]*ebpf.Map) []*ratelimit {
+	var rls []*ratelimit
+
+	for _, spec := range specs.Maps {
+		name := spec.Name
+		m, ok := maps[name]
+		if !ok || !isRatelimitMap(spec) {
+			continue
+		}
+
+		if strings.HasPrefix(name, ratelimitMapPrefix) {
+			rls = append(rls, &ratelimit{name: strings.TrimPrefix(name, ratelimitMapPrefix), bMap: m})
+			continue
+		}
+
+		for _, v := range spec.Value.(*btf.Datasec).Vars {
+			if vv, ok := v.Type.(*btf.Var); ok && isRatelimit(vv.Type) {
+				rls = append(rls, &ratelimit{name: vv.Name, bMap: m, offset: int(v.Offset)})
+			}
+		}
+	}
+
+	sort.Slice(rls, func(i, j int) bool { return rls[i].name < rls[j].name })
+	return rls
+}
+
+func registerRatelimits(object string, rls []*ratelimit) {
+	if len(rls) == 0 {
+		return
+	}
+
+	loadedRatelimits.Lock()
+	defer loadedRatelimits.Unlock()
+
+	loadedRatelimits.objects[object] = rls
+}
+
+func unregisterRatelimits(object string, maps map[uint32]mapSpec) {
+	loadedRatelimits.Lock()
+	defer loadedRatelimits.Unlock()
+
+	rls, ok := loadedRatelimits.objects[object]
+	if !ok || len(rls) == 0 {
+		return
+	}
+	for _, m := range maps {
+		if m.bMap == rls[0].bMap {
+			for _, r := range rls {
+				r.munmap()
+			}
+			delete(loadedRatelimits.objects, object)
+			return
+		}
+	}
+}
+
+// mmap maps the map holding the ratelimit, the map is mmapable only if set up
+// by setupRatelimits.
+func (r *ratelimit) mmap() ([]byte, error) {
+	if r.mem != nil {
+		return r.mem, nil
+	}
+
+	size := int(r.bMap.ValueSize() * r.bMap.MaxEntries())
+	pageSize := os.Getpagesize()
+	mem, err := unix.Mmap(r.bMap.FD(), 0, (size+pageSize-1)/pageSize*pageSize,
+		unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("mmap ratelimit %s: %w", r.name, err)
+	}
+	if size < r.offset+ratelimitSize {
+		_ = unix.Munmap(mem)
+		return nil, fmt.Errorf("ratelimit %s: value size %d", r.name, size)
+	}
+
+	r.mem = mem
+	return mem, nil
+}
+
+func (r *ratelimit) munmap() {
+	if r.mem != nil {
+		_ = unix.Munmap(r.mem)
+		r.mem = nil
+	}
+}
+
+// field returns the field of the mmapped ratelimit, the fields are aligned
+// u64s updated atomically.
+func (r *ratelimit) field(mem []byte, offset int) *uint64 {
+	return (*uint64)(unsafe.Pointer(&mem[r.offset+offset]))
+}
+
+// read reads the value of the map holding the ratelimit.
+func (r *ratelimit) read() ([]byte, error) {
+	var value []byte
+	if err := r.bMap.Lookup(uint32(0), &value); err != nil {
+		return nil, fmt.Errorf("lookup ratelimit %s: %w", r.name, err)
+	}
+	if len(value) < r.offset+ratelimitSize {
+		return nil, fmt.Errorf("ratelimit %s: value size %d", r.name, len(value))
+	}
+	return value, nil
+}
+
+func (r *ratelimit) info() (RateLimit, error) {
+	value, err := r.read()
+	if err != nil {
+		return RateLimit{}, err
+	}
+
+	rl := value[r.offset:]
+	return RateLimit{
+		Name:         r.name,
+		Interval:     binary.NativeEndian.Uint64(rl[ratelimitInterval:]),
+		Burst:        binary.NativeEndian.Uint64(rl[ratelimitBurst:]),
+		TotalEvents:  binary.NativeEndian.Uint64(rl[ratelimitTotalEvents:]),
+		TotalNmissed: binary.NativeEndian.Uint64(rl[ratelimitTotalNmissed:]),
+		Overridden:   r.overridden,
+	}, nil
+}
+
+// setBurst sets the burst in every second, zero restores the original ones.
+// The ratelimit in the map is initialized by the bpf on the first event if
+// the interval is zero, so the restored zero interval initializes it again.
+// Only the interval and the burst are written, through the mmapped map.
+func (r *ratelimit) setBurst(burst uint64) error {
+	mem, err := r.mmap()
+	if err != nil {
+		return err
+	}
+
+	intervalField := r.field(mem, ratelimitInterval)
+	burstField := r.field(mem, ratelimitBurst)
+	if !r.overridden {
+		r.origInterval = atomic.LoadUint64(intervalField)
+		r.origBurst = atomic.LoadUint64(burstField)
+	}
+
+	interval := uint64(1)
+	if burst == 0 {
+		interval, burst = r.origInterval, r.origBurst
+	}
+	atomic.StoreUint64(burstField, burst)
+	atomic.StoreUint64(intervalField, interval)
+
+	r.overridden = burst != r.origBurst || interval != r.origInterval
+	return nil
+}
+
+// RateLimitsOf returns the ratelimits of the loaded bpf object.
+func RateLimitsOf(object string) []RateLimit {
+	loadedRatelimits.Lock()
+	defer loadedRatelimits.Unlock()
+
+	var rls []RateLimit
+	for _, r := range loadedRatelimits.objects[object] {
+		if rl, err := r.info(); err == nil {
+			rls = append(rls, rl)
+		}
+	}
+	return rls
+}
+
+// SetRateLimitBurst sets the burst in every second of all the ratelimits of
+// the loaded bpf object, zero restores the original ones. It returns the
+// number of the ratelimits set.
+func SetRateLimitBurst(object string, burst uint64) (int, error) {
+	loadedRatelimits.Lock()
+	defer loadedRatelimits.Unlock()
+
+	var errs []error
+	n := 0
+	for _, r := range loadedRatelimits.objects[object] {
+		if err := r.setBurst(burst); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		n++
+	}
+	return n, errors.Join(errs...)
+}
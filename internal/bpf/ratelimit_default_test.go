@@ -0,0 +1,137 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !didi
+
+package bpf
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// ratelimitSpecs returns the specs of BPF_RATELIMIT_IN_MAP(rate, 1, 100, 0),
+// and BPF_RATELIMIT(drop, 1, 10) after an int in .data.
+func ratelimitSpecs() *ebpf.CollectionSpec {
+	u64 := &btf.Int{Name: "uint64_t", Size: 8}
+	i32 := &btf.Int{Name: "int", Size: 4, Encoding: btf.Signed}
+
+	var members []btf.Member
+	for i, name := range []string{
+		"interval", "begin", "burst", "max_burst", "events", "nmissed",
+		"total_events", "total_nmissed", "total_interval",
+	} {
+		members = append(members, btf.Member{Name: name, Type: u64, Offset: btf.Bits(i * 64)})
+	}
+	rl := &btf.Struct{Name: ratelimitStruct, Size: ratelimitSize, Members: members}
+
+	data := &btf.Datasec{Name: ".data", Size: 8 + ratelimitSize, Vars: []btf.VarSecinfo{
+		{Type: &btf.Var{Name: "debug", Type: i32}, Offset: 0, Size: 4},
+		{Type: &btf.Var{Name: "drop", Type: rl}, Offset: 8, Size: ratelimitSize},
+	}}
+
+	return &ebpf.CollectionSpec{Maps: map[string]*ebpf.MapSpec{
+		"bpf_rlimit_rate": {Name: "bpf_rlimit_rate", Type: ebpf.Array, KeySize: 4, ValueSize: ratelimitSize, MaxEntries: 1},
+		".data":           {Name: ".data", Type: ebpf.Array, KeySize: 4, ValueSize: 8 + ratelimitSize, MaxEntries: 1, Value: data},
+		"events":          {Name: "events", Type: ebpf.PerfEventArray},
+	}}
+}
+
+func newRatelimitMap(t *testing.T, valueSize uint32, value []byte) *ebpf.Map {
+	t.Helper()
+
+	m, err := ebpf.NewMap(&ebpf.MapSpec{
+		Type: ebpf.Array, KeySize: 4, ValueSize: valueSize, MaxEntries: 1, Flags: unix.BPF_F_MMAPABLE,
+	})
+	if errors.Is(err, ebpf.ErrNotSupported) {
+		t.Skipf("skipping: ebpf not supported: %v", err)
+	}
+	require.NoError(t, err)
+	t.Cleanup(func() { m.Close() })
+
+	require.NoError(t, m.Update(uint32(0), value, ebpf.UpdateExist))
+	return m
+}
+
+func TestRateLimits(t *testing.T) {
+	order := binary.NativeEndian
+
+	// the map is initialized by the bpf on the first event.
+	inMap := newRatelimitMap(t, ratelimitSize, make([]byte, ratelimitSize))
+
+	value := make([]byte, 8+ratelimitSize)
+	order.PutUint32(value, 1)
+	order.PutUint64(value[8+ratelimitInterval:], 1)
+	order.PutUint64(value[8+ratelimitBurst:], 10)
+	order.PutUint64(value[8+ratelimitTotalNmissed:], 3)
+	data := newRatelimitMap(t, 8+ratelimitSize, value)
+
+	rls := findRatelimits(ratelimitSpecs(), map[string]*ebpf.Map{
+		"bpf_rlimit_rate": inMap,
+		".data":           data,
+	})
+	require.Len(t, rls, 2)
+
+	object := "ratelimit_test.o"
+	registerRatelimits(object, rls)
+	t.Cleanup(func() {
+		unregisterRatelimits(object, map[uint32]mapSpec{1: {bMap: data}})
+		assert.Empty(t, RateLimitsOf(object))
+	})
+
+	assert.Equal(t, []RateLimit{
+		{Name: "drop", Interval: 1, Burst: 10, TotalNmissed: 3},
+		{Name: "rate"},
+	}, RateLimitsOf(object))
+
+	n, err := SetRateLimitBurst(object, 5)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []RateLimit{
+		{Name: "drop", Interval: 1, Burst: 5, TotalNmissed: 3, Overridden: true},
+		{Name: "rate", Interval: 1, Burst: 5, Overridden: true},
+	}, RateLimitsOf(object))
+
+	// the other variables are untouched.
+	var got []byte
+	require.NoError(t, data.Lookup(uint32(0), &got))
+	assert.Equal(t, uint32(1), order.Uint32(got))
+
+	// the bpf updates the other variables and the counters meanwhile, only
+	// the interval and the burst are written.
+	order.PutUint32(got, 2)
+	order.PutUint64(got[8+ratelimitTotalNmissed:], 7)
+	require.NoError(t, data.Update(uint32(0), got, ebpf.UpdateExist))
+
+	_, err = SetRateLimitBurst(object, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []RateLimit{
+		{Name: "drop", Interval: 1, Burst: 10, TotalNmissed: 7},
+		{Name: "rate"},
+	}, RateLimitsOf(object))
+
+	require.NoError(t, data.Lookup(uint32(0), &got))
+	assert.Equal(t, uint32(2), order.Uint32(got))
+
+	n, err = SetRateLimitBurst("nonexistent.o", 5)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+}
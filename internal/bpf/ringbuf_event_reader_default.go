@@ -0,0 +1,207 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !didi
+
+package bpf
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"huatuo-bamai/pkg/types"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/ringbuf"
+	"github.com/pkg/errors"
+)
+
+// eventPipeRingbufConst is the constant of the bpf declared by
+// BPF_EVENT_PIPE, which switches the event output to the ring buffers,
+// see bpf/include/bpf_event_pipe.h.
+const eventPipeRingbufConst = "event_pipe_ringbuf"
+
+const (
+	// eventPipeRingbufSuffix is the name suffix of the ring buffer declared
+	// along with the perf event array by BPF_EVENT_PIPE.
+	eventPipeRingbufSuffix = "_ringbuf"
+	// eventPipeLostSuffix is the name suffix of the per-cpu counters of the
+	// events dropped by the full ring buffer.
+	eventPipeLostSuffix = "_lost"
+	// ringbufLostInterval is the interval of reading the lost counters.
+	ringbufLostInterval = time.Second
+)
+
+// ringbufSupported returns whether the kernel supports BPF_MAP_TYPE_RINGBUF,
+// since 5.8.
+var ringbufSupported = sync.OnceValue(func() bool {
+	return ProgramProbe(Kprobe, FnRingbufOutput) == nil
+})
+
+// ringbufEventReader reads the eBPF ring buffer.
+type ringbufEventReader struct {
+	ctx       context.Context
+	rd        *ringbuf.Reader
+	cancelCtx context.CancelFunc
+
+	// lost is the per-cpu counters of the events dropped by the bpf, the
+	// ring buffer has no lost records as the perf buffers.
+	lost       *ebpf.Map
+	lastLost   []uint64
+	lastLostAt time.Time
+	owner      string
+	stats      eventPipeStats
+}
+
+// _ is a type assertion
+var _ PerfEventReader = (*ringbufEventReader)(nil)
+
+// newRingbufEventReader creates a new ringbufEventReader, lost is the per-cpu
+// counters of the dropped events, nil if there is none.
+func newRingbufEventReader(ctx context.Context, ring, lost *ebpf.Map) (PerfEventReader, error) {
+	rd, err := ringbuf.NewReader(ring)
+	if err != nil {
+		return nil, fmt.Errorf("can't create the ringbuf reader: %w", err)
+	}
+
+	readerCtx, cancel := context.WithCancel(ctx)
+	return &ringbufEventReader{
+		ctx:       readerCtx,
+		rd:        rd,
+		cancelCtx: cancel,
+		lost:      lost,
+		owner:     ownerOf(ctx, ""),
+		stats:     eventPipeStats{owner: ownerOf(ctx, "")},
+	}, nil
+}
+
+// updateLost accounts the events dropped since the last time.
+func (r *ringbufEventReader) updateLost() {
+	if r.lost == nil || time.Since(r.lastLostAt) < ringbufLostInterval {
+		return
+	}
+	r.lastLostAt = time.Now()
+
+	var counts []uint64
+	if err := r.lost.Lookup(uint32(0), &counts); err != nil {
+		return
+	}
+
+	for cpu, count := range counts {
+		var last uint64
+		if cpu < len(r.lastLost) {
+			last = r.lastLost[cpu]
+		}
+		if count > last {
+			addLostEvents(r.owner, cpu, count-last)
+		}
+	}
+	r.lastLost = counts
+}
+
+// Close the ringbufEventReader.
+func (r *ringbufEventReader) Close() error {
+	r.cancelCtx()
+	r.rd.Close()
+
+	return nil
+}
+
+// ReadInto reads the eBPF ring buffer record into pdata.
+func (r *ringbufEventReader) ReadInto(pdata any) error {
+	r.stats.begin()
+
+	for {
+		select {
+		case <-r.ctx.Done():
+			return types.ErrExitByCancelCtx
+		default:
+			r.updateLost()
+
+			// set the poll deadline 100ms
+			r.rd.SetDeadline(time.Now().Add(100 * time.Millisecond))
+
+			// read the event
+			record, err := r.rd.Read()
+			if err != nil {
+				if errors.Is(err, ringbuf.ErrClosed) { // Close
+					return fmt.Errorf("ringbufEventReader is closed: %w", types.ErrExitByCancelCtx)
+				} else if errors.Is(err, os.ErrDeadlineExceeded) { // poll deadline
+					continue
+				}
+				return fmt.Errorf("failed to read the event: %w", err)
+			}
+
+			if !r.stats.pass() {
+				continue
+			}
+
+			// parse the event
+			if err := binary.Read(bytes.NewBuffer(record.RawSample), binary.NativeEndian, pdata); err != nil {
+				return fmt.Errorf("failed to parse the event: %w: %w", ErrBadEvent, err)
+			}
+
+			r.stats.end()
+			return nil
+		}
+	}
+}
+
+// setupEventPipes selects the ring buffers or the perf event arrays of the
+// event pipes declared by BPF_EVENT_PIPE. The ring buffers are used if the
+// kernel supports them, otherwise they are replaced by the smallest arrays,
+// which are never written as the bpf code of them is eliminated as dead by
+// the verifier.
+func setupEventPipes(specs *ebpf.CollectionSpec, consts map[string]any) map[string]any {
+	var rings []*ebpf.MapSpec
+
+	for name, spec := range specs.Maps {
+		if spec.Type != ebpf.RingBuf || !strings.HasSuffix(name, eventPipeRingbufSuffix) {
+			continue
+		}
+
+		pipe, ok := specs.Maps[strings.TrimSuffix(name, eventPipeRingbufSuffix)]
+		if !ok || pipe.Type != ebpf.PerfEventArray {
+			continue
+		}
+		rings = append(rings, spec)
+	}
+
+	if len(rings) == 0 {
+		return consts
+	}
+
+	if ringbufSupported() {
+		withRingbuf := make(map[string]any, len(consts)+1)
+		for k, v := range consts {
+			withRingbuf[k] = v
+		}
+		withRingbuf[eventPipeRingbufConst] = true
+		return withRingbuf
+	}
+
+	for _, spec := range rings {
+		spec.Type = ebpf.Array
+		spec.KeySize = 4
+		spec.ValueSize = 4
+		spec.MaxEntries = 1
+	}
+	return consts
+}
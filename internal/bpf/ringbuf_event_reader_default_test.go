@@ -0,0 +1,219 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !didi
+
+package bpf
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	testutils "huatuo-bamai/internal/testing"
+	"huatuo-bamai/pkg/types"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRingbufEventReader_ReadInto(t *testing.T) {
+	ring := ringbufMap(t)
+
+	reader, err := newRingbufEventReader(t.Context(), ring, nil)
+	require.NoError(t, err)
+	defer reader.Close()
+
+	prog := outputRingbufProg(t, ring, 0x1122334455667788)
+	ret, _, err := prog.Test(emptyBpfContext)
+	if errors.Is(err, ebpf.ErrNotSupported) {
+		t.Skipf("skipping: ebpf not supported: %v", err)
+	}
+	require.NoError(t, err)
+	require.Zero(t, ret)
+
+	var out uint64
+	require.NoError(t, reader.ReadInto(&out))
+	assert.Equal(t, uint64(0x1122334455667788), out)
+}
+
+func TestRingbufEventReader_Close(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	reader, err := newRingbufEventReader(ctx, ringbufMap(t), nil)
+	require.NoError(t, err)
+
+	errCh := make(chan error, 1)
+	go func() {
+		var data uint64
+		errCh <- reader.ReadInto(&data)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, reader.Close())
+
+	select {
+	case err := <-errCh:
+		assert.ErrorIs(t, err, types.ErrExitByCancelCtx)
+	case <-time.After(time.Second):
+		t.Fatal("ReadInto() timed out waiting for Close()")
+	}
+}
+
+func TestEventPipe_Ringbuf(t *testing.T) {
+	ring := ringbufMap(t)
+	perfArray := perfEventArray(t)
+
+	b := &defaultBPF{
+		mapSpecs: map[uint32]mapSpec{
+			1: {name: "events", bMap: perfArray},
+			2: {name: "events" + eventPipeRingbufSuffix, bMap: ring},
+			3: {name: "ring", bMap: ring},
+		},
+		mapName2IDs: map[string]uint32{
+			"events":                          1,
+			"events" + eventPipeRingbufSuffix: 2,
+			"ring":                            3,
+		},
+	}
+
+	for _, name := range []string{"events", "ring"} {
+		reader, err := b.EventPipeByName(t.Context(), name, 4096)
+		require.NoError(t, err)
+		assert.IsType(t, &ringbufEventReader{}, reader, name)
+		reader.Close()
+	}
+
+	// the perf event array without the ring buffer.
+	b.mapSpecs[2] = mapSpec{name: "events" + eventPipeRingbufSuffix, bMap: perfEventArray(t)}
+	reader, err := b.EventPipeByName(t.Context(), "events", 4096)
+	require.NoError(t, err)
+	assert.IsType(t, &perfEventReader{}, reader)
+	reader.Close()
+
+	_, err = b.EventPipeByName(t.Context(), "missing", 4096)
+	assert.Error(t, err)
+}
+
+func TestSetupEventPipes(t *testing.T) {
+	newSpecs := func() *ebpf.CollectionSpec {
+		return &ebpf.CollectionSpec{
+			Maps: map[string]*ebpf.MapSpec{
+				"events":         {Name: "events", Type: ebpf.PerfEventArray},
+				"events_ringbuf": {Name: "events_ringbuf", Type: ebpf.RingBuf, MaxEntries: 4096},
+				"other_ringbuf":  {Name: "other_ringbuf", Type: ebpf.RingBuf, MaxEntries: 4096},
+			},
+		}
+	}
+
+	consts := map[string]any{"threshold": uint64(1)}
+	specs := newSpecs()
+	got := setupEventPipes(specs, consts)
+
+	if ringbufSupported() {
+		assert.Equal(t, map[string]any{"threshold": uint64(1), eventPipeRingbufConst: true}, got)
+		assert.Len(t, consts, 1, "the consts of the caller are not changed")
+		assert.Equal(t, ebpf.RingBuf, specs.Maps["events_ringbuf"].Type)
+	} else {
+		assert.Equal(t, consts, got)
+		assert.Equal(t, ebpf.Array, specs.Maps["events_ringbuf"].Type)
+	}
+	// not an event pipe
+	assert.Equal(t, ebpf.RingBuf, specs.Maps["other_ringbuf"].Type)
+
+	specs = &ebpf.CollectionSpec{Maps: map[string]*ebpf.MapSpec{
+		"events": {Name: "events", Type: ebpf.PerfEventArray},
+	}}
+	assert.Nil(t, setupEventPipes(specs, nil))
+}
+
+func ringbufMap(tb testing.TB) *ebpf.Map {
+	tb.Helper()
+
+	// Requires at least 5.8 (457f44363a88 "bpf: Implement BPF ring buffer and verifier support for it")
+	testutils.SkipOnOldKernel(tb, "5.8", "BPF ring buffer")
+
+	m, err := ebpf.NewMap(&ebpf.MapSpec{
+		Type:       ebpf.RingBuf,
+		MaxEntries: 4096,
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { m.Close() })
+	return m
+}
+
+// outputRingbufProg creates an eBPF program which outputs the value to the
+// ring buffer by bpf_ringbuf_output.
+func outputRingbufProg(tb testing.TB, ring *ebpf.Map, value int64) *ebpf.Program {
+	tb.Helper()
+
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		License: "GPL",
+		Type:    ebpf.XDP,
+		Instructions: asm.Instructions{
+			asm.LoadImm(asm.R0, value, asm.DWord),
+			asm.StoreMem(asm.RFP, -8, asm.R0, asm.DWord),
+			asm.LoadMapPtr(asm.R1, ring.FD()),
+			asm.Mov.Reg(asm.R2, asm.RFP),
+			asm.Add.Imm(asm.R2, -8),
+			asm.Mov.Imm(asm.R3, 8),
+			asm.Mov.Imm(asm.R4, 0),
+			asm.FnRingbufOutput.Call(),
+			asm.Mov.Imm(asm.R0, 0),
+			asm.Return(),
+		},
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { prog.Close() })
+	return prog
+}
+
+func TestRingbufEventReader_Lost(t *testing.T) {
+	lost, err := ebpf.NewMap(&ebpf.MapSpec{
+		Type:       ebpf.PerCPUArray,
+		KeySize:    4,
+		ValueSize:  8,
+		MaxEntries: 1,
+	})
+	require.NoError(t, err)
+	defer lost.Close()
+
+	ctx := WithOwner(t.Context(), t.Name())
+	reader, err := newRingbufEventReader(ctx, ringbufMap(t), lost)
+	require.NoError(t, err)
+	defer reader.Close()
+
+	r := reader.(*ringbufEventReader)
+	counts := make([]uint64, ebpf.MustPossibleCPU())
+	counts[0] = 3
+	require.NoError(t, lost.Put(uint32(0), counts))
+
+	r.updateLost()
+	assert.Equal(t, LostEvents{Total: 3, PerCPU: map[int]uint64{0: 3}}, LostEventsOf(t.Name()))
+
+	// only the new lost events are accounted.
+	counts[0] = 5
+	require.NoError(t, lost.Put(uint32(0), counts))
+	r.lastLostAt = time.Time{}
+	r.updateLost()
+	assert.Equal(t, LostEvents{Total: 5, PerCPU: map[int]uint64{0: 5}}, LostEventsOf(t.Name()))
+}
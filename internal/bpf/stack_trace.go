@@ -0,0 +1,287 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bpf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"huatuo-bamai/internal/log"
+)
+
+const (
+	// MaxStackDepth is the max depth of the stacks, the same as the
+	// kernel.perf_event_max_stack sysctl default.
+	MaxStackDepth = 127
+
+	// stackAgingInterval is the interval of deleting the stale stacks of
+	// the aging StackTraces, see EnableAging.
+	stackAgingInterval = time.Minute
+	// maxCachedStacks bounds the resolved stacks, the cache is dropped and
+	// the stacks are resolved again when it is full.
+	maxCachedStacks = 4096
+)
+
+// stackDepth is the depth of the BPF_MAP_TYPE_STACK_TRACE maps, the value
+// size of the maps is set to it on loading.
+var stackDepth = MaxStackDepth
+
+// ErrStackNotFound is returned when the stack id is invalid, e.g. the bpf
+// failed to get the stack id, or the stack is deleted.
+var ErrStackNotFound = errors.New("stack not found")
+
+// stackErrors are the stacks lost by the owners of the stack trace maps.
+var stackErrors = struct {
+	sync.Mutex
+	owners map[string]uint64
+}{owners: make(map[string]uint64)}
+
+func addStackError(owner string) {
+	stackErrors.Lock()
+	defer stackErrors.Unlock()
+
+	stackErrors.owners[owner]++
+}
+
+// StackErrorsOf returns the stacks lost by the stack trace maps of the owner,
+// i.e. the stack ids failed with -EEXIST on the collisions of the buckets,
+// and the stacks deleted before read.
+func StackErrorsOf(owner string) uint64 {
+	stackErrors.Lock()
+	defer stackErrors.Unlock()
+
+	return stackErrors.owners[owner]
+}
+
+// AllStackErrors returns the stacks lost by the stack trace maps of all owners.
+func AllStackErrors() map[string]uint64 {
+	stackErrors.Lock()
+	defer stackErrors.Unlock()
+
+	return maps.Clone(stackErrors.owners)
+}
+
+// SetStackDepth sets the depth of the stacks collected by the stack trace
+// maps loaded afterwards, it is capped at MaxStackDepth.
+func SetStackDepth(depth int) {
+	if depth <= 0 || depth > MaxStackDepth {
+		depth = MaxStackDepth
+	}
+	stackDepth = depth
+}
+
+// StackDepth returns the depth of the stacks collected by the stack trace maps.
+func StackDepth() int {
+	return stackDepth
+}
+
+// StackTraces reads the stacks of a BPF_MAP_TYPE_STACK_TRACE map by the stack
+// ids. The stack of every id is read and resolved, e.g. symbolized, only once.
+// The bpf gets the stack ids without BPF_F_REUSE_STACKID, so an id always
+// refers to the same stack until the map is reset.
+//
+// The buckets of the map are never freed by the bpf, the map is reset by the
+// users which consume the stack ids periodically, or aged, see EnableAging.
+// The stacks lost are accounted to the owner of the bpf object, see
+// StackErrorsOf.
+type StackTraces[T any] struct {
+	b       BPF
+	mapID   uint32
+	owner   string
+	resolve func(addrs []uint64) T
+
+	mu    sync.Mutex
+	cache map[int32]T
+	aging time.Duration
+	// lastUsed are the times the stack ids are read, or found unread in
+	// the map, and ageTime is the time of the last aging.
+	lastUsed map[int32]time.Time
+	ageTime  time.Time
+}
+
+// NewStackTraces creates the StackTraces of the stack trace map.
+func NewStackTraces[T any](b BPF, mapName string, resolve func(addrs []uint64) T) (*StackTraces[T], error) {
+	mapID := b.MapIDByName(mapName)
+	if mapID == 0 {
+		return nil, fmt.Errorf("stack trace map %s not found", mapName)
+	}
+
+	owner := ObjectOwner(b.Name())
+	if owner == "" {
+		owner = b.Name()
+	}
+
+	return &StackTraces[T]{
+		b:        b,
+		mapID:    mapID,
+		owner:    owner,
+		resolve:  resolve,
+		cache:    make(map[int32]T),
+		lastUsed: make(map[int32]time.Time),
+	}, nil
+}
+
+// EnableAging deletes the stacks unused for a minute on Get, it is for the
+// event tracers which resolve the stack ids of the events right after reading
+// them. Otherwise the buckets are all taken by the stale stacks sooner or
+// later, and bpf_get_stackid fails with -EEXIST forever. A stack is unused
+// when it is neither read nor new in the map in the interval, so the stacks
+// of the events still in the event pipes are kept.
+func (s *StackTraces[T]) EnableAging() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.aging = stackAgingInterval
+	s.ageTime = time.Now()
+}
+
+// Get returns the resolved stack of the stack id.
+func (s *StackTraces[T]) Get(id int32) (T, error) {
+	var zero T
+
+	// the negative id is the errno of bpf_get_stackid, -EEXIST is the
+	// stack lost on the collision of the bucket, the others are of no
+	// stacks, e.g. -EFAULT of the user stacks of the kernel threads.
+	if id < 0 {
+		if id == -int32(unix.EEXIST) {
+			addStackError(s.owner)
+		}
+		return zero, fmt.Errorf("stack id %d: %w", id, ErrStackNotFound)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.aging > 0 {
+		s.lastUsed[id] = time.Now()
+		if time.Since(s.ageTime) > s.aging {
+			if err := s.age(); err != nil {
+				log.Debugf("age the stack trace map %d: %v", s.mapID, err)
+			}
+		}
+	}
+
+	if stack, ok := s.cache[id]; ok {
+		return stack, nil
+	}
+
+	key := make([]byte, 4)
+	binary.NativeEndian.PutUint32(key, uint32(id))
+
+	value, err := s.b.ReadMap(s.mapID, key)
+	if err == nil && len(value) == 0 {
+		err = ErrStackNotFound
+	}
+	if err != nil {
+		addStackError(s.owner)
+		return zero, fmt.Errorf("stack id %d: %w", id, err)
+	}
+
+	stack := s.resolve(stackAddrs(value))
+	if len(s.cache) >= maxCachedStacks {
+		clear(s.cache)
+	}
+	s.cache[id] = stack
+	return stack, nil
+}
+
+// Reset deletes all the stacks of the map and the resolved ones. It is for
+// the maps whose stack ids are consumed periodically, otherwise the map is
+// full of the stale stacks sooner or later.
+func (s *StackTraces[T]) Reset() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.reset()
+}
+
+func (s *StackTraces[T]) reset() error {
+	clear(s.cache)
+	clear(s.lastUsed)
+	s.ageTime = time.Now()
+
+	items, err := s.b.DumpMap(s.mapID)
+	if err != nil {
+		return err
+	}
+
+	keys := make([][]byte, 0, len(items))
+	for _, item := range items {
+		keys = append(keys, item.Key)
+	}
+	return s.b.DeleteMapItems(s.mapID, keys)
+}
+
+// age deletes the stacks unused in the interval. The stacks new in the map
+// are marked used once, they are of the events not read yet.
+func (s *StackTraces[T]) age() error {
+	now := time.Now()
+	s.ageTime = now
+
+	items, err := s.b.DumpMap(s.mapID)
+	if err != nil {
+		return err
+	}
+
+	var keys [][]byte
+	used := make(map[int32]time.Time, len(items))
+	for _, item := range items {
+		id := int32(binary.NativeEndian.Uint32(item.Key))
+
+		last, ok := s.lastUsed[id]
+		if !ok {
+			used[id] = now
+			continue
+		}
+		if now.Sub(last) < s.aging {
+			used[id] = last
+			continue
+		}
+
+		keys = append(keys, item.Key)
+	}
+	s.lastUsed = used
+
+	// the buckets of the stacks deleted are free for the other stacks.
+	for id := range s.cache {
+		if _, ok := used[id]; !ok {
+			delete(s.cache, id)
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil
+	}
+	return s.b.DeleteMapItems(s.mapID, keys)
+}
+
+// stackAddrs decodes the stack addresses, the unused tail is zero.
+func stackAddrs(value []byte) []uint64 {
+	addrs := make([]uint64, 0, len(value)/8)
+	for i := 0; i+8 <= len(value); i += 8 {
+		addr := binary.NativeEndian.Uint64(value[i:])
+		if addr == 0 {
+			break
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
@@ -0,0 +1,191 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !didi
+
+package bpf
+
+import (
+	"encoding/binary"
+	"errors"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stackMapBPF serves a stack trace map from memory, the other methods of
+// the BPF interface are not used.
+type stackMapBPF struct {
+	BPF
+	stacks map[uint32][]uint64
+	reads  int
+}
+
+const stackMapID = 7
+
+func (b *stackMapBPF) Name() string {
+	return "stacks.o"
+}
+
+func (b *stackMapBPF) MapIDByName(name string) uint32 {
+	if name == "stacks" {
+		return stackMapID
+	}
+	return 0
+}
+
+func (b *stackMapBPF) ReadMap(mapID uint32, key []byte) ([]byte, error) {
+	b.reads++
+
+	addrs, ok := b.stacks[binary.NativeEndian.Uint32(key)]
+	if !ok {
+		return nil, errors.New("key not found")
+	}
+
+	value := make([]byte, StackDepth()*8)
+	for i, addr := range addrs {
+		binary.NativeEndian.PutUint64(value[i*8:], addr)
+	}
+	return value, nil
+}
+
+func (b *stackMapBPF) DumpMap(mapID uint32) ([]MapItem, error) {
+	items := make([]MapItem, 0, len(b.stacks))
+	for id := range b.stacks {
+		key := make([]byte, 4)
+		binary.NativeEndian.PutUint32(key, id)
+		items = append(items, MapItem{Key: key})
+	}
+	return items, nil
+}
+
+func (b *stackMapBPF) DeleteMapItems(mapID uint32, keys [][]byte) error {
+	for _, key := range keys {
+		delete(b.stacks, binary.NativeEndian.Uint32(key))
+	}
+	return nil
+}
+
+func TestSetStackDepth(t *testing.T) {
+	defer SetStackDepth(MaxStackDepth)
+
+	cases := []struct {
+		depth int
+		want  int
+	}{
+		{depth: 20, want: 20},
+		{depth: 0, want: MaxStackDepth},
+		{depth: -1, want: MaxStackDepth},
+		{depth: 1024, want: MaxStackDepth},
+	}
+
+	for _, tc := range cases {
+		SetStackDepth(tc.depth)
+		assert.Equal(t, tc.want, StackDepth(), "depth %d", tc.depth)
+	}
+}
+
+func TestStackTraces(t *testing.T) {
+	b := &stackMapBPF{
+		stacks: map[uint32][]uint64{
+			1: {0xffffffff81000010, 0xffffffff81000020},
+			2: {0x401000},
+		},
+	}
+
+	_, err := NewStackTraces(b, "missing", func(addrs []uint64) []uint64 { return addrs })
+	require.Error(t, err)
+
+	resolved := 0
+	stacks, err := NewStackTraces(b, "stacks", func(addrs []uint64) []uint64 {
+		resolved++
+		return addrs
+	})
+	require.NoError(t, err)
+
+	// the stacks are read and resolved once.
+	for range 3 {
+		addrs, err := stacks.Get(1)
+		require.NoError(t, err)
+		assert.Equal(t, []uint64{0xffffffff81000010, 0xffffffff81000020}, addrs)
+	}
+	assert.Equal(t, 1, resolved)
+	assert.Equal(t, 1, b.reads)
+
+	addrs, err := stacks.Get(2)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{0x401000}, addrs)
+
+	// -EFAULT of bpf_get_stackid, and the ids not in the map.
+	lost := StackErrorsOf("stacks.o")
+	_, err = stacks.Get(-14)
+	require.ErrorIs(t, err, ErrStackNotFound)
+	assert.Equal(t, lost, StackErrorsOf("stacks.o"))
+	_, err = stacks.Get(3)
+	require.Error(t, err)
+	assert.Equal(t, lost+1, StackErrorsOf("stacks.o"))
+
+	// -EEXIST of bpf_get_stackid, the stack is lost.
+	_, err = stacks.Get(-17)
+	require.ErrorIs(t, err, ErrStackNotFound)
+	assert.Equal(t, lost+2, StackErrorsOf("stacks.o"))
+
+	require.NoError(t, stacks.Reset())
+	assert.Empty(t, b.stacks)
+	_, err = stacks.Get(1)
+	require.Error(t, err)
+}
+
+func TestStackTraces_Aging(t *testing.T) {
+	b := &stackMapBPF{
+		stacks: map[uint32][]uint64{
+			1: {0xffffffff81000010},
+			2: {0xffffffff81000020},
+			3: {0xffffffff81000030},
+		},
+	}
+
+	stacks, err := NewStackTraces(b, "stacks", func(addrs []uint64) []uint64 { return addrs })
+	require.NoError(t, err)
+	stacks.EnableAging()
+
+	_, err = stacks.Get(1)
+	require.NoError(t, err)
+	_, err = stacks.Get(2)
+	require.NoError(t, err)
+
+	// the stack 2 is unused in the interval, the unread stack 3 is of the
+	// events in flight, it is kept for another interval.
+	stacks.lastUsed[2] = time.Now().Add(-2 * stackAgingInterval)
+	stacks.ageTime = time.Now().Add(-2 * stackAgingInterval)
+	_, err = stacks.Get(1)
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{1, 3}, slices.Sorted(maps.Keys(b.stacks)))
+
+	b.stacks[2] = []uint64{0xffffffff81000040}
+	addrs, err := stacks.Get(2)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{0xffffffff81000040}, addrs)
+
+	// the stack 3 is still unread after another interval.
+	stacks.lastUsed[3] = time.Now().Add(-2 * stackAgingInterval)
+	stacks.ageTime = time.Now().Add(-2 * stackAgingInterval)
+	_, err = stacks.Get(1)
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{1, 2}, slices.Sorted(maps.Keys(b.stacks)))
+}
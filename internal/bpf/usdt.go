@@ -0,0 +1,180 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bpf
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	usdtNoteSection  = ".note.stapsdt"
+	usdtBaseSection  = ".stapsdt.base"
+	usdtNoteName     = "stapsdt"
+	usdtNoteType     = 3
+	elfNoteAlignment = 4
+)
+
+// ErrUSDTNotFound is returned when the binary has no such USDT probe.
+var ErrUSDTNotFound = errors.New("usdt probe not found")
+
+// usdtProbe is a USDT probe of the SystemTap SDT notes, the locations are the
+// file offsets for uprobes.
+type usdtProbe struct {
+	Provider string
+	Name     string
+	// Args is the argument spec, e.g. "-4@%edi 8@%rsi", the bpf reads the
+	// arguments by the registers itself.
+	Args            string
+	Offset          uint64
+	SemaphoreOffset uint64
+}
+
+// usdtNote is a note of .note.stapsdt, the addresses are the link-time
+// virtual addresses.
+type usdtNote struct {
+	pc, base, semaphore uint64
+	provider, name      string
+	args                string
+}
+
+func alignNote(n uint32) uint32 {
+	return (n + elfNoteAlignment - 1) &^ (elfNoteAlignment - 1)
+}
+
+// parseUSDTNotes parses the notes of the .note.stapsdt section.
+func parseUSDTNotes(data []byte, order binary.ByteOrder, addrSize int) ([]usdtNote, error) {
+	var notes []usdtNote
+
+	for len(data) > 0 {
+		if len(data) < 12 {
+			return nil, errors.New("truncated note header")
+		}
+
+		namesz := order.Uint32(data[0:])
+		descsz := order.Uint32(data[4:])
+		typ := order.Uint32(data[8:])
+		data = data[12:]
+
+		nameEnd, descEnd := alignNote(namesz), alignNote(namesz)+alignNote(descsz)
+		if uint64(len(data)) < uint64(descEnd) {
+			return nil, errors.New("truncated note")
+		}
+
+		name := string(bytes.TrimRight(data[:namesz], "\x00"))
+		desc := data[nameEnd : nameEnd+descsz]
+		data = data[descEnd:]
+
+		if name != usdtNoteName || typ != usdtNoteType {
+			continue
+		}
+
+		if len(desc) < 3*addrSize {
+			return nil, errors.New("truncated stapsdt note")
+		}
+
+		var note usdtNote
+		addrs := make([]uint64, 3)
+		for i := range addrs {
+			if addrSize == 8 {
+				addrs[i] = order.Uint64(desc[i*8:])
+			} else {
+				addrs[i] = uint64(order.Uint32(desc[i*4:]))
+			}
+		}
+		note.pc, note.base, note.semaphore = addrs[0], addrs[1], addrs[2]
+
+		strs := bytes.SplitN(desc[3*addrSize:], []byte{0}, 4)
+		if len(strs) < 3 {
+			return nil, errors.New("invalid stapsdt note strings")
+		}
+		note.provider, note.name, note.args = string(strs[0]), string(strs[1]), string(strs[2])
+
+		notes = append(notes, note)
+	}
+
+	return notes, nil
+}
+
+// elfFileOffset converts the virtual address to the file offset by the
+// loadable segments.
+func elfFileOffset(f *elf.File, addr uint64) (uint64, error) {
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_LOAD {
+			continue
+		}
+		if addr >= prog.Vaddr && addr < prog.Vaddr+prog.Memsz {
+			return addr - prog.Vaddr + prog.Off, nil
+		}
+	}
+	return 0, fmt.Errorf("address %#x not in any loadable segment", addr)
+}
+
+// findUSDTProbe finds the USDT probe provider:name of the binary.
+func findUSDTProbe(path, provider, name string) (*usdtProbe, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	section := f.Section(usdtNoteSection)
+	if section == nil {
+		return nil, fmt.Errorf("%s: no %s section: %w", path, usdtNoteSection, ErrUSDTNotFound)
+	}
+
+	data, err := section.Data()
+	if err != nil {
+		return nil, fmt.Errorf("%s: read %s: %w", path, usdtNoteSection, err)
+	}
+
+	addrSize := 8
+	if f.Class == elf.ELFCLASS32 {
+		addrSize = 4
+	}
+
+	notes, err := parseUSDTNotes(data, f.ByteOrder, addrSize)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for _, note := range notes {
+		if note.provider != provider || note.name != name {
+			continue
+		}
+
+		// the binary is prelinked if the .stapsdt.base moved.
+		pc := note.pc
+		if base := f.Section(usdtBaseSection); base != nil && note.base != 0 {
+			pc += base.Addr - note.base
+		}
+
+		probe := &usdtProbe{Provider: provider, Name: name, Args: note.args}
+		if probe.Offset, err = elfFileOffset(f, pc); err != nil {
+			return nil, fmt.Errorf("%s: usdt %s:%s: %w", path, provider, name, err)
+		}
+		if note.semaphore != 0 {
+			if probe.SemaphoreOffset, err = elfFileOffset(f, note.semaphore); err != nil {
+				return nil, fmt.Errorf("%s: usdt %s:%s semaphore: %w", path, provider, name, err)
+			}
+		}
+		return probe, nil
+	}
+
+	return nil, fmt.Errorf("%s: usdt %s:%s: %w", path, provider, name, ErrUSDTNotFound)
+}
@@ -0,0 +1,256 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !didi
+
+package bpf
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/link"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stapsdtNote builds a .note.stapsdt note of a 64-bit binary.
+func stapsdtNote(name string, typ uint32, pc, base, semaphore uint64, strs ...string) []byte {
+	var desc []byte
+	for _, addr := range []uint64{pc, base, semaphore} {
+		desc = binary.LittleEndian.AppendUint64(desc, addr)
+	}
+	for _, s := range strs {
+		desc = append(desc, s...)
+		desc = append(desc, 0)
+	}
+
+	noteName := append([]byte(name), 0)
+
+	var note []byte
+	note = binary.LittleEndian.AppendUint32(note, uint32(len(noteName)))
+	note = binary.LittleEndian.AppendUint32(note, uint32(len(desc)))
+	note = binary.LittleEndian.AppendUint32(note, typ)
+	note = append(note, noteName...)
+	note = append(note, make([]byte, alignNote(uint32(len(noteName)))-uint32(len(noteName)))...)
+	note = append(note, desc...)
+	note = append(note, make([]byte, alignNote(uint32(len(desc)))-uint32(len(desc)))...)
+	return note
+}
+
+func TestParseUSDTNotes(t *testing.T) {
+	var data []byte
+	data = append(data, stapsdtNote("stapsdt", usdtNoteType, 0x1130, 0x2004, 0, "libc", "setjmp", "8@%rdi -4@%esi")...)
+	data = append(data, stapsdtNote("GNU", 1, 0, 0, 0, "build-id")...)
+	data = append(data, stapsdtNote("stapsdt", usdtNoteType, 0x1200, 0x2004, 0x4010, "app", "request__start", "")...)
+
+	notes, err := parseUSDTNotes(data, binary.LittleEndian, 8)
+	require.NoError(t, err)
+	assert.Equal(t, []usdtNote{
+		{pc: 0x1130, base: 0x2004, provider: "libc", name: "setjmp", args: "8@%rdi -4@%esi"},
+		{pc: 0x1200, base: 0x2004, semaphore: 0x4010, provider: "app", name: "request__start"},
+	}, notes)
+
+	_, err = parseUSDTNotes(data[:len(data)-8], binary.LittleEndian, 8)
+	assert.Error(t, err)
+}
+
+// usdtSource is a program with a USDT probe app:request__start and its
+// semaphore, the note is the same as the one of the sys/sdt.h macros.
+const usdtSource = `
+unsigned short app_request__start_semaphore __attribute__((section(".probes"), used));
+
+__attribute__((noinline)) int request(int n)
+{
+	__asm__ __volatile__(
+		"990: nop\n"
+		".pushsection .note.stapsdt,\"?\",\"note\"\n"
+		".balign 4\n"
+		".4byte 992f-991f, 994f-993f, 3\n"
+		"991: .asciz \"stapsdt\"\n"
+		"992: .balign 4\n"
+		"993: .8byte 990b\n"
+		".8byte _.stapsdt.base\n"
+		".8byte app_request__start_semaphore\n"
+		".asciz \"app\"\n"
+		".asciz \"request__start\"\n"
+		".asciz \"-4@%0\"\n"
+		"994: .balign 4\n"
+		".popsection\n"
+		".ifndef _.stapsdt.base\n"
+		".pushsection .stapsdt.base,\"aG\",\"progbits\",.stapsdt.base,comdat\n"
+		".weak _.stapsdt.base\n"
+		".hidden _.stapsdt.base\n"
+		"_.stapsdt.base: .space 1\n"
+		".size _.stapsdt.base, 1\n"
+		".popsection\n"
+		".endif\n" :: "nor"(n));
+	return n + 1;
+}
+
+int main(void) { return request(1) - 2; }
+`
+
+func buildUSDTBinary(t *testing.T) string {
+	t.Helper()
+
+	cc, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("cc not found")
+	}
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.c")
+	require.NoError(t, os.WriteFile(src, []byte(usdtSource), 0o644))
+
+	bin := filepath.Join(dir, "main")
+	if out, err := exec.Command(cc, "-O1", "-o", bin, src).CombinedOutput(); err != nil {
+		t.Skipf("cc: %v: %s", err, out)
+	}
+	return bin
+}
+
+func TestFindUSDTProbe(t *testing.T) {
+	bin := buildUSDTBinary(t)
+
+	f, err := elf.Open(bin)
+	require.NoError(t, err)
+	defer f.Close()
+
+	probes := f.Section(".probes")
+	require.NotNil(t, probes)
+
+	probe, err := findUSDTProbe(bin, "app", "request__start")
+	require.NoError(t, err)
+	assert.Equal(t, "app", probe.Provider)
+	assert.Equal(t, "request__start", probe.Name)
+	assert.NotEmpty(t, probe.Args)
+	assert.Equal(t, probes.Offset, probe.SemaphoreOffset)
+
+	// the probe is in the function request.
+	symbols, err := f.Symbols()
+	require.NoError(t, err)
+	for _, sym := range symbols {
+		if sym.Name == "request" {
+			off, err := elfFileOffset(f, sym.Value)
+			require.NoError(t, err)
+			assert.GreaterOrEqual(t, probe.Offset, off)
+			assert.Less(t, probe.Offset, off+sym.Size)
+		}
+	}
+
+	_, err = findUSDTProbe(bin, "app", "missing")
+	assert.ErrorIs(t, err, ErrUSDTNotFound)
+}
+
+func TestUprobeSectionTarget(t *testing.T) {
+	tests := []struct {
+		section    string
+		wantPath   string
+		wantSymbol string
+	}{
+		{section: "uprobe/usr/bin/bash:readline", wantPath: "usr/bin/bash", wantSymbol: "readline"},
+		{section: "uretprobe//usr/bin/bash:readline", wantPath: "/usr/bin/bash", wantSymbol: "readline"},
+		{section: "uprobe//lib/libc.so.6:malloc+16", wantPath: "/lib/libc.so.6", wantSymbol: "malloc+16"},
+		{section: "usdt//usr/bin/app:app:request__start", wantPath: "/usr/bin/app", wantSymbol: "app:request__start"},
+		{section: "usdt//a:b/app:app:start", wantPath: "/a:b/app", wantSymbol: "app:start"},
+		{section: "usdt//usr/bin/app:start"},
+		{section: "uprobe"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.section, func(t *testing.T) {
+			spec := &programSpec{
+				sectionName:   tt.section,
+				sectionPrefix: strings.SplitN(tt.section, "/", 2)[0],
+			}
+
+			path, symbol := uprobeSectionTarget(spec)
+			assert.Equal(t, tt.wantPath, path)
+			assert.Equal(t, tt.wantSymbol, symbol)
+		})
+	}
+}
+
+func TestDefaultBPF_AttachUprobe(t *testing.T) {
+	bin := buildUSDTBinary(t)
+
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		License: "GPL",
+		Type:    ebpf.Kprobe,
+		Instructions: asm.Instructions{
+			asm.Mov.Imm(asm.R0, 0),
+			asm.Return(),
+		},
+	})
+	if err != nil {
+		t.Skipf("skipping: kprobe program not supported: %v", err)
+	}
+	defer prog.Close()
+
+	newSpec := func(section string) programSpec {
+		return programSpec{
+			specType:      ebpf.Kprobe,
+			sectionName:   section,
+			sectionPrefix: strings.SplitN(section, "/", 2)[0],
+			bProg:         prog,
+			links:         make(map[string]link.Link),
+		}
+	}
+
+	b := &defaultBPF{
+		name: "test_uprobe",
+		programSpecs: map[uint32]programSpec{
+			1: newSpec("uprobe/" + bin + ":main"),
+			2: newSpec("uretprobe/" + bin + ":main"),
+			3: newSpec("usdt/" + bin + ":app:request__start"),
+			4: newSpec("uprobe"),
+		},
+		programName2IDs: map[string]uint32{"uprobe": 1, "uretprobe": 2, "usdt": 3, "uprobe_noname": 4},
+	}
+	defer b.Detach()
+
+	// the uprobe without the target in the section needs the options. The
+	// uprobes are not on request, the usdt probe at the start of it has the
+	// semaphore, and the uprobes of an address must have the same one.
+	opt := AttachOption{ProgramName: "uprobe_noname", Symbol: "main"}
+	opt.Uprobe.Path = bin
+
+	if err := b.AttachWithOptions([]AttachOption{opt}); err != nil {
+		t.Skipf("skipping: uprobe not supported: %v", err)
+	}
+	delete(b.programSpecs, 4)
+	require.NoError(t, b.Attach())
+
+	for id := range b.programSpecs {
+		assert.Len(t, b.programSpecs[id].links, 1, b.programSpecs[id].sectionName)
+	}
+
+	// the same symbol of a process, and the cgroup filter needs the map.
+	opt = AttachOption{ProgramName: "uprobe"}
+	opt.Uprobe.PID = os.Getpid()
+	require.NoError(t, b.AttachWithOptions([]AttachOption{opt}))
+	assert.Len(t, b.programSpecs[1].links, 2)
+
+	opt.Uprobe.CgroupPath = "/sys/fs/cgroup"
+	assert.Error(t, b.attachUprobeWithOption(1, &opt))
+}
@@ -0,0 +1,290 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flamegraph
+
+import (
+	"fmt"
+	"sort"
+
+	googlev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
+)
+
+// DiffRootName is the name of the root frame in a diff flamegraph.
+const DiffRootName = "total"
+
+// WeightedStack is a call stack, root frame first, with its value.
+type WeightedStack struct {
+	Names []string
+	Value int64
+}
+
+// DiffFlamebearer is the pyroscope "double" flamebearer format. Every bar is
+// 7 numbers in the level: [offsetLeft, totalLeft, selfLeft, offsetRight,
+// totalRight, selfRight, nameIndex], the offsets are relative to the end of
+// the previous bar in the same level.
+type DiffFlamebearer struct {
+	Format     string    `json:"format"`
+	Names      []string  `json:"names"`
+	Levels     [][]int64 `json:"levels"`
+	NumTicks   int64     `json:"numTicks"`
+	MaxSelf    int64     `json:"maxSelf"`
+	LeftTicks  int64     `json:"leftTicks"`
+	RightTicks int64     `json:"rightTicks"`
+}
+
+// DiffFrameData is the nested set frame of a diff flamegraph, as the grafana
+// flame graph panel expects: value and self are the sum of both profiles,
+// valueRight and selfRight are of the right (target) profile.
+type DiffFrameData struct {
+	Level      int64  `json:"level"`
+	Label      string `json:"label"`
+	Value      int64  `json:"value"`
+	Self       int64  `json:"self"`
+	ValueRight int64  `json:"valueRight"`
+	SelfRight  int64  `json:"selfRight"`
+	// Delta is the change of the frame total, right - left.
+	Delta int64 `json:"delta"`
+	// DeltaPercent is the change of the frame share of the profile total,
+	// right/rightTicks - left/leftTicks, in percent.
+	DeltaPercent float64 `json:"deltaPercent"`
+}
+
+// DiffProfile is the differential profile of the left (baseline) and the
+// right (target) profiles.
+type DiffProfile struct {
+	Flamebearer *DiffFlamebearer `json:"flamebearer"`
+	Frames      []DiffFrameData  `json:"frames"`
+}
+
+type diffNode struct {
+	name        string
+	left, right int64
+	leftSelf    int64
+	rightSelf   int64
+	children    map[string]*diffNode
+}
+
+func newDiffNode(name string) *diffNode {
+	return &diffNode{name: name, children: map[string]*diffNode{}}
+}
+
+func (n *diffNode) insert(names []string, value int64, right bool) {
+	node := n
+	node.add(value, right, len(names) == 0)
+
+	for i, name := range names {
+		child, ok := node.children[name]
+		if !ok {
+			child = newDiffNode(name)
+			node.children[name] = child
+		}
+
+		child.add(value, right, i == len(names)-1)
+		node = child
+	}
+}
+
+func (n *diffNode) add(value int64, right, self bool) {
+	if right {
+		n.right += value
+		if self {
+			n.rightSelf += value
+		}
+		return
+	}
+
+	n.left += value
+	if self {
+		n.leftSelf += value
+	}
+}
+
+func (n *diffNode) sortedChildren() []*diffNode {
+	children := make([]*diffNode, 0, len(n.children))
+	for _, c := range n.children {
+		children = append(children, c)
+	}
+
+	sort.Slice(children, func(i, j int) bool { return children[i].name < children[j].name })
+	return children
+}
+
+// FrameDataStacks converts the nested set flamegraph data back to stacks.
+// Every frame with a self value is the leaf of a stack. The level 0 frame is
+// the root of the flamegraph, "total", and not a part of the stacks.
+func FrameDataStacks(frames []FrameData) ([]WeightedStack, error) {
+	var (
+		stacks []WeightedStack
+		path   []string
+	)
+
+	for i, f := range frames {
+		if f.Level < 0 || int(f.Level) > len(path) {
+			return nil, fmt.Errorf("frame %d: invalid level %d", i, f.Level)
+		}
+
+		path = append(path[:f.Level], f.Label)
+		if f.Self > 0 {
+			stacks = append(stacks, WeightedStack{
+				Names: append([]string(nil), path[1:]...),
+				Value: f.Self,
+			})
+		}
+	}
+
+	return stacks, nil
+}
+
+// TimeSampleIndex returns the index of the first sample type in nanoseconds,
+// e.g. cpu or offcpu, the sample counts of the profiles in the different
+// periods aren't comparable. It is 0 if the profile has none.
+func TimeSampleIndex(p *googlev1.Profile) int {
+	for i, t := range p.SampleType {
+		if int(t.Unit) < len(p.StringTable) && p.StringTable[t.Unit] == "nanoseconds" {
+			return i
+		}
+	}
+	return 0
+}
+
+// ProfileStacks converts the pprof samples to stacks with the value of the
+// sample type index. The comm label, if any, is the root frame the same as
+// the flamegraph data of the perf tool.
+func ProfileStacks(p *googlev1.Profile, sampleIndex int) ([]WeightedStack, error) {
+	if sampleIndex < 0 || sampleIndex >= len(p.SampleType) {
+		return nil, fmt.Errorf("invalid sample index %d", sampleIndex)
+	}
+
+	str := func(i int64) string {
+		if i < 0 || int(i) >= len(p.StringTable) {
+			return ""
+		}
+		return p.StringTable[i]
+	}
+
+	functions := make(map[uint64]string, len(p.Function))
+	for _, f := range p.Function {
+		functions[f.Id] = str(f.Name)
+	}
+
+	locations := make(map[uint64]*googlev1.Location, len(p.Location))
+	for _, l := range p.Location {
+		locations[l.Id] = l
+	}
+
+	stacks := make([]WeightedStack, 0, len(p.Sample))
+	for _, s := range p.Sample {
+		var names []string
+		for _, l := range s.Label {
+			if str(l.Key) == "comm" {
+				names = append(names, str(l.Str))
+			}
+		}
+
+		// the location ids are leaf first, and the lines of a location
+		// are the inlined functions, innermost first.
+		for i := len(s.LocationId) - 1; i >= 0; i-- {
+			loc, ok := locations[s.LocationId[i]]
+			if !ok {
+				return nil, fmt.Errorf("location %d not found", s.LocationId[i])
+			}
+
+			for j := len(loc.Line) - 1; j >= 0; j-- {
+				names = append(names, functions[loc.Line[j].FunctionId])
+			}
+		}
+
+		stacks = append(stacks, WeightedStack{Names: names, Value: s.Value[sampleIndex]})
+	}
+
+	return stacks, nil
+}
+
+// Diff computes the differential profile of the left (baseline) stacks and
+// the right (target) stacks.
+func Diff(left, right []WeightedStack) *DiffProfile {
+	root := newDiffNode(DiffRootName)
+	for _, s := range left {
+		root.insert(s.Names, s.Value, false)
+	}
+	for _, s := range right {
+		root.insert(s.Names, s.Value, true)
+	}
+
+	fb := &DiffFlamebearer{
+		Format:     "double",
+		LeftTicks:  root.left,
+		RightTicks: root.right,
+		NumTicks:   root.left + root.right,
+	}
+	diff := &DiffProfile{Flamebearer: fb}
+
+	nameIndex := map[string]int64{}
+	// the end of the last bar in the level, left and right.
+	type levelEnd struct{ left, right int64 }
+	var ends []levelEnd
+
+	var walk func(n *diffNode, level int, startLeft, startRight int64)
+	walk = func(n *diffNode, level int, startLeft, startRight int64) {
+		if level == len(fb.Levels) {
+			fb.Levels = append(fb.Levels, nil)
+			ends = append(ends, levelEnd{})
+		}
+
+		idx, ok := nameIndex[n.name]
+		if !ok {
+			idx = int64(len(fb.Names))
+			fb.Names = append(fb.Names, n.name)
+			nameIndex[n.name] = idx
+		}
+
+		fb.Levels[level] = append(fb.Levels[level],
+			startLeft-ends[level].left, n.left, n.leftSelf,
+			startRight-ends[level].right, n.right, n.rightSelf,
+			idx)
+		ends[level] = levelEnd{left: startLeft + n.left, right: startRight + n.right}
+		fb.MaxSelf = max(fb.MaxSelf, n.leftSelf, n.rightSelf)
+
+		frame := DiffFrameData{
+			Level:      int64(level),
+			Label:      n.name,
+			Value:      n.left + n.right,
+			Self:       n.leftSelf + n.rightSelf,
+			ValueRight: n.right,
+			SelfRight:  n.rightSelf,
+			Delta:      n.right - n.left,
+		}
+		frame.DeltaPercent = ratio(n.right, root.right) - ratio(n.left, root.left)
+		diff.Frames = append(diff.Frames, frame)
+
+		childLeft, childRight := startLeft, startRight
+		for _, c := range n.sortedChildren() {
+			walk(c, level+1, childLeft, childRight)
+			childLeft += c.left
+			childRight += c.right
+		}
+	}
+	walk(root, 0, 0, 0)
+
+	return diff
+}
+
+func ratio(value, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+
+	return float64(value) * 100 / float64(total)
+}
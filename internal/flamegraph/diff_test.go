@@ -0,0 +1,137 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flamegraph
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFrameDataStacks(t *testing.T) {
+	frames := []FrameData{
+		{Level: 0, Label: "total", Value: 10},
+		{Level: 1, Label: "app", Value: 10, Self: 1},
+		{Level: 2, Label: "main", Value: 9, Self: 4},
+		{Level: 3, Label: "read", Value: 5, Self: 5},
+	}
+
+	stacks, err := FrameDataStacks(frames)
+	if err != nil {
+		t.Fatalf("FrameDataStacks() error=%v", err)
+	}
+
+	want := []WeightedStack{
+		{Names: []string{"app"}, Value: 1},
+		{Names: []string{"app", "main"}, Value: 4},
+		{Names: []string{"app", "main", "read"}, Value: 5},
+	}
+	if !reflect.DeepEqual(stacks, want) {
+		t.Errorf("FrameDataStacks()=%v, want %v", stacks, want)
+	}
+
+	if _, err := FrameDataStacks([]FrameData{{Level: 2, Label: "bad"}}); err == nil {
+		t.Errorf("FrameDataStacks() with invalid level should fail")
+	}
+}
+
+func TestProfileStacks(t *testing.T) {
+	b := NewProfileBuilder(&ProfileOption{SampleTypes: []ValueType{{Type: "samples", Unit: "count"}}})
+	stack := []Frame{{Name: "read", Address: 0x2}, {Name: "main", Address: 0x1}}
+	if err := b.AddSample(stack, []int64{3}, map[string]string{"comm": "app"}); err != nil {
+		t.Fatalf("AddSample() error=%v", err)
+	}
+
+	stacks, err := ProfileStacks(b.Profile(), 0)
+	if err != nil {
+		t.Fatalf("ProfileStacks() error=%v", err)
+	}
+
+	want := []WeightedStack{{Names: []string{"app", "main", "read"}, Value: 3}}
+	if !reflect.DeepEqual(stacks, want) {
+		t.Errorf("ProfileStacks()=%v, want %v", stacks, want)
+	}
+
+	if _, err := ProfileStacks(b.Profile(), 1); err == nil {
+		t.Errorf("ProfileStacks() with invalid sample index should fail")
+	}
+}
+
+func TestTimeSampleIndex(t *testing.T) {
+	b := NewProfileBuilder(&ProfileOption{SampleTypes: []ValueType{{Type: "samples", Unit: "count"}}})
+	if got := TimeSampleIndex(b.Profile()); got != 0 {
+		t.Errorf("TimeSampleIndex() without the time=%d, want 0", got)
+	}
+
+	b = NewProfileBuilder(&ProfileOption{SampleTypes: []ValueType{
+		{Type: "samples", Unit: "count"},
+		{Type: "cpu", Unit: "nanoseconds"},
+	}})
+	if got := TimeSampleIndex(b.Profile()); got != 1 {
+		t.Errorf("TimeSampleIndex()=%d, want 1", got)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	left := []WeightedStack{
+		{Names: []string{"app", "main", "read"}, Value: 6},
+		{Names: []string{"app", "main", "write"}, Value: 4},
+	}
+	right := []WeightedStack{
+		{Names: []string{"app", "main", "read"}, Value: 2},
+		{Names: []string{"app", "main", "write"}, Value: 12},
+		{Names: []string{"app", "gc"}, Value: 6},
+	}
+
+	diff := Diff(left, right)
+	fb := diff.Flamebearer
+
+	if fb.Format != "double" || fb.LeftTicks != 10 || fb.RightTicks != 20 || fb.NumTicks != 30 || fb.MaxSelf != 12 {
+		t.Fatalf("unexpected flamebearer header %+v", fb)
+	}
+	if len(fb.Levels) != 4 {
+		t.Fatalf("levels=%d, want 4", len(fb.Levels))
+	}
+
+	// level 2 is [gc, main], sorted by name: gc only exists in right.
+	name := func(i int64) string { return fb.Names[i] }
+	level := fb.Levels[2]
+	if len(level) != 14 || name(level[6]) != "gc" || name(level[13]) != "main" {
+		t.Fatalf("unexpected level 2 %v", level)
+	}
+	if level[1] != 0 || level[4] != 6 || level[5] != 6 {
+		t.Errorf("gc left=%d right=%d rightSelf=%d, want 0 6 6", level[1], level[4], level[5])
+	}
+	// main starts after gc, which is empty in left.
+	if level[7] != 0 || level[8] != 10 || level[10] != 0 || level[11] != 14 {
+		t.Errorf("main offsets/totals %v", level[7:14])
+	}
+
+	frames := map[string]DiffFrameData{}
+	for _, f := range diff.Frames {
+		frames[f.Label] = f
+	}
+
+	read := frames["read"]
+	if read.Value != 8 || read.ValueRight != 2 || read.Delta != -4 || read.DeltaPercent != -50 {
+		t.Errorf("unexpected read frame %+v", read)
+	}
+	write := frames["write"]
+	if write.Self != 16 || write.SelfRight != 12 || write.Delta != 8 || write.DeltaPercent != 20 {
+		t.Errorf("unexpected write frame %+v", write)
+	}
+	if diff.Frames[0].Label != DiffRootName || diff.Frames[0].Value != 30 {
+		t.Errorf("unexpected root frame %+v", diff.Frames[0])
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flamegraph
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	FormatJSON  = "json"
+	FormatPprof = "pprof"
+)
+
+// Output is how the profiling tools output the stack samples.
+type Output struct {
+	// Format is FormatJSON or FormatPprof.
+	Format string
+	// PprofOutput is the pprof file written in addition to the json.
+	PprofOutput string
+	// ArtifactDir is the task artifact dir, the pprof profile is written
+	// into it as ArtifactName if PprofOutput is empty.
+	ArtifactDir  string
+	ArtifactName string
+	// Profile and Values build the pprof profile, see BuildProfile.
+	Profile *ProfileOption
+	Values  func(s *StackSample) []int64
+}
+
+// Write prints the flamegraph json, and/or writes the pprof profile. The
+// pprof profile is written to PprofOutput, or into the artifact dir, or to
+// stdout in this order.
+func (o *Output) Write(samples []*StackSample) error {
+	pprofOutput := o.PprofOutput
+
+	switch o.Format {
+	case FormatJSON:
+		if err := PrintFrameData(samples); err != nil {
+			return err
+		}
+	case FormatPprof:
+		if pprofOutput == "" {
+			if o.ArtifactDir == "" {
+				return o.writePprof(os.Stdout, samples)
+			}
+			pprofOutput = filepath.Join(o.ArtifactDir, o.ArtifactName)
+		}
+	default:
+		return fmt.Errorf("invalid format %q", o.Format)
+	}
+
+	if pprofOutput == "" {
+		return nil
+	}
+
+	if err := o.writePprofFile(pprofOutput, samples); err != nil {
+		return fmt.Errorf("write pprof %s: %w", pprofOutput, err)
+	}
+	return nil
+}
+
+func (o *Output) writePprof(w io.Writer, samples []*StackSample) error {
+	builder, err := BuildProfile(samples, o.Profile, o.Values)
+	if err != nil {
+		return err
+	}
+
+	return builder.WriteGzip(w)
+}
+
+func (o *Output) writePprofFile(path string, samples []*StackSample) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := o.writePprof(f, samples); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// PrintFrameData prints the flamegraph json of the samples to stdout.
+func PrintFrameData(samples []*StackSample) error {
+	flameData, err := BuildFrameData(samples)
+	if err != nil {
+		return err
+	}
+
+	jsonData, err := json.Marshal(flameData)
+	if err != nil {
+		return fmt.Errorf("JSON encoding error: %w", err)
+	}
+	fmt.Println(string(jsonData))
+	return nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flamegraph
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOutputArtifact(t *testing.T) {
+	dir := t.TempDir()
+	o := &Output{
+		Format:       FormatPprof,
+		ArtifactDir:  dir,
+		ArtifactName: "profile.pb.gz",
+		Profile: &ProfileOption{
+			SampleTypes: []ValueType{{Type: "samples", Unit: "count"}},
+			PeriodType:  ValueType{Type: "cpu", Unit: "nanoseconds"},
+			Period:      1,
+		},
+		Values: func(s *StackSample) []int64 { return []int64{s.Value} },
+	}
+
+	samples := []*StackSample{{Names: []string{"main", "app"}, Frames: []Frame{{Name: "main"}, {Name: "app"}}, Value: 3}}
+	if err := o.Write(samples); err != nil {
+		t.Fatalf("Write() error=%v", err)
+	}
+
+	f, err := os.Open(filepath.Join(dir, "profile.pb.gz"))
+	if err != nil {
+		t.Fatalf("open the artifact: %v", err)
+	}
+	defer f.Close()
+
+	p, err := ReadProfileGzip(f)
+	if err != nil {
+		t.Fatalf("ReadProfileGzip() error=%v", err)
+	}
+	if len(p.Sample) != 1 || p.Sample[0].Value[0] != 3 {
+		t.Errorf("samples=%v, want one sample of 3", p.Sample)
+	}
+
+	o.Format = "svg"
+	if err := o.Write(samples); err == nil {
+		t.Errorf("Write() with an invalid format should fail")
+	}
+}
@@ -0,0 +1,273 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flamegraph
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+
+	googlev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
+)
+
+// KernelMappingFile is the mapping name of the kernel image, the same as perf.
+const KernelMappingFile = "[kernel.kallsyms]"
+
+// ValueType describes the type and the unit of a sample value.
+type ValueType struct {
+	Type string
+	Unit string
+}
+
+// Mapping is the binary which a frame belongs to.
+type Mapping struct {
+	Start   uint64
+	Limit   uint64
+	Offset  uint64
+	File    string
+	BuildID string
+}
+
+// Frame is a resolved stack frame.
+type Frame struct {
+	Name    string
+	Address uint64
+	Mapping *Mapping
+}
+
+// ProfileOption is the header of a pprof profile.
+type ProfileOption struct {
+	SampleTypes   []ValueType
+	PeriodType    ValueType
+	Period        int64
+	TimeNanos     int64
+	DurationNanos int64
+}
+
+type locationKey struct {
+	mappingID uint64
+	address   uint64
+	name      string
+}
+
+type functionKey struct {
+	name string
+	file string
+}
+
+// ProfileBuilder accumulates stack samples into a pprof profile, deduplicating
+// strings, mappings, functions and locations.
+type ProfileBuilder struct {
+	profile   *googlev1.Profile
+	strings   map[string]int64
+	mappings  map[Mapping]uint64
+	functions map[functionKey]uint64
+	locations map[locationKey]uint64
+}
+
+// NewProfileBuilder creates a ProfileBuilder.
+func NewProfileBuilder(opt *ProfileOption) *ProfileBuilder {
+	b := &ProfileBuilder{
+		profile:   &googlev1.Profile{},
+		strings:   map[string]int64{},
+		mappings:  map[Mapping]uint64{},
+		functions: map[functionKey]uint64{},
+		locations: map[locationKey]uint64{},
+	}
+
+	// string_table[0] must always be "".
+	b.stringIndex("")
+
+	for _, t := range opt.SampleTypes {
+		b.profile.SampleType = append(b.profile.SampleType, b.valueType(t))
+	}
+	b.profile.PeriodType = b.valueType(opt.PeriodType)
+	b.profile.Period = opt.Period
+	b.profile.TimeNanos = opt.TimeNanos
+	b.profile.DurationNanos = opt.DurationNanos
+	return b
+}
+
+func (b *ProfileBuilder) stringIndex(s string) int64 {
+	if idx, ok := b.strings[s]; ok {
+		return idx
+	}
+
+	idx := int64(len(b.profile.StringTable))
+	b.profile.StringTable = append(b.profile.StringTable, s)
+	b.strings[s] = idx
+	return idx
+}
+
+func (b *ProfileBuilder) valueType(t ValueType) *googlev1.ValueType {
+	return &googlev1.ValueType{
+		Type: b.stringIndex(t.Type),
+		Unit: b.stringIndex(t.Unit),
+	}
+}
+
+func (b *ProfileBuilder) mappingID(m *Mapping) uint64 {
+	if m == nil {
+		return 0
+	}
+
+	if id, ok := b.mappings[*m]; ok {
+		return id
+	}
+
+	id := uint64(len(b.profile.Mapping) + 1)
+	b.profile.Mapping = append(b.profile.Mapping, &googlev1.Mapping{
+		Id:           id,
+		MemoryStart:  m.Start,
+		MemoryLimit:  m.Limit,
+		FileOffset:   m.Offset,
+		Filename:     b.stringIndex(m.File),
+		BuildId:      b.stringIndex(m.BuildID),
+		HasFunctions: true,
+	})
+	b.mappings[*m] = id
+	return id
+}
+
+func (b *ProfileBuilder) functionID(name, file string) uint64 {
+	key := functionKey{name: name, file: file}
+	if id, ok := b.functions[key]; ok {
+		return id
+	}
+
+	id := uint64(len(b.profile.Function) + 1)
+	b.profile.Function = append(b.profile.Function, &googlev1.Function{
+		Id:         id,
+		Name:       b.stringIndex(name),
+		SystemName: b.stringIndex(name),
+	})
+	b.functions[key] = id
+	return id
+}
+
+func (b *ProfileBuilder) locationID(f *Frame) uint64 {
+	key := locationKey{mappingID: b.mappingID(f.Mapping), address: f.Address}
+	// frames without address are keyed by the name, e.g. the process comm.
+	if f.Address == 0 {
+		key.name = f.Name
+	}
+
+	if id, ok := b.locations[key]; ok {
+		return id
+	}
+
+	file := ""
+	if f.Mapping != nil {
+		file = f.Mapping.File
+	}
+
+	id := uint64(len(b.profile.Location) + 1)
+	b.profile.Location = append(b.profile.Location, &googlev1.Location{
+		Id:        id,
+		MappingId: key.mappingID,
+		Address:   f.Address,
+		Line:      []*googlev1.Line{{FunctionId: b.functionID(f.Name, file)}},
+	})
+	b.locations[key] = id
+	return id
+}
+
+// AddSample adds a stack, leaf frame first, with one value per sample type.
+func (b *ProfileBuilder) AddSample(frames []Frame, values []int64, labels map[string]string) error {
+	if len(values) != len(b.profile.SampleType) {
+		return fmt.Errorf("sample has %d values, want %d", len(values), len(b.profile.SampleType))
+	}
+
+	sample := &googlev1.Sample{
+		LocationId: make([]uint64, 0, len(frames)),
+		Value:      values,
+	}
+
+	for i := range frames {
+		sample.LocationId = append(sample.LocationId, b.locationID(&frames[i]))
+	}
+
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		sample.Label = append(sample.Label, &googlev1.Label{
+			Key: b.stringIndex(k),
+			Str: b.stringIndex(labels[k]),
+		})
+	}
+
+	b.profile.Sample = append(b.profile.Sample, sample)
+	return nil
+}
+
+// Profile returns the pprof profile.
+func (b *ProfileBuilder) Profile() *googlev1.Profile {
+	return b.profile
+}
+
+// WriteGzip writes the gzipped profile.proto, which is what `go tool pprof`
+// and the pyroscope ingestion accept.
+func (b *ProfileBuilder) WriteGzip(w io.Writer) error {
+	return WriteProfileGzip(w, b.profile)
+}
+
+// WriteProfileGzip writes a gzipped profile.proto.
+func WriteProfileGzip(w io.Writer, profile *googlev1.Profile) error {
+	data, err := profile.MarshalVT()
+	if err != nil {
+		return fmt.Errorf("marshal profile: %w", err)
+	}
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(data); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// ReadProfileGzip reads a gzipped, or a plain, profile.proto.
+func ReadProfileGzip(r io.Reader) (*googlev1.Profile, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// gzip magic number
+	if len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+
+		if data, err = io.ReadAll(zr); err != nil {
+			return nil, err
+		}
+	}
+
+	profile := &googlev1.Profile{}
+	if err := profile.UnmarshalVT(data); err != nil {
+		return nil, fmt.Errorf("unmarshal profile: %w", err)
+	}
+
+	return profile, nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flamegraph
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestProfileBuilder(t *testing.T) {
+	b := NewProfileBuilder(&ProfileOption{
+		SampleTypes: []ValueType{{Type: "samples", Unit: "count"}},
+		PeriodType:  ValueType{Type: "cpu", Unit: "nanoseconds"},
+		Period:      10,
+	})
+
+	kernel := &Mapping{File: KernelMappingFile, BuildID: "abcd"}
+	stack := []Frame{
+		{Name: "do_syscall_64", Address: 0xffff1000, Mapping: kernel},
+		{Name: "main", Address: 0x401000, Mapping: &Mapping{Start: 0x400000, Limit: 0x500000, File: "/bin/app"}},
+		{Name: "app"},
+	}
+
+	if err := b.AddSample(stack, []int64{3}, map[string]string{"pid": "1", "comm": "app"}); err != nil {
+		t.Fatalf("AddSample() error=%v", err)
+	}
+	if err := b.AddSample(stack[1:], []int64{2}, nil); err != nil {
+		t.Fatalf("AddSample() error=%v", err)
+	}
+	if err := b.AddSample(stack, []int64{1, 2}, nil); err == nil {
+		t.Errorf("AddSample() with mismatched values should fail")
+	}
+
+	var buf bytes.Buffer
+	if err := b.WriteGzip(&buf); err != nil {
+		t.Fatalf("WriteGzip() error=%v", err)
+	}
+
+	p, err := ReadProfileGzip(&buf)
+	if err != nil {
+		t.Fatalf("ReadProfileGzip() error=%v", err)
+	}
+
+	if p.StringTable[0] != "" {
+		t.Errorf("string_table[0]=%q, want empty", p.StringTable[0])
+	}
+	if len(p.Sample) != 2 || len(p.Location) != 3 || len(p.Function) != 3 || len(p.Mapping) != 2 {
+		t.Fatalf("samples=%d locations=%d functions=%d mappings=%d, want 2 3 3 2",
+			len(p.Sample), len(p.Location), len(p.Function), len(p.Mapping))
+	}
+	if p.Period != 10 || p.StringTable[p.PeriodType.Type] != "cpu" {
+		t.Errorf("period=%d type=%q", p.Period, p.StringTable[p.PeriodType.Type])
+	}
+
+	// labels are sorted by key.
+	labels := p.Sample[0].Label
+	if len(labels) != 2 || p.StringTable[labels[0].Key] != "comm" || p.StringTable[labels[1].Str] != "1" {
+		t.Errorf("unexpected labels %v", labels)
+	}
+
+	// the shared frames are deduplicated.
+	if p.Sample[0].LocationId[1] != p.Sample[1].LocationId[0] {
+		t.Errorf("location of frame main is not shared: %v, %v", p.Sample[0].LocationId, p.Sample[1].LocationId)
+	}
+	if got := p.StringTable[p.Mapping[0].BuildId]; got != "abcd" {
+		t.Errorf("kernel build id=%q, want abcd", got)
+	}
+}
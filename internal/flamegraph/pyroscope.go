@@ -0,0 +1,123 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flamegraph
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PyroscopeSpyName is reported to pyroscope as the profiler name.
+const PyroscopeSpyName = "huatuo"
+
+// PyroscopePushRequest is a pprof profile pushed to the pyroscope /ingest API.
+type PyroscopePushRequest struct {
+	AppName    string
+	Labels     map[string]string
+	From       time.Time
+	Until      time.Time
+	SampleRate uint64
+	// Profile is the gzipped profile.proto.
+	Profile []byte
+}
+
+// PyroscopeClient pushes profiles to a pyroscope compatible server.
+type PyroscopeClient struct {
+	address string
+	client  *http.Client
+}
+
+// NewPyroscopeClient creates a client for the server address, e.g.
+// http://127.0.0.1:4040.
+func NewPyroscopeClient(address string, timeout time.Duration) *PyroscopeClient {
+	return &PyroscopeClient{
+		address: strings.TrimSuffix(address, "/"),
+		client:  &http.Client{Timeout: timeout},
+	}
+}
+
+// pyroscopeAppName builds the series name: app{key=value,...}.
+func pyroscopeAppName(app string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return app
+	}
+
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+labels[k])
+	}
+	return app + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Push sends the profile as a multipart form, the way the pyroscope
+// ingestion expects pprof data.
+func (c *PyroscopeClient) Push(ctx context.Context, req *PyroscopePushRequest) error {
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+
+	part, err := w.CreateFormFile("profile", "profile.pb.gz")
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(req.Profile); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	query.Set("name", pyroscopeAppName(req.AppName, req.Labels))
+	query.Set("from", strconv.FormatInt(req.From.Unix(), 10))
+	query.Set("until", strconv.FormatInt(req.Until.Unix(), 10))
+	query.Set("sampleRate", strconv.FormatUint(req.SampleRate, 10))
+	query.Set("spyName", PyroscopeSpyName)
+	query.Set("format", "pprof")
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		c.address+"/ingest?"+query.Encode(), body)
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", w.FormDataContentType())
+
+	resp, err := c.client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("push profile: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("push profile: status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
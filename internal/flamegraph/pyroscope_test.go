@@ -0,0 +1,75 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flamegraph
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPyroscopePush(t *testing.T) {
+	var (
+		gotName, gotFormat string
+		gotProfile         []byte
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ingest" {
+			http.NotFound(w, r)
+			return
+		}
+
+		gotName = r.URL.Query().Get("name")
+		gotFormat = r.URL.Query().Get("format")
+
+		f, _, err := r.FormFile("profile")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer f.Close()
+		gotProfile, _ = io.ReadAll(f)
+	}))
+	defer srv.Close()
+
+	c := NewPyroscopeClient(srv.URL+"/", time.Second)
+	err := c.Push(context.Background(), &PyroscopePushRequest{
+		AppName:    "huatuo.cpu",
+		Labels:     map[string]string{"hostname": "node1", "container_id": "abc"},
+		From:       time.Unix(100, 0),
+		Until:      time.Unix(160, 0),
+		SampleRate: 19,
+		Profile:    []byte("pprof"),
+	})
+	if err != nil {
+		t.Fatalf("Push() error=%v", err)
+	}
+
+	if want := "huatuo.cpu{container_id=abc,hostname=node1}"; gotName != want {
+		t.Errorf("name=%q, want %q", gotName, want)
+	}
+	if gotFormat != "pprof" || string(gotProfile) != "pprof" {
+		t.Errorf("format=%q profile=%q", gotFormat, gotProfile)
+	}
+
+	bad := NewPyroscopeClient(srv.URL+"/not-found", time.Second)
+	if err := bad.Push(context.Background(), &PyroscopePushRequest{AppName: "x"}); err == nil {
+		t.Errorf("Push() to a bad address should fail")
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flamegraph
+
+import (
+	"fmt"
+	"strconv"
+
+	ingestv1 "github.com/grafana/pyroscope/api/gen/proto/go/ingester/v1"
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+)
+
+// StackSample is an aggregated stack of a task.
+type StackSample struct {
+	// Names are the flamegraph labels, leaf first, the root is the comm.
+	Names []string
+	// Frames are the resolved frames for pprof, leaf first.
+	Frames []Frame
+	// Labels are the pprof sample labels, e.g. comm and pid.
+	Labels map[string]string
+	// Value is the weight in the flamegraph, e.g. samples or blocked time.
+	Value int64
+}
+
+func convertLevels(levels []*querierv1.Level) []*Level {
+	var result []*Level
+	for _, l := range levels {
+		newLevel := &Level{
+			Values: l.Values,
+		}
+		result = append(result, newLevel)
+	}
+	return result
+}
+
+func findOrAdd(strA string, b []string) (int, []string) {
+	var index int
+	found := false
+	for idxB, strB := range b {
+		if strA == strB {
+			index = idxB
+			found = true
+			break
+		}
+	}
+	if !found {
+		b = append(b, strA)
+		index = len(b) - 1
+	}
+
+	return index, b
+}
+
+// BuildFrameData merges the stack samples into the flamegraph json data.
+func BuildFrameData(samples []*StackSample) ([]FrameData, error) {
+	var stacktraces []*ingestv1.StacktraceSample
+	var functionNames []string
+
+	for _, s := range samples {
+		sample := &ingestv1.StacktraceSample{Value: s.Value}
+		for _, name := range s.Names {
+			var index int
+			index, functionNames = findOrAdd(name, functionNames)
+			sample.FunctionIds = append(sample.FunctionIds, int32(index))
+		}
+
+		stacktraces = append(stacktraces, sample)
+	}
+
+	// Convert data formats
+	m := phlaremodel.NewTreeMerger()
+	sm := phlaremodel.NewStackTraceMerger()
+
+	sm.MergeStackTraces(stacktraces, functionNames)
+	if sm.Size() > 0 {
+		if err := m.MergeTreeBytes(sm.TreeBytes(-1)); err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, fmt.Errorf("phlaremodel: Error parsing stack data")
+	}
+
+	flame := phlaremodel.NewFlameGraph(m.Tree(), -1)
+	convertedLevels := convertLevels(flame.Levels)
+	tree := LevelsToTree(convertedLevels, flame.Names)
+	frame, label := TreeToNestedSetDataFrame(tree, "")
+
+	level := *frame.Fields[0]
+	value := *frame.Fields[1]
+	self := *frame.Fields[2]
+	labelf := *frame.Fields[3]
+
+	var (
+		levelarr []int64
+		valuearr []int64
+		selfarr  []int64
+		labelarr []string
+	)
+
+	for i := 0; i < level.Len(); i++ {
+		levelarr = append(levelarr, level.At(i).(int64))
+	}
+
+	for i := 0; i < value.Len(); i++ {
+		valuearr = append(valuearr, value.At(i).(int64))
+	}
+
+	for i := 0; i < self.Len(); i++ {
+		selfarr = append(selfarr, self.At(i).(int64))
+	}
+
+	labelVmp := label.GetValuesMap()
+	keys := make([]string, len(labelVmp))
+	for k, v := range labelVmp {
+		keys[v] = k
+	}
+
+	for i := 0; i < labelf.Len(); i++ {
+		formattedNum := fmt.Sprintf("%d", labelf.At(i))
+		number, _ := strconv.ParseInt(formattedNum, 10, 64)
+		labelarr = append(labelarr, keys[number])
+	}
+
+	DataSize := len(levelarr)
+
+	if len(valuearr) != DataSize || len(selfarr) != DataSize || len(labelarr) != DataSize {
+		return nil, fmt.Errorf("Data length is not equal")
+	}
+
+	flameData := make([]FrameData, DataSize)
+	for i := 0; i < DataSize; i++ {
+		flameData[i] = FrameData{
+			Level: levelarr[i],
+			Value: valuearr[i],
+			Self:  selfarr[i],
+			Label: labelarr[i],
+		}
+	}
+
+	return flameData, nil
+}
+
+// BuildProfile builds the pprof profile of the stack samples, values returns
+// the sample values in the order of opt.SampleTypes.
+func BuildProfile(samples []*StackSample, opt *ProfileOption, values func(s *StackSample) []int64) (*ProfileBuilder, error) {
+	builder := NewProfileBuilder(opt)
+
+	for _, s := range samples {
+		if err := builder.AddSample(s.Frames, values(s), s.Labels); err != nil {
+			return nil, err
+		}
+	}
+
+	return builder, nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flamegraph
+
+import (
+	"huatuo-bamai/internal/symbol"
+)
+
+const kernelModule = "[kernel]"
+
+func kernelMapping(module string) *Mapping {
+	if module != kernelModule {
+		return &Mapping{File: module}
+	}
+
+	buildID, _ := symbol.KernelBuildID()
+	return &Mapping{File: KernelMappingFile, BuildID: buildID}
+}
+
+// KernelFrames resolves the kernel stack addresses, leaf first, into frames.
+func KernelFrames(addrs []uint64, maxDepth int) []Frame {
+	var frames []Frame
+
+	for i, addr := range addrs {
+		if addr == 0 || i >= maxDepth {
+			break
+		}
+
+		sym := symbol.KernelSymbol(addr)
+		if sym.Name == "" {
+			continue
+		}
+
+		frames = append(frames, Frame{
+			Name:    sym.Name,
+			Address: addr,
+			Mapping: kernelMapping(sym.Module),
+		})
+	}
+	return frames
+}
+
+// UserFrames resolves the user stack addresses of the pid, leaf first, into
+// frames. Addresses which can't be resolved are skipped.
+func UserFrames(u *symbol.Usym, addrs []uint64, pid uint32) []Frame {
+	var frames []Frame
+
+	for i, addr := range addrs {
+		if addr == 0 {
+			break
+		}
+
+		f := u.ResolveUstackFrame(addr, pid, i > 0)
+		if f.Name == "" {
+			continue
+		}
+
+		frame := Frame{Name: f.Name, Address: addr}
+		if m := f.Mapping; m != nil {
+			frame.Mapping = &Mapping{
+				Start:   m.Start,
+				Limit:   m.Limit,
+				Offset:  m.Offset,
+				File:    m.File,
+				BuildID: m.BuildID,
+			}
+		}
+		frames = append(frames, frame)
+	}
+	return frames
+}
@@ -0,0 +1,129 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kfunc
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+
+	"huatuo-bamai/internal/bpf"
+	"huatuo-bamai/internal/utils/bytesutil"
+)
+
+// Event is a captured call or return of the kernel function.
+type Event struct {
+	TimestampNs uint64         `json:"timestamp_ns"`
+	Probe       string         `json:"probe"`
+	PID         uint32         `json:"pid"`
+	TID         uint32         `json:"tid"`
+	Comm        string         `json:"comm"`
+	Css         uint64         `json:"-"`
+	ContainerID string         `json:"container_id,omitempty"`
+	Fields      map[string]any `json:"fields"`
+	StackID     int32          `json:"-"`
+	Stack       []string       `json:"stack,omitempty"`
+}
+
+// EventSize returns the size of the events.
+func (p *Program) EventSize() int {
+	return p.size
+}
+
+// Decode decodes the raw event.
+func (p *Program) Decode(raw []byte) (*Event, error) {
+	if len(raw) < p.size {
+		return nil, fmt.Errorf("event size %d, want %d", len(raw), p.size)
+	}
+
+	order := binary.NativeEndian
+	pidTgid := order.Uint64(raw[headerPidTgid:])
+	ret := order.Uint32(raw[headerRet:]) != 0
+
+	ev := &Event{
+		TimestampNs: order.Uint64(raw[headerTimestamp:]),
+		Probe:       "entry",
+		PID:         uint32(pidTgid >> 32),
+		TID:         uint32(pidTgid),
+		Comm:        bytesutil.ToStr(raw[headerComm : headerComm+16]),
+		Css:         order.Uint64(raw[headerCss:]),
+		StackID:     int32(order.Uint32(raw[headerStackID:])),
+		Fields:      make(map[string]any),
+	}
+	if ret {
+		ev.Probe = "return"
+	}
+
+	for _, f := range p.programFields(ret) {
+		ev.Fields[f.name] = f.decode(raw[f.slot:])
+	}
+	return ev, nil
+}
+
+// decode decodes the field in the slot, the fields in the registers are
+// always 8 bytes.
+func (f *field) decode(data []byte) any {
+	order := binary.NativeEndian
+
+	var v uint64
+	switch {
+	case f.kind == kindString:
+		return bytesutil.ToStr(data[:f.size])
+	case f.kind == kindBytes:
+		return hex.EncodeToString(data[:f.size])
+	case f.inRegister():
+		v = order.Uint64(data)
+	case f.size == 1:
+		v = uint64(data[0])
+	case f.size == 2:
+		v = uint64(order.Uint16(data))
+	case f.size == 4:
+		v = uint64(order.Uint32(data))
+	default:
+		v = order.Uint64(data)
+	}
+
+	// truncate the register to the size.
+	bits := uint(f.size * 8)
+	if bits < 64 {
+		v &= 1<<bits - 1
+	}
+
+	switch f.kind {
+	case kindBool:
+		return v != 0
+	case kindPointer:
+		return fmt.Sprintf("0x%x", v)
+	case kindInt:
+		if bits < 64 { // sign extend.
+			return int64(v<<(64-bits)) >> (64 - bits)
+		}
+		return int64(v)
+	default:
+		return v
+	}
+}
+
+// Dropped returns the events dropped by the rate limit.
+func Dropped(b bpf.BPF) (uint64, error) {
+	value, err := b.ReadMap(b.MapIDByName(rateMap), make([]byte, 4))
+	if err != nil {
+		return 0, err
+	}
+	if len(value) < rateValueSize {
+		return 0, fmt.Errorf("rate map value size %d", len(value))
+	}
+	return binary.NativeEndian.Uint64(value[rateDropped:]), nil
+}
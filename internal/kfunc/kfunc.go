@@ -0,0 +1,417 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package kfunc generates the bpf programs capturing the arguments of a
+// kernel function, the fields of the arguments are resolved by the BTF.
+package kfunc
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/cilium/ebpf/btf"
+)
+
+// The probe types.
+const (
+	ProbeKprobe = "kprobe"
+	ProbeFentry = "fentry"
+)
+
+const (
+	// maxFieldSize is the max size of a field, the larger ones are truncated.
+	maxFieldSize = 64
+	// maxEventSize is the max size of an event, it is built on the bpf stack.
+	maxEventSize = 448
+)
+
+// ErrInvalidField is returned when a field can't be resolved by the BTF.
+var ErrInvalidField = errors.New("invalid field")
+
+// Options are the options of the capture.
+type Options struct {
+	// Function is the kernel function.
+	Function string
+	// Probe is ProbeKprobe or ProbeFentry.
+	Probe string
+	// Return also captures the return of the function. The arguments are
+	// captured on the entry by kprobe, on the return by fentry (fexit).
+	Return bool
+	// Fields are the expressions of the fields to capture, the argument
+	// name or argN, or ret, followed by ->member and .member, e.g.
+	// sk->__sk_common.skc_dport.
+	Fields []string
+	// PID only captures the process, 0 for all.
+	PID uint32
+	// Css only captures the tasks of the cpu cgroup css, 0 for all.
+	Css uint64
+	// Stack captures the kernel stack.
+	Stack bool
+	// RateLimit is the max events per second, the others are dropped.
+	RateLimit uint32
+}
+
+type fieldKind int
+
+const (
+	kindUint fieldKind = iota
+	kindInt
+	kindBool
+	kindPointer
+	kindString
+	kindBytes
+)
+
+// field is a resolved field.
+type field struct {
+	name string
+	ret  bool
+	arg  int
+	// offsets are the offsets to read the field from the argument, the
+	// pointers are read at all but the last one. Empty is the argument.
+	offsets []uint32
+	size    int
+	kind    fieldKind
+	// slot is the offset in the event.
+	slot int
+}
+
+func (f *field) inRegister() bool {
+	return len(f.offsets) == 0
+}
+
+// Program is the capture of a kernel function.
+type Program struct {
+	opts   Options
+	nargs  int
+	fields []field
+	size   int
+
+	// the offsets to read the cpu cgroup css of the current task.
+	taskCgroups uint32
+	cpuSubsys   uint32
+}
+
+var fieldPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*((->|\.)[A-Za-z_][A-Za-z0-9_]*)*$`)
+
+// New resolves the options by the kernel BTF.
+func New(spec *btf.Spec, opts *Options) (*Program, error) {
+	switch opts.Probe {
+	case ProbeKprobe:
+		if _, ok := argRegisters[runtime.GOARCH]; !ok {
+			return nil, fmt.Errorf("kprobe on %s is not supported", runtime.GOARCH)
+		}
+	case ProbeFentry:
+	default:
+		return nil, fmt.Errorf("invalid probe %q", opts.Probe)
+	}
+
+	if opts.RateLimit == 0 {
+		return nil, fmt.Errorf("invalid rate limit 0")
+	}
+
+	proto, err := funcProto(spec, opts.Function)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Program{opts: *opts, nargs: len(proto.Params), size: eventHeaderSize}
+	if opts.Probe == ProbeKprobe && p.nargs > len(argRegisters[runtime.GOARCH]) {
+		return nil, fmt.Errorf("%s has %d arguments, kprobe reads up to %d", opts.Function, p.nargs, len(argRegisters[runtime.GOARCH]))
+	}
+
+	exprs := opts.Fields
+	if opts.Return && !hasRetField(exprs) && proto.Return != nil && !isVoid(proto.Return) {
+		exprs = append(slices.Clone(exprs), "ret")
+	}
+
+	seen := make(map[string]bool, len(exprs))
+	for _, expr := range exprs {
+		if seen[expr] {
+			return nil, fmt.Errorf("duplicate field %s: %w", expr, ErrInvalidField)
+		}
+		seen[expr] = true
+
+		f, err := resolveField(proto, expr)
+		if err != nil {
+			return nil, err
+		}
+		if f.ret && !opts.Return {
+			return nil, fmt.Errorf("field %s needs the return: %w", expr, ErrInvalidField)
+		}
+
+		f.slot = p.size
+		if f.inRegister() {
+			p.size += 8
+		} else {
+			p.size += (f.size + 7) &^ 7
+		}
+		if p.size > maxEventSize {
+			return nil, fmt.Errorf("fields exceed the max event size %d: %w", maxEventSize, ErrInvalidField)
+		}
+
+		p.fields = append(p.fields, *f)
+	}
+
+	if err := p.resolveCss(spec); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func hasRetField(exprs []string) bool {
+	for _, expr := range exprs {
+		if expr == "ret" || strings.HasPrefix(expr, "ret-") || strings.HasPrefix(expr, "ret.") {
+			return true
+		}
+	}
+	return false
+}
+
+func isVoid(t btf.Type) bool {
+	_, ok := btf.UnderlyingType(t).(*btf.Void)
+	return ok
+}
+
+// funcProto returns the prototype of the kernel function.
+func funcProto(spec *btf.Spec, name string) (*btf.FuncProto, error) {
+	types, err := spec.AnyTypesByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("function %s: %w", name, err)
+	}
+
+	for _, t := range types {
+		if fn, ok := t.(*btf.Func); ok {
+			if proto, ok := fn.Type.(*btf.FuncProto); ok {
+				return proto, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("function %s: %w", name, btf.ErrNotFound)
+}
+
+// splitField splits the expression into the root and the operators with the
+// members, e.g. "sk->__sk_common.skc_dport" is "sk" and ["->", "__sk_common",
+// ".", "skc_dport"].
+func splitField(expr string) (string, []string) {
+	i := strings.IndexAny(expr, "-.")
+	if i < 0 {
+		return expr, nil
+	}
+
+	root, rest := expr[:i], expr[i:]
+	var tokens []string
+	for rest != "" {
+		op := "."
+		if strings.HasPrefix(rest, "->") {
+			op = "->"
+		}
+		rest = rest[len(op):]
+
+		j := strings.IndexAny(rest, "-.")
+		if j < 0 {
+			j = len(rest)
+		}
+		tokens = append(tokens, op, rest[:j])
+		rest = rest[j:]
+	}
+	return root, tokens
+}
+
+// resolveField resolves the expression by the function prototype.
+func resolveField(proto *btf.FuncProto, expr string) (*field, error) {
+	if !fieldPattern.MatchString(expr) {
+		return nil, fmt.Errorf("field %q: %w", expr, ErrInvalidField)
+	}
+
+	root, tokens := splitField(expr)
+	f := &field{name: expr}
+
+	var typ btf.Type
+	switch {
+	case root == "ret":
+		f.ret = true
+		f.arg = len(proto.Params)
+		typ = proto.Return
+	default:
+		f.arg = -1
+		for i, param := range proto.Params {
+			if param.Name == root || root == "arg"+strconv.Itoa(i) {
+				f.arg = i
+				typ = param.Type
+				break
+			}
+		}
+		if f.arg < 0 {
+			return nil, fmt.Errorf("field %s: no argument %s: %w", expr, root, ErrInvalidField)
+		}
+	}
+
+	// the value is in the register, or in the memory at the offset.
+	var offset uint32
+	inMemory := false
+
+	for i := 0; i < len(tokens); i += 2 {
+		op, member := tokens[i], tokens[i+1]
+
+		if op == "->" {
+			ptr, ok := btf.UnderlyingType(typ).(*btf.Pointer)
+			if !ok {
+				return nil, fmt.Errorf("field %s: %s is not a pointer: %w", expr, typ, ErrInvalidField)
+			}
+			if inMemory { // read the pointer.
+				f.offsets = append(f.offsets, offset)
+			}
+			typ, offset, inMemory = ptr.Target, 0, true
+		} else if !inMemory {
+			return nil, fmt.Errorf("field %s: .%s of a non-pointer argument: %w", expr, member, ErrInvalidField)
+		}
+
+		m, bits, err := findMember(typ, member)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", expr, err)
+		}
+		offset += bits / 8
+		typ = m.Type
+	}
+
+	if inMemory {
+		f.offsets = append(f.offsets, offset)
+	}
+
+	kind, size, err := typeKind(typ)
+	if err != nil {
+		return nil, fmt.Errorf("field %s: %w", expr, err)
+	}
+	if !inMemory && (size > 8 || kind == kindString || kind == kindBytes) {
+		return nil, fmt.Errorf("field %s: argument by value of %s: %w", expr, typ, ErrInvalidField)
+	}
+	f.kind, f.size = kind, size
+	return f, nil
+}
+
+// findMember finds the member of the struct or the union, including the
+// members of the anonymous ones, the offset is in bits.
+func findMember(typ btf.Type, name string) (*btf.Member, uint32, error) {
+	var members []btf.Member
+	switch t := btf.UnderlyingType(typ).(type) {
+	case *btf.Struct:
+		members = t.Members
+	case *btf.Union:
+		members = t.Members
+	default:
+		return nil, 0, fmt.Errorf("%s is not a struct or a union: %w", typ, ErrInvalidField)
+	}
+
+	for i := range members {
+		m := &members[i]
+		if m.Name == name {
+			if m.BitfieldSize != 0 {
+				return nil, 0, fmt.Errorf("bitfield %s: %w", name, ErrInvalidField)
+			}
+			return m, uint32(m.Offset), nil
+		}
+	}
+
+	for i := range members {
+		m := &members[i]
+		if m.Name != "" {
+			continue
+		}
+		if inner, bits, err := findMember(m.Type, name); err == nil {
+			return inner, uint32(m.Offset) + bits, nil
+		}
+	}
+
+	return nil, 0, fmt.Errorf("no member %s in %s: %w", name, typ, ErrInvalidField)
+}
+
+// typeKind returns the kind and the size of the type.
+func typeKind(typ btf.Type) (fieldKind, int, error) {
+	switch t := btf.UnderlyingType(typ).(type) {
+	case *btf.Int:
+		switch {
+		case t.Encoding&btf.Bool != 0:
+			return kindBool, int(t.Size), nil
+		case t.Encoding&btf.Signed != 0:
+			return kindInt, int(t.Size), nil
+		default:
+			return kindUint, int(t.Size), nil
+		}
+	case *btf.Enum:
+		if t.Signed {
+			return kindInt, int(t.Size), nil
+		}
+		return kindUint, int(t.Size), nil
+	case *btf.Pointer:
+		return kindPointer, 8, nil
+	case *btf.Array:
+		size, err := btf.Sizeof(t)
+		if err != nil {
+			return 0, 0, err
+		}
+		if elem, ok := btf.UnderlyingType(t.Type).(*btf.Int); ok && elem.Size == 1 {
+			return kindString, min(size, maxFieldSize), nil
+		}
+		return kindBytes, min(size, maxFieldSize), nil
+	case *btf.Struct, *btf.Union:
+		size, err := btf.Sizeof(t)
+		if err != nil {
+			return 0, 0, err
+		}
+		return kindBytes, min(size, maxFieldSize), nil
+	default:
+		return 0, 0, fmt.Errorf("unsupported type %s: %w", typ, ErrInvalidField)
+	}
+}
+
+// resolveCss resolves the offsets of task_struct->cgroups->subsys[cpu_cgrp_id].
+func (p *Program) resolveCss(spec *btf.Spec) error {
+	var task *btf.Struct
+	if err := spec.TypeByName("task_struct", &task); err != nil {
+		return fmt.Errorf("task_struct: %w", err)
+	}
+	m, bits, err := findMember(task, "cgroups")
+	if err != nil {
+		return err
+	}
+	p.taskCgroups = bits / 8
+
+	ptr, ok := btf.UnderlyingType(m.Type).(*btf.Pointer)
+	if !ok {
+		return fmt.Errorf("task_struct.cgroups is not a pointer: %w", ErrInvalidField)
+	}
+	if _, bits, err = findMember(ptr.Target, "subsys"); err != nil {
+		return err
+	}
+	p.cpuSubsys = bits / 8
+
+	var subsys *btf.Enum
+	if err := spec.TypeByName("cgroup_subsys_id", &subsys); err != nil {
+		return fmt.Errorf("cgroup_subsys_id: %w", err)
+	}
+	for _, v := range subsys.Values {
+		if v.Name == "cpu_cgrp_id" {
+			p.cpuSubsys += uint32(v.Value) * 8
+			return nil
+		}
+	}
+	return fmt.Errorf("no cpu_cgrp_id: %w", btf.ErrNotFound)
+}
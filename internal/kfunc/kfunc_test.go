@@ -0,0 +1,285 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kfunc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"runtime"
+	"testing"
+
+	"huatuo-bamai/internal/bpf"
+
+	"github.com/cilium/ebpf/btf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testSpec returns the BTF of a tiny kernel:
+//
+//	struct proto { char name[32]; };
+//	struct sock_common {
+//		union { u64 skc_addrpair; struct { u32 skc_daddr; u32 skc_rcv_saddr; }; };
+//		u16 skc_dport;
+//		u16 skc_flags:4;
+//		struct proto *skc_prot;
+//	};
+//	struct sock { struct sock_common __sk_common; int sk_err; };
+//	struct css_set { void *subsys[4]; };
+//	struct task_struct { int pid; struct css_set *cgroups; };
+//	enum cgroup_subsys_id { cpuset_cgrp_id, cpu_cgrp_id };
+//	int tcp_v4_connect(struct sock *sk, void *uaddr, int addr_len);
+func testSpec(t *testing.T) *btf.Spec {
+	t.Helper()
+
+	u16 := &btf.Int{Name: "u16", Size: 2}
+	u32 := &btf.Int{Name: "u32", Size: 4}
+	u64 := &btf.Int{Name: "u64", Size: 8}
+	i32 := &btf.Int{Name: "int", Size: 4, Encoding: btf.Signed}
+	char := &btf.Int{Name: "char", Size: 1, Encoding: btf.Char}
+	voidPtr := &btf.Pointer{Target: &btf.Void{}}
+
+	proto := &btf.Struct{Name: "proto", Size: 32, Members: []btf.Member{
+		{Name: "name", Type: &btf.Array{Index: u32, Type: char, Nelems: 32}},
+	}}
+	addrs := &btf.Struct{Size: 8, Members: []btf.Member{
+		{Name: "skc_daddr", Type: u32},
+		{Name: "skc_rcv_saddr", Type: u32, Offset: 32},
+	}}
+	common := &btf.Struct{Name: "sock_common", Size: 24, Members: []btf.Member{
+		{Type: &btf.Union{Size: 8, Members: []btf.Member{
+			{Name: "skc_addrpair", Type: u64},
+			{Type: addrs},
+		}}},
+		{Name: "skc_dport", Type: u16, Offset: 64},
+		{Name: "skc_flags", Type: u16, Offset: 80, BitfieldSize: 4},
+		{Name: "skc_prot", Type: &btf.Pointer{Target: proto}, Offset: 128},
+	}}
+	sock := &btf.Struct{Name: "sock", Size: 32, Members: []btf.Member{
+		{Name: "__sk_common", Type: common},
+		{Name: "sk_err", Type: i32, Offset: 192},
+	}}
+	cssSet := &btf.Struct{Name: "css_set", Size: 32, Members: []btf.Member{
+		{Name: "subsys", Type: &btf.Array{Index: u32, Type: voidPtr, Nelems: 4}},
+	}}
+	task := &btf.Struct{Name: "task_struct", Size: 16, Members: []btf.Member{
+		{Name: "pid", Type: i32},
+		{Name: "cgroups", Type: &btf.Pointer{Target: cssSet}, Offset: 64},
+	}}
+	subsys := &btf.Enum{Name: "cgroup_subsys_id", Size: 4, Values: []btf.EnumValue{
+		{Name: "cpuset_cgrp_id", Value: 0},
+		{Name: "cpu_cgrp_id", Value: 1},
+	}}
+	fn := &btf.Func{Name: "tcp_v4_connect", Linkage: btf.GlobalFunc, Type: &btf.FuncProto{
+		Return: i32,
+		Params: []btf.FuncParam{
+			{Name: "sk", Type: &btf.Pointer{Target: sock}},
+			{Name: "uaddr", Type: voidPtr},
+			{Name: "addr_len", Type: i32},
+		},
+	}}
+
+	b, err := btf.NewBuilder([]btf.Type{fn, task, subsys})
+	require.NoError(t, err)
+	data, err := b.Marshal(nil, nil)
+	require.NoError(t, err)
+	spec, err := btf.LoadSpecFromReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	return spec
+}
+
+func TestSplitField(t *testing.T) {
+	tests := []struct {
+		expr   string
+		root   string
+		tokens []string
+	}{
+		{"sk", "sk", nil},
+		{"sk->sk_err", "sk", []string{"->", "sk_err"}},
+		{"sk->__sk_common.skc_prot->name", "sk", []string{"->", "__sk_common", ".", "skc_prot", "->", "name"}},
+	}
+
+	for _, tt := range tests {
+		root, tokens := splitField(tt.expr)
+		assert.Equal(t, tt.root, root, tt.expr)
+		assert.Equal(t, tt.tokens, tokens, tt.expr)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if runtime.GOARCH != "amd64" && runtime.GOARCH != "arm64" {
+		t.Skip("kprobe is not supported")
+	}
+
+	spec := testSpec(t)
+
+	p, err := New(spec, &Options{
+		Function: "tcp_v4_connect",
+		Probe:    ProbeKprobe,
+		Return:   true,
+		Fields: []string{
+			"sk->__sk_common.skc_dport",
+			"sk->__sk_common.skc_prot->name",
+			"sk->__sk_common.skc_rcv_saddr",
+			"arg1",
+			"addr_len",
+		},
+		RateLimit: 10,
+	})
+	require.NoError(t, err)
+
+	type want struct {
+		name    string
+		arg     int
+		offsets []uint32
+		size    int
+		kind    fieldKind
+		slot    int
+	}
+	wants := []want{
+		{"sk->__sk_common.skc_dport", 0, []uint32{8}, 2, kindUint, 48},
+		{"sk->__sk_common.skc_prot->name", 0, []uint32{16, 0}, 32, kindString, 56},
+		{"sk->__sk_common.skc_rcv_saddr", 0, []uint32{4}, 4, kindUint, 88},
+		{"arg1", 1, nil, 8, kindPointer, 96},
+		{"addr_len", 2, nil, 4, kindInt, 104},
+		{"ret", 3, nil, 4, kindInt, 112}, // added by the return.
+	}
+
+	require.Len(t, p.fields, len(wants))
+	for i, w := range wants {
+		f := p.fields[i]
+		assert.Equal(t, w, want{f.name, f.arg, f.offsets, f.size, f.kind, f.slot}, w.name)
+	}
+	assert.Equal(t, 120, p.EventSize())
+	assert.Equal(t, uint32(8), p.taskCgroups)
+	assert.Equal(t, uint32(8), p.cpuSubsys)
+
+	assert.Len(t, p.programFields(false), 5)
+	assert.Len(t, p.programFields(true), 1)
+}
+
+func TestNewInvalid(t *testing.T) {
+	spec := testSpec(t)
+
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{"probe", Options{Function: "tcp_v4_connect", Probe: "uprobe", RateLimit: 1}},
+		{"rate limit", Options{Function: "tcp_v4_connect", Probe: ProbeFentry}},
+		{"function", Options{Function: "tcp_v6_connect", Probe: ProbeFentry, RateLimit: 1}},
+		{"argument", Options{Function: "tcp_v4_connect", Probe: ProbeFentry, RateLimit: 1, Fields: []string{"skb"}}},
+		{"syntax", Options{Function: "tcp_v4_connect", Probe: ProbeFentry, RateLimit: 1, Fields: []string{"sk->"}}},
+		{"not pointer", Options{Function: "tcp_v4_connect", Probe: ProbeFentry, RateLimit: 1, Fields: []string{"addr_len->x"}}},
+		{"member", Options{Function: "tcp_v4_connect", Probe: ProbeFentry, RateLimit: 1, Fields: []string{"sk->sk_xxx"}}},
+		{"bitfield", Options{Function: "tcp_v4_connect", Probe: ProbeFentry, RateLimit: 1, Fields: []string{"sk->__sk_common.skc_flags"}}},
+		{"by value", Options{Function: "tcp_v4_connect", Probe: ProbeFentry, RateLimit: 1, Fields: []string{"sk.sk_err"}}},
+		{"return", Options{Function: "tcp_v4_connect", Probe: ProbeFentry, RateLimit: 1, Fields: []string{"ret"}}},
+		{"duplicate", Options{Function: "tcp_v4_connect", Probe: ProbeFentry, RateLimit: 1, Fields: []string{"sk", "sk"}}},
+	}
+
+	for _, tt := range tests {
+		_, err := New(spec, &tt.opts)
+		assert.Error(t, err, tt.name)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	spec := testSpec(t)
+
+	p, err := New(spec, &Options{
+		Function:  "tcp_v4_connect",
+		Probe:     ProbeFentry,
+		Return:    true,
+		Fields:    []string{"sk->__sk_common.skc_dport", "sk->__sk_common.skc_prot->name", "uaddr", "addr_len"},
+		RateLimit: 10,
+	})
+	require.NoError(t, err)
+
+	order := binary.NativeEndian
+	raw := make([]byte, p.EventSize())
+	order.PutUint64(raw[headerTimestamp:], 100)
+	order.PutUint64(raw[headerPidTgid:], 10<<32|11)
+	order.PutUint64(raw[headerCss:], 0xffff0001)
+	copy(raw[headerComm:], "curl")
+	order.PutUint32(raw[headerStackID:], uint32(0xffffffff))
+	order.PutUint32(raw[headerRet:], 1)
+	order.PutUint16(raw[48:], 443)
+	copy(raw[56:], "TCP\x00")
+	order.PutUint64(raw[88:], 0xffff8881)
+	order.PutUint64(raw[96:], 0xdeadbeeffffffff2) // -14 in the low 32 bits.
+	order.PutUint64(raw[104:], 0xfffffff5)        // -11
+
+	ev, err := p.Decode(raw)
+	require.NoError(t, err)
+	assert.Equal(t, &Event{
+		TimestampNs: 100,
+		Probe:       "return",
+		PID:         10,
+		TID:         11,
+		Comm:        "curl",
+		Css:         0xffff0001,
+		StackID:     -1,
+		Fields: map[string]any{
+			"sk->__sk_common.skc_dport":      uint64(443),
+			"sk->__sk_common.skc_prot->name": "TCP",
+			"uaddr":                          "0xffff8881",
+			"addr_len":                       int64(-14),
+			"ret":                            int64(-11),
+		},
+	}, ev)
+
+	_, err = p.Decode(raw[:10])
+	assert.Error(t, err)
+}
+
+func TestCollectionSpecLoad(t *testing.T) {
+	if runtime.GOARCH != "amd64" && runtime.GOARCH != "arm64" {
+		t.Skip("kprobe is not supported")
+	}
+
+	spec, err := bpf.KernelBTF()
+	if err != nil {
+		t.Skipf("no kernel btf: %v", err)
+	}
+	if err := bpf.NewManager(&bpf.Option{}); err != nil {
+		t.Skipf("no bpf: %v", err)
+	}
+	defer bpf.Close()
+
+	p, err := New(spec, &Options{
+		Function:  "tcp_v4_connect",
+		Probe:     ProbeKprobe,
+		Return:    true,
+		Fields:    []string{"sk->__sk_common.skc_dport", "sk->__sk_common.skc_prot->name", "addr_len"},
+		PID:       1,
+		Css:       0xffff0001,
+		Stack:     true,
+		RateLimit: 10,
+	})
+	require.NoError(t, err)
+
+	// the verifier accepts the programs.
+	b, err := bpf.LoadBpfFromSpec("kfunc_test", p.CollectionSpec(), nil)
+	require.NoError(t, err)
+	defer b.Close()
+
+	assert.NotZero(t, b.ProgIDByName(entryProgram))
+	assert.NotZero(t, b.ProgIDByName(returnProgram))
+
+	dropped, err := Dropped(b)
+	require.NoError(t, err)
+	assert.Zero(t, dropped)
+}
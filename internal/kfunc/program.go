@@ -0,0 +1,308 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kfunc
+
+import (
+	"runtime"
+
+	"huatuo-bamai/internal/bpf"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+)
+
+// The maps and the programs of the generated bpf.
+const (
+	EventsMap = "events"
+	StacksMap = "stacks"
+	rateMap   = "rate"
+
+	entryProgram  = "kfunc_entry"
+	returnProgram = "kfunc_return"
+)
+
+// The event header, the fields follow it:
+//
+//	struct {
+//		u64 timestamp;
+//		u64 pid_tgid;
+//		u64 css;
+//		char comm[16];
+//		s32 stack_id;
+//		u32 ret;
+//	};
+const (
+	headerTimestamp = 0
+	headerPidTgid   = 8
+	headerCss       = 16
+	headerComm      = 24
+	headerStackID   = 40
+	headerRet       = 44
+	eventHeaderSize = 48
+)
+
+// The rate limit state, the window is one second:
+//
+//	struct {
+//		u64 window_start;
+//		u64 count;
+//		u64 dropped;
+//	};
+const (
+	rateWindowStart = 0
+	rateCount       = 8
+	rateDropped     = 16
+	rateValueSize   = 24
+	rateWindowNs    = 1000000000
+)
+
+// argRegisters are the offsets of the arguments in the pt_regs of kprobe.
+var argRegisters = map[string][]int16{
+	"amd64": {112, 104, 96, 88, 72, 64}, // di, si, dx, cx, r8, r9
+	"arm64": {0, 8, 16, 24, 32, 40, 48, 56},
+}
+
+// retRegister is the offset of the return in the pt_regs of kretprobe.
+var retRegister = map[string]int16{
+	"amd64": 80, // ax
+	"arm64": 0,
+}
+
+// CollectionSpec generates the bpf, loaded by bpf.LoadBpfFromSpec.
+func (p *Program) CollectionSpec() *ebpf.CollectionSpec {
+	probeRead := asm.FnProbeReadKernel
+	if bpf.ProgramProbe(bpf.Kprobe, bpf.FnProbeReadKernel) != nil {
+		probeRead = asm.FnProbeRead
+	}
+
+	spec := &ebpf.CollectionSpec{
+		Maps: map[string]*ebpf.MapSpec{
+			EventsMap: {
+				Name:    EventsMap,
+				Type:    ebpf.PerfEventArray,
+				KeySize: 4, ValueSize: 4,
+			},
+			rateMap: {
+				Name:    rateMap,
+				Type:    ebpf.Array,
+				KeySize: 4, ValueSize: rateValueSize, MaxEntries: 1,
+			},
+		},
+		Programs: make(map[string]*ebpf.ProgramSpec),
+	}
+	if p.opts.Stack {
+		spec.Maps[StacksMap] = &ebpf.MapSpec{
+			Name:    StacksMap,
+			Type:    ebpf.StackTrace,
+			KeySize: 4, ValueSize: uint32(bpf.StackDepth()) * 8, MaxEntries: 1024,
+		}
+	}
+
+	addProgram := func(name string, ret bool, typ ebpf.ProgramType, attachType ebpf.AttachType, section string) {
+		spec.Programs[name] = &ebpf.ProgramSpec{
+			Name:         name,
+			Type:         typ,
+			AttachType:   attachType,
+			SectionName:  section + "/" + p.opts.Function,
+			License:      "GPL",
+			Instructions: p.instructions(name, ret, probeRead),
+		}
+		if typ == ebpf.Tracing {
+			spec.Programs[name].AttachTo = p.opts.Function
+		}
+	}
+
+	switch {
+	case p.opts.Probe == ProbeKprobe:
+		addProgram(entryProgram, false, ebpf.Kprobe, ebpf.AttachNone, "kprobe")
+		if p.opts.Return {
+			addProgram(returnProgram, true, ebpf.Kprobe, ebpf.AttachNone, "kretprobe")
+		}
+	case p.opts.Return:
+		addProgram(returnProgram, true, ebpf.Tracing, ebpf.AttachTraceFExit, "fexit")
+	default:
+		addProgram(entryProgram, false, ebpf.Tracing, ebpf.AttachTraceFEntry, "fentry")
+	}
+
+	return spec
+}
+
+// programFields returns the fields captured by the entry or the return.
+func (p *Program) programFields(ret bool) []field {
+	var fields []field
+	for _, f := range p.fields {
+		// fexit captures both of the arguments and the return.
+		if f.ret == ret || (ret && p.opts.Probe == ProbeFentry) {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// argLoad loads the argument of the field into dst.
+func (p *Program) argLoad(dst asm.Register, f *field) asm.Instruction {
+	switch {
+	case p.opts.Probe == ProbeFentry: // the arguments, then the return.
+		return asm.LoadMem(dst, asm.R6, int16(f.arg*8), asm.DWord)
+	case f.ret:
+		return asm.LoadMem(dst, asm.R6, retRegister[runtime.GOARCH], asm.DWord)
+	default:
+		return asm.LoadMem(dst, asm.R6, argRegisters[runtime.GOARCH][f.arg], asm.DWord)
+	}
+}
+
+// instructions generates the program, R6 is the context, the event is on
+// the stack at -size, the key of the rate map follows it.
+func (p *Program) instructions(name string, ret bool, probeRead asm.BuiltinFunc) asm.Instructions {
+	event := int16(-p.size)
+	rateKey := event - 8
+
+	// probeReadInto reads size bytes at R3 into the event at offset.
+	probeReadInto := func(offset int, size int32) asm.Instructions {
+		return asm.Instructions{
+			asm.Mov.Reg(asm.R1, asm.RFP),
+			asm.Add.Imm(asm.R1, int32(event)+int32(offset)),
+			asm.Mov.Imm(asm.R2, size),
+			probeRead.Call(),
+		}
+	}
+
+	insns := asm.Instructions{
+		asm.Mov.Reg(asm.R6, asm.R1).WithSymbol(name),
+	}
+
+	// zero the event, the helpers only read the initialized stack.
+	for off := 0; off < p.size; off += 8 {
+		insns = append(insns, asm.StoreImm(asm.RFP, event+int16(off), 0, asm.DWord))
+	}
+	insns = append(insns, asm.StoreImm(asm.RFP, rateKey, 0, asm.DWord))
+
+	// pid_tgid and the pid filter.
+	insns = append(insns,
+		asm.FnGetCurrentPidTgid.Call(),
+		asm.StoreMem(asm.RFP, event+headerPidTgid, asm.R0, asm.DWord),
+	)
+	if p.opts.PID != 0 {
+		insns = append(insns,
+			asm.RSh.Imm(asm.R0, 32),
+			asm.JNE.Imm(asm.R0, int32(p.opts.PID), "exit"),
+		)
+	}
+
+	// task->cgroups->subsys[cpu_cgrp_id] and the css filter.
+	insns = append(insns,
+		asm.FnGetCurrentTask.Call(),
+		asm.Mov.Reg(asm.R3, asm.R0),
+		asm.Add.Imm(asm.R3, int32(p.taskCgroups)),
+	)
+	insns = append(insns, probeReadInto(headerCss, 8)...)
+	insns = append(insns,
+		asm.LoadMem(asm.R3, asm.RFP, event+headerCss, asm.DWord),
+		asm.Add.Imm(asm.R3, int32(p.cpuSubsys)),
+	)
+	insns = append(insns, probeReadInto(headerCss, 8)...)
+	if p.opts.Css != 0 {
+		insns = append(insns,
+			asm.LoadMem(asm.R1, asm.RFP, event+headerCss, asm.DWord),
+			asm.LoadImm(asm.R2, int64(p.opts.Css), asm.DWord),
+			asm.JNE.Reg(asm.R1, asm.R2, "exit"),
+		)
+	}
+
+	// the rate limit, R8 is the state.
+	insns = append(insns,
+		asm.LoadMapPtr(asm.R1, 0).WithReference(rateMap),
+		asm.Mov.Reg(asm.R2, asm.RFP),
+		asm.Add.Imm(asm.R2, int32(rateKey)),
+		asm.FnMapLookupElem.Call(),
+		asm.JEq.Imm(asm.R0, 0, "exit"),
+		asm.Mov.Reg(asm.R8, asm.R0),
+		asm.FnKtimeGetNs.Call(),
+		asm.StoreMem(asm.RFP, event+headerTimestamp, asm.R0, asm.DWord),
+		asm.LoadMem(asm.R1, asm.R8, rateWindowStart, asm.DWord),
+		asm.Mov.Reg(asm.R2, asm.R0),
+		asm.Sub.Reg(asm.R2, asm.R1),
+		asm.JLT.Imm(asm.R2, rateWindowNs, "rate_check"),
+		asm.StoreMem(asm.R8, rateWindowStart, asm.R0, asm.DWord),
+		asm.StoreImm(asm.R8, rateCount, 0, asm.DWord),
+		asm.LoadMem(asm.R1, asm.R8, rateCount, asm.DWord).WithSymbol("rate_check"),
+		asm.JLT.Imm(asm.R1, int32(p.opts.RateLimit), "rate_pass"),
+		asm.Mov.Reg(asm.R1, asm.R8),
+		asm.Add.Imm(asm.R1, rateDropped),
+		asm.Mov.Imm(asm.R2, 1),
+		asm.StoreXAdd(asm.R1, asm.R2, asm.DWord),
+		asm.Ja.Label("exit"),
+		asm.Mov.Reg(asm.R1, asm.R8).WithSymbol("rate_pass"),
+		asm.Add.Imm(asm.R1, rateCount),
+		asm.Mov.Imm(asm.R2, 1),
+		asm.StoreXAdd(asm.R1, asm.R2, asm.DWord),
+	)
+
+	// comm, the stack and the probe.
+	insns = append(insns,
+		asm.Mov.Reg(asm.R1, asm.RFP),
+		asm.Add.Imm(asm.R1, int32(event+headerComm)),
+		asm.Mov.Imm(asm.R2, 16),
+		asm.FnGetCurrentComm.Call(),
+	)
+	if p.opts.Stack {
+		insns = append(insns,
+			asm.Mov.Reg(asm.R1, asm.R6),
+			asm.LoadMapPtr(asm.R2, 0).WithReference(StacksMap),
+			asm.Mov.Imm(asm.R3, 0),
+			asm.FnGetStackid.Call(),
+			asm.StoreMem(asm.RFP, event+headerStackID, asm.R0, asm.Word),
+		)
+	} else {
+		insns = append(insns, asm.StoreImm(asm.RFP, event+headerStackID, -1, asm.Word))
+	}
+	if ret {
+		insns = append(insns, asm.StoreImm(asm.RFP, event+headerRet, 1, asm.Word))
+	}
+
+	// the fields.
+	for _, f := range p.programFields(ret) {
+		insns = append(insns, p.argLoad(asm.R3, &f))
+		if f.inRegister() {
+			insns = append(insns, asm.StoreMem(asm.RFP, event+int16(f.slot), asm.R3, asm.DWord))
+			continue
+		}
+
+		// read the pointers, then the field.
+		last := len(f.offsets) - 1
+		for _, off := range f.offsets[:last] {
+			insns = append(insns, asm.Add.Imm(asm.R3, int32(off)))
+			insns = append(insns, probeReadInto(f.slot, 8)...)
+			insns = append(insns, asm.LoadMem(asm.R3, asm.RFP, event+int16(f.slot), asm.DWord))
+		}
+		insns = append(insns, asm.Add.Imm(asm.R3, int32(f.offsets[last])))
+		insns = append(insns, probeReadInto(f.slot, int32(f.size))...)
+	}
+
+	// output the event.
+	insns = append(insns,
+		asm.Mov.Reg(asm.R1, asm.R6),
+		asm.LoadMapPtr(asm.R2, 0).WithReference(EventsMap),
+		asm.LoadImm(asm.R3, 0xffffffff, asm.DWord), // BPF_F_CURRENT_CPU
+		asm.Mov.Reg(asm.R4, asm.RFP),
+		asm.Add.Imm(asm.R4, int32(event)),
+		asm.Mov.Imm(asm.R5, int32(p.size)),
+		asm.FnPerfEventOutput.Call(),
+		asm.Mov.Imm(asm.R0, 0).WithSymbol("exit"),
+		asm.Return(),
+	)
+
+	return insns
+}
@@ -90,6 +90,12 @@ func (ctx *Context) ProtoBuf(code int, obj any) {
 	ctx.c.ProtoBuf(code, obj)
 }
 
+// FileAttachment writes the file at filepath into the body as a download
+// named filename.
+func (ctx *Context) FileAttachment(filepath, filename string) {
+	ctx.c.FileAttachment(filepath, filename)
+}
+
 func (ctx *Context) Status(code int) {
 	ctx.c.Status(code)
 }
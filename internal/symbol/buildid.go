@@ -0,0 +1,112 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package symbol
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync"
+)
+
+const noteTypeGNUBuildID = 3
+
+var (
+	kernelNotesPath  = "/sys/kernel/notes"
+	kernelBuildID    string
+	kernelBuildIDErr error
+	kernelBuildOnce  sync.Once
+)
+
+// parseBuildIDNote walks the ELF notes in data and returns the hex encoded
+// GNU build ID.
+func parseBuildIDNote(data []byte, order binary.ByteOrder) (string, error) {
+	for len(data) >= 12 {
+		nameSize := order.Uint32(data[0:4])
+		descSize := order.Uint32(data[4:8])
+		noteType := order.Uint32(data[8:12])
+		data = data[12:]
+
+		nameEnd := align4(nameSize)
+		descEnd := nameEnd + align4(descSize)
+		if uint64(len(data)) < uint64(nameEnd)+uint64(descSize) {
+			break
+		}
+
+		name := bytes.TrimRight(data[:nameSize], "\x00")
+		if noteType == noteTypeGNUBuildID && string(name) == "GNU" {
+			return hex.EncodeToString(data[nameEnd : nameEnd+descSize]), nil
+		}
+
+		if uint64(len(data)) < uint64(descEnd) {
+			break
+		}
+		data = data[descEnd:]
+	}
+
+	return "", fmt.Errorf("build id note not found")
+}
+
+func align4(n uint32) uint32 {
+	return (n + 3) &^ 3
+}
+
+// ELFFileBuildID returns the GNU build ID of an opened ELF file.
+func ELFFileBuildID(f *elf.File) (string, error) {
+	for _, s := range f.Sections {
+		if s.Type != elf.SHT_NOTE {
+			continue
+		}
+
+		data, err := s.Data()
+		if err != nil {
+			continue
+		}
+
+		if id, err := parseBuildIDNote(data, f.ByteOrder); err == nil {
+			return id, nil
+		}
+	}
+
+	return "", fmt.Errorf("build id not found")
+}
+
+// ELFBuildID returns the GNU build ID of the ELF file in path.
+func ELFBuildID(path string) (string, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	return ELFFileBuildID(f)
+}
+
+// KernelBuildID returns the build ID of the running kernel.
+func KernelBuildID() (string, error) {
+	kernelBuildOnce.Do(func() {
+		data, err := os.ReadFile(kernelNotesPath)
+		if err != nil {
+			kernelBuildIDErr = err
+			return
+		}
+		kernelBuildID, kernelBuildIDErr = parseBuildIDNote(data, binary.NativeEndian)
+	})
+
+	return kernelBuildID, kernelBuildIDErr
+}
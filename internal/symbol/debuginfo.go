@@ -0,0 +1,119 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package symbol
+
+import (
+	"bytes"
+	"debug/elf"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// debugDir is the global debug directory, the same as gdb and perf.
+var debugDir = "/usr/lib/debug"
+
+// debugLink parses the .gnu_debuglink section: the file name of the
+// separate debuginfo and the crc32 of that file.
+func debugLink(f *elf.File) (name string, crc uint32, ok bool) {
+	s := f.Section(".gnu_debuglink")
+	if s == nil {
+		return "", 0, false
+	}
+
+	data, err := s.Data()
+	if err != nil {
+		return "", 0, false
+	}
+
+	end := bytes.IndexByte(data, 0)
+	if end <= 0 {
+		return "", 0, false
+	}
+
+	// the crc is 4 bytes aligned after the file name.
+	off := int(align4(uint32(end + 1)))
+	if off+4 > len(data) {
+		return "", 0, false
+	}
+
+	return string(data[:end]), f.ByteOrder.Uint32(data[off : off+4]), true
+}
+
+func fileCRC32(path string) (uint32, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	h := crc32.NewIEEE()
+	if _, err := io.Copy(h, file); err != nil {
+		return 0, err
+	}
+	return h.Sum32(), nil
+}
+
+func isFile(path string) bool {
+	st, err := os.Stat(path)
+	return err == nil && st.Mode().IsRegular()
+}
+
+// findDebugFile returns the separate debuginfo file of the binary path in the
+// root filesystem, or "" if there isn't any. The lookup order is the same as
+// gdb: the build id under the debug directory, then the .gnu_debuglink in the
+// binary directory, the .debug subdirectory and the debug directory. The
+// files in the root filesystem are preferred, then the host ones.
+func findDebugFile(root, path, buildID string, f *elf.File) string {
+	roots := []string{root}
+	if root != "" && root != "/" {
+		roots = append(roots, "/")
+	}
+
+	if len(buildID) > 2 {
+		for _, r := range roots {
+			p := filepath.Join(r, debugDir, ".build-id", buildID[:2], buildID[2:]+".debug")
+			if isFile(p) {
+				return p
+			}
+		}
+	}
+
+	name, crc, ok := debugLink(f)
+	if !ok {
+		return ""
+	}
+
+	dir := filepath.Dir(path)
+	for _, r := range roots {
+		for _, p := range []string{
+			filepath.Join(r, dir, name),
+			filepath.Join(r, dir, ".debug", name),
+			filepath.Join(r, debugDir, dir, name),
+		} {
+			// the debuglink may be the binary itself.
+			if p == filepath.Join(root, path) || !isFile(p) {
+				continue
+			}
+
+			if sum, err := fileCRC32(p); err == nil && sum == crc {
+				return p
+			}
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,215 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package symbol
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"fmt"
+	"sync"
+)
+
+// maxLineCacheSize is the max number of the addresses whose source lines are
+// cached per binary.
+const maxLineCacheSize = 65536
+
+// SourceLine is the source location of an address. The address in the inlined
+// code has several, the innermost function first.
+type SourceLine struct {
+	Function string `json:"function"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Inlined  bool   `json:"inlined,omitempty"`
+}
+
+func (l SourceLine) String() string {
+	return fmt.Sprintf("%s:%d", l.File, l.Line)
+}
+
+// lineTable resolves the addresses of a binary to the source lines by the
+// DWARF debuginfo.
+type lineTable struct {
+	mu    sync.Mutex
+	data  *dwarf.Data
+	cache map[uint64][]SourceLine
+}
+
+func newLineTable(f *elf.File) (*lineTable, error) {
+	data, err := f.DWARF()
+	if err != nil {
+		return nil, err
+	}
+
+	return &lineTable{data: data, cache: map[uint64][]SourceLine{}}, nil
+}
+
+func openLineTable(path string) (*lineTable, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	// the dwarf data is read into the memory, the file can be closed.
+	defer f.Close()
+
+	return newLineTable(f)
+}
+
+// lookup returns the source lines of the pc, innermost first.
+func (t *lineTable) lookup(pc uint64) []SourceLine {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if lines, ok := t.cache[pc]; ok {
+		return lines
+	}
+
+	lines := t.resolve(pc)
+	if len(t.cache) >= maxLineCacheSize {
+		clear(t.cache)
+	}
+	t.cache[pc] = lines
+	return lines
+}
+
+func (t *lineTable) resolve(pc uint64) []SourceLine {
+	r := t.data.Reader()
+	cu, err := r.SeekPC(pc)
+	if err != nil {
+		return nil
+	}
+
+	lr, err := t.data.LineReader(cu)
+	if err != nil || lr == nil {
+		return nil
+	}
+
+	var entry dwarf.LineEntry
+	if err := lr.SeekPC(pc, &entry); err != nil {
+		return nil
+	}
+
+	line := SourceLine{Line: entry.Line}
+	if entry.File != nil {
+		line.File = entry.File.Name
+	}
+
+	// the scopes which contain the pc: the function and the inlined
+	// functions, outermost first.
+	scopes, _ := t.findScopes(r, pc, nil)
+	if len(scopes) == 0 {
+		return []SourceLine{line}
+	}
+
+	files := lr.Files()
+	lines := make([]SourceLine, 0, len(scopes))
+	for i := len(scopes) - 1; i >= 0; i-- {
+		line.Function = t.entryName(scopes[i])
+		line.Inlined = i > 0
+		lines = append(lines, line)
+
+		// the caller line is the call site of the inlined function.
+		line = SourceLine{}
+		if idx, ok := scopes[i].Val(dwarf.AttrCallFile).(int64); ok && idx >= 0 && int(idx) < len(files) && files[idx] != nil {
+			line.File = files[idx].Name
+		}
+		if n, ok := scopes[i].Val(dwarf.AttrCallLine).(int64); ok {
+			line.Line = int(n)
+		}
+	}
+
+	return lines
+}
+
+func (t *lineTable) containsPC(e *dwarf.Entry, pc uint64) bool {
+	ranges, err := t.data.Ranges(e)
+	if err != nil {
+		return false
+	}
+
+	for _, r := range ranges {
+		if pc >= r[0] && pc < r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// findScopes walks the sibling entries of the reader for the ones which
+// contain the pc, and the children of them recursively.
+func (t *lineTable) findScopes(r *dwarf.Reader, pc uint64, scopes []*dwarf.Entry) ([]*dwarf.Entry, bool) {
+	for {
+		e, err := r.Next()
+		if err != nil || e == nil || e.Tag == 0 {
+			return scopes, false
+		}
+
+		switch e.Tag {
+		case dwarf.TagSubprogram, dwarf.TagInlinedSubroutine, dwarf.TagLexDwarfBlock:
+			if !t.containsPC(e, pc) {
+				break
+			}
+
+			if e.Tag != dwarf.TagLexDwarfBlock {
+				scopes = append(scopes, e)
+			}
+			if e.Children {
+				scopes, _ = t.findScopes(r, pc, scopes)
+			}
+			return scopes, true
+		case dwarf.TagNamespace, dwarf.TagModule, dwarf.TagClassType, dwarf.TagStructType:
+			// the functions of c++ may be in the namespaces and classes.
+			if e.Children {
+				if found, ok := t.findScopes(r, pc, scopes); ok {
+					return found, true
+				}
+			}
+			continue
+		}
+
+		if e.Children {
+			r.SkipChildren()
+		}
+	}
+}
+
+// entryName returns the name of the function entry, the inlined functions
+// and the out-of-line definitions refer to the declarations.
+func (t *lineTable) entryName(e *dwarf.Entry) string {
+	for range 4 {
+		if name, ok := e.Val(dwarf.AttrLinkageName).(string); ok {
+			return Demangle(name)
+		}
+		if name, ok := e.Val(dwarf.AttrName).(string); ok {
+			return name
+		}
+
+		off, ok := e.Val(dwarf.AttrAbstractOrigin).(dwarf.Offset)
+		if !ok {
+			if off, ok = e.Val(dwarf.AttrSpecification).(dwarf.Offset); !ok {
+				return ""
+			}
+		}
+
+		r := t.data.Reader()
+		r.Seek(off)
+		next, err := r.Next()
+		if err != nil || next == nil {
+			return ""
+		}
+		e = next
+	}
+
+	return ""
+}
@@ -0,0 +1,133 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package symbol
+
+import (
+	"debug/elf"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+const lineTableSource = `volatile int sink;
+
+static inline __attribute__((always_inline)) void inner(int v)
+{
+	sink = v * 3;
+}
+
+__attribute__((noinline)) void outer(int v)
+{
+	inner(v);
+	sink += 1;
+}
+
+int main(void)
+{
+	outer(1);
+	return 0;
+}
+`
+
+func buildLineTableBinary(t *testing.T) string {
+	cc, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("cc not found")
+	}
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.c")
+	if err := os.WriteFile(src, []byte(lineTableSource), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	bin := filepath.Join(dir, "main")
+	if out, err := exec.Command(cc, "-g", "-O1", "-o", bin, src).CombinedOutput(); err != nil {
+		t.Skipf("cc: %v: %s", err, out)
+	}
+	return bin
+}
+
+func TestLineTable(t *testing.T) {
+	bin := buildLineTableBinary(t)
+
+	f, err := elf.Open(bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	syms, _ := elfSymbols(f)
+	var outer symbol
+	for _, s := range syms {
+		if s.name == "outer" {
+			outer = s
+		}
+	}
+	if outer.size == 0 {
+		t.Fatalf("symbol outer not found")
+	}
+
+	table, err := openLineTable(bin)
+	if err != nil {
+		t.Fatalf("openLineTable() error=%v", err)
+	}
+
+	var inlined, plain []SourceLine
+	for pc := outer.start; pc < outer.start+outer.size; pc++ {
+		lines := table.lookup(pc)
+		switch {
+		case len(lines) == 2 && inlined == nil:
+			inlined = lines
+		case len(lines) == 1 && lines[0].Line == 11:
+			plain = lines
+		}
+	}
+
+	if inlined == nil {
+		t.Fatalf("no inlined frame in outer")
+	}
+	if inlined[0].Function != "inner" || !inlined[0].Inlined || inlined[0].Line != 5 || filepath.Base(inlined[0].File) != "main.c" {
+		t.Errorf("unexpected inlined line %+v", inlined[0])
+	}
+	if inlined[1].Function != "outer" || inlined[1].Inlined || inlined[1].Line != 10 {
+		t.Errorf("unexpected caller line %+v", inlined[1])
+	}
+	if plain == nil || plain[0].Function != "outer" {
+		t.Errorf("unexpected line of outer %+v", plain)
+	}
+
+	if lines := table.lookup(0); len(lines) != 0 {
+		t.Errorf("lookup(0)=%+v, want none", lines)
+	}
+}
+
+func TestSymbolTableSourceLines(t *testing.T) {
+	bin := buildLineTableBinary(t)
+
+	table, err := loadSymbolTable("", bin)
+	if err != nil {
+		t.Fatalf("loadSymbolTable() error=%v", err)
+	}
+	if table.dwarfPath != bin {
+		t.Errorf("dwarfPath=%q, want %q", table.dwarfPath, bin)
+	}
+
+	// the source lines are disabled by default.
+	if lines := table.sourceLines(table.symbols[0].start); lines != nil {
+		t.Errorf("sourceLines()=%+v, want nil when disabled", lines)
+	}
+}
@@ -43,6 +43,17 @@ const (
 // Stack is record backtrace
 type Stack struct {
 	BackTrace []string `json:"back_trace"`
+	// Frames is the source lines of the backtrace, only when the source
+	// lines are enabled.
+	Frames []StackFrame `json:"frames,omitempty"`
+}
+
+// StackFrame is a frame of the backtrace with the source lines, the inlined
+// functions first.
+type StackFrame struct {
+	Addr   uint64       `json:"addr"`
+	Symbol string       `json:"symbol"`
+	Lines  []SourceLine `json:"lines,omitempty"`
 }
 
 // Symbol is record kernel symbol info
@@ -151,8 +162,33 @@ func ksymbolSearch(key uint64) Symbol {
 	return ksymbolCache[i-1]
 }
 
+func kernelSymbolByName(name string) (Symbol, bool) {
+	ksymbolLock.Lock()
+	defer ksymbolLock.Unlock()
+
+	if !ksymbolIsInit {
+		_ = loadKAllSymbols()
+	}
+
+	for i := 0; i < ksymbolCounter; i++ {
+		if ksymbolCache[i].Name == name && ksymbolCache[i].Module == moduleKernel {
+			return ksymbolCache[i], true
+		}
+	}
+	return Symbol{}, false
+}
+
+// KernelSymbol returns the kernel symbol the address belongs to.
+func KernelSymbol(addr uint64) Symbol {
+	return ksymbolSearch(addr)
+}
+
 // DumpKernelBackTrace converts the kernel stack address to the kernel symbol
-// and returns the Stack structure
+// and returns the Stack structure. When the source lines are enabled, the
+// frame is followed by the source line, and the inlined functions are
+// expanded before it, e.g.
+//
+//	tcp_v4_rcv/+1234 [kernel] net/ipv4/tcp_ipv4.c:2210
 func DumpKernelBackTrace(stack []uint64, maxDepth int) Stack {
 	var s Stack
 
@@ -161,11 +197,33 @@ func DumpKernelBackTrace(stack []uint64, maxDepth int) Stack {
 			break
 		}
 		sym := ksymbolSearch(addr)
-		if sym.Name != "" {
-			offset := addr - sym.Addr
-			s.BackTrace = append(s.BackTrace, fmt.Sprintf("%s/+%d %s",
-				sym.Name, offset, sym.Module))
+		if sym.Name == "" {
+			continue
+		}
+
+		offset := addr - sym.Addr
+		frame := fmt.Sprintf("%s/+%d %s", sym.Name, offset, sym.Module)
+
+		// the callers are the return addresses, the call instruction is
+		// before them.
+		pc := addr
+		if i > 0 {
+			pc--
+		}
+
+		lines := kernelSourceLines(pc, sym)
+		if SourceLinesEnabled() {
+			s.Frames = append(s.Frames, StackFrame{Addr: addr, Symbol: sym.Name, Lines: lines})
+		}
+		if len(lines) == 0 {
+			s.BackTrace = append(s.BackTrace, frame)
+			continue
+		}
+
+		for _, l := range lines[:len(lines)-1] {
+			s.BackTrace = append(s.BackTrace, fmt.Sprintf("%s (inlined) %s", l.Function, l))
 		}
+		s.BackTrace = append(s.BackTrace, fmt.Sprintf("%s %s", frame, lines[len(lines)-1]))
 	}
 	return s
 }
@@ -0,0 +1,276 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package symbol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"huatuo-bamai/internal/log"
+	"huatuo-bamai/internal/procfs"
+)
+
+const (
+	jitdumpMagic        = 0x4A695444 // "JiTD"
+	jitdumpHeaderSize   = 40
+	jitdumpRecordHeader = 16
+
+	jitCodeLoad = 0
+	jitCodeMove = 1
+
+	// jitRefreshInterval is the min interval to reload the changed perf
+	// map and jitdump files of a process on the address misses.
+	jitRefreshInterval = time.Second
+)
+
+// jitdumpFileRe matches the jitdump file which the JIT runtime mmaps as the
+// marker for perf, e.g. /tmp/jit-1234.dump.
+var jitdumpFileRe = regexp.MustCompile(`/jit-\d+\.dump$`)
+
+func isJitdumpFile(path string) bool {
+	return jitdumpFileRe.MatchString(path)
+}
+
+// parsePerfMap parses the perf map file, /tmp/perf-<pid>.map, written by the
+// JIT runtimes, e.g. java with perf-map-agent and node --perf-basic-prof.
+// Every line is "START SIZE name", START and SIZE are hex.
+func parsePerfMap(r io.Reader) []symbol {
+	var symbols []symbol
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		fields := strings.SplitN(strings.TrimSpace(scanner.Text()), " ", 3)
+		if len(fields) != 3 {
+			continue
+		}
+
+		start, err := strconv.ParseUint(strings.TrimPrefix(fields[0], "0x"), 16, 64)
+		if err != nil {
+			continue
+		}
+		size, err := strconv.ParseUint(strings.TrimPrefix(fields[1], "0x"), 16, 64)
+		if err != nil {
+			continue
+		}
+
+		symbols = append(symbols, symbol{name: fields[2], start: start, size: size})
+	}
+
+	return symbols
+}
+
+// parseJitdump parses the jitdump file of the JIT runtime, the format is in
+// tools/perf/Documentation/jitdump-specification.txt of the kernel. Only
+// the code load and move records are used.
+func parseJitdump(data []byte) ([]symbol, error) {
+	if len(data) < jitdumpHeaderSize {
+		return nil, errors.New("jitdump: short header")
+	}
+
+	var order binary.ByteOrder = binary.LittleEndian
+	switch {
+	case binary.LittleEndian.Uint32(data) == jitdumpMagic:
+	case binary.BigEndian.Uint32(data) == jitdumpMagic:
+		order = binary.BigEndian
+	default:
+		return nil, errors.New("jitdump: bad magic")
+	}
+
+	headerSize := order.Uint32(data[8:12])
+	if headerSize < jitdumpHeaderSize || int(headerSize) > len(data) {
+		return nil, fmt.Errorf("jitdump: bad header size %d", headerSize)
+	}
+
+	// the code index is the unique id of the code, moved code keeps it.
+	codes := map[uint64]symbol{}
+	for data = data[headerSize:]; len(data) >= jitdumpRecordHeader; {
+		id := order.Uint32(data[0:4])
+		size := order.Uint32(data[4:8])
+		if size < jitdumpRecordHeader || int(size) > len(data) {
+			// the runtime may be writing the last record.
+			break
+		}
+
+		rec := data[jitdumpRecordHeader:size]
+		switch id {
+		case jitCodeLoad:
+			// pid, tid, vma, code_addr, code_size, code_index, name
+			if len(rec) < 40 {
+				break
+			}
+
+			name := rec[40:]
+			if end := bytes.IndexByte(name, 0); end >= 0 {
+				name = name[:end]
+			}
+
+			codes[order.Uint64(rec[32:40])] = symbol{
+				name:  string(name),
+				start: order.Uint64(rec[16:24]),
+				size:  order.Uint64(rec[24:32]),
+			}
+		case jitCodeMove:
+			// pid, tid, vma, old_code_addr, new_code_addr, code_size, code_index
+			if len(rec) < 48 {
+				break
+			}
+
+			index := order.Uint64(rec[40:48])
+			if sym, ok := codes[index]; ok {
+				sym.start = order.Uint64(rec[24:32])
+				sym.size = order.Uint64(rec[32:40])
+				codes[index] = sym
+			}
+		}
+
+		data = data[size:]
+	}
+
+	symbols := make([]symbol, 0, len(codes))
+	for _, sym := range codes {
+		symbols = append(symbols, sym)
+	}
+	return symbols, nil
+}
+
+type fileVersion struct {
+	size  int64
+	mtime time.Time
+}
+
+func statVersion(path string) (fileVersion, bool) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return fileVersion{}, false
+	}
+	return fileVersion{size: st.Size(), mtime: st.ModTime()}, true
+}
+
+// jitSymbols is the symbols of the JIT compiled code of a process, from the
+// perf map and the jitdump files in the mount namespace of the process.
+type jitSymbols struct {
+	root        string
+	perfMapPath string
+	jitdumpPath string
+	versions    map[string]fileVersion
+	lastRefresh time.Time
+	table       symbolTable
+}
+
+func newJitSymbols(pid uint32, jitdumpPath string) *jitSymbols {
+	// the perf map is named by the pid in the pid namespace of the process.
+	nspid := uint64(pid)
+	if proc, err := procfs.NewProc(int(pid)); err == nil {
+		if status, err := proc.NewStatus(); err == nil && len(status.NSpids) > 0 {
+			nspid = status.NSpids[len(status.NSpids)-1]
+		}
+	}
+
+	return &jitSymbols{
+		root:        fmt.Sprintf("/proc/%d/root", pid),
+		perfMapPath: fmt.Sprintf("/tmp/perf-%d.map", nspid),
+		jitdumpPath: jitdumpPath,
+		versions:    map[string]fileVersion{},
+	}
+}
+
+// refresh reloads the symbols if any of the files is changed, at most once
+// per jitRefreshInterval.
+func (j *jitSymbols) refresh() {
+	if time.Since(j.lastRefresh) < jitRefreshInterval {
+		return
+	}
+	j.lastRefresh = time.Now()
+
+	changed := false
+	for _, path := range []string{j.perfMapPath, j.jitdumpPath} {
+		if path == "" {
+			continue
+		}
+
+		version, ok := statVersion(filepath.Join(j.root, path))
+		if ok && version != j.versions[path] {
+			j.versions[path] = version
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+
+	var symbols []symbol
+	if file, err := os.Open(filepath.Join(j.root, j.perfMapPath)); err == nil {
+		symbols = append(symbols, parsePerfMap(file)...)
+		file.Close()
+	}
+
+	if j.jitdumpPath != "" {
+		data, err := os.ReadFile(filepath.Join(j.root, j.jitdumpPath))
+		if err == nil {
+			var dumpSymbols []symbol
+			dumpSymbols, err = parseJitdump(data)
+			symbols = append(symbols, dumpSymbols...)
+		}
+		if err != nil {
+			log.Debugf("Usym jitdump %s: %v", j.jitdumpPath, err)
+		}
+	}
+
+	// the later symbols of the same address win, e.g. the recompiled code.
+	sort.SliceStable(symbols, func(i, k int) bool { return symbols[i].start < symbols[k].start })
+	uniq := symbols[:0]
+	for i := range symbols {
+		if len(uniq) > 0 && uniq[len(uniq)-1].start == symbols[i].start {
+			uniq[len(uniq)-1] = symbols[i]
+			continue
+		}
+		uniq = append(uniq, symbols[i])
+	}
+	j.table.symbols = uniq
+}
+
+// lookup resolves the address of the JIT compiled code, the files are
+// reloaded when the address misses, the runtime appends new code. The file
+// the symbol is from is returned as the mapping of the frame, the same as
+// perf.
+func (j *jitSymbols) lookup(addr uint64) (name, file string) {
+	name = j.table.lookup(addr)
+	if name == "" || name == "<unknown>" {
+		j.refresh()
+		name = j.table.lookup(addr)
+	}
+
+	// anonymous memory which is not JIT compiled code.
+	if name == "" || name == "<unknown>" {
+		return "", ""
+	}
+
+	if j.jitdumpPath != "" {
+		return name, j.jitdumpPath
+	}
+	return name, j.perfMapPath
+}
@@ -0,0 +1,138 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package symbol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePerfMap(t *testing.T) {
+	data := "7f0000001000 40 LazyCompile:~main /app/index.js:1\n" +
+		"0x7f0000002000 0x10 Ljava/lang/String;::hashCode\n" +
+		"bad line\n"
+
+	symbols := parsePerfMap(strings.NewReader(data))
+	if len(symbols) != 2 {
+		t.Fatalf("parsePerfMap() symbols=%v, want 2", symbols)
+	}
+	if symbols[0].name != "LazyCompile:~main /app/index.js:1" || symbols[0].start != 0x7f0000001000 || symbols[0].size != 0x40 {
+		t.Errorf("unexpected symbol %+v", symbols[0])
+	}
+	if symbols[1].start != 0x7f0000002000 || symbols[1].size != 0x10 {
+		t.Errorf("unexpected symbol %+v", symbols[1])
+	}
+}
+
+func jitdumpRecord(id uint32, fields []uint64, name string) []byte {
+	var body bytes.Buffer
+	// pid and tid
+	_ = binary.Write(&body, binary.LittleEndian, [2]uint32{1, 1})
+	_ = binary.Write(&body, binary.LittleEndian, fields)
+	if id == jitCodeLoad {
+		body.WriteString(name)
+		body.WriteByte(0)
+	}
+
+	var rec bytes.Buffer
+	_ = binary.Write(&rec, binary.LittleEndian, id)
+	_ = binary.Write(&rec, binary.LittleEndian, uint32(jitdumpRecordHeader+body.Len()))
+	_ = binary.Write(&rec, binary.LittleEndian, uint64(0))
+	rec.Write(body.Bytes())
+	return rec.Bytes()
+}
+
+func testJitdump() []byte {
+	var buf bytes.Buffer
+	// magic, version, total_size, elf_mach, pad1, pid, timestamp, flags
+	_ = binary.Write(&buf, binary.LittleEndian, [6]uint32{jitdumpMagic, 1, jitdumpHeaderSize, 62, 0, 1})
+	_ = binary.Write(&buf, binary.LittleEndian, [2]uint64{0, 0})
+
+	// vma, code_addr, code_size, code_index
+	buf.Write(jitdumpRecord(jitCodeLoad, []uint64{0x1000, 0x1000, 0x100, 1}, "foo"))
+	buf.Write(jitdumpRecord(jitCodeLoad, []uint64{0x2000, 0x2000, 0x100, 2}, "bar"))
+	// vma, old_code_addr, new_code_addr, code_size, code_index
+	buf.Write(jitdumpRecord(jitCodeMove, []uint64{0x3000, 0x2000, 0x3000, 0x80, 2}, ""))
+	// a truncated record being written
+	buf.Write([]byte{0, 0, 0, 0, 0xff})
+	return buf.Bytes()
+}
+
+func TestParseJitdump(t *testing.T) {
+	symbols, err := parseJitdump(testJitdump())
+	if err != nil {
+		t.Fatalf("parseJitdump() error=%v", err)
+	}
+
+	table := symbolTable{symbols: symbols}
+	sort.Slice(table.symbols, func(i, j int) bool { return table.symbols[i].start < table.symbols[j].start })
+	for addr, want := range map[uint64]string{0x1010: "foo", 0x2010: "", 0x3010: "bar"} {
+		if got := table.lookup(addr); got != want && !(want == "" && got == "<unknown>") {
+			t.Errorf("lookup(%#x)=%q, want %q", addr, got, want)
+		}
+	}
+
+	if _, err := parseJitdump([]byte("not a jitdump file, not at all")); err == nil {
+		t.Errorf("parseJitdump() with bad magic should fail")
+	}
+}
+
+func TestJitSymbolsRefresh(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "tmp"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	perfMap := filepath.Join(root, "tmp", "perf-1.map")
+	if err := os.WriteFile(perfMap, []byte("1000 10 first\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "tmp", "jit-1.dump"), testJitdump(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	jit := &jitSymbols{
+		root:        root,
+		perfMapPath: "/tmp/perf-1.map",
+		jitdumpPath: "/tmp/jit-1.dump",
+		versions:    map[string]fileVersion{},
+	}
+
+	if name, file := jit.lookup(0x3000); name != "bar" || file != "/tmp/jit-1.dump" {
+		t.Errorf("lookup()=%q %q, want bar /tmp/jit-1.dump", name, file)
+	}
+	if name, _ := jit.lookup(0x5000); name != "" {
+		t.Errorf("lookup() of unknown address=%q, want empty", name)
+	}
+
+	// the runtime appends new code, the miss reloads the files.
+	if err := os.WriteFile(perfMap, []byte("1000 10 first\n5000 10 second\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	jit.lastRefresh = time.Time{}
+	if name, _ := jit.lookup(0x5000); name != "second" {
+		t.Errorf("lookup() after refresh=%q, want second", name)
+	}
+
+	if !isJitdumpFile("/tmp/jit-1234.dump") || isJitdumpFile("/tmp/perf-1234.map") {
+		t.Errorf("isJitdumpFile() mismatch")
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package symbol
+
+import (
+	"debug/elf"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"huatuo-bamai/internal/log"
+)
+
+var (
+	sourceLinesEnabled atomic.Bool
+	vmlinuxPath        string
+
+	kernelLinesOnce   sync.Once
+	kernelLines       *lineTable
+	kernelLinesOffset uint64
+)
+
+// EnableSourceLines enables resolving the stack addresses to the source lines
+// by the DWARF debuginfo. vmlinux is the kernel image with debuginfo for the
+// kernel stacks, e.g. /usr/lib/debug/boot/vmlinux-$(uname -r), the kernel
+// stacks have no source lines if it is empty.
+func EnableSourceLines(vmlinux string) {
+	vmlinuxPath = vmlinux
+	sourceLinesEnabled.Store(true)
+}
+
+// SourceLinesEnabled returns whether the source lines are enabled.
+func SourceLinesEnabled() bool {
+	return sourceLinesEnabled.Load()
+}
+
+func loadKernelLines() {
+	if vmlinuxPath == "" {
+		return
+	}
+
+	f, err := elf.Open(vmlinuxPath)
+	if err != nil {
+		log.Infof("open vmlinux %s: %v", vmlinuxPath, err)
+		return
+	}
+	defer f.Close()
+
+	// the vmlinux must be of the running kernel.
+	if want, err := KernelBuildID(); err == nil {
+		if id, err := ELFFileBuildID(f); err == nil && id != want {
+			log.Infof("vmlinux %s build id %s mismatches the running kernel %s", vmlinuxPath, id, want)
+			return
+		}
+	}
+
+	offset, err := kaslrOffset(f)
+	if err != nil {
+		log.Infof("vmlinux %s: %v", vmlinuxPath, err)
+		return
+	}
+
+	table, err := newLineTable(f)
+	if err != nil {
+		log.Infof("vmlinux %s dwarf: %v", vmlinuxPath, err)
+		return
+	}
+
+	kernelLines, kernelLinesOffset = table, offset
+}
+
+// kaslrOffset returns the offset of the running kernel text to the vmlinux.
+func kaslrOffset(f *elf.File) (uint64, error) {
+	syms, err := f.Symbols()
+	if err != nil {
+		return 0, err
+	}
+
+	var stext uint64
+	for _, sym := range syms {
+		if sym.Name == "_stext" {
+			stext = sym.Value
+			break
+		}
+	}
+	if stext == 0 {
+		return 0, fmt.Errorf("symbol _stext not found")
+	}
+
+	sym, ok := kernelSymbolByName("_stext")
+	if !ok {
+		return 0, fmt.Errorf("kernel symbol _stext not found")
+	}
+
+	return sym.Addr - stext, nil
+}
+
+// kernelSourceLines returns the source lines of the kernel address, nil if
+// the source lines are disabled or unavailable. The modules are not supported.
+func kernelSourceLines(addr uint64, sym Symbol) []SourceLine {
+	if !SourceLinesEnabled() || sym.Module != moduleKernel {
+		return nil
+	}
+
+	kernelLinesOnce.Do(loadKernelLines)
+	if kernelLines == nil {
+		return nil
+	}
+
+	return kernelLines.lookup(addr - kernelLinesOffset)
+}
@@ -0,0 +1,301 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package symbol
+
+import (
+	"debug/elf"
+	"debug/gosym"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+
+	"huatuo-bamai/internal/log"
+
+	"github.com/hashicorp/golang-lru/simplelru"
+)
+
+// defaultSymbolCacheSize is the max number of binaries in the symbol cache.
+const defaultSymbolCacheSize = 512
+
+// symbolTable is the function symbols of one binary, sorted by the address.
+type symbolTable struct {
+	buildID  string
+	sections []section
+	segments []loadSegment
+	symbols  []symbol
+
+	// dwarfPath is the binary or the separate debuginfo with the DWARF
+	// debuginfo, the line table is loaded on the first use.
+	dwarfPath string
+	linesOnce sync.Once
+	lines     *lineTable
+}
+
+// sourceLines returns the source lines of the pc, nil if the source lines are
+// disabled or the binary has no debuginfo.
+func (t *symbolTable) sourceLines(pc uint64) []SourceLine {
+	if !SourceLinesEnabled() || t.dwarfPath == "" {
+		return nil
+	}
+
+	t.linesOnce.Do(func() {
+		lines, err := openLineTable(t.dwarfPath)
+		if err != nil {
+			log.Debugf("Usym dwarf %s: %v", t.dwarfPath, err)
+			return
+		}
+		t.lines = lines
+	})
+	if t.lines == nil {
+		return nil
+	}
+
+	return t.lines.lookup(pc)
+}
+
+// loadSegment is the PT_LOAD program header.
+type loadSegment struct {
+	offset uint64
+	vaddr  uint64
+	filesz uint64
+}
+
+// vaddr converts the file offset, which the memory mapping is of, into the
+// virtual address the symbols are at.
+func (t *symbolTable) vaddr(offset uint64) uint64 {
+	for _, seg := range t.segments {
+		if offset >= seg.offset && offset < seg.offset+seg.filesz {
+			return offset - seg.offset + seg.vaddr
+		}
+	}
+	return offset
+}
+
+// lookup returns the symbol name of the address, "<unknown>" if the address
+// is not in any symbol, or "" if there is no symbol at all before it.
+func (t *symbolTable) lookup(addr uint64) string {
+	index := sort.Search(len(t.symbols), func(i int) bool {
+		return t.symbols[i].start > addr
+	})
+	if index == 0 {
+		return ""
+	}
+
+	sym := &t.symbols[index-1]
+	if sym.name != "" && addr < sym.start+sym.size {
+		return sym.name
+	}
+	return "<unknown>"
+}
+
+// symbolCache is the symbol tables shared by all processes, keyed by the
+// build id, so that the binaries are parsed once however many processes,
+// or containers, run them.
+type symbolCache struct {
+	mu  sync.Mutex
+	lru *simplelru.LRU
+}
+
+var symbolTables = newSymbolCache(defaultSymbolCacheSize)
+
+func newSymbolCache(size int) *symbolCache {
+	lru, _ := simplelru.NewLRU(size, nil)
+	return &symbolCache{lru: lru}
+}
+
+func (c *symbolCache) get(key string) (*symbolTable, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	v, ok := c.lru.Get(key)
+	if !ok {
+		return nil, false
+	}
+	return v.(*symbolTable), true
+}
+
+func (c *symbolCache) add(key string, t *symbolTable) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lru.Add(key, t)
+}
+
+// fileKey identifies the binary without build id by the inode and the
+// modification time.
+func fileKey(path string) (string, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	sys, ok := st.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", fmt.Errorf("stat %s: unsupported", path)
+	}
+	return fmt.Sprintf("%d:%d:%d", sys.Dev, sys.Ino, st.ModTime().UnixNano()), nil
+}
+
+// loadSymbolTable loads the symbol table of the binary path in the root
+// filesystem, e.g. /proc/<pid>/root, from the shared cache if possible.
+func loadSymbolTable(root, path string) (*symbolTable, error) {
+	fullpath := filepath.Join(root, path)
+
+	f, err := elf.Open(fullpath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	buildID, err := ELFFileBuildID(f)
+	key := buildID
+	if err != nil {
+		if key, err = fileKey(fullpath); err != nil {
+			return nil, err
+		}
+	}
+
+	if t, ok := symbolTables.get(key); ok {
+		return t, nil
+	}
+
+	t := &symbolTable{buildID: buildID}
+	for _, s := range f.Sections {
+		t.sections = append(t.sections, section{name: s.Name, start: s.Addr, end: s.Addr + s.Size, offset: s.Offset, sectiontype: elftype})
+	}
+	for _, p := range f.Progs {
+		if p.Type == elf.PT_LOAD {
+			t.segments = append(t.segments, loadSegment{offset: p.Off, vaddr: p.Vaddr, filesz: p.Filesz})
+		}
+	}
+	// the separate debuginfo is looked up only if the binary is stripped.
+	debugPath := ""
+	if f.Section(".symtab") == nil || f.Section(".debug_info") == nil {
+		debugPath = findDebugFile(root, path, buildID, f)
+	}
+	t.symbols = readSymbols(f, path, debugPath)
+
+	t.dwarfPath = debugPath
+	if f.Section(".debug_info") != nil {
+		t.dwarfPath = fullpath
+	}
+
+	symbolTables.add(key, t)
+	return t, nil
+}
+
+// readSymbols reads the function symbols of the binary. The stripped binary
+// falls back to the symbols of the separate debuginfo, and then to the
+// .gopclntab of the go binary, which is kept by `go build -ldflags=-s`.
+func readSymbols(f *elf.File, path, dbgpath string) []symbol {
+	symbols, full := elfSymbols(f)
+
+	if !full {
+		if dbgpath != "" {
+			if dbg, err := elf.Open(dbgpath); err == nil {
+				var dbgSymbols []symbol
+				dbgSymbols, full = elfSymbols(dbg)
+				symbols = append(symbols, dbgSymbols...)
+				dbg.Close()
+			} else {
+				log.Debugf("Usym debuginfo %s: %v", dbgpath, err)
+			}
+		}
+	}
+
+	if !full {
+		goSymbols, err := goSymbols(f)
+		if err != nil {
+			log.Debugf("Usym gopclntab %s: %v", path, err)
+		}
+		symbols = append(symbols, goSymbols...)
+	}
+
+	for i := range symbols {
+		symbols[i].name = Demangle(symbols[i].name)
+	}
+
+	sort.Slice(symbols, func(i, j int) bool {
+		if symbols[i].start != symbols[j].start {
+			return symbols[i].start < symbols[j].start
+		}
+		return symbols[i].size > symbols[j].size
+	})
+
+	// .dynsym is a subset of .symtab, drop the duplicates.
+	uniq := symbols[:0]
+	for i := range symbols {
+		if i > 0 && symbols[i].start == symbols[i-1].start {
+			continue
+		}
+		uniq = append(uniq, symbols[i])
+	}
+	return uniq
+}
+
+// elfSymbols returns the function symbols in .dynsym and .symtab, full is
+// whether the binary has .symtab, i.e. is not stripped.
+func elfSymbols(f *elf.File) (symbols []symbol, full bool) {
+	dynsymbols, err := f.DynamicSymbols()
+	if err != nil {
+		log.Debugf("Usym elf no dynsymbols err %v", err)
+	}
+	for _, dsym := range dynsymbols {
+		if elf.ST_TYPE(dsym.Info) == elf.STT_FUNC && dsym.Value != 0 {
+			symbols = append(symbols, symbol{name: dsym.Name, start: dsym.Value, size: dsym.Size})
+		}
+	}
+
+	syms, err := f.Symbols()
+	if err != nil {
+		log.Debugf("Usym elf no symbols err %v", err)
+		return symbols, false
+	}
+	for _, sym := range syms {
+		if elf.ST_TYPE(sym.Info) == elf.STT_FUNC && sym.Value != 0 {
+			symbols = append(symbols, symbol{name: sym.Name, start: sym.Value, size: sym.Size})
+		}
+	}
+	return symbols, true
+}
+
+// goSymbols returns the functions in the .gopclntab of the go binary.
+func goSymbols(f *elf.File) ([]symbol, error) {
+	pclntab := f.Section(".gopclntab")
+	text := f.Section(".text")
+	if pclntab == nil || text == nil {
+		return nil, nil
+	}
+
+	data, err := pclntab.Data()
+	if err != nil {
+		return nil, err
+	}
+
+	table, err := gosym.NewTable(nil, gosym.NewLineTable(data, text.Addr))
+	if err != nil {
+		return nil, err
+	}
+
+	symbols := make([]symbol, 0, len(table.Funcs))
+	for i := range table.Funcs {
+		fn := &table.Funcs[i]
+		symbols = append(symbols, symbol{name: fn.Name, start: fn.Entry, size: fn.End - fn.Entry})
+	}
+	return symbols, nil
+}
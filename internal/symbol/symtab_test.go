@@ -0,0 +1,143 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package symbol
+
+import (
+	"debug/elf"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDemangle(t *testing.T) {
+	tests := map[string]string{
+		"_ZN3foo3BarC2Ev":                        "foo::Bar::Bar",
+		"_ZN4core3fmt5write17h0123456789abcdefE": "core::fmt::write",
+		"_RNvCs1234_7mycrate3foo":                "mycrate::foo",
+		"main":                                   "main",
+		"_Zinvalid":                              "_Zinvalid",
+		"runtime.mallocgc":                       "runtime.mallocgc",
+		"_ZNSt6vectorIiSaIiEE9push_backERKi":     "std::vector<int, std::allocator<int> >::push_back",
+		"_ZN9__gnu_cxx13new_allocatorIcE8allocateEmPKv": "__gnu_cxx::new_allocator<char>::allocate",
+	}
+
+	for name, want := range tests {
+		if got := Demangle(name); got != want {
+			t.Errorf("Demangle(%q)=%q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestSymbolTableLookup(t *testing.T) {
+	table := &symbolTable{
+		symbols: []symbol{
+			{name: "a", start: 0x100, size: 0x10},
+			{name: "b", start: 0x200, size: 0x10},
+		},
+		segments: []loadSegment{{offset: 0x1000, vaddr: 0x2000, filesz: 0x1000}},
+	}
+
+	for addr, want := range map[uint64]string{
+		0x50:  "",
+		0x100: "a",
+		0x10f: "a",
+		0x110: "<unknown>",
+		0x205: "b",
+		0x300: "<unknown>",
+	} {
+		if got := table.lookup(addr); got != want {
+			t.Errorf("lookup(%#x)=%q, want %q", addr, got, want)
+		}
+	}
+
+	if got := table.vaddr(0x1100); got != 0x2100 {
+		t.Errorf("vaddr(0x1100)=%#x, want 0x2100", got)
+	}
+	if got := table.vaddr(0x100); got != 0x100 {
+		t.Errorf("vaddr(0x100)=%#x, want 0x100", got)
+	}
+}
+
+func TestFindDebugFile(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, debugDir, ".build-id", "ab")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join(dir, "cdef.debug")
+	if err := os.WriteFile(want, []byte("debug"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := findDebugFile(root, "/usr/bin/app", "abcdef", &elf.File{}); got != want {
+		t.Errorf("findDebugFile()=%q, want %q", got, want)
+	}
+	if got := findDebugFile(root, "/usr/bin/app", "abcdee", &elf.File{}); got != "" {
+		t.Errorf("findDebugFile() with unknown build id=%q, want empty", got)
+	}
+}
+
+func TestLoadSymbolTable(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table, err := loadSymbolTable("", exe)
+	if err != nil {
+		t.Fatalf("loadSymbolTable() error=%v", err)
+	}
+
+	cached, err := loadSymbolTable("", exe)
+	if err != nil || cached != table {
+		t.Errorf("loadSymbolTable() is not served from the cache")
+	}
+
+	f, err := elf.Open(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// the go test binary is not stripped, the .gopclntab has the same
+	// functions as .symtab.
+	goSyms, err := goSymbols(f)
+	if err != nil {
+		t.Fatalf("goSymbols() error=%v", err)
+	}
+
+	var fn *symbol
+	for i := range goSyms {
+		if strings.HasSuffix(goSyms[i].name, ".TestLoadSymbolTable") {
+			fn = &goSyms[i]
+		}
+	}
+	if fn == nil {
+		t.Fatalf("TestLoadSymbolTable not found in .gopclntab")
+	}
+
+	got := table.lookup(fn.start + 1)
+	if got != fn.name {
+		t.Errorf("lookup()=%q, want %q", got, fn.name)
+	}
+	// the sections map the addresses to the file offsets of the mappings.
+	text := f.Section(".text")
+	for _, sec := range table.sections {
+		if sec.name == ".text" && (sec.start != text.Addr || sec.offset != text.Offset) {
+			t.Errorf(".text section=%+v, want start %#x offset %#x", sec, text.Addr, text.Offset)
+		}
+	}
+}
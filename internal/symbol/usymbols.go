@@ -16,7 +16,6 @@ package symbol
 
 import (
 	"bufio"
-	"debug/elf"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -44,55 +43,55 @@ type section struct {
 	name        string
 	start       uint64
 	end         uint64
+	offset      uint64
 	sectiontype int
 }
 
+// Mapping is the binary mapping which a user address belongs to.
+type Mapping struct {
+	Start   uint64
+	Limit   uint64
+	Offset  uint64
+	File    string
+	BuildID string
+}
+
+// Frame is a resolved user stack frame.
+type Frame struct {
+	Name    string
+	Mapping *Mapping
+	// Lines is the source lines, the inlined functions first, only when
+	// the source lines are enabled.
+	Lines []SourceLine
+}
+
 // elfcache elf slice
 type elfcache struct {
-	sections  []section
-	symcaches []symbol
+	root        string
+	exepath     string
+	jitdumpPath string
+	sections    []section
+	symtab      *symbolTable
 }
 
-// Usym User mode stack information
+// Usym User mode stack information. The symbols of the binaries are shared
+// by all Usym objects, keyed by the build id, only the process memory maps
+// are cached per pid.
 type Usym struct {
 	elfcaches map[uint32]elfcache
-	libcaches map[string][]symbol
+	libcaches map[string]*symbolTable
+	jitcaches map[uint32]*jitSymbols
 }
 
 // NewUsym creates a new Usym object
 func NewUsym() *Usym {
 	return &Usym{
 		elfcaches: make(map[uint32]elfcache),
-		libcaches: make(map[string][]symbol),
+		libcaches: make(map[string]*symbolTable),
+		jitcaches: make(map[uint32]*jitSymbols),
 	}
 }
 
-func (m *Usym) getElfSymbols(f *elf.File) []symbol {
-	tabSym := []symbol{}
-	dynsymbols, err := f.DynamicSymbols()
-	if err != nil {
-		log.Debugf("Usym elf no dynsymbols err %v", err)
-	} else {
-		for _, dsym := range dynsymbols {
-			if elf.ST_TYPE(dsym.Info) == elf.STT_FUNC {
-				tabSym = append(tabSym, symbol{name: dsym.Name, start: dsym.Value, size: dsym.Size})
-			}
-		}
-	}
-
-	symbols, err := f.Symbols()
-	if err != nil {
-		log.Debugf("Usym elf no symbols err %v", err)
-	} else {
-		for _, sym := range symbols {
-			if elf.ST_TYPE(sym.Info) == elf.STT_FUNC {
-				tabSym = append(tabSym, symbol{name: sym.Name, start: sym.Value, size: sym.Size})
-			}
-		}
-	}
-	return tabSym
-}
-
 var backedArr = []string{"anon_inode:[perf_event]", "[stack]", "[vvar]", "[vdso]", "[vsyscall]", "[heap]", "//anon", "/dev/zero", "/anon_hugepage", "/SYSV"}
 
 func (m *Usym) isInBacked(str string) bool {
@@ -110,18 +109,14 @@ func (m *Usym) getExePath(pid uint32) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	res := filepath.Join(fmt.Sprintf("/proc/%d/root", pid), binpath)
-	log.Debugf("Usym path: %v", res)
-	return res, nil
+	log.Debugf("Usym path: %v", binpath)
+	return binpath, nil
 }
 
 func (m *Usym) loadElfCaches(addr uint64, pid uint32) error {
 	if _, ok := m.elfcaches[pid]; ok {
 		return nil
 	}
-	// load sections
-	sectionArray := []section{}
-
 	path, err := m.getExePath(pid)
 	if err != nil {
 		return err
@@ -130,15 +125,13 @@ func (m *Usym) loadElfCaches(addr uint64, pid uint32) error {
 		return fmt.Errorf("exepath is nil")
 	}
 
-	f, err := elf.Open(path)
+	// load sections and elfsymbols
+	root := fmt.Sprintf("/proc/%d/root", pid)
+	symtab, err := loadSymbolTable(root, path)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	sections := f.Sections
-	for _, s := range sections {
-		sectionArray = append(sectionArray, section{name: s.Name, start: s.Addr, end: s.Addr + s.Size, sectiontype: elftype})
-	}
+	sectionArray := append([]section{}, symtab.sections...)
 
 	// load maps
 	mapPath := fmt.Sprintf("/proc/%d/maps", pid)
@@ -153,6 +146,7 @@ func (m *Usym) loadElfCaches(addr uint64, pid uint32) error {
 		return err
 	}
 
+	jitdumpPath := ""
 	for scanner.Scan() {
 		line := scanner.Text()
 		field := strings.Fields(line)
@@ -166,37 +160,41 @@ func (m *Usym) loadElfCaches(addr uint64, pid uint32) error {
 
 		startNum, _ := strconv.ParseUint(start, 16, 64)
 		endNum, _ := strconv.ParseUint(end, 16, 64)
+		offsetNum, _ := strconv.ParseUint(field[2], 16, 64)
+		// the JIT runtime mmaps the jitdump file as the marker for perf.
+		if isJitdumpFile(path) {
+			jitdumpPath = path
+			continue
+		}
 		if !m.isInBacked(path) {
-			sectionArray = append(sectionArray, section{name: path, start: startNum, end: endNum, sectiontype: libtype})
+			sectionArray = append(sectionArray, section{name: path, start: startNum, end: endNum, offset: offsetNum, sectiontype: libtype})
 		}
 	}
 	sort.Slice(sectionArray, func(i, j int) bool { return sectionArray[i].start < sectionArray[j].start })
 	log.Debugf("Usym elf + maps section: %v", sectionArray)
 
-	// load elfsymbols
-	tabsymbol := m.getElfSymbols(f)
-	sort.Slice(tabsymbol, func(i, j int) bool { return tabsymbol[i].start < tabsymbol[j].start })
-
-	var elf elfcache
-	elf.sections = sectionArray
-	elf.symcaches = tabsymbol
-	m.elfcaches[pid] = elf
+	m.elfcaches[pid] = elfcache{
+		root:        root,
+		exepath:     path,
+		jitdumpPath: jitdumpPath,
+		sections:    sectionArray,
+		symtab:      symtab,
+	}
 	return nil
 }
 
-func (m *Usym) loadLibCache(libPath string) error {
-	if _, ok := m.libcaches[libPath]; ok {
-		return nil
+func (m *Usym) loadLibCache(root, libPath string) (*symbolTable, error) {
+	key := filepath.Join(root, libPath)
+	if symtab, ok := m.libcaches[key]; ok {
+		return symtab, nil
 	}
-	f, err := elf.Open(libPath)
+
+	symtab, err := loadSymbolTable(root, libPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer f.Close()
-	mtabsymbols := m.getElfSymbols(f)
-	sort.Slice(mtabsymbols, func(i, j int) bool { return mtabsymbols[i].start < mtabsymbols[j].start })
-	m.libcaches[libPath] = mtabsymbols
-	return nil
+	m.libcaches[key] = symtab
+	return symtab, nil
 }
 
 func (m *Usym) searchSection(pid uint32, addr uint64) *section {
@@ -224,60 +222,92 @@ func (m *Usym) searchSection(pid uint32, addr uint64) *section {
 	return &section{}
 }
 
-func (m *Usym) searchSym(addr uint64, symbols []symbol) string {
-	index := sort.Search(len(symbols), func(i int) bool {
-		return symbols[i].start > addr
-	})
-	if index == len(symbols) {
-		return ""
-	}
-	index--
-	log.Debugf("Usym searchSym addr %d index %v len %v", addr, index, len(symbols))
-	if index < len(symbols) && index >= 0 {
-		log.Debugf("Usym searchSym curr %v next %v", symbols[index], symbols[index+1])
-		start := symbols[index].start
-		size := symbols[index].size
-		if symbols[index].name != "" && addr >= start && addr < start+size {
-			return symbols[index].name
-		}
-		return "<unknown>"
-	}
-	return ""
-}
-
 // ResolveUstack display user mode stack information
 func (m *Usym) ResolveUstack(addr uint64, pid uint32) string {
+	return m.ResolveUstackFrame(addr, pid, false).Name
+}
+
+// ResolveUstackFrame resolves the user address into the symbol name and the
+// binary mapping it belongs to. caller is true for all the frames but the
+// leaf, their addresses are the return addresses and the source lines are
+// of the call instructions before them.
+func (m *Usym) ResolveUstackFrame(addr uint64, pid uint32, caller bool) Frame {
 	log.Debugf("Usym ResolveUstack addr %d pid %d", addr, pid)
 	err := m.loadElfCaches(addr, pid)
 	if err != nil {
 		log.Debugf("Usym loadElfCaches err %v", err)
-		return ""
+		return Frame{}
 	}
-	// search elf section
+	// search elf section, the anonymous memory may be JIT compiled code
 	sec := m.searchSection(pid, addr)
 	if sec.name == "" {
-		return ""
+		return m.resolveJIT(addr, pid)
 	}
 	// search elf symbol
 	if sec.sectiontype == elftype {
-		if _, ok := m.elfcaches[pid]; !ok {
-			return ""
+		cache, ok := m.elfcaches[pid]
+		if !ok {
+			return Frame{}
 		}
 		log.Debugf("Usym elf type")
-		return m.searchSym(addr, m.elfcaches[pid].symcaches)
+		return Frame{
+			Name:  cache.symtab.lookup(addr),
+			Lines: cache.symtab.sourceLines(callPC(addr, caller)),
+			Mapping: &Mapping{
+				Start:   sec.start,
+				Limit:   sec.end,
+				Offset:  sec.offset,
+				File:    cache.exepath,
+				BuildID: cache.symtab.buildID,
+			},
+		}
 	}
 	// search lib symbol
-	libpath := filepath.Join(fmt.Sprintf("/proc/%d/root", pid), sec.name)
+	root := fmt.Sprintf("/proc/%d/root", pid)
 	baseaddr := sec.start
-	addr -= baseaddr
-	err = m.loadLibCache(libpath)
+	symtab, err := m.loadLibCache(root, sec.name)
 	if err != nil {
 		log.Debugf("Usym loadLibCache err %v", err)
-		return ""
+		return Frame{}
+	}
+	log.Debugf("Usym lib type libpath %v", sec.name)
+	pc := symtab.vaddr(addr - baseaddr + sec.offset)
+	return Frame{
+		Name:  symtab.lookup(pc),
+		Lines: symtab.sourceLines(callPC(pc, caller)),
+		Mapping: &Mapping{
+			Start:   sec.start,
+			Limit:   sec.end,
+			Offset:  sec.offset,
+			File:    sec.name,
+			BuildID: symtab.buildID,
+		},
+	}
+}
+
+// callPC returns the pc of the call instruction before the return address of
+// the callers, as DumpKernelBackTrace does.
+func callPC(pc uint64, caller bool) uint64 {
+	if caller {
+		return pc - 1
 	}
-	if _, ok := m.libcaches[libpath]; !ok {
-		return ""
+	return pc
+}
+
+// resolveJIT resolves the address in the anonymous memory by the perf map
+// and the jitdump files of the JIT runtimes, e.g. java and node.
+func (m *Usym) resolveJIT(addr uint64, pid uint32) Frame {
+	jit, ok := m.jitcaches[pid]
+	if !ok {
+		jit = newJitSymbols(pid, m.elfcaches[pid].jitdumpPath)
+		m.jitcaches[pid] = jit
 	}
-	log.Debugf("Usym lib type libpath %v", libpath)
-	return m.searchSym(addr, m.libcaches[libpath])
+
+	name, file := jit.lookup(addr)
+	if name == "" {
+		return Frame{}
+	}
+
+	log.Debugf("Usym jit type file %v", file)
+	return Frame{Name: name, Mapping: &Mapping{File: file}}
 }
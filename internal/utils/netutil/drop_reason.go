@@ -0,0 +1,70 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netutil
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cilium/ebpf/btf"
+)
+
+const (
+	dropReasonPrefix = "SKB_DROP_REASON_"
+
+	// DropReasonUnknown is the reason of the drops on the kernels without
+	// the drop reasons, < 5.17.
+	DropReasonUnknown = "unknown"
+)
+
+// DropReasons are the names of enum skb_drop_reason of the running kernel,
+// the values differ between the kernel versions, e.g. "no_socket" for
+// SKB_DROP_REASON_NO_SOCKET. The nil DropReasons are of the kernels without
+// the drop reasons.
+type DropReasons map[uint32]string
+
+// DropReasonsFromBTF returns the drop reasons by the kernel BTF.
+func DropReasonsFromBTF(spec *btf.Spec) (DropReasons, error) {
+	var enum *btf.Enum
+	if err := spec.TypeByName("skb_drop_reason", &enum); err != nil {
+		return nil, fmt.Errorf("enum skb_drop_reason: %w", err)
+	}
+	return newDropReasons(enum), nil
+}
+
+func newDropReasons(enum *btf.Enum) DropReasons {
+	reasons := make(DropReasons, len(enum.Values))
+	for _, v := range enum.Values {
+		// SKB_DROP_REASON_MAX and the subsystem masks are not reasons.
+		if !strings.HasPrefix(v.Name, dropReasonPrefix) || v.Name == dropReasonPrefix+"MAX" {
+			continue
+		}
+		reasons[uint32(v.Value)] = strings.ToLower(strings.TrimPrefix(v.Name, dropReasonPrefix))
+	}
+	return reasons
+}
+
+// Name returns the name of the reason, the value for the reasons not in the
+// enum, e.g. of the subsystems.
+func (r DropReasons) Name(reason uint32) string {
+	if r == nil {
+		return DropReasonUnknown
+	}
+	if name, ok := r[reason]; ok {
+		return name
+	}
+	return strconv.FormatUint(uint64(reason), 10)
+}
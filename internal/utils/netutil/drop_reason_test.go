@@ -0,0 +1,53 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netutil
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/btf"
+)
+
+func TestDropReasons(t *testing.T) {
+	reasons := newDropReasons(&btf.Enum{
+		Name: "skb_drop_reason",
+		Values: []btf.EnumValue{
+			{Name: "SKB_NOT_DROPPED_YET", Value: 0},
+			{Name: "SKB_DROP_REASON_NOT_SPECIFIED", Value: 2},
+			{Name: "SKB_DROP_REASON_NO_SOCKET", Value: 3},
+			{Name: "SKB_DROP_REASON_MAX", Value: 4},
+		},
+	})
+
+	tests := []struct {
+		name    string
+		reasons DropReasons
+		reason  uint32
+		want    string
+	}{
+		{name: "reason", reasons: reasons, reason: 3, want: "no_socket"},
+		{name: "not specified", reasons: reasons, reason: 2, want: "not_specified"},
+		{name: "not a reason", reasons: reasons, reason: 0, want: "0"},
+		{name: "max", reasons: reasons, reason: 4, want: "4"},
+		{name: "subsystem", reasons: reasons, reason: 0x10000, want: "65536"},
+		{name: "no reasons", reasons: nil, reason: 3, want: DropReasonUnknown},
+	}
+
+	for _, tt := range tests {
+		if got := tt.reasons.Name(tt.reason); got != tt.want {
+			t.Errorf("%s: Name(%d)=%s, want %s", tt.name, tt.reason, got, tt.want)
+		}
+	}
+}
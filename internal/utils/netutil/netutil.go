@@ -20,6 +20,7 @@ import (
 	"net"
 
 	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
 )
 
 var NativeEndian = nl.NativeEndian()
@@ -38,6 +39,23 @@ func Inetv4Ntop(addr uint32) net.IP {
 	).To4()
 }
 
+// InetNtop, inet_ntop
+// convert IPv4 or IPv6 addresses (in network byte order) from binary to text
+// by the address family, the IPv4 address is in the first 4 bytes. It is nil
+// for the unknown families.
+//
+// https://man7.org/linux/man-pages/man3/inet_ntop.3.html
+func InetNtop(family uint16, addr [16]byte) net.IP {
+	switch family {
+	case unix.AF_INET:
+		return net.IPv4(addr[0], addr[1], addr[2], addr[3]).To4()
+	case unix.AF_INET6:
+		return net.IP(addr[:]).To16()
+	default:
+		return nil
+	}
+}
+
 // Ntohs
 // converts the unsigned short integer netshort from network byte order to host byte order.
 //
@@ -52,6 +52,48 @@ func TestInetv4Ntop(t *testing.T) {
 	}
 }
 
+func TestInetNtop(t *testing.T) {
+	v6 := [16]byte{0x20, 0x01, 0x0d, 0xb8, 15: 0x01}
+	tests := []struct {
+		name   string
+		family uint16
+		addr   [16]byte
+		want   string
+	}{
+		{
+			name:   "IPv4",
+			family: 2, // AF_INET
+			addr:   [16]byte{127, 0, 0, 1},
+			want:   "127.0.0.1",
+		},
+		{
+			name:   "IPv6",
+			family: 10, // AF_INET6
+			addr:   v6,
+			want:   "2001:db8::1",
+		},
+		{
+			name:   "IPv4-mapped IPv6",
+			family: 10,
+			addr:   [16]byte{10: 0xff, 11: 0xff, 12: 10, 13: 0, 14: 0, 15: 1},
+			want:   "10.0.0.1",
+		},
+		{
+			name:   "Unknown family",
+			family: 0,
+			addr:   v6,
+			want:   "<nil>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := InetNtop(tt.family, tt.addr).String(); got != tt.want {
+				t.Errorf("InetNtop() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestNtohs(t *testing.T) {
 	tests := []struct {
 		name string
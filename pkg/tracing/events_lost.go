@@ -0,0 +1,95 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"time"
+
+	"huatuo-bamai/internal/bpf"
+	"huatuo-bamai/internal/log"
+)
+
+// EventsLostThreshold is the number of the events lost by a tracer since the
+// last events_lost document, at which a new one is saved. Zero disables the
+// documents.
+var EventsLostThreshold uint64
+
+// EventsLostInterval is the interval of checking the lost events.
+var EventsLostInterval = 10 * time.Second
+
+// EventsLostTracingData is the document of the events lost by a tracer, which
+// makes the gap in the tracing documents explicit.
+type EventsLostTracingData struct {
+	Tracer string         `json:"tracer"`
+	Lost   uint64         `json:"lost"`
+	PerCPU map[int]uint64 `json:"per_cpu"`
+	Start  time.Time      `json:"start"`
+	End    time.Time      `json:"end"`
+}
+
+// eventsLostSince returns the events lost since the last, by cpu.
+func eventsLostSince(last, now bpf.LostEvents) *EventsLostTracingData {
+	data := &EventsLostTracingData{
+		Lost:   now.Total - last.Total,
+		PerCPU: make(map[int]uint64),
+	}
+
+	for cpu, count := range now.PerCPU {
+		if delta := count - last.PerCPU[cpu]; delta > 0 {
+			data.PerCPU[cpu] = delta
+		}
+	}
+	return data
+}
+
+// watchEventsLost saves an events_lost document when the events lost by the
+// tracer reach EventsLostThreshold, checked every EventsLostInterval.
+func watchEventsLost(ctx context.Context, tracer string) {
+	ticker := time.NewTicker(EventsLostInterval)
+	defer ticker.Stop()
+
+	last := bpf.LostEventsOf(tracer)
+	start := time.Now()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case end := <-ticker.C:
+			now := bpf.LostEventsOf(tracer)
+			data := eventsLostSince(last, now)
+			if data.Lost < EventsLostThreshold {
+				continue
+			}
+
+			data.Tracer = tracer
+			data.Start = start
+			data.End = end
+			log.Warnf("tracing %s lost %d events in %v", tracer, data.Lost, end.Sub(start))
+
+			if err := Save(&WriteRequest{
+				TracerName: "events_lost",
+				TracerTime: end,
+				TracerData: data,
+			}); err != nil {
+				log.Warnf("failed to save tracing data: %v", err)
+			}
+
+			last = now
+			start = end
+		}
+	}
+}
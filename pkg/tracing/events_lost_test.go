@@ -0,0 +1,63 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"maps"
+	"testing"
+
+	"huatuo-bamai/internal/bpf"
+)
+
+func TestEventsLostSince(t *testing.T) {
+	tests := []struct {
+		name       string
+		last, now  bpf.LostEvents
+		wantLost   uint64
+		wantPerCPU map[int]uint64
+	}{
+		{
+			name:       "first loss",
+			now:        bpf.LostEvents{Total: 5, PerCPU: map[int]uint64{0: 2, 3: 3}},
+			wantLost:   5,
+			wantPerCPU: map[int]uint64{0: 2, 3: 3},
+		},
+		{
+			name:       "only the new loss",
+			last:       bpf.LostEvents{Total: 5, PerCPU: map[int]uint64{0: 2, 3: 3}},
+			now:        bpf.LostEvents{Total: 9, PerCPU: map[int]uint64{0: 2, 1: 1, 3: 6}},
+			wantLost:   4,
+			wantPerCPU: map[int]uint64{1: 1, 3: 3},
+		},
+		{
+			name:       "no loss",
+			last:       bpf.LostEvents{Total: 5, PerCPU: map[int]uint64{0: 5}},
+			now:        bpf.LostEvents{Total: 5, PerCPU: map[int]uint64{0: 5}},
+			wantPerCPU: map[int]uint64{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := eventsLostSince(tt.last, tt.now)
+			if got.Lost != tt.wantLost {
+				t.Errorf("Lost=%d, want %d", got.Lost, tt.wantLost)
+			}
+			if !maps.Equal(got.PerCPU, tt.wantPerCPU) {
+				t.Errorf("PerCPU=%v, want %v", got.PerCPU, tt.wantPerCPU)
+			}
+		})
+	}
+}
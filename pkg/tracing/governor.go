@@ -0,0 +1,388 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"huatuo-bamai/internal/bpf"
+	"huatuo-bamai/internal/log"
+)
+
+// GovernorOption is the budgets of every tracer, the tracers over the budgets
+// are throttled by the governor. The governor is disabled when both the
+// budgets are zero.
+type GovernorOption struct {
+	// Interval is the interval of checking the tracers.
+	Interval time.Duration
+	// MaxEventRate is the max events per second processed by a tracer.
+	MaxEventRate float64
+	// MaxCPUPercent is the max cpu of a tracer processing the events, 100 is
+	// a whole cpu, see bpf.EventStats.ProcessNs.
+	MaxCPUPercent float64
+	// PauseDuration is the duration of pausing a tracer, the last resort.
+	PauseDuration time.Duration
+}
+
+// The levels of throttling a tracer, the governor escalates the level by one
+// in every interval over the budgets. The ratelimit drops the events in the
+// bpf, while the sampling drops them in the user space after the perf buffer,
+// see bpf.SetEventSampling.
+const (
+	governLevelNone = iota
+	governLevelRateLimit
+	governLevelSampling
+	governLevelPaused
+)
+
+var governLevels = []string{"none", "ratelimit", "sampling", "paused"}
+
+const (
+	// maxGovernSampling is the max sampling before pausing the tracer.
+	maxGovernSampling = 1024
+	// governCalmTicks are the intervals under half of the budgets before
+	// relaxing the throttling by one level.
+	governCalmTicks = 3
+)
+
+// GovernorTracingData is the document of an action of the governor.
+type GovernorTracingData struct {
+	Tracer string `json:"tracer"`
+	// Action is ratelimit, sampling, pause, resume or relax.
+	Action string `json:"action"`
+	// Level is the level after the action.
+	Level      string  `json:"level"`
+	EventRate  float64 `json:"event_rate"`
+	CPUPercent float64 `json:"cpu_percent"`
+	// Burst is the burst in every second of the bpf ratelimits, 0 is the
+	// original ones.
+	Burst    uint64 `json:"burst"`
+	Sampling uint64 `json:"sampling"`
+	Reason   string `json:"reason"`
+}
+
+type governorState struct {
+	level    int
+	burst    uint64
+	sampling uint64
+	calm     int
+
+	last        bpf.EventStats
+	lastNmissed uint64
+	lastTime    time.Time
+	pausedUntil time.Time
+}
+
+// governorUsage is the usage of a tracer in an interval.
+type governorUsage struct {
+	// rate and cpu are of the events processed by the tracer.
+	rate, cpu float64
+	// demand is the events per second before the sampling and the bpf
+	// ratelimits.
+	demand float64
+}
+
+type governor struct {
+	opt    GovernorOption
+	mu     sync.Mutex
+	states map[string]*governorState
+
+	// the hooks, replaced by the tests.
+	now         func() time.Time
+	running     func() []string
+	stats       func(tracer string) bpf.EventStats
+	nmissed     func(tracer string) uint64
+	setBurst    func(tracer string, burst uint64) (int, error)
+	setSampling func(tracer string, n uint64)
+	pause       func(tracer string) error
+	resume      func(tracer string) error
+	save        func(data *GovernorTracingData)
+}
+
+func newGovernor(opt GovernorOption) *governor {
+	return &governor{
+		opt:         opt,
+		states:      make(map[string]*governorState),
+		now:         time.Now,
+		stats:       bpf.EventStatsOf,
+		nmissed:     rateLimitsNmissed,
+		setSampling: bpf.SetEventSampling,
+		setBurst: func(tracer string, burst uint64) (int, error) {
+			var n int
+			for _, object := range TracingBpfObjects(tracer) {
+				set, err := bpf.SetRateLimitBurst(object, burst)
+				if err != nil {
+					return n, err
+				}
+				n += set
+			}
+			return n, nil
+		},
+		save: func(data *GovernorTracingData) {
+			if err := Save(&WriteRequest{
+				TracerName: "tracing_governor",
+				TracerTime: time.Now(),
+				TracerData: data,
+			}); err != nil {
+				log.Warnf("failed to save tracing data: %v", err)
+			}
+		},
+	}
+}
+
+func rateLimitsNmissed(tracer string) uint64 {
+	var n uint64
+	for _, object := range TracingBpfObjects(tracer) {
+		for _, rl := range bpf.RateLimitsOf(object) {
+			n += rl.TotalNmissed
+		}
+	}
+	return n
+}
+
+func (g *governor) run(ctx context.Context) {
+	ticker := time.NewTicker(g.opt.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			g.restore()
+			return
+		case <-ticker.C:
+			g.tick()
+		}
+	}
+}
+
+// level returns the level of the tracer.
+func (g *governor) level(tracer string) string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if s, ok := g.states[tracer]; ok {
+		return governLevels[s.level]
+	}
+	return governLevels[governLevelNone]
+}
+
+func (g *governor) tick() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	for tracer, s := range g.states {
+		if s.level == governLevelPaused && !now.Before(s.pausedUntil) {
+			g.resumeTracer(tracer, s, now)
+		}
+	}
+
+	for _, tracer := range g.running() {
+		s, ok := g.states[tracer]
+		if !ok {
+			s = &governorState{last: g.stats(tracer), lastNmissed: g.nmissed(tracer), lastTime: now}
+			g.states[tracer] = s
+			continue
+		}
+
+		if u, ok := g.measure(tracer, s, now); ok {
+			g.govern(tracer, s, u)
+		}
+	}
+}
+
+// measure returns the usage of the tracer since the last interval.
+func (g *governor) measure(tracer string, s *governorState, now time.Time) (governorUsage, bool) {
+	stats, nmissed := g.stats(tracer), g.nmissed(tracer)
+	last, lastNmissed, lastTime := s.last, s.lastNmissed, s.lastTime
+	s.last, s.lastNmissed, s.lastTime = stats, nmissed, now
+
+	dt := now.Sub(lastTime).Seconds()
+	// the stats are reset when the tracer is restarted.
+	if dt <= 0 || stats.Events < last.Events || stats.Sampled < last.Sampled {
+		return governorUsage{}, false
+	}
+
+	events := float64(stats.Events - last.Events)
+	sampled := float64(stats.Sampled - last.Sampled)
+	var missed float64
+	if nmissed >= lastNmissed {
+		missed = float64(nmissed - lastNmissed)
+	}
+
+	return governorUsage{
+		rate:   events / dt,
+		cpu:    float64(stats.ProcessNs-last.ProcessNs) / 1e9 / dt * 100,
+		demand: (events + sampled + missed) / dt,
+	}, true
+}
+
+// factor returns the ratio of the budgets to the usage, less than 1 is over
+// the budgets.
+func (g *governor) factor(rate, cpu float64) float64 {
+	factor := math.Inf(1)
+	if g.opt.MaxEventRate > 0 && rate > 0 {
+		factor = min(factor, g.opt.MaxEventRate/rate)
+	}
+	if g.opt.MaxCPUPercent > 0 && cpu > 0 {
+		factor = min(factor, g.opt.MaxCPUPercent/cpu)
+	}
+	return factor
+}
+
+func (g *governor) govern(tracer string, s *governorState, u governorUsage) {
+	factor := g.factor(u.rate, u.cpu)
+	if factor < 1 {
+		s.calm = 0
+		g.escalate(tracer, s, u, factor)
+		return
+	}
+
+	if s.level == governLevelNone {
+		return
+	}
+
+	// the usage without the throttling, the cpu scales with the events.
+	cpu := u.cpu
+	if u.rate > 0 {
+		cpu *= u.demand / u.rate
+	}
+	if g.factor(u.demand, cpu) < 2 {
+		s.calm = 0
+		return
+	}
+
+	s.calm++
+	if s.calm >= governCalmTicks {
+		s.calm = 0
+		g.relax(tracer, s, u)
+	}
+}
+
+func (g *governor) escalate(tracer string, s *governorState, u governorUsage, factor float64) {
+	reason := fmt.Sprintf("event rate %.0f/s, cpu %.1f%%, over the budgets by %.1fx", u.rate, u.cpu, 1/factor)
+
+	switch s.level {
+	case governLevelNone:
+		burst := max(uint64(u.rate*factor), 1)
+		n, err := g.setBurst(tracer, burst)
+		if err != nil {
+			log.Warnf("governor: set the ratelimits of %s: %v", tracer, err)
+		}
+		if n > 0 {
+			s.level, s.burst = governLevelRateLimit, burst
+			g.report(tracer, "ratelimit", s, u, reason)
+			return
+		}
+		// no bpf ratelimits, sampling instead. It only saves the
+		// processing in the user space, pausing is the last resort
+		// for the cost of the bpf.
+		fallthrough
+	case governLevelRateLimit:
+		s.level = governLevelSampling
+		s.sampling = min(max(uint64(math.Ceil(1/factor)), 2), maxGovernSampling)
+		g.setSampling(tracer, s.sampling)
+		g.report(tracer, "sampling", s, u, reason)
+	case governLevelSampling:
+		if s.sampling < maxGovernSampling {
+			s.sampling = min(s.sampling*max(uint64(math.Ceil(1/factor)), 2), maxGovernSampling)
+			g.setSampling(tracer, s.sampling)
+			g.report(tracer, "sampling", s, u, reason)
+			return
+		}
+
+		if err := g.pause(tracer); err != nil {
+			log.Warnf("governor: pause %s: %v", tracer, err)
+			return
+		}
+		s.level = governLevelPaused
+		s.pausedUntil = g.now().Add(g.opt.PauseDuration)
+		g.report(tracer, "pause", s, u, reason)
+	}
+}
+
+func (g *governor) relax(tracer string, s *governorState, u governorUsage) {
+	reason := fmt.Sprintf("event rate %.0f/s without throttling, under half of the budgets", u.demand)
+
+	switch s.level {
+	case governLevelSampling:
+		s.sampling /= 2
+		if s.sampling <= 1 {
+			s.sampling = 0
+			s.level = governLevelNone
+			if s.burst != 0 {
+				s.level = governLevelRateLimit
+			}
+		}
+		g.setSampling(tracer, s.sampling)
+	case governLevelRateLimit:
+		if _, err := g.setBurst(tracer, 0); err != nil {
+			log.Warnf("governor: restore the ratelimits of %s: %v", tracer, err)
+			return
+		}
+		s.level, s.burst = governLevelNone, 0
+	default:
+		return
+	}
+	g.report(tracer, "relax", s, governorUsage{rate: u.rate, cpu: u.cpu}, reason)
+}
+
+// resumeTracer resumes the paused tracer with the max sampling.
+func (g *governor) resumeTracer(tracer string, s *governorState, now time.Time) {
+	if err := g.resume(tracer); err != nil {
+		log.Warnf("governor: resume %s: %v", tracer, err)
+		return
+	}
+
+	s.level = governLevelSampling
+	s.last, s.lastNmissed, s.lastTime = g.stats(tracer), g.nmissed(tracer), now
+	g.report(tracer, "resume", s, governorUsage{}, fmt.Sprintf("paused for %v", g.opt.PauseDuration))
+}
+
+// restore restores the throttled tracers, but the paused ones are left
+// stopped.
+func (g *governor) restore() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for tracer, s := range g.states {
+		if s.sampling != 0 {
+			g.setSampling(tracer, 0)
+		}
+		if s.burst != 0 {
+			_, _ = g.setBurst(tracer, 0)
+		}
+	}
+	g.states = make(map[string]*governorState)
+}
+
+func (g *governor) report(tracer, action string, s *governorState, u governorUsage, reason string) {
+	log.Warnf("governor: %s %s, %s", action, tracer, reason)
+
+	g.save(&GovernorTracingData{
+		Tracer:     tracer,
+		Action:     action,
+		Level:      governLevels[s.level],
+		EventRate:  math.Round(u.rate*100) / 100,
+		CPUPercent: math.Round(u.cpu*100) / 100,
+		Burst:      s.burst,
+		Sampling:   s.sampling,
+		Reason:     reason,
+	})
+}
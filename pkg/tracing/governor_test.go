@@ -0,0 +1,273 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"huatuo-bamai/internal/bpf"
+)
+
+// fakeGovernor is a governor of the tracer "noisy", which gets rate events
+// per second, each costs cost of the cpu.
+type fakeGovernor struct {
+	*governor
+
+	now        time.Time
+	rate       uint64
+	cost       time.Duration
+	ratelimits bool
+
+	stats    bpf.EventStats
+	nmissed  uint64
+	burst    uint64
+	sampling uint64
+	paused   bool
+	actions  []string
+}
+
+func newFakeGovernor(ratelimits bool) *fakeGovernor {
+	f := &fakeGovernor{now: time.Unix(1000, 0), ratelimits: ratelimits}
+
+	g := newGovernor(GovernorOption{
+		Interval:      time.Second,
+		MaxEventRate:  1000,
+		MaxCPUPercent: 10,
+		PauseDuration: 5 * time.Second,
+	})
+	g.now = func() time.Time { return f.now }
+	g.running = func() []string {
+		if f.paused {
+			return nil
+		}
+		return []string{"noisy"}
+	}
+	g.stats = func(string) bpf.EventStats { return f.stats }
+	g.nmissed = func(string) uint64 { return f.nmissed }
+	g.setBurst = func(_ string, burst uint64) (int, error) {
+		if !f.ratelimits {
+			return 0, nil
+		}
+		f.burst = burst
+		return 1, nil
+	}
+	g.setSampling = func(_ string, n uint64) { f.sampling = n }
+	g.pause = func(string) error { f.paused = true; return nil }
+	g.resume = func(string) error { f.paused = false; return nil }
+	g.save = func(data *GovernorTracingData) { f.actions = append(f.actions, data.Action+"/"+data.Level) }
+
+	f.governor = g
+	return f
+}
+
+// advance runs the tracer for a second, and ticks the governor.
+func (f *fakeGovernor) advance() {
+	f.now = f.now.Add(time.Second)
+
+	if !f.paused {
+		events := f.rate
+		if f.burst != 0 && events > f.burst {
+			f.nmissed += events - f.burst
+			events = f.burst
+		}
+
+		passed := events
+		if f.sampling > 1 {
+			passed = events / f.sampling
+		}
+		f.stats.Events += passed
+		f.stats.Sampled += events - passed
+		f.stats.ProcessNs += passed * uint64(f.cost)
+	}
+
+	f.tick()
+}
+
+func TestGovernorEscalate(t *testing.T) {
+	tests := []struct {
+		name       string
+		ratelimits bool
+		rate       uint64
+		cost       time.Duration
+		ticks      int
+		actions    []string
+	}{
+		{
+			name:  "under budgets",
+			rate:  500,
+			cost:  time.Microsecond,
+			ticks: 5,
+		},
+		{
+			name:       "ratelimit",
+			ratelimits: true,
+			rate:       5000,
+			cost:       time.Microsecond,
+			ticks:      5,
+			actions:    []string{"ratelimit/ratelimit"},
+		},
+		{
+			name:    "sampling without ratelimits",
+			rate:    5000,
+			cost:    time.Microsecond,
+			ticks:   5,
+			actions: []string{"sampling/sampling"},
+		},
+		{
+			name:  "pause",
+			rate:  1000000,
+			cost:  10 * time.Millisecond,
+			ticks: 5,
+			actions: []string{
+				"sampling/sampling",
+				"pause/paused",
+			},
+		},
+		{
+			name:  "resume",
+			rate:  1000000,
+			cost:  10 * time.Millisecond,
+			ticks: 10,
+			actions: []string{
+				"sampling/sampling",
+				"pause/paused",
+				"resume/sampling",
+				"pause/paused",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		f := newFakeGovernor(tt.ratelimits)
+		f.rate, f.cost = tt.rate, tt.cost
+
+		for range tt.ticks {
+			f.advance()
+		}
+
+		if !slices.Equal(f.actions, tt.actions) {
+			t.Errorf("%s: actions=%v, want %v", tt.name, f.actions, tt.actions)
+		}
+	}
+}
+
+func TestGovernorBudgets(t *testing.T) {
+	f := newFakeGovernor(true)
+	f.rate, f.cost = 5000, time.Microsecond
+
+	f.advance()
+	f.advance()
+	if f.burst != 1000 {
+		t.Errorf("burst=%d, want 1000", f.burst)
+	}
+
+	// the cpu budget is tighter than the rate: 5000 * 100us is 50%.
+	f = newFakeGovernor(false)
+	f.rate, f.cost = 5000, 100*time.Microsecond
+
+	f.advance()
+	f.advance()
+	if f.sampling != 5 {
+		t.Errorf("sampling=%d, want 5", f.sampling)
+	}
+	if got := f.level("noisy"); got != "sampling" {
+		t.Errorf("level=%s, want sampling", got)
+	}
+}
+
+func TestGovernorRelax(t *testing.T) {
+	f := newFakeGovernor(true)
+	f.rate, f.cost = 5000, time.Microsecond
+
+	f.advance()
+	f.advance()
+	f.rate = 2000 // over the rate budget even at the ratelimit.
+	f.burst = 0
+	f.advance()
+	if f.sampling != 2 {
+		t.Errorf("sampling=%d, want 2", f.sampling)
+	}
+
+	// the ratelimit hides the demand, which is still over the budgets.
+	f.burst = 1000
+	f.advance()
+	f.advance()
+	f.advance()
+	f.advance()
+	if got := f.level("noisy"); got != "sampling" {
+		t.Errorf("level=%s, want sampling", got)
+	}
+
+	f.rate = 100
+	for range 2 * governCalmTicks {
+		f.advance()
+	}
+
+	want := []string{"ratelimit/ratelimit", "sampling/sampling", "relax/ratelimit", "relax/none"}
+	if !slices.Equal(f.actions, want) {
+		t.Errorf("actions=%v, want %v", f.actions, want)
+	}
+	if f.sampling != 0 || f.burst != 0 {
+		t.Errorf("sampling=%d burst=%d, want restored", f.sampling, f.burst)
+	}
+}
+
+func TestGovernorRestore(t *testing.T) {
+	f := newFakeGovernor(true)
+	f.rate, f.cost = 50000, time.Microsecond
+
+	f.advance()
+	f.advance()
+	f.cost *= 200 // over the cpu budget at the ratelimit.
+	f.advance()
+	if f.burst == 0 || f.sampling == 0 {
+		t.Errorf("burst=%d sampling=%d, want throttled", f.burst, f.sampling)
+	}
+
+	f.restore()
+	if f.burst != 0 || f.sampling != 0 {
+		t.Errorf("burst=%d sampling=%d, want restored", f.burst, f.sampling)
+	}
+	if got := f.level("noisy"); got != "none" {
+		t.Errorf("level=%s, want none", got)
+	}
+}
+
+func TestStartGovernor(t *testing.T) {
+	mgr := &TracingManager{tracingEvents: map[string]*EventTracing{}}
+
+	if err := mgr.StartGovernor(GovernorOption{Interval: time.Second}); err != nil || mgr.governor != nil {
+		t.Errorf("StartGovernor() without budgets err=%v, governor=%v", err, mgr.governor)
+	}
+
+	if err := mgr.StartGovernor(GovernorOption{MaxEventRate: 1}); err == nil {
+		t.Errorf("StartGovernor() without interval, want error")
+	}
+
+	opt := GovernorOption{Interval: time.Second, MaxEventRate: 1, PauseDuration: time.Second}
+	if err := mgr.StartGovernor(opt); err != nil {
+		t.Errorf("StartGovernor() err=%v", err)
+	}
+	if err := mgr.StartGovernor(opt); err == nil {
+		t.Errorf("StartGovernor() twice, want error")
+	}
+
+	_ = mgr.Stop()
+	if mgr.governor != nil {
+		t.Errorf("governor should be stopped")
+	}
+}
@@ -15,6 +15,7 @@
 package tracing
 
 import (
+	"context"
 	"fmt"
 	"slices"
 	"sync"
@@ -24,6 +25,9 @@ type TracingManager struct {
 	tracingEvents map[string]*EventTracing
 	mu            sync.Mutex
 	blackListed   []string
+
+	governor       *governor
+	governorCancel context.CancelFunc
 }
 
 func NewManager(blackListed []string) (*TracingManager, error) {
@@ -74,7 +78,54 @@ func (mgr *TracingManager) StartByName(name string) error {
 	return te.Start()
 }
 
+// StartGovernor starts the governor throttling the tracers over the budgets,
+// it is stopped by Stop.
+func (mgr *TracingManager) StartGovernor(opt GovernorOption) error {
+	if opt.MaxEventRate <= 0 && opt.MaxCPUPercent <= 0 {
+		return nil
+	}
+	if opt.Interval <= 0 || opt.PauseDuration <= 0 {
+		return fmt.Errorf("invalid governor interval %v or pause duration %v", opt.Interval, opt.PauseDuration)
+	}
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	if mgr.governor != nil {
+		return fmt.Errorf("governor already running")
+	}
+
+	g := newGovernor(opt)
+	g.running = mgr.runningTracers
+	g.pause = mgr.StopByName
+	g.resume = mgr.StartByName
+
+	ctx, cancel := context.WithCancel(context.Background())
+	mgr.governor, mgr.governorCancel = g, cancel
+	go g.run(ctx)
+	return nil
+}
+
+func (mgr *TracingManager) runningTracers() []string {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	var names []string
+	for name, te := range mgr.tracingEvents {
+		if te.isRunning {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
 func (mgr *TracingManager) Stop() error {
+	mgr.mu.Lock()
+	if mgr.governorCancel != nil {
+		mgr.governorCancel()
+		mgr.governor, mgr.governorCancel = nil, nil
+	}
+	mgr.mu.Unlock()
+
 	for name := range mgr.tracingEvents {
 		if err := mgr.StopByName(name); err != nil {
 			return err
@@ -106,6 +157,35 @@ func (mgr *TracingManager) Dump() map[string]*EventTracingInfo {
 		mgr.mu.Lock()
 		dump[name] = c.Info()
 		mgr.mu.Unlock()
+		mgr.governorInfo(dump[name])
 	}
 	return dump
 }
+
+// Info gets the tracer info by name
+func (mgr *TracingManager) Info(name string) (*EventTracingInfo, error) {
+	te, ok := mgr.tracingEvents[name]
+	if !ok {
+		return nil, fmt.Errorf("%q not found", name)
+	}
+
+	mgr.mu.Lock()
+	info := te.Info()
+	mgr.mu.Unlock()
+
+	mgr.governorInfo(info)
+	return info, nil
+}
+
+// governorInfo sets the throttling level of the tracer by the governor. The
+// level is read without mgr.mu, the governor holds its lock while pausing and
+// resuming the tracers under mgr.mu.
+func (mgr *TracingManager) governorInfo(info *EventTracingInfo) {
+	mgr.mu.Lock()
+	g := mgr.governor
+	mgr.mu.Unlock()
+
+	if g != nil {
+		info.Governor = g.level(info.Name)
+	}
+}
@@ -311,3 +311,24 @@ func TestMgrTracingInfoDump(t *testing.T) {
 		})
 	}
 }
+
+func TestMgrTracingInfo(t *testing.T) {
+	mgr := &TracingManager{
+		tracingEvents: map[string]*EventTracing{
+			"trace-2026": {name: "trace-2026", isRunning: true, interval: 2, flag: FlagTracing},
+		},
+	}
+
+	info, err := mgr.Info("trace-2026")
+	if err != nil {
+		t.Errorf("Info() error=%v", err)
+		return
+	}
+	if info.Name != "trace-2026" || !info.Running || info.Interval != 2 {
+		t.Errorf("Info() mismatch: %+v", info)
+	}
+
+	if _, err := mgr.Info("missing"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("Info(missing) error=%v, want not found", err)
+	}
+}
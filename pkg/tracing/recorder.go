@@ -0,0 +1,66 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Recorder records the tracing data saved by Save instead of the stores, for
+// the tests of the tracers, e.g. with the fake bpf backend of
+// internal/bpf/bpftest.
+type Recorder struct {
+	mu       sync.Mutex
+	requests []*WriteRequest
+}
+
+var tracingRecorder atomic.Pointer[Recorder]
+
+// Record records the tracing data saved afterwards, until stop is called.
+func Record() (r *Recorder, stop func()) {
+	r = &Recorder{}
+	tracingRecorder.Store(r)
+	return r, func() { tracingRecorder.CompareAndSwap(r, nil) }
+}
+
+func (r *Recorder) record(req *WriteRequest) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.requests = append(r.requests, req)
+}
+
+// Requests returns the recorded requests.
+func (r *Recorder) Requests() []*WriteRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]*WriteRequest(nil), r.requests...)
+}
+
+// TracerData returns the recorded tracing data of the tracer.
+func (r *Recorder) TracerData(tracerName string) []any {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var data []any
+	for _, req := range r.requests {
+		if req.TracerName == tracerName {
+			data = append(data, req.TracerData)
+		}
+	}
+	return data
+}
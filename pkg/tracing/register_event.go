@@ -18,8 +18,10 @@ import (
 	"errors"
 	"fmt"
 	"slices"
+	"strings"
 	"sync"
 
+	"huatuo-bamai/internal/bpf"
 	"huatuo-bamai/internal/log"
 	"huatuo-bamai/pkg/types"
 )
@@ -53,6 +55,28 @@ func RegisterEventTracing(name string, factory func() (*EventTracingAttr, error)
 	factories[name] = factory
 }
 
+// EventTracingRegistered reports whether the tracer is registered.
+func EventTracingRegistered(name string) bool {
+	_, ok := factories[name]
+	return ok
+}
+
+// TracingBpfObjects returns the names of the bpf objects loaded by the
+// tracer, the tracers are the owners of the bpf objects they load, see
+// bpf.LoadBpfContext.
+func TracingBpfObjects(name string) []string {
+	return bpf.OwnerObjects(name)
+}
+
+// BpfObjectTracing returns the name of the tracer loading the bpf object, or
+// the object name without the ".o" suffix when no tracer loads it.
+func BpfObjectTracing(object string) string {
+	if owner := bpf.ObjectOwner(object); owner != "" {
+		return owner
+	}
+	return strings.TrimSuffix(object, ".o")
+}
+
 func NewRegister(blackListed []string) (map[string]*EventTracingAttr, error) {
 	var err error
 
@@ -63,6 +87,7 @@ func NewRegister(blackListed []string) (map[string]*EventTracingAttr, error) {
 		for name, factory := range factories {
 			if slices.Contains(blackListed, name) {
 				tracingStatusCache[name] = statusDisabled
+				removePins(name)
 				continue
 			}
 
@@ -70,6 +95,7 @@ func NewRegister(blackListed []string) (map[string]*EventTracingAttr, error) {
 			if err != nil {
 				if errors.Is(err, types.ErrNotSupported) {
 					tracingStatusCache[name] = statusInactive
+					removePins(name)
 					// reset the error for the last error in the loop.
 					err = nil
 					continue
@@ -100,6 +126,14 @@ func NewRegister(blackListed []string) (map[string]*EventTracingAttr, error) {
 	return tracingEventAttrCache, nil
 }
 
+// removePins removes the bpf objects pinned by the tracer before it was
+// disabled.
+func removePins(name string) {
+	if err := bpf.RemovePinsOf(name); err != nil {
+		log.Warnf("remove the pins of %s: %v", name, err)
+	}
+}
+
 func EventTracingStatus() map[string]string {
 	return tracingStatusCache
 }
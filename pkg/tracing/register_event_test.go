@@ -15,10 +15,14 @@
 package tracing
 
 import (
+	"context"
 	"errors"
+	"slices"
 	"sync"
 	"testing"
 
+	"huatuo-bamai/internal/bpf"
+	"huatuo-bamai/internal/bpf/bpftest"
 	pkgtypes "huatuo-bamai/pkg/types"
 )
 
@@ -161,3 +165,24 @@ func TestNewRegisterSyncOnce(t *testing.T) {
 		t.Errorf("second NewRegister() should not include trace-2027 because map is initialized only once")
 	}
 }
+
+func TestRegisterBpfObject(t *testing.T) {
+	bpftest.Install(t)
+
+	ctx := bpf.WithOwner(context.Background(), "trace-2026")
+	b, err := bpf.LoadBpfContext(ctx, "register_event_test.o", nil)
+	if err != nil {
+		t.Fatalf("LoadBpfContext() error=%v", err)
+	}
+	defer b.Close()
+
+	if got := TracingBpfObjects("trace-2026"); !slices.Equal(got, []string{"register_event_test.o"}) {
+		t.Errorf("TracingBpfObjects() = %q, want [register_event_test.o]", got)
+	}
+	if got := BpfObjectTracing("register_event_test.o"); got != "trace-2026" {
+		t.Errorf("BpfObjectTracing() = %q, want trace-2026", got)
+	}
+	if got := BpfObjectTracing("other.o"); got != "other" {
+		t.Errorf("BpfObjectTracing() = %q, want other", got)
+	}
+}
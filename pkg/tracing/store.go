@@ -15,9 +15,12 @@
 package tracing
 
 import (
+	"context"
+	"errors"
 	"time"
 
 	"huatuo-bamai/internal/storage"
+	"huatuo-bamai/internal/storage/driver"
 )
 
 const (
@@ -59,8 +62,14 @@ func SetTracingStore(stores []*storage.Store[*Document], options DocumentOptions
 	tracingDataWriter = newDocumentWriter(stores, options)
 }
 
-// Save writes tracing data when a tracing document store is configured.
+// Save writes tracing data when a tracing document store is configured, or
+// records it when a Recorder is recording.
 func Save(req *WriteRequest) error {
+	if r := tracingRecorder.Load(); r != nil {
+		r.record(req)
+		return nil
+	}
+
 	if tracingDataWriter == nil {
 		return nil
 	}
@@ -71,6 +80,53 @@ func Save(req *WriteRequest) error {
 	return tracingDataWriter.saveRaw(req)
 }
 
+// ErrNoQueryableStore is returned when none of the tracing document stores
+// supports reading documents back, e.g. only the localfile store is used.
+var ErrNoQueryableStore = errors.New("no queryable tracing document store")
+
+// GetDocument reads a tracing document by the tracer id.
+func GetDocument(ctx context.Context, id string) (*Document, error) {
+	if tracingDataWriter == nil {
+		return nil, ErrNoQueryableStore
+	}
+
+	for _, store := range tracingDataWriter.stores {
+		if store == nil {
+			continue
+		}
+
+		document, err := store.Get(ctx, id)
+		if errors.Is(err, driver.ErrUnsupported) {
+			continue
+		}
+		return document, err
+	}
+
+	return nil, ErrNoQueryableStore
+}
+
+// QueryDocuments queries the tracing documents from the first store which
+// supports queries.
+func QueryDocuments(ctx context.Context, q driver.Query) ([]*Document, error) {
+	if tracingDataWriter == nil {
+		return nil, ErrNoQueryableStore
+	}
+
+	for _, store := range tracingDataWriter.stores {
+		if store == nil {
+			continue
+		}
+
+		documents, err := store.Query(ctx, q)
+		if errors.Is(err, driver.ErrUnsupported) {
+			continue
+		}
+		return documents, err
+	}
+
+	return nil, ErrNoQueryableStore
+}
+
 // SetTaskStore configures stores for task output.
 func SetTaskStore(stores []*storage.Store[*Document], options DocumentOptions) {
 	if len(stores) == 0 {
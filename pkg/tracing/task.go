@@ -20,6 +20,7 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"os"
 	"os/exec"
 	"path"
 	"sync"
@@ -46,6 +47,13 @@ const (
 
 var TaskBinDir = "bin"
 
+const (
+	taskIDLength = 16
+	// taskWaitDelay bounds the wait for the output of a cancelled task, e.g.
+	// held by the children of the task tool.
+	taskWaitDelay = 5 * time.Second
+)
+
 type TaskStorageType int
 
 const (
@@ -56,9 +64,10 @@ const (
 )
 
 type TaskResult struct {
-	TaskStatus Status
-	TaskData   []byte
-	TaskErr    error
+	TaskStatus    Status
+	TaskData      []byte
+	TaskErr       error
+	TaskArtifacts []TaskArtifact
 }
 
 // task represents a unit of work to be executed.
@@ -72,6 +81,8 @@ type task struct {
 	storage      TaskStorageType    // Type of data produced by the task.
 	cancelFunc   context.CancelFunc // Function to cancel the task.
 	deadlineTime time.Time          // Time after which the task will be automatically deleted.
+	artifacts    []TaskArtifact     // Files written by the task into its artifact dir.
+	done         chan struct{}      // Closed when the task tool exits.
 }
 
 var (
@@ -100,6 +111,7 @@ func tasksGarbageCollect() {
 				if now.After(task.deadlineTime) {
 					log.Infof("task %s deleted by timeout", key)
 					taskLifeTmpCache.Delete(key)
+					removeTaskArtifacts(task.id)
 				}
 			}
 			return true
@@ -109,8 +121,7 @@ func tasksGarbageCollect() {
 
 func allocTaskID() string {
 	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	const length = 16
-	result := make([]byte, length)
+	result := make([]byte, taskIDLength)
 	charsetLength := big.NewInt(int64(len(charset)))
 
 	for i := range result {
@@ -135,6 +146,7 @@ func NewTask(execBinary string, timeout time.Duration, storageType TaskStorageTy
 		execBinary: execBinary,
 		storage:    storageType,
 		execArgs:   execArgs,
+		done:       make(chan struct{}),
 	}
 	taskLifeTmpCache.Store(taskID, task)
 
@@ -146,13 +158,33 @@ func NewTask(execBinary string, timeout time.Duration, storageType TaskStorageTy
 func runTask(ctx context.Context, task *task) {
 	defer func() {
 		setDeadlineDefault(task)
+		close(task.done)
 	}()
 
 	task.status = StatusRunning
 	log.Infof("task %s %s started", task.execBinary, task.id)
 
 	cmd := exec.CommandContext(ctx, path.Join(TaskBinDir, task.execBinary), task.execArgs...)
+	cmd.WaitDelay = taskWaitDelay
+	artifactDir, err := prepareTaskArtifactDir(task.id)
+	if err != nil {
+		log.Warnf("task %s %s: %v", task.execBinary, task.id, err)
+	} else {
+		cmd.Env = append(os.Environ(), TaskArtifactDirEnv+"="+artifactDir)
+	}
+
 	output, err := cmd.CombinedOutput()
+
+	// index the artifacts before publishing the final status, partial
+	// artifacts of a failed or timed out task are still worth keeping.
+	if artifactDir != "" {
+		artifacts, indexErr := indexTaskArtifacts(artifactDir)
+		if indexErr != nil {
+			log.Warnf("task %s %s index artifacts: %v", task.execBinary, task.id, indexErr)
+		}
+		task.artifacts = artifacts
+	}
+
 	if err != nil {
 		task.status = StatusFailed
 		contextErr := ctx.Err()
@@ -233,9 +265,10 @@ func Result(taskID string) *TaskResult {
 		setDeadlineDefault(task)
 	}
 	return &TaskResult{
-		TaskData:   task.stdoutData,
-		TaskStatus: task.status,
-		TaskErr:    task.error,
+		TaskData:      task.stdoutData,
+		TaskStatus:    task.status,
+		TaskErr:       task.error,
+		TaskArtifacts: task.artifacts,
 	}
 }
 
@@ -247,10 +280,14 @@ func StopTask(taskID string) error {
 	}
 
 	task := taskAny.(*task)
-	if task.status == StatusRunning {
-		task.cancelFunc()
-	}
+	task.cancelFunc()
 	taskLifeTmpCache.Delete(taskID)
+
+	// the cancelled tool may still be writing its artifacts.
+	if task.done != nil {
+		<-task.done
+	}
+	removeTaskArtifacts(taskID)
 	log.Infof("task %s stoped", task.id)
 	return nil
 }
@@ -0,0 +1,210 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"huatuo-bamai/internal/log"
+)
+
+// TaskArtifactDirEnv is the environment variable through which a task tool
+// learns the directory where its binary artifacts should be written.
+const TaskArtifactDirEnv = "HUATUO_TASK_ARTIFACT_DIR"
+
+// TaskArtifactDir is the root directory of per-task artifact directories.
+var TaskArtifactDir = "/var/lib/huatuo-bamai/artifacts"
+
+var (
+	// ErrArtifactNotFound Error returned when an artifact is not found.
+	ErrArtifactNotFound = errors.New("artifact not found")
+	// ErrArtifactInvalidName Error returned when an artifact name is invalid.
+	ErrArtifactInvalidName = errors.New("invalid artifact name")
+)
+
+// TaskArtifact describes a file written by a task tool, e.g. pprof, pcap or svg.
+type TaskArtifact struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	SHA256  string    `json:"sha256"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+func taskArtifactDir(taskID string) string {
+	return filepath.Join(TaskArtifactDir, taskID)
+}
+
+func prepareTaskArtifactDir(taskID string) (string, error) {
+	dir := taskArtifactDir(taskID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("create artifact dir %s: %w", dir, err)
+	}
+
+	return dir, nil
+}
+
+func removeTaskArtifacts(taskID string) {
+	if err := os.RemoveAll(taskArtifactDir(taskID)); err != nil {
+		log.Warnf("remove task %s artifacts: %v", taskID, err)
+	}
+}
+
+// isTaskID reports whether the name is allocated by allocTaskID, only such
+// directories are swept from the artifact root.
+func isTaskID(name string) bool {
+	if len(name) != taskIDLength {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// SweepTaskArtifacts removes the artifact directories of the tasks not in the
+// task history, e.g. left by the previous runs of the agent.
+func SweepTaskArtifacts() {
+	entries, err := os.ReadDir(TaskArtifactDir)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Warnf("sweep task artifacts: %v", err)
+		}
+		return
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || !isTaskID(entry.Name()) {
+			continue
+		}
+		if _, ok := taskLifeTmpCache.Load(entry.Name()); ok {
+			continue
+		}
+
+		log.Infof("remove orphaned task %s artifacts", entry.Name())
+		removeTaskArtifacts(entry.Name())
+	}
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// indexTaskArtifacts walks the artifact dir and returns the regular files
+// sorted by name. Sub-directories and symlinks are ignored.
+func indexTaskArtifacts(dir string) ([]TaskArtifact, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	artifacts := make([]TaskArtifact, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+
+		sum, err := fileSHA256(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("checksum %s: %w", entry.Name(), err)
+		}
+
+		artifacts = append(artifacts, TaskArtifact{
+			Name:    entry.Name(),
+			Size:    info.Size(),
+			SHA256:  sum,
+			ModTime: info.ModTime(),
+		})
+	}
+
+	sort.Slice(artifacts, func(i, j int) bool { return artifacts[i].Name < artifacts[j].Name })
+	return artifacts, nil
+}
+
+func validateArtifactName(name string) error {
+	if name == "" || strings.Contains(name, "..") || filepath.Base(name) != name {
+		return ErrArtifactInvalidName
+	}
+
+	return nil
+}
+
+func loadTask(taskID string) (*task, error) {
+	taskAny, ok := taskLifeTmpCache.Load(taskID)
+	if !ok {
+		return nil, ErrTaskNotFound
+	}
+
+	return taskAny.(*task), nil
+}
+
+// TaskArtifacts returns the artifacts indexed for a finished task.
+func TaskArtifacts(taskID string) ([]TaskArtifact, error) {
+	task, err := loadTask(taskID)
+	if err != nil {
+		return nil, err
+	}
+
+	return task.artifacts, nil
+}
+
+// TaskArtifactPath returns the on-disk path and the index entry of an artifact.
+func TaskArtifactPath(taskID, name string) (string, *TaskArtifact, error) {
+	if err := validateArtifactName(name); err != nil {
+		return "", nil, err
+	}
+
+	task, err := loadTask(taskID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	for i := range task.artifacts {
+		if task.artifacts[i].Name == name {
+			return filepath.Join(taskArtifactDir(taskID), name), &task.artifacts[i], nil
+		}
+	}
+
+	return "", nil, ErrArtifactNotFound
+}
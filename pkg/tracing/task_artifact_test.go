@@ -0,0 +1,134 @@
+// Copyright 2026 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIndexTaskArtifacts(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"profile.pb.gz": "pprof-data",
+		"flame.svg":     "<svg/>",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "nested"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	artifacts, err := indexTaskArtifacts(dir)
+	if err != nil {
+		t.Fatalf("indexTaskArtifacts() error=%v", err)
+	}
+
+	if got, want := len(artifacts), len(files); got != want {
+		t.Fatalf("artifacts len=%d, want %d", got, want)
+	}
+
+	if artifacts[0].Name != "flame.svg" || artifacts[1].Name != "profile.pb.gz" {
+		t.Errorf("artifacts not sorted by name: %v", artifacts)
+	}
+
+	for _, a := range artifacts {
+		sum := sha256.Sum256([]byte(files[a.Name]))
+		if a.SHA256 != hex.EncodeToString(sum[:]) {
+			t.Errorf("%s sha256=%s, want %x", a.Name, a.SHA256, sum)
+		}
+		if a.Size != int64(len(files[a.Name])) {
+			t.Errorf("%s size=%d, want %d", a.Name, a.Size, len(files[a.Name]))
+		}
+	}
+
+	missing, err := indexTaskArtifacts(filepath.Join(dir, "not-exist"))
+	if err != nil || missing != nil {
+		t.Errorf("indexTaskArtifacts(missing)=%v, %v, want nil, nil", missing, err)
+	}
+}
+
+func TestTaskArtifactPath(t *testing.T) {
+	origDir := TaskArtifactDir
+	TaskArtifactDir = t.TempDir()
+	t.Cleanup(func() { TaskArtifactDir = origDir })
+
+	taskID := "artifact-task"
+	taskLifeTmpCache.Store(taskID, &task{
+		id:        taskID,
+		status:    StatusCompleted,
+		artifacts: []TaskArtifact{{Name: "capture.pcap", Size: 4}},
+	})
+	t.Cleanup(func() { taskLifeTmpCache.Delete(taskID) })
+
+	path, artifact, err := TaskArtifactPath(taskID, "capture.pcap")
+	if err != nil {
+		t.Fatalf("TaskArtifactPath() error=%v", err)
+	}
+	if want := filepath.Join(TaskArtifactDir, taskID, "capture.pcap"); path != want {
+		t.Errorf("path=%q, want %q", path, want)
+	}
+	if artifact.Size != 4 {
+		t.Errorf("artifact size=%d, want 4", artifact.Size)
+	}
+
+	tests := []struct {
+		taskID, name string
+		wantErr      error
+	}{
+		{taskID, "../capture.pcap", ErrArtifactInvalidName},
+		{taskID, "a/b", ErrArtifactInvalidName},
+		{taskID, "", ErrArtifactInvalidName},
+		{taskID, "missing.svg", ErrArtifactNotFound},
+		{"no-such-task", "capture.pcap", ErrTaskNotFound},
+	}
+	for _, tt := range tests {
+		if _, _, err := TaskArtifactPath(tt.taskID, tt.name); !errors.Is(err, tt.wantErr) {
+			t.Errorf("TaskArtifactPath(%q, %q) error=%v, want %v", tt.taskID, tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSweepTaskArtifacts(t *testing.T) {
+	origDir := TaskArtifactDir
+	TaskArtifactDir = t.TempDir()
+	t.Cleanup(func() { TaskArtifactDir = origDir })
+
+	const orphaned, running = "orphanedTask0001", "runningTask00001"
+	taskLifeTmpCache.Store(running, &task{id: running, status: StatusRunning})
+	t.Cleanup(func() { taskLifeTmpCache.Delete(running) })
+
+	for _, name := range []string{orphaned, running, "not-a-task"} {
+		if err := os.Mkdir(filepath.Join(TaskArtifactDir, name), 0o755); err != nil {
+			t.Fatalf("mkdir %s: %v", name, err)
+		}
+	}
+
+	SweepTaskArtifacts()
+
+	for name, want := range map[string]bool{orphaned: false, running: true, "not-a-task": true} {
+		_, err := os.Stat(filepath.Join(TaskArtifactDir, name))
+		if got := err == nil; got != want {
+			t.Errorf("%s exists=%v, want %v", name, got, want)
+		}
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"errors"
 	"time"
 
+	"huatuo-bamai/internal/bpf"
 	"huatuo-bamai/internal/log"
 	"huatuo-bamai/pkg/types"
 )
@@ -81,6 +82,12 @@ func (c *EventTracing) doStart() {
 	c.cancelCtx = cancel
 	defer c.cancelCtx()
 
+	// the bpf objects loaded and the event pipes created by the tracer.
+	ctx = bpf.WithOwner(ctx, c.name)
+	if EventsLostThreshold > 0 {
+		go watchEventsLost(ctx, c.name)
+	}
+
 	if err := c.ic.Start(ctx); err != nil {
 		if !(errors.Is(err, types.ErrExitByCancelCtx) ||
 			errors.Is(err, types.ErrDisconnectedHuatuo) ||
@@ -102,20 +109,46 @@ func (c *EventTracing) Stop() {
 
 // EventTracingInfo represents tracing information
 type EventTracingInfo struct {
-	Name     string `json:"name"`
-	Running  bool   `json:"running"`
-	HitCount int    `json:"hit"`
-	Interval int    `json:"restart_interval"`
-	Flag     uint32 `json:"flag"`
+	Name       string         `json:"name"`
+	Running    bool           `json:"running"`
+	HitCount   int            `json:"hit"`
+	Interval   int            `json:"restart_interval"`
+	Flag       uint32         `json:"flag"`
+	LostEvents bpf.LostEvents `json:"lost_events"`
+	// Programs are the bpf programs with the runtime stats.
+	Programs []bpf.ProgramInfo `json:"programs,omitempty"`
+	// BTF is the btf loading the bpf object of the tracer, "kernel", "external"
+	// or "missing" when it is skipped for no btf.
+	BTF string `json:"btf,omitempty"`
+	// EventStats are the events processed by the tracer.
+	EventStats bpf.EventStats `json:"event_stats"`
+	// StackErrors are the stacks lost by the stack trace maps of the tracer.
+	StackErrors uint64 `json:"stack_errors"`
+	// RateLimits are the bpf ratelimits of the bpf object of the tracer.
+	RateLimits []bpf.RateLimit `json:"ratelimits,omitempty"`
+	// Governor is the throttling level by the governor, see GovernorOption.
+	Governor string `json:"governor,omitempty"`
 }
 
 // Info return tracing's base information
 func (c *EventTracing) Info() *EventTracingInfo {
-	return &EventTracingInfo{
-		Name:     c.name,
-		Running:  c.isRunning,
-		HitCount: c.hitCount,
-		Interval: c.interval,
-		Flag:     c.flag,
+	info := &EventTracingInfo{
+		Name:        c.name,
+		Running:     c.isRunning,
+		HitCount:    c.hitCount,
+		Interval:    c.interval,
+		Flag:        c.flag,
+		LostEvents:  bpf.LostEventsOf(c.name),
+		EventStats:  bpf.EventStatsOf(c.name),
+		StackErrors: bpf.StackErrorsOf(c.name),
+	}
+
+	for _, object := range TracingBpfObjects(c.name) {
+		info.Programs = append(info.Programs, bpf.ProgramStatsOf(object)...)
+		info.RateLimits = append(info.RateLimits, bpf.RateLimitsOf(object)...)
+		if info.BTF == "" {
+			info.BTF = bpf.BTFStatusOf(object)
+		}
 	}
+	return info
 }